@@ -0,0 +1,344 @@
+package covutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Per-Test Attribution ---
+
+// TestNameLabel is the pod label naming the test that produced the pod.
+// It is set by WithTestDirectories and may be set by callers that build
+// pods themselves.
+const TestNameLabel = "GoTestName"
+
+// PodTestName returns the name of the test that produced pod, taken from
+// its TestNameLabel label or, failing that, its "test_name" label. It
+// returns "" if the pod is not attributed to a test.
+func PodTestName(pod *Pod) string {
+	if name := pod.Labels[TestNameLabel]; name != "" {
+		return name
+	}
+	return pod.Labels["test_name"]
+}
+
+// CoveredUnit is a coverable unit that was executed, together with the
+// function it belongs to.
+type CoveredUnit struct {
+	PkgPath  string
+	FuncName string
+	File     string
+	Unit     CoverableUnit
+	Count    uint32 // execution count, summed over the pods considered
+}
+
+// TestsCovering returns the sorted names of the tests that executed any
+// unit spanning line of file. The file may be given as recorded in the
+// meta-data (for example "example.com/mod/pkg/file.go") or as any suffix
+// of it starting at a path element, such as "pkg/file.go". Pods that are
+// not attributed to a test are ignored.
+func (cs *CoverageSet) TestsCovering(file string, line uint32) []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.testsCovering(func(u CoveredUnit) bool {
+		return u.Spans(file, line)
+	})
+}
+
+// TestsCoveringFunc returns the sorted names of the tests that executed
+// any unit of the named function.
+func (cs *CoverageSet) TestsCoveringFunc(pkgPath, funcName string) []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.testsCovering(func(u CoveredUnit) bool {
+		return u.PkgPath == pkgPath && u.FuncName == funcName
+	})
+}
+
+// UnitsCoveredBy returns the units executed by the named test, ordered by
+// file and position. Counts are summed over all of the test's pods.
+func (cs *CoverageSet) UnitsCoveredBy(testName string) []CoveredUnit {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.unitsCoveredBy(testName, func(CoveredUnit) bool { return true })
+}
+
+// TestNames returns the sorted names of all tests pods are attributed to.
+func (cs *CoverageSet) TestNames() []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	names := make(map[string]bool)
+	for _, pod := range cs.Pods {
+		if name := PodTestName(pod); name != "" {
+			names[name] = true
+		}
+	}
+	return sortedKeys(names)
+}
+
+func (cs *CoverageSet) testsCovering(match func(CoveredUnit) bool) []string {
+	tests := make(map[string]bool)
+	for _, pod := range cs.Pods {
+		name := PodTestName(pod)
+		if name == "" || tests[name] {
+			continue
+		}
+		visitCoveredUnits(pod, func(u CoveredUnit) bool {
+			if match(u) {
+				tests[name] = true
+				return false
+			}
+			return true
+		})
+	}
+	return sortedKeys(tests)
+}
+
+func (cs *CoverageSet) unitsCoveredBy(testName string, match func(CoveredUnit) bool) []CoveredUnit {
+	// Units are identified by position rather than index so that pods
+	// built from different binaries still line up.
+	type unitKey struct {
+		fn   PkgFuncKey
+		unit CoverableUnit
+	}
+	index := make(map[unitKey]int)
+	var units []CoveredUnit
+	for _, pod := range cs.Pods {
+		if PodTestName(pod) != testName {
+			continue
+		}
+		visitCoveredUnits(pod, func(u CoveredUnit) bool {
+			if !match(u) {
+				return true
+			}
+			k := unitKey{PkgFuncKey{PkgPath: u.PkgPath, FuncName: u.FuncName}, u.Unit}
+			if i, ok := index[k]; ok {
+				units[i].Count += u.Count
+				return true
+			}
+			index[k] = len(units)
+			units = append(units, u)
+			return true
+		})
+	}
+	sort.Slice(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Unit.StartLine != b.Unit.StartLine {
+			return a.Unit.StartLine < b.Unit.StartLine
+		}
+		return a.Unit.StartCol < b.Unit.StartCol
+	})
+	return units
+}
+
+// visitCoveredUnits calls f for each unit of pod with a non-zero count,
+// stopping early if f returns false.
+func visitCoveredUnits(pod *Pod, f func(CoveredUnit) bool) {
+	if pod.Profile == nil {
+		return
+	}
+	for _, pkg := range pod.Profile.Meta.Packages {
+		for _, fd := range pkg.Functions {
+			counts := pod.Profile.Counters[PkgFuncKey{PkgPath: pkg.Path, FuncName: fd.FuncName}]
+			if len(counts) != len(fd.Units) {
+				continue
+			}
+			for i, u := range fd.Units {
+				if counts[i] == 0 {
+					continue
+				}
+				cu := CoveredUnit{PkgPath: pkg.Path, FuncName: fd.FuncName, File: fd.SrcFile, Unit: u, Count: counts[i]}
+				if !f(cu) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// matchSrcFile reports whether the meta-data source path src names file,
+// either exactly or as a suffix starting at a path element.
+func matchSrcFile(src, file string) bool {
+	return src == file || strings.HasSuffix(src, "/"+strings.TrimPrefix(file, "/"))
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// --- /by-line/ Filesystem View ---
+
+// openByLinePath serves the /by-line/ tree:
+//
+//	/by-line/                       - source files with coverage
+//	/by-line/<file>/                - lines executed by any test
+//	/by-line/<file>/<line>/         - tests that executed the line
+//	/by-line/<file>/<line>/<test>   - the test's units on that line (JSON)
+//
+// Each element of a source path is a directory level, so that
+// /by-line/example.com/ lists the files and directories below it. Test
+// names are a single element, with slashes in subtest names escaped as
+// by url.PathEscape.
+func (cs *CoverageSet) openByLinePath(parts []string, fullPath string) (fs.File, error) {
+	// Absolute source paths are listed without their leading slash.
+	byName := make(map[string]string)
+	var files []string
+	for _, f := range cs.sourceFiles() {
+		name := strings.TrimPrefix(f, "/")
+		byName[name] = f
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	var file string
+	var rest []string
+	for i := 1; i <= len(parts); i++ {
+		if f, ok := byName[strings.Join(parts[:i], "/")]; ok {
+			file, rest = f, parts[i:]
+			break
+		}
+	}
+	if file == "" {
+		return openSourceDir(files, parts, fullPath)
+	}
+
+	if len(rest) == 0 {
+		var entries []fs.DirEntry
+		for _, line := range cs.coveredLines(file) {
+			entries = append(entries, &podDirEntry{name: strconv.FormatUint(uint64(line), 10)})
+		}
+		return &virtualDir{name: path.Base(file), entries: entries}, nil
+	}
+
+	n, err := strconv.ParseUint(rest[0], 10, 32)
+	if err != nil || len(rest) > 2 {
+		return nil, &fs.PathError{Op: "open", Path: fullPath, Err: fs.ErrNotExist}
+	}
+	line := uint32(n)
+	onLine := func(u CoveredUnit) bool {
+		return u.File == file && u.Unit.StartLine <= line && line <= u.Unit.EndLine
+	}
+	unitsJSON := func(test string) ([]byte, error) {
+		units := cs.unitsCoveredBy(test, onLine)
+		if len(units) == 0 {
+			return nil, fs.ErrNotExist
+		}
+		return json.MarshalIndent(units, "", "  ")
+	}
+
+	if len(rest) == 1 {
+		var entries []fs.DirEntry
+		for _, test := range cs.testsCovering(onLine) {
+			data, err := unitsJSON(test)
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: fullPath, Err: err}
+			}
+			entries = append(entries, &fileEntry{name: url.PathEscape(test), size: int64(len(data))})
+		}
+		return &virtualDir{name: rest[0], entries: entries}, nil
+	}
+
+	test, err := url.PathUnescape(rest[1])
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: fullPath, Err: fs.ErrNotExist}
+	}
+	data, err := unitsJSON(test)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: fullPath, Err: err}
+	}
+	return &memoryFile{name: rest[1], data: data}, nil
+}
+
+// openSourceDir opens the directory of the /by-line/ tree named by parts,
+// which lists the next path element of each file below it.
+func openSourceDir(files, parts []string, fullPath string) (fs.File, error) {
+	prefix := strings.Join(parts, "/")
+	children := make(map[string]bool)
+	for _, f := range files {
+		rel := f
+		if prefix != "" {
+			var ok bool
+			if rel, ok = strings.CutPrefix(f, prefix+"/"); !ok {
+				continue
+			}
+		}
+		elem, _, _ := strings.Cut(rel, "/")
+		children[elem] = true
+	}
+	if prefix != "" && len(children) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: fullPath, Err: fs.ErrNotExist}
+	}
+	var entries []fs.DirEntry
+	for _, name := range sortedKeys(children) {
+		entries = append(entries, &podDirEntry{name: name})
+	}
+	name := "by-line"
+	if len(parts) > 0 {
+		name = parts[len(parts)-1]
+	}
+	return &virtualDir{name: name, entries: entries}, nil
+}
+
+// sourceFiles returns the sorted source files executed by attributed pods.
+func (cs *CoverageSet) sourceFiles() []string {
+	files := make(map[string]bool)
+	for _, pod := range cs.Pods {
+		if PodTestName(pod) == "" {
+			continue
+		}
+		visitCoveredUnits(pod, func(u CoveredUnit) bool {
+			files[u.File] = true
+			return true
+		})
+	}
+	return sortedKeys(files)
+}
+
+// coveredLines returns the sorted lines of file executed by attributed pods.
+func (cs *CoverageSet) coveredLines(file string) []uint32 {
+	lines := make(map[uint32]bool)
+	for _, pod := range cs.Pods {
+		if PodTestName(pod) == "" {
+			continue
+		}
+		visitCoveredUnits(pod, func(u CoveredUnit) bool {
+			if u.File == file {
+				for l := u.Unit.StartLine; l <= u.Unit.EndLine; l++ {
+					lines[l] = true
+				}
+			}
+			return true
+		})
+	}
+	result := make([]uint32, 0, len(lines))
+	for l := range lines {
+		result = append(result, l)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// Spans reports whether the unit spans line of file, where file is matched
+// against the recorded source path as by TestsCovering.
+func (u CoveredUnit) Spans(file string, line uint32) bool {
+	return matchSrcFile(u.File, file) && u.Unit.StartLine <= line && line <= u.Unit.EndLine
+}
+
+// String returns the unit in file:line.col,line.col form.
+func (u CoveredUnit) String() string {
+	return fmt.Sprintf("%s:%d.%d,%d.%d", u.File, u.Unit.StartLine, u.Unit.StartCol, u.Unit.EndLine, u.Unit.EndCol)
+}
@@ -0,0 +1,179 @@
+package covutil
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func attributionProfile(counts []uint32) *Profile {
+	return &Profile{
+		Meta: MetaFile{
+			Mode:        ModeCount,
+			Granularity: GranularityBlock,
+			Packages: []PackageMeta{{
+				Path: "example.com/mod/a",
+				Name: "a",
+				Functions: []FuncDesc{{
+					PackagePath: "example.com/mod/a",
+					FuncName:    "Add",
+					SrcFile:     "example.com/mod/a/a.go",
+					Units: []CoverableUnit{
+						{StartLine: 3, StartCol: 24, EndLine: 4, EndCol: 11, NumStmt: 1},
+						{StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3, NumStmt: 1},
+						{StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 14, NumStmt: 1},
+					},
+				}},
+			}},
+		},
+		Counters: map[PkgFuncKey][]uint32{
+			{PkgPath: "example.com/mod/a", FuncName: "Add"}: counts,
+		},
+	}
+}
+
+// loadPerTestSet writes one GOCOVERDIR per test and loads them with
+// WithTestDirectories.
+func loadPerTestSet(t *testing.T, tests map[string][]uint32) *CoverageSet {
+	t.Helper()
+	root := t.TempDir()
+	for name, counts := range tests {
+		if err := WriteProfileToDirectory(filepath.Join(root, name), attributionProfile(counts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set, err := LoadCoverageSet(os.DirFS(root), WithTestDirectories())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Pods) != len(tests) {
+		t.Fatalf("got %d pods, want %d", len(set.Pods), len(tests))
+	}
+	return set
+}
+
+func TestTestsCovering(t *testing.T) {
+	set := loadPerTestSet(t, map[string][]uint32{
+		"TestPositive": {1, 0, 1},
+		"TestNegative": {1, 1, 0},
+		"TestUnused":   {0, 0, 0},
+	})
+
+	if got, want := set.TestNames(), []string{"TestNegative", "TestPositive", "TestUnused"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TestNames() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		file string
+		line uint32
+		want []string
+	}{
+		{"example.com/mod/a/a.go", 3, []string{"TestNegative", "TestPositive"}},
+		{"a/a.go", 5, []string{"TestNegative"}},
+		{"a.go", 7, []string{"TestPositive"}},
+		{"a.go", 10, nil},
+		{"b.go", 3, nil},
+	}
+	for _, tt := range tests {
+		got := set.TestsCovering(tt.file, tt.line)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TestsCovering(%q, %d) = %v, want %v", tt.file, tt.line, got, tt.want)
+		}
+	}
+
+	if got := set.TestsCoveringFunc("example.com/mod/a", "Add"); len(got) != 2 {
+		t.Errorf("TestsCoveringFunc = %v, want two tests", got)
+	}
+
+	units := set.UnitsCoveredBy("TestNegative")
+	if len(units) != 2 || units[0].Unit.StartLine != 3 || units[1].Unit.StartLine != 4 || units[0].Count != 1 {
+		t.Errorf("UnitsCoveredBy(TestNegative) = %+v", units)
+	}
+	if units := set.UnitsCoveredBy("TestUnused"); len(units) != 0 {
+		t.Errorf("UnitsCoveredBy(TestUnused) = %+v, want none", units)
+	}
+}
+
+func TestByLineFS(t *testing.T) {
+	set := loadPerTestSet(t, map[string][]uint32{
+		"TestPositive": {2, 0, 1},
+		"TestNegative": {1, 1, 0},
+	})
+
+	entries, err := fs.ReadDir(set, "by-line/example.com/mod/a/a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, e.Name())
+	}
+	if want := []string{"3", "4", "5", "6", "7"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("covered lines = %v, want %v", lines, want)
+	}
+
+	entries, err = fs.ReadDir(set, "by-line/example.com/mod/a/a.go/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "TestPositive" {
+		t.Errorf("tests covering line 7 = %v", entries)
+	}
+
+	data, err := fs.ReadFile(set, "by-line/example.com/mod/a/a.go/3/TestPositive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var units []CoveredUnit
+	if err := json.Unmarshal(data, &units); err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0].Count != 2 || units[0].FuncName != "Add" {
+		t.Errorf("units on line 3 = %+v", units)
+	}
+
+	if _, err := fs.ReadFile(set, "by-line/example.com/mod/a/a.go/5/TestPositive"); err == nil {
+		t.Errorf("expected error reading a line the test did not execute")
+	}
+
+	// Every element of a source path is a directory.
+	entries, err = fs.ReadDir(set, "by-line/example.com/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "a" || !entries[0].IsDir() {
+		t.Errorf("by-line/example.com/mod = %v, want [a/]", entries)
+	}
+
+	// Subtest names are escaped into a single path element.
+	set.Pods = append(set.Pods, &Pod{
+		ID:      "sub",
+		Labels:  map[string]string{TestNameLabel: "TestPositive/small"},
+		Profile: attributionProfile([]uint32{0, 0, 3}),
+	})
+	entries, err = fs.ReadDir(set, "by-line/example.com/mod/a/a.go/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Name() != "TestPositive%2Fsmall" {
+		t.Errorf("tests covering line 7 = %v", entries)
+	}
+	if _, err := fs.ReadFile(set, "by-line/example.com/mod/a/a.go/7/TestPositive%2Fsmall"); err != nil {
+		t.Error(err)
+	}
+
+	sub, err := fs.Sub(set, "by-line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "example.com/mod/a/a.go/3/TestPositive", "example.com/mod/a/a.go/7/TestPositive%2Fsmall"); err != nil {
+		t.Error(err)
+	}
+}
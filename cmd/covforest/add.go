@@ -7,17 +7,22 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"os"
-	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
+	"github.com/tmc/covutil"
 	"github.com/tmc/covutil/covtree"
 	"github.com/tmc/covutil/internal/covforest"
+	"github.com/tmc/covutil/vcs"
 )
 
 var cmdAdd = &Command{
-	UsageLine: "covforest add -i=<directory> -name=<name> [-machine=<machine>] [-repo=<repo>] [-branch=<branch>]",
+	UsageLine: "covforest add -i=<directory> -name=<name> [-machine=<machine>] [-os=<goos>] [-repo=<repo>] [-branch=<branch>]",
 	Short:     "add a coverage tree to the forest",
 	Long: `
 Add processes a coverage directory and adds it as a tree to the forest.
@@ -25,12 +30,19 @@ Add processes a coverage directory and adds it as a tree to the forest.
 The -i flag specifies the directory containing coverage data.
 The -name flag specifies a human-readable name for this tree.
 The -machine flag specifies the machine/host where coverage was collected.
+The -os flag specifies the operating system coverage was collected on
+(default: that of the current machine).
 The -repo flag specifies the repository URL or path.
 The -branch flag specifies the git branch.
-The -forest flag specifies the forest file path (default: ~/.covforest/forest.json).
+The -forest flag specifies the forest directory (default: ~/.covforest/forest).
 
-The command will attempt to automatically detect git information if run
-within a git repository.
+The commit, branch, tag and repository URL are read from the git
+repository given by -repo, or else containing the input directory or the
+current directory. Outside a repository, the commit is taken from the
+version control information the go command records in the instrumented
+binaries, if they still exist. Flags take precedence over detected values.
+The detected state is also recorded in pod metadata files stored with the
+tree's coverage files, for pods that had none.
 
 Example:
 
@@ -43,9 +55,10 @@ var (
 	addInputDir = cmdAdd.Flag.String("i", "", "input directory containing coverage data")
 	addName     = cmdAdd.Flag.String("name", "", "human-readable name for this tree")
 	addMachine  = cmdAdd.Flag.String("machine", "", "machine/host where coverage was collected")
+	addOS       = cmdAdd.Flag.String("os", runtime.GOOS, "operating system where coverage was collected")
 	addRepo     = cmdAdd.Flag.String("repo", "", "repository URL or path")
 	addBranch   = cmdAdd.Flag.String("branch", "", "git branch")
-	addForest   = cmdAdd.Flag.String("forest", "", "forest file path (default: ~/.covforest/forest.json)")
+	addForest   = cmdAdd.Flag.String("forest", "", "forest directory (default: ~/.covforest/forest)")
 )
 
 func init() {
@@ -66,15 +79,22 @@ func runAdd(ctx context.Context, args []string) error {
 	}
 
 	// Load coverage data
-	tree := covtree.NewCoverageTree()
-	if err := tree.LoadFromNestedRepository(*addInputDir); err != nil {
+	input := os.DirFS(*addInputDir)
+	set, err := covutil.LoadCoverageSet(input)
+	if err != nil {
 		return fmt.Errorf("failed to load coverage data from %s: %v", *addInputDir, err)
 	}
+	merged, err := set.Merge()
+	if err != nil {
+		return fmt.Errorf("failed to load coverage data from %s: %v", *addInputDir, err)
+	}
+	tree := covtree.NewCoverageTreeFromProfile(merged.Profile)
 
 	// Detect git information if not provided
 	source := covforest.TreeSource{
 		Type:      "local",
 		Path:      *addInputDir,
+		OS:        *addOS,
 		Timestamp: time.Now(),
 	}
 
@@ -86,24 +106,33 @@ func runAdd(ctx context.Context, args []string) error {
 		}
 	}
 
+	// Stamp the pods with the version control state, and use it for the
+	// tree.
+	var stamped []*covutil.Pod
+	for _, pod := range set.Pods {
+		if pod.Source == nil {
+			stamped = append(stamped, pod)
+		}
+	}
+	if err := vcs.Stamp(sourceDir(), stamped...); err != nil && err != vcs.ErrNotFound {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	for _, pod := range set.Pods {
+		if pod.Source != nil {
+			source.Repository = pod.Source.RepoURI
+			source.Branch = pod.Source.Branch
+			source.Commit = pod.Source.CommitSHA
+			source.CommitTime = pod.Source.CommitTime
+			source.Tag = pod.Source.Tag
+			source.Dirty = pod.Source.Dirty
+			break
+		}
+	}
 	if *addRepo != "" {
 		source.Repository = *addRepo
-	} else {
-		if repo := detectGitRepo(); repo != "" {
-			source.Repository = repo
-		}
 	}
-
 	if *addBranch != "" {
 		source.Branch = *addBranch
-	} else {
-		if branch := detectGitBranch(); branch != "" {
-			source.Branch = branch
-		}
-	}
-
-	if commit := detectGitCommit(); commit != "" {
-		source.Commit = commit
 	}
 
 	// Create tree
@@ -117,29 +146,32 @@ func runAdd(ctx context.Context, args []string) error {
 		},
 	}
 
-	// Load forest
-	forestPath := *addForest
-	if forestPath == "" {
-		forestPath = covforest.DefaultForestPath()
+	store, err := openStorage(*addForest)
+	if err != nil {
+		return err
 	}
-
-	forest, err := covforest.LoadFromFile(forestPath)
+	unlock, err := store.Lock()
 	if err != nil {
-		return fmt.Errorf("failed to load forest: %v", err)
+		return err
 	}
+	defer unlock()
 
-	// Add tree to forest
-	if err := forest.AddTree(forestTree); err != nil {
-		return fmt.Errorf("failed to add tree to forest: %v", err)
+	// Store the tree along with the raw coverage files it was loaded from,
+	// and the sources stamped on their pods.
+	metaDir, err := os.MkdirTemp("", "covforest-add-")
+	if err != nil {
+		return err
 	}
-
-	// Save forest
-	if err := forest.SaveToFile(forestPath); err != nil {
-		return fmt.Errorf("failed to save forest: %v", err)
+	defer os.RemoveAll(metaDir)
+	if err := writePodSources(metaDir, input, stamped); err != nil {
+		return fmt.Errorf("failed to write pod metadata: %v", err)
+	}
+	if err := store.Put(forestTree, *addInputDir, metaDir); err != nil {
+		return fmt.Errorf("failed to add tree to forest: %v", err)
 	}
 
 	fmt.Printf("Added tree %s (%s) to forest\n", forestTree.ID, forestTree.Name)
-	fmt.Printf("Forest saved to: %s\n", forestPath)
+	fmt.Printf("Forest saved to: %s\n", store.Dir())
 
 	summary := tree.Summary()
 	fmt.Printf("Coverage: %.1f%% (%d/%d lines, %d packages)\n",
@@ -148,6 +180,46 @@ func runAdd(ctx context.Context, args []string) error {
 	return nil
 }
 
+// writePodSources writes the source of each of pods to the pod metadata
+// file of the directory holding its meta-data file, recreated under dir.
+// The labels, links and timestamp of the input's metadata file for that
+// directory, if any, are kept. Where pods share a directory, the source of
+// the first is used.
+func writePodSources(dir string, input fs.FS, pods []*covutil.Pod) error {
+	written := make(map[string]bool)
+	for _, pod := range pods {
+		podDir := path.Dir(pod.Profile.Meta.FilePath)
+		if pod.Source == nil || written[podDir] {
+			continue
+		}
+		written[podDir] = true
+		md := &covutil.PodMetadata{}
+		name := path.Join(podDir, covutil.PodMetadataFile)
+		if f, err := input.Open(name); err == nil {
+			md, err = covutil.LoadPodMetadata(f, name)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		out := filepath.Join(dir, filepath.FromSlash(podDir))
+		if err := os.MkdirAll(out, 0755); err != nil {
+			return err
+		}
+		err := covutil.WritePodMetadata(out, &covutil.Pod{
+			ID:        md.ID,
+			Labels:    md.Labels,
+			Links:     md.Links,
+			Timestamp: md.Timestamp,
+			Source:    pod.Source,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func generateTreeID(name string, source covforest.TreeSource) string {
 	// Create a unique ID based on name, machine, and timestamp
 	id := strings.ToLower(name)
@@ -164,29 +236,17 @@ func generateTreeID(name string, source covforest.TreeSource) string {
 	return id
 }
 
-func detectGitRepo() string {
-	cmd := exec.Command("git", "config", "--get", "remote.origin.url")
-	output, err := cmd.Output()
-	if err != nil {
-		return ""
-	}
-	return strings.TrimSpace(string(output))
-}
-
-func detectGitBranch() string {
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-	output, err := cmd.Output()
-	if err != nil {
-		return ""
+// sourceDir returns the directory of the git repository to read the
+// version control state from: the -repo directory, or the input or
+// current directory if within a repository, or "" if none is.
+func sourceDir() string {
+	if fi, err := os.Stat(*addRepo); *addRepo != "" && err == nil && fi.IsDir() {
+		return *addRepo
 	}
-	return strings.TrimSpace(string(output))
-}
-
-func detectGitCommit() string {
-	cmd := exec.Command("git", "rev-parse", "HEAD")
-	output, err := cmd.Output()
-	if err != nil {
-		return ""
+	for _, dir := range []string{*addInputDir, "."} {
+		if _, err := vcs.Root(dir); err == nil {
+			return dir
+		}
 	}
-	return strings.TrimSpace(string(output))
+	return ""
 }
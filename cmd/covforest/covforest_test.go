@@ -11,6 +11,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/internal/covforest"
 )
 
 func TestCovforestHelp(t *testing.T) {
@@ -43,14 +47,17 @@ func TestCovforestCommands(t *testing.T) {
 		{"help add", []string{"help", "add"}, false},
 		{"help list", []string{"help", "list"}, false},
 		{"help summary", []string{"help", "summary"}, false},
+		{"help trend", []string{"help", "trend"}, false},
 		{"help serve", []string{"help", "serve"}, false},
 		{"help prune", []string{"help", "prune"}, false},
 		{"help sync", []string{"help", "sync"}, false},
 		{"add no args", []string{"add"}, true},
 		{"list empty forest", []string{"list"}, false},
 		{"summary empty forest", []string{"summary"}, false},
+		{"summary per-machine", []string{"summary", "-mode=per-machine"}, false},
+		{"summary unknown mode", []string{"summary", "-mode=sum"}, true},
 		{"prune empty forest", []string{"prune"}, false},
-		{"sync not implemented", []string{"sync"}, true},
+		{"sync no config", []string{"sync"}, true},
 	}
 
 	for _, tt := range tests {
@@ -321,3 +328,192 @@ func TestCovforestEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+// writeAddProfile writes a GOCOVERDIR-style pod to dir for a package
+// example.com/mod/a holding a single function Add with three units.
+func writeAddProfile(t *testing.T, dir string, counts []uint32) {
+	t.Helper()
+	profile := &covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode:        covutil.ModeCount,
+			Granularity: covutil.GranularityBlock,
+			Packages: []covutil.PackageMeta{{
+				Path:       "example.com/mod/a",
+				Name:       "a",
+				ModulePath: "example.com/mod",
+				Functions: []covutil.FuncDesc{{
+					PackagePath: "example.com/mod/a",
+					FuncName:    "Add",
+					SrcFile:     "example.com/mod/a/a.go",
+					Units: []covutil.CoverableUnit{
+						{StartLine: 3, StartCol: 24, EndLine: 4, EndCol: 11, NumStmt: 1},
+						{StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3, NumStmt: 1},
+						{StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 14, NumStmt: 1},
+					},
+				}},
+			}},
+		},
+		Counters: map[covutil.PkgFuncKey][]uint32{
+			{PkgPath: "example.com/mod/a", FuncName: "Add"}: counts,
+		},
+	}
+	if err := covutil.WriteProfileToDirectory(dir, profile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCovforestStorage(t *testing.T) {
+	dir := t.TempDir()
+	forestDir := filepath.Join(dir, "forest")
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("go", append([]string{"run", "."}, args...)...)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("covforest %v failed: %v\nOutput: %s", args, err, output)
+		}
+		return string(output)
+	}
+
+	for name, counts := range map[string][]uint32{"left": {1, 0, 1}, "right": {1, 1, 0}} {
+		covDir := filepath.Join(dir, name)
+		writeAddProfile(t, covDir, counts)
+		// -repo names a directory outside any git repository, so that
+		// no pod metadata is stored.
+		run("add", "-forest="+forestDir, "-name="+name, "-i="+covDir, "-machine=m", "-repo="+dir)
+	}
+	// Both runs share a meta-data file, which is stored once.
+	objects, _ := filepath.Glob(filepath.Join(forestDir, "objects", "*", "*"))
+	if len(objects) != 3 {
+		t.Errorf("forest holds %d objects, want 3", len(objects))
+	}
+
+	var list struct {
+		Trees []struct {
+			Summary *struct{ TotalLines, CoveredLines int } `json:"summary"`
+		} `json:"trees"`
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal([]byte(run("list", "-forest="+forestDir, "-format=json")), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Count != 2 || list.Trees[0].Summary == nil || list.Trees[0].Summary.TotalLines != 6 {
+		t.Errorf("unexpected list output %+v", list)
+	}
+
+	if out := run("summary", "-forest="+forestDir); !strings.Contains(out, "Trees: 2") || !strings.Contains(out, "Covered Lines: 6") {
+		t.Errorf("unexpected summary output:\n%s", out)
+	}
+
+	var trend covforest.Trend
+	if err := json.Unmarshal([]byte(run("trend", "-forest="+forestDir, "-pkg=example.com/...", "-format=json")), &trend); err != nil {
+		t.Fatal(err)
+	}
+	if len(trend.Packages) != 1 || trend.Packages[0].ImportPath != "example.com/mod/a" {
+		t.Errorf("unexpected trend %+v", trend)
+	}
+
+	if out := run("prune", "-forest="+forestDir, "-older-than=-1h"); !strings.Contains(out, "Pruned 2 trees") {
+		t.Errorf("unexpected prune output:\n%s", out)
+	}
+	if objects, _ := filepath.Glob(filepath.Join(forestDir, "objects", "*", "*")); len(objects) != 0 {
+		t.Errorf("%d objects left after pruning every tree", len(objects))
+	}
+}
+
+func TestCovforestAddSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "-b", "feature")
+	git("remote", "add", "origin", "https://example.com/mod.git")
+	covDir := filepath.Join(repo, "coverage")
+	writeAddProfile(t, covDir, []uint32{1, 0, 1})
+	git("add", ".")
+	git("commit", "-q", "-m", "coverage")
+	git("tag", "v0.1.0")
+
+	forestDir := filepath.Join(t.TempDir(), "forest")
+	cmd := exec.Command("go", "run", ".", "add", "-forest="+forestDir, "-name=src", "-i="+covDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("covforest add failed: %v\nOutput: %s", err, out)
+	}
+	store, err := covforest.OpenDirStorage(forestDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := store.Index()
+	if err != nil || len(idx.Trees) != 1 {
+		t.Fatalf("index: %v, %d trees", err, len(idx.Trees))
+	}
+	src := idx.Trees[0].Source
+	if src.Commit != git("rev-parse", "HEAD") || src.Branch != "feature" || src.Tag != "v0.1.0" ||
+		src.Repository != "https://example.com/mod.git" || src.CommitTime.IsZero() || src.Dirty {
+		t.Errorf("unexpected source %+v", src)
+	}
+
+	// The pods stored with the tree are stamped with the same source.
+	out := t.TempDir()
+	if err := store.ExtractPods(idx.Trees[0].ID, out); err != nil {
+		t.Fatal(err)
+	}
+	set, err := covutil.LoadCoverageSet(os.DirFS(out))
+	if err != nil || len(set.Pods) != 1 {
+		t.Fatalf("loading stored pods: %v", err)
+	}
+	if got := set.Pods[0].Source; got == nil || got.CommitSHA != src.Commit || got.Tag != "v0.1.0" {
+		t.Errorf("stored pod source = %+v, want commit %s", got, src.Commit)
+	}
+}
+
+func TestTrendOutput(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	points := []covforest.TrendPoint{
+		{Commit: "c1", TreeIDs: []string{"t1"}, Timestamp: at, TotalLines: 10, CoveredLines: 8, CoverageRate: 0.8},
+		{Commit: "c2", TreeIDs: []string{"t2", "t3"}, Timestamp: at.Add(time.Hour), TotalLines: 10, CoveredLines: 5, CoverageRate: 0.5},
+	}
+	trend := &covforest.Trend{
+		Packages:    []*covforest.PackageTrend{{ImportPath: "example.com/a", Points: points}},
+		Regressions: []*covforest.Regression{{ImportPath: "example.com/a", From: points[0], To: points[1], Drop: 30, Functions: []string{"F", "G"}}},
+	}
+
+	var buf strings.Builder
+	if err := outputTrendCSV(&buf, trend); err != nil {
+		t.Fatal(err)
+	}
+	want := `package,commit,trees,timestamp,total_lines,covered_lines,coverage_rate,drop,lost_functions
+example.com/a,c1,t1,2024-01-02T03:04:05Z,10,8,0.8000,,
+example.com/a,c2,t2;t3,2024-01-02T04:04:05Z,10,5,0.5000,30.00,F;G
+`
+	if buf.String() != want {
+		t.Errorf("CSV output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeSparkline(&buf, trend.Packages[0], trend.Regressions, 104, 24)
+	svg := buf.String()
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="104" height="24"`,
+		`points="2.0,6.0 102.0,12.0"`,
+		`<circle cx="102.0" cy="12.0" r="2.5" fill="#d73a49"/>`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("sparkline missing %q:\n%s", want, svg)
+		}
+	}
+}
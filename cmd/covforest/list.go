@@ -23,19 +23,19 @@ var cmdList = &Command{
 List displays all coverage trees in the forest with their metadata.
 
 The -format flag specifies the output format: "table" (default), "json", or "csv".
-The -forest flag specifies the forest file path (default: ~/.covforest/forest.json).
+The -forest flag specifies the forest directory (default: ~/.covforest/forest).
 
 Example:
 
 	covforest list
 	covforest list -format=json
-	covforest list -forest=/path/to/forest.json
+	covforest list -forest=/path/to/forest
 `,
 }
 
 var (
 	listFormat = cmdList.Flag.String("format", "table", "output format: table, json, csv")
-	listForest = cmdList.Flag.String("forest", "", "forest file path (default: ~/.covforest/forest.json)")
+	listForest = cmdList.Flag.String("forest", "", "forest directory (default: ~/.covforest/forest)")
 )
 
 func init() {
@@ -43,12 +43,13 @@ func init() {
 }
 
 func runList(ctx context.Context, args []string) error {
-	forestPath := *listForest
-	if forestPath == "" {
-		forestPath = covforest.DefaultForestPath()
+	store, err := openStorage(*listForest)
+	if err != nil {
+		return err
 	}
 
-	forest, err := covforest.LoadFromFile(forestPath)
+	// Listing only needs the index, not the coverage data of each tree
+	forest, err := covforest.LoadForestIndex(store)
 	if err != nil {
 		return fmt.Errorf("failed to load forest: %v", err)
 	}
@@ -76,8 +77,7 @@ func outputListTable(trees []*covforest.Tree) error {
 
 	for _, tree := range trees {
 		coverage := "N/A"
-		if tree.CoverageTree != nil {
-			summary := tree.CoverageTree.Summary()
+		if summary, ok := tree.CoverageSummary(); ok {
 			coverage = fmt.Sprintf("%.1f%%", summary.CoverageRate*100)
 		}
 
@@ -118,8 +118,7 @@ func outputListCSV(trees []*covforest.Tree) error {
 		totalLines := "0"
 		coveredLines := "0"
 
-		if tree.CoverageTree != nil {
-			summary := tree.CoverageTree.Summary()
+		if summary, ok := tree.CoverageSummary(); ok {
 			coverageRate = fmt.Sprintf("%.4f", summary.CoverageRate)
 			totalLines = fmt.Sprintf("%d", summary.TotalLines)
 			coveredLines = fmt.Sprintf("%d", summary.CoveredLines)
@@ -14,6 +14,7 @@
 //	add		add a coverage tree to the forest
 //	list		list all coverage trees in the forest
 //	summary		show summary statistics across all trees
+//	trend		show coverage over time and detect regressions
 //	serve		start HTTP server for exploring the forest
 //	prune		remove old or invalid coverage trees
 //	sync		synchronize trees from remote sources
@@ -29,6 +30,8 @@ import (
 	"log"
 	"os"
 	"strings"
+
+	"github.com/tmc/covutil/internal/covforest"
 )
 
 func main() {
@@ -153,6 +156,7 @@ var commands = []*Command{
 	cmdAdd,
 	cmdList,
 	cmdSummary,
+	cmdTrend,
 	cmdServe,
 	cmdPrune,
 	cmdSync,
@@ -171,3 +175,16 @@ func init() {
 		cmd.Name = name
 	}
 }
+
+// openStorage opens the forest stored in dir, or in the default location
+// if dir is empty.
+func openStorage(dir string) (*covforest.DirStorage, error) {
+	if dir == "" {
+		dir = covforest.DefaultForestPath()
+	}
+	s, err := covforest.OpenDirStorage(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open forest: %v", err)
+	}
+	return s, nil
+}
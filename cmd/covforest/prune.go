@@ -21,7 +21,7 @@ Prune removes coverage trees from the forest based on age or validity.
 The -older-than flag specifies the age threshold (e.g., "30d", "1w", "24h").
 Trees older than this threshold will be removed.
 
-The -forest flag specifies the forest file path (default: ~/.covforest/forest.json).
+The -forest flag specifies the forest directory (default: ~/.covforest/forest).
 
 Example:
 
@@ -32,7 +32,7 @@ Example:
 
 var (
 	pruneOlderThan = cmdPrune.Flag.String("older-than", "", "remove trees older than this duration (e.g., 30d, 1w, 24h)")
-	pruneForest    = cmdPrune.Flag.String("forest", "", "forest file path (default: ~/.covforest/forest.json)")
+	pruneForest    = cmdPrune.Flag.String("forest", "", "forest directory (default: ~/.covforest/forest)")
 )
 
 func init() {
@@ -40,12 +40,17 @@ func init() {
 }
 
 func runPrune(ctx context.Context, args []string) error {
-	forestPath := *pruneForest
-	if forestPath == "" {
-		forestPath = covforest.DefaultForestPath()
+	store, err := openStorage(*pruneForest)
+	if err != nil {
+		return err
+	}
+	unlock, err := store.Lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	forest, err := covforest.LoadFromFile(forestPath)
+	forest, err := covforest.LoadForestIndex(store)
 	if err != nil {
 		return fmt.Errorf("failed to load forest: %v", err)
 	}
@@ -68,13 +73,13 @@ func runPrune(ctx context.Context, args []string) error {
 			shouldRemove = true
 		}
 
-		// Remove if coverage tree is nil (invalid)
-		if tree.CoverageTree == nil {
+		// Remove if the tree has no coverage data (invalid)
+		if _, ok := tree.CoverageSummary(); !ok {
 			shouldRemove = true
 		}
 
 		if shouldRemove {
-			if err := forest.RemoveTree(id); err != nil {
+			if err := store.Delete(id); err != nil {
 				return fmt.Errorf("failed to remove tree %s: %v", id, err)
 			}
 			removed = append(removed, id)
@@ -86,16 +91,11 @@ func runPrune(ctx context.Context, args []string) error {
 		return nil
 	}
 
-	// Save updated forest
-	if err := forest.SaveToFile(forestPath); err != nil {
-		return fmt.Errorf("failed to save forest: %v", err)
-	}
-
 	fmt.Printf("Pruned %d trees:\n", len(removed))
 	for _, id := range removed {
 		fmt.Printf("  - %s\n", id)
 	}
-	fmt.Printf("Forest saved to: %s\n", forestPath)
+	fmt.Printf("Forest saved to: %s\n", store.Dir())
 
 	return nil
 }
@@ -11,6 +11,7 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/tmc/covutil/internal/covforest"
@@ -23,20 +24,25 @@ var cmdServe = &Command{
 Serve starts an HTTP server that provides an interactive web interface
 for exploring coverage data across multiple trees in the forest.
 
+The index page shows the coverage trend of each package as a sparkline,
+computed as by "covforest trend". The trend is also served as JSON at
+/api/trend, and as an SVG sparkline at /trend.svg?pkg=<import path>; both
+accept branch, pkg and threshold query parameters.
+
 The -http flag specifies the address and port to listen on (default: ":8080").
-The -forest flag specifies the forest file path (default: ~/.covforest/forest.json).
+The -forest flag specifies the forest directory (default: ~/.covforest/forest).
 
 Example:
 
 	covforest serve
 	covforest serve -http=:9000
-	covforest serve -forest=/path/to/forest.json
+	covforest serve -forest=/path/to/forest
 `,
 }
 
 var (
 	serveHTTPAddr = cmdServe.Flag.String("http", ":8080", "HTTP server address")
-	serveForest   = cmdServe.Flag.String("forest", "", "forest file path (default: ~/.covforest/forest.json)")
+	serveForest   = cmdServe.Flag.String("forest", "", "forest directory (default: ~/.covforest/forest)")
 )
 
 func init() {
@@ -44,12 +50,12 @@ func init() {
 }
 
 func runServe(ctx context.Context, args []string) error {
-	forestPath := *serveForest
-	if forestPath == "" {
-		forestPath = covforest.DefaultForestPath()
+	store, err := openStorage(*serveForest)
+	if err != nil {
+		return err
 	}
 
-	forest, err := covforest.LoadFromFile(forestPath)
+	forest, err := covforest.LoadForest(store)
 	if err != nil {
 		return fmt.Errorf("failed to load forest: %v", err)
 	}
@@ -60,8 +66,42 @@ func runServe(ctx context.Context, args []string) error {
 		serveForestIndex(w, r, forest)
 	})
 	mux.HandleFunc("/api/summary", func(w http.ResponseWriter, r *http.Request) {
+		mode := covforest.SummaryUnion
+		if m := r.URL.Query().Get("mode"); m != "" {
+			mode = covforest.SummaryMode(m)
+		}
+		summary, err := forest.SummaryBy(mode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	})
+	mux.HandleFunc("/api/trend", func(w http.ResponseWriter, r *http.Request) {
+		trend, err := trendFromQuery(forest, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(forest.Summary())
+		json.NewEncoder(w).Encode(trend)
+	})
+	mux.HandleFunc("/trend.svg", func(w http.ResponseWriter, r *http.Request) {
+		trend, err := trendFromQuery(forest, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pkg := r.URL.Query().Get("pkg")
+		for _, pt := range trend.Packages {
+			if pt.ImportPath == pkg {
+				w.Header().Set("Content-Type", "image/svg+xml")
+				writeSparkline(w, pt, trend.Regressions, 160, 32)
+				return
+			}
+		}
+		http.NotFound(w, r)
 	})
 	mux.HandleFunc("/api/trees", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -79,7 +119,7 @@ func runServe(ctx context.Context, args []string) error {
 	})
 
 	log.Printf("covforest: serving forest at http://%s", *serveHTTPAddr)
-	log.Printf("covforest: loaded %d trees from %s", len(forest.Trees), forestPath)
+	log.Printf("covforest: loaded %d trees from %s", len(forest.Trees), store.Dir())
 
 	server := &http.Server{
 		Addr:    *serveHTTPAddr,
@@ -89,6 +129,21 @@ func runServe(ctx context.Context, args []string) error {
 	return server.ListenAndServe()
 }
 
+// trendFromQuery computes the trend selected by the branch, pkg and
+// threshold query parameters of r.
+func trendFromQuery(forest *covforest.Forest, r *http.Request) (*covforest.Trend, error) {
+	q := r.URL.Query()
+	opts := covforest.TrendOptions{Branch: q.Get("branch"), Package: q.Get("pkg"), Threshold: 1}
+	if s := q.Get("threshold"); s != "" {
+		t, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q", s)
+		}
+		opts.Threshold = t
+	}
+	return forest.Trend(opts), nil
+}
+
 func serveForestIndex(w http.ResponseWriter, r *http.Request, forest *covforest.Forest) {
 	tmpl := template.Must(template.New("index").Funcs(template.FuncMap{
 		"mult": func(a, b float64) float64 { return a * b },
@@ -197,6 +252,13 @@ const forestIndexTemplate = `<!DOCTYPE html>
 			</div>
 		</div>
 
+		<div class="trees-section">
+			<h3>Coverage Trend</h3>
+			<div id="trend-list">
+				<div class="loading">Loading trend...</div>
+			</div>
+		</div>
+
 		<div class="trees-section">
 			<h3>Coverage Trees</h3>
 			<div id="trees-list">
@@ -248,8 +310,51 @@ const forestIndexTemplate = `<!DOCTYPE html>
 				});
 		}
 
-		// Load trees on page load
+		function escapeHTML(s) {
+			return String(s).replace(/[&<>"']/g, c => ({'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'})[c]);
+		}
+
+		function loadTrend() {
+			fetch('/api/trend')
+				.then(response => response.json())
+				.then(trend => {
+					const container = document.getElementById('trend-list');
+					if (!trend.packages || trend.packages.length === 0) {
+						container.innerHTML = '<div class="loading">No trend data.</div>';
+						return;
+					}
+					const regressions = {};
+					(trend.regressions || []).forEach(r => {
+						(regressions[r.import_path] = regressions[r.import_path] || []).push(r);
+					});
+					let html = '<table style="width: 100%; border-collapse: collapse;">';
+					trend.packages.forEach(pkg => {
+						const last = pkg.points[pkg.points.length - 1];
+						const notes = (regressions[pkg.import_path] || []).map(r =>
+							'-' + r.drop.toFixed(1) + ' points at ' + escapeHTML(r.to.commit || r.to.tree_ids.join(',')) +
+							(r.functions ? ': ' + r.functions.map(escapeHTML).join(', ') : '')).join('<br>');
+						html += ` + "`" + `
+							<tr style="border-bottom: 1px solid #e1e4e8;">
+								<td style="padding: 6px;">${escapeHTML(pkg.import_path)}</td>
+								<td style="padding: 6px;"><img alt="" src="/trend.svg?pkg=${encodeURIComponent(pkg.import_path)}"></td>
+								<td style="padding: 6px; text-align: right;">${(last.coverage_rate * 100).toFixed(1)}%</td>
+								<td style="padding: 6px; color: #d73a49; font-size: 0.9em;">${notes}</td>
+							</tr>
+						` + "`" + `;
+					});
+					html += '</table>';
+					container.innerHTML = html;
+				})
+				.catch(error => {
+					console.error('Error loading trend:', error);
+					document.getElementById('trend-list').innerHTML =
+						'<div class="loading">Error loading trend.</div>';
+				});
+		}
+
+		// Load trees and trend on page load
 		loadTrees();
+		loadTrend();
 	</script>
 </body>
 </html>`
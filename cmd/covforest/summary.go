@@ -15,24 +15,42 @@ import (
 )
 
 var cmdSummary = &Command{
-	UsageLine: "covforest summary [-format=<format>] [-forest=<path>]",
+	UsageLine: "covforest summary [-mode=<mode>] [-format=<format>] [-forest=<path>]",
 	Short:     "show summary statistics across all trees",
 	Long: `
 Summary displays aggregate statistics across all coverage trees in the forest.
 
+Trees are merged line by line rather than added up: a line is covered if
+any of the merged trees covers it, and lines collected by several trees
+are counted once. The -mode flag selects which trees are merged:
+
+	union        all trees (default)
+	latest       the most recent tree of each branch
+	per-machine  all trees, also reporting the union of each machine's trees
+	per-os       all trees, also reporting the union of each OS's trees
+	per-branch   all trees, also reporting the union of each branch's trees
+
+Lines are matched by file and position, which assumes the merged trees
+were collected from the same source. Merging trees of different commits
+counts code that moved between them once at each position, overstating
+the total and covered lines. For exact figures, summarize a forest
+holding the trees of a single commit.
+
 The -format flag specifies the output format: "table" (default) or "json".
-The -forest flag specifies the forest file path (default: ~/.covforest/forest.json).
+The -forest flag specifies the forest directory (default: ~/.covforest/forest).
 
 Example:
 
 	covforest summary
-	covforest summary -format=json
+	covforest summary -mode=latest
+	covforest summary -mode=per-machine -format=json
 `,
 }
 
 var (
+	summaryMode   = cmdSummary.Flag.String("mode", "union", "aggregation mode: union, latest, per-machine, per-os, per-branch")
 	summaryFormat = cmdSummary.Flag.String("format", "table", "output format: table, json")
-	summaryForest = cmdSummary.Flag.String("forest", "", "forest file path (default: ~/.covforest/forest.json)")
+	summaryForest = cmdSummary.Flag.String("forest", "", "forest directory (default: ~/.covforest/forest)")
 )
 
 func init() {
@@ -40,17 +58,20 @@ func init() {
 }
 
 func runSummary(ctx context.Context, args []string) error {
-	forestPath := *summaryForest
-	if forestPath == "" {
-		forestPath = covforest.DefaultForestPath()
+	store, err := openStorage(*summaryForest)
+	if err != nil {
+		return err
 	}
 
-	forest, err := covforest.LoadFromFile(forestPath)
+	forest, err := covforest.LoadForest(store)
 	if err != nil {
 		return fmt.Errorf("failed to load forest: %v", err)
 	}
 
-	summary := forest.Summary()
+	summary, err := forest.SummaryBy(covforest.SummaryMode(*summaryMode))
+	if err != nil {
+		return err
+	}
 
 	switch *summaryFormat {
 	case "json":
@@ -63,29 +84,39 @@ func runSummary(ctx context.Context, args []string) error {
 func outputSummaryTable(summary covforest.ForestSummary) error {
 	fmt.Printf("Forest Summary\n")
 	fmt.Printf("=============\n")
+	fmt.Printf("Mode: %s\n", summary.Mode)
 	fmt.Printf("Trees: %d\n", summary.TreeCount)
 	fmt.Printf("Total Lines: %s\n", formatNumber(summary.TotalLines))
 	fmt.Printf("Covered Lines: %s\n", formatNumber(summary.CoveredLines))
 	fmt.Printf("Coverage Rate: %.2f%%\n\n", summary.CoverageRate*100)
 
+	if len(summary.Groups) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "GROUP\tTREES\tCOVERED\tTOTAL\tCOVERAGE")
+		for _, g := range summary.Groups {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%.1f%%\n",
+				g.Key, len(g.TreeIDs), formatNumber(g.CoveredLines), formatNumber(g.TotalLines), g.CoverageRate*100)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+
 	if len(summary.Packages) > 0 {
 		fmt.Printf("Package Coverage Across Trees\n")
 		fmt.Printf("============================\n")
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(w, "PACKAGE\tTREES\tAVG COVERAGE\tRANGE")
+		fmt.Fprintln(w, "PACKAGE\tTREES\tCOVERAGE\tRANGE")
 
 		for _, pkg := range summary.Packages {
 			if len(pkg.Trees) == 0 {
 				continue
 			}
 
-			// Calculate average and range
-			var total float64
+			// Calculate the range across trees
 			minCov := pkg.Trees[0].CoverageRate
 			maxCov := pkg.Trees[0].CoverageRate
 
 			for _, tree := range pkg.Trees {
-				total += tree.CoverageRate
 				if tree.CoverageRate < minCov {
 					minCov = tree.CoverageRate
 				}
@@ -94,11 +125,10 @@ func outputSummaryTable(summary covforest.ForestSummary) error {
 				}
 			}
 
-			avg := total / float64(len(pkg.Trees))
 			rangeStr := fmt.Sprintf("%.1f%%-%.1f%%", minCov*100, maxCov*100)
 
 			fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%s\n",
-				pkg.ImportPath, len(pkg.Trees), avg*100, rangeStr)
+				pkg.ImportPath, len(pkg.Trees), pkg.CoverageRate*100, rangeStr)
 		}
 
 		w.Flush()
@@ -7,32 +7,53 @@ package main
 import (
 	"context"
 	"fmt"
+
+	"github.com/tmc/covutil/internal/covforest"
 )
 
 var cmdSync = &Command{
-	UsageLine: "covforest sync [-config=<file>]",
+	UsageLine: "covforest sync -config=<file> [-forest=<path>] [-cache=<dir>]",
 	Short:     "synchronize trees from remote sources",
 	Long: `
-Sync synchronizes coverage trees from remote sources defined in a configuration file.
+Sync synchronizes coverage trees from sources defined in a configuration file.
 
 The -config flag specifies the sync configuration file path.
+The -forest flag specifies the forest directory (default: ~/.covforest/forest).
+The -cache flag overrides the directory where fetched snapshots are stored
+(default: the cache_dir from the configuration, or ~/.covforest/cache).
+
+Each source is fetched into the cache and added to the forest as a tree.
+Sync is idempotent: a commit or artifact that is already present in the
+forest is not added again.
 
 Example:
 
-	covforest sync -config=sync.yaml
+	covforest sync -config=sync.json
+
+The configuration file is JSON and lists the sources to synchronize:
 
-The sync configuration file defines remote sources such as:
-- Git repositories with coverage data
-- CI/CD artifact storage
-- Remote file systems
-- HTTP endpoints serving coverage data
+	{
+	  "cache_dir": "/var/cache/covforest",
+	  "sources": [
+	    {"name": "local", "type": "dir", "path": "./coverage"},
+	    {"name": "nightly", "type": "tarball", "path": "artifacts/cov.tar.gz"},
+	    {"name": "ci", "type": "http", "url": "https://ci.example.com/cov.tar.gz", "machine": "ci-worker-1"},
+	    {"name": "main", "type": "git", "url": "https://github.com/example/repo.git", "ref": "main", "path": "coverage"}
+	  ]
+	}
 
-This command is currently a placeholder for future implementation.
+Source types:
+- dir: a local GOCOVERDIR directory
+- tarball: a local .tar or .tar.gz archive of a GOCOVERDIR directory
+- http: an HTTP endpoint serving a .tar or .tar.gz archive
+- git: a directory committed to a git repository at a given ref
 `,
 }
 
 var (
 	syncConfig = cmdSync.Flag.String("config", "", "sync configuration file path")
+	syncForest = cmdSync.Flag.String("forest", "", "forest directory (default: ~/.covforest/forest)")
+	syncCache  = cmdSync.Flag.String("cache", "", "cache directory for fetched snapshots")
 )
 
 func init() {
@@ -40,5 +61,61 @@ func init() {
 }
 
 func runSync(ctx context.Context, args []string) error {
-	return fmt.Errorf("sync command is not yet implemented - coming soon!")
+	if *syncConfig == "" {
+		return fmt.Errorf("must specify sync configuration with -config flag")
+	}
+
+	cfg, err := covforest.LoadSyncConfig(*syncConfig)
+	if err != nil {
+		return err
+	}
+	if *syncCache != "" {
+		cfg.CacheDir = *syncCache
+	}
+
+	store, err := openStorage(*syncForest)
+	if err != nil {
+		return err
+	}
+	unlock, err := store.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Deduplication only needs the index, not the coverage data of each tree
+	forest, err := covforest.LoadForestIndex(store)
+	if err != nil {
+		return fmt.Errorf("failed to load forest: %v", err)
+	}
+
+	results := forest.Sync(ctx, cfg)
+
+	var added, failed int
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			failed++
+			fmt.Printf("%s: error: %v\n", r.Name, r.Err)
+		case r.Added:
+			if err := store.Put(forest.Trees[r.TreeID], r.Dir); err != nil {
+				failed++
+				fmt.Printf("%s: error: %v\n", r.Name, err)
+				continue
+			}
+			added++
+			fmt.Printf("%s: added tree %s\n", r.Name, r.TreeID)
+		default:
+			fmt.Printf("%s: up to date (%s)\n", r.Name, r.TreeID)
+		}
+	}
+
+	if added > 0 {
+		fmt.Printf("Forest saved to: %s\n", store.Dir())
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sources failed to sync", failed, len(results))
+	}
+	return nil
 }
@@ -0,0 +1,210 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tmc/covutil/internal/covforest"
+)
+
+var cmdTrend = &Command{
+	UsageLine: "covforest trend [-branch=<branch>] [-pkg=<pattern>] [-threshold=<points>] [-format=<format>] [-forest=<path>]",
+	Short:     "show coverage over time and detect regressions",
+	Long: `
+Trend shows the coverage of each package at successive commits, in the
+order the trees were collected. Trees collected at the same commit are
+merged into a single data point.
+
+A package whose coverage drops by more than -threshold percentage points
+between consecutive commits is reported as a regression, along with the
+functions that had units covered at the earlier commit and not at the
+later one.
+
+The -branch flag restricts the trend to trees of a branch.
+The -pkg flag restricts the trend to packages matching a pattern, such as
+"example.com/app/..." (default: all packages).
+The -threshold flag sets the regression threshold (default: 1).
+The -format flag specifies the output format: "table" (default), "csv" or "json".
+The -forest flag specifies the forest directory (default: ~/.covforest/forest).
+
+The trend is also shown, as sparklines, by "covforest serve".
+
+Example:
+
+	covforest trend -branch=main
+	covforest trend -branch=main -pkg=example.com/app/... -format=csv
+	covforest trend -threshold=5 -format=json
+`,
+}
+
+var (
+	trendBranch    = cmdTrend.Flag.String("branch", "", "only use trees of this branch")
+	trendPackage   = cmdTrend.Flag.String("pkg", "", "only show packages matching this pattern")
+	trendThreshold = cmdTrend.Flag.Float64("threshold", 1, "report drops of more than this many percentage points")
+	trendFormat    = cmdTrend.Flag.String("format", "table", "output format: table, csv, json")
+	trendForest    = cmdTrend.Flag.String("forest", "", "forest directory (default: ~/.covforest/forest)")
+)
+
+func init() {
+	cmdTrend.Run = runTrend
+}
+
+func runTrend(ctx context.Context, args []string) error {
+	store, err := openStorage(*trendForest)
+	if err != nil {
+		return err
+	}
+
+	forest, err := covforest.LoadForest(store)
+	if err != nil {
+		return fmt.Errorf("failed to load forest: %v", err)
+	}
+
+	trend := forest.Trend(covforest.TrendOptions{
+		Branch:    *trendBranch,
+		Package:   *trendPackage,
+		Threshold: *trendThreshold,
+	})
+
+	switch *trendFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(trend)
+	case "csv":
+		return outputTrendCSV(os.Stdout, trend)
+	default:
+		return outputTrendTable(trend)
+	}
+}
+
+func outputTrendTable(trend *covforest.Trend) error {
+	if len(trend.Packages) == 0 {
+		fmt.Println("No coverage trees found in forest.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tPOINTS\tFIRST\tLATEST\tTREND")
+	for _, pkg := range trend.Packages {
+		first, last := pkg.Points[0], pkg.Points[len(pkg.Points)-1]
+		rates := make([]string, len(pkg.Points))
+		for i, p := range pkg.Points {
+			rates[i] = fmt.Sprintf("%.1f", p.CoverageRate*100)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%.1f%%\t%s\n",
+			pkg.ImportPath, len(pkg.Points), first.CoverageRate*100, last.CoverageRate*100, strings.Join(rates, " "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(trend.Regressions) > 0 {
+		fmt.Printf("\nRegressions\n")
+		fmt.Printf("===========\n")
+		for _, r := range trend.Regressions {
+			fmt.Printf("%s: %.1f%% -> %.1f%% (-%.1f points) at %s\n",
+				r.ImportPath, r.From.CoverageRate*100, r.To.CoverageRate*100, r.Drop, pointName(r.To))
+			for _, fn := range r.Functions {
+				fmt.Printf("\t%s\n", fn)
+			}
+		}
+	}
+	return nil
+}
+
+// outputTrendCSV writes one row per package and commit. Rows at which a
+// regression was detected carry the drop and the functions that lost
+// coverage, separated by semicolons.
+func outputTrendCSV(out io.Writer, trend *covforest.Trend) error {
+	type key struct{ pkg, name string }
+	regressions := make(map[key]*covforest.Regression)
+	for _, r := range trend.Regressions {
+		regressions[key{r.ImportPath, pointName(r.To)}] = r
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"package", "commit", "trees", "timestamp", "total_lines", "covered_lines", "coverage_rate", "drop", "lost_functions"})
+	for _, pkg := range trend.Packages {
+		for _, p := range pkg.Points {
+			drop, lost := "", ""
+			if r := regressions[key{pkg.ImportPath, pointName(p)}]; r != nil {
+				drop = fmt.Sprintf("%.2f", r.Drop)
+				lost = strings.Join(r.Functions, ";")
+			}
+			w.Write([]string{
+				pkg.ImportPath,
+				p.Commit,
+				strings.Join(p.TreeIDs, ";"),
+				p.Timestamp.Format(time.RFC3339),
+				fmt.Sprint(p.TotalLines),
+				fmt.Sprint(p.CoveredLines),
+				fmt.Sprintf("%.4f", p.CoverageRate),
+				drop,
+				lost,
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// pointName identifies a trend point by its commit, or by its tree for
+// trees without a commit.
+func pointName(p covforest.TrendPoint) string {
+	if p.Commit != "" {
+		return p.Commit
+	}
+	return strings.Join(p.TreeIDs, ",")
+}
+
+// writeSparkline writes an SVG polyline of coverage rates, scaled so that
+// the bottom of the image is 0% and the top 100%. Points at which a
+// regression was detected are marked in red.
+func writeSparkline(w io.Writer, pkg *covforest.PackageTrend, regressions []*covforest.Regression, width, height int) {
+	const pad = 2
+	regressed := make(map[string]bool)
+	for _, r := range regressions {
+		if r.ImportPath == pkg.ImportPath {
+			regressed[pointName(r.To)] = true
+		}
+	}
+	x := func(i int) float64 {
+		if len(pkg.Points) < 2 {
+			return float64(width) / 2
+		}
+		return pad + float64(i)*float64(width-2*pad)/float64(len(pkg.Points)-1)
+	}
+	y := func(rate float64) float64 {
+		return pad + (1-rate)*float64(height-2*pad)
+	}
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="#f6f8fa"/>`, width, height)
+	var points []string
+	for i, p := range pkg.Points {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x(i), y(p.CoverageRate)))
+	}
+	fmt.Fprintf(w, `<polyline fill="none" stroke="#0366d6" stroke-width="1.5" points="%s"/>`, strings.Join(points, " "))
+	for i, p := range pkg.Points {
+		if regressed[pointName(p)] {
+			fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="2.5" fill="#d73a49"/>`, x(i), y(p.CoverageRate))
+		}
+	}
+	if n := len(pkg.Points); n > 0 {
+		last := pkg.Points[n-1]
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="2" fill="#0366d6"><title>%.1f%%</title></circle>`, x(n-1), y(last.CoverageRate), last.CoverageRate*100)
+	}
+	fmt.Fprint(w, `</svg>`)
+}
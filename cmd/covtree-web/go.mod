@@ -2,9 +2,6 @@ module github.com/tmc/covutil/cmd/covtree-web
 
 go 1.24.3
 
-require (
-	github.com/fsnotify/fsnotify v1.9.0
-	github.com/tmc/covutil v0.0.0-20250524112448-1cfc002fa05c
-)
+require github.com/tmc/covutil v0.0.0-20250524112448-1cfc002fa05c
 
-require golang.org/x/sys v0.13.0 // indirect
+replace github.com/tmc/covutil => ../..
@@ -13,8 +13,13 @@
 //	-i directory    input directory to scan recursively for coverage data
 //	-http address   HTTP server address (default :8080)
 //	-title string   custom title for the web interface
+//	-src dirs       comma-separated module roots used to locate source files
 //	-open           open browser automatically after starting server
 //
+// Clicking a function shows its source, annotated with coverage. Source
+// files are located in the modules named by -src and otherwise with
+// "go list" from the current directory.
+//
 // Example:
 //
 //	covtree-web -i=./coverage -http=:9000 -title="My Project Coverage"
@@ -44,6 +49,7 @@ var (
 	title       = flag.String("title", "Coverage Report", "custom title for the web interface")
 	openBrowser = flag.Bool("open", false, "open browser automatically after starting server")
 	watch       = flag.Bool("watch", false, "watch directory for changes and reload automatically")
+	srcDirs     = flag.String("src", "", "comma-separated module root directories used to locate source files")
 )
 
 func main() {
@@ -64,33 +70,38 @@ func main() {
 		log.Fatalf("input directory does not exist: %s", *inputDir)
 	}
 
-	// Load coverage data from nested repository
-	tree := covtree.NewCoverageTree()
-	log.Printf("loading coverage data from %s...", *inputDir)
-	if err := tree.LoadFromNestedRepository(*inputDir); err != nil {
-		log.Fatalf("failed to load coverage data from %s: %v", *inputDir, err)
+	sources := covtree.NewSourceResolver()
+	for _, dir := range strings.Split(*srcDirs, ",") {
+		if dir = strings.TrimSpace(dir); dir == "" {
+			continue
+		}
+		if err := sources.AddModuleRoot(dir); err != nil {
+			log.Fatalf("invalid -src directory: %v", err)
+		}
 	}
 
 	// Create web server
 	server := &WebServer{
-		Tree:     tree,
 		Title:    *title,
 		HTTPAddr: *httpAddr,
+		Sources:  sources,
 	}
 
-	// Set up file watching if requested
-	var watchedServer *WatchedWebServer
+	// Load coverage data from nested repository, or watch it for changes.
+	// When watching, the directory may not hold any data yet.
+	log.Printf("loading coverage data from %s...", *inputDir)
 	if *watch {
-		var err error
-		watchedServer, err = NewWatchedWebServer(server, *inputDir)
+		watcher, err := covtree.NewWatcher(*inputDir, 0)
 		if err != nil {
-			log.Fatalf("failed to create watcher: %v", err)
+			log.Fatalf("failed to watch %s: %v", *inputDir, err)
 		}
-		defer watchedServer.Close()
-
-		ctx := context.Background()
-		if err := watchedServer.StartWatching(ctx); err != nil {
-			log.Fatalf("failed to start watching: %v", err)
+		go watcher.Run(context.Background())
+		server.Watcher = watcher
+		log.Printf("watching %s for coverage data changes", *inputDir)
+	} else {
+		server.Tree = covtree.NewCoverageTree()
+		if err := server.Tree.LoadFromNestedRepository(*inputDir); err != nil {
+			log.Fatalf("failed to load coverage data from %s: %v", *inputDir, err)
 		}
 	}
 
@@ -103,7 +114,7 @@ func main() {
 		Handler: mux,
 	}
 
-	log.Printf("loaded %d packages from %s", len(tree.Packages), *inputDir)
+	log.Printf("loaded %d packages from %s", len(server.tree().Packages), *inputDir)
 	if *watch {
 		log.Printf("serving coverage web interface at http://localhost%s (with auto-reload)", *httpAddr)
 	} else {
@@ -135,8 +146,9 @@ The flags are:
 	-i directory    input directory to scan recursively for coverage data
 	-http address   HTTP server address (default :8080)
 	-title string   custom title for the web interface
+	-src dirs       comma-separated module roots used to locate source files
 	-open           open browser automatically after starting server
-	-watch          watch directory for changes and reload automatically
+	-watch          watch directory for changes and update the page live
 
 Example:
 
@@ -149,6 +161,12 @@ type WebServer struct {
 	Tree     *covtree.CoverageTree
 	Title    string
 	HTTPAddr string
+	// Sources locates the source files shown by /api/file and
+	// /api/function. If nil, files are looked up with "go list".
+	Sources *covtree.SourceResolver
+	// Watcher, if set, supplies the coverage tree in place of Tree and
+	// streams its changes from /api/events.
+	Watcher *covtree.Watcher
 }
 
 // SetupRoutes configures HTTP routes for the web server
@@ -157,8 +175,21 @@ func (s *WebServer) SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/summary", s.handleAPISummary)
 	mux.HandleFunc("/api/packages", s.handleAPIPackages)
 	mux.HandleFunc("/api/package/", s.handleAPIPackage)
+	mux.HandleFunc("/api/file", s.handleAPIFile)
+	mux.HandleFunc("/api/function", s.handleAPIFunction)
 	mux.HandleFunc("/api/health", s.handleAPIHealth)
 	mux.HandleFunc("/favicon.ico", s.handleFavicon)
+	if s.Watcher != nil {
+		mux.HandleFunc("/api/events", s.Watcher.ServeEvents)
+	}
+}
+
+// tree returns the coverage tree to serve.
+func (s *WebServer) tree() *covtree.CoverageTree {
+	if s.Watcher != nil {
+		return s.Watcher.Tree()
+	}
+	return s.Tree
 }
 
 func (s *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -174,9 +205,11 @@ func (s *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 	data := struct {
 		Title   string
 		Summary interface{}
+		Watch   bool
 	}{
 		Title:   s.Title,
-		Summary: s.Tree.Summary(),
+		Summary: s.tree().Summary(),
+		Watch:   s.Watcher != nil,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -188,19 +221,27 @@ func (s *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 func (s *WebServer) handleAPISummary(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.Tree.Summary())
+	json.NewEncoder(w).Encode(s.tree().Summary())
 }
 
 func (s *WebServer) handleAPIPackages(w http.ResponseWriter, r *http.Request) {
 	filterObj := parseFilterFromQuery(r)
-	packages := s.Tree.FilterPackages(filterObj)
+	packages := s.tree().FilterPackages(filterObj)
+	if expr := r.URL.Query().Get("q"); expr != "" {
+		q, err := covtree.ParseQuery(expr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		packages = q.Filter(packages)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(packages)
 }
 
 func (s *WebServer) handleAPIPackage(w http.ResponseWriter, r *http.Request) {
 	packagePath := strings.TrimPrefix(r.URL.Path, "/api/package/")
-	pkg := s.Tree.GetPackage(packagePath)
+	pkg := s.tree().GetPackage(packagePath)
 	if pkg == nil {
 		http.NotFound(w, r)
 		return
@@ -213,7 +254,7 @@ func (s *WebServer) handleAPIHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":         "ok",
-		"total_packages": len(s.Tree.Packages),
+		"total_packages": len(s.tree().Packages),
 		"title":          s.Title,
 		"timestamp":      time.Now().Unix(),
 	})
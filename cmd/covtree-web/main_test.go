@@ -5,14 +5,18 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/tmc/covutil"
 	"github.com/tmc/covutil/covtree"
 )
 
@@ -308,3 +312,200 @@ func TestFilterParsing(t *testing.T) {
 		})
 	}
 }
+
+const sourceTestFile = `package a
+
+func Add(x, y int) int {
+	if x < 0 {
+		return 0
+	}
+	return x + y
+}
+`
+
+// sourceTestServer returns a server for a tree with one function, Add,
+// whose source lives in a module rooted in a temporary directory.
+func sourceTestServer(t *testing.T) *WebServer {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/mod\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "a.go"), []byte(sourceTestFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sources := &covtree.SourceResolver{}
+	if err := sources.AddModuleRoot(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := covtree.NewCoverageTreeFromProfile(&covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode: covutil.ModeCount,
+			Packages: []covutil.PackageMeta{{
+				Path: "example.com/mod/a",
+				Name: "a",
+				Functions: []covutil.FuncDesc{{
+					FuncName: "Add",
+					SrcFile:  "example.com/mod/a/a.go",
+					Units: []covutil.CoverableUnit{
+						{StartLine: 3, StartCol: 24, EndLine: 4, EndCol: 11, NumStmt: 1},
+						{StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3, NumStmt: 1},
+						{StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 14, NumStmt: 1},
+					},
+				}},
+			}},
+		},
+		Counters: map[covutil.PkgFuncKey][]uint32{
+			{PkgPath: "example.com/mod/a", FuncName: "Add"}: {2, 0, 2},
+		},
+	})
+	return &WebServer{Tree: tree, Title: "Source Test", Sources: sources}
+}
+
+func TestAPISource(t *testing.T) {
+	server := sourceTestServer(t)
+	mux := http.NewServeMux()
+	server.SetupRoutes(mux)
+
+	get := func(path string) (*httptest.ResponseRecorder, *FileView) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			return w, nil
+		}
+		var view FileView
+		if err := json.NewDecoder(w.Body).Decode(&view); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return w, &view
+	}
+
+	_, view := get("/api/file?path=example.com/mod/a/a.go")
+	if view == nil {
+		t.Fatal("/api/file failed")
+	}
+	if len(view.Lines) != 8 || view.Package != "example.com/mod/a" || view.MaxCount != 2 {
+		t.Errorf("file view: %d lines, package %q, max count %d", len(view.Lines), view.Package, view.MaxCount)
+	}
+	if len(view.Functions) != 1 || view.Functions[0] != (FunctionSpan{Name: "Add", StartLine: 3, EndLine: 7, CoverageRate: view.Functions[0].CoverageRate}) {
+		t.Errorf("functions = %+v", view.Functions)
+	}
+	if l := view.Lines[4]; l.Number != 5 || !l.Instrumented || l.Covered {
+		t.Errorf("line 5 = %+v, want instrumented and uncovered", l)
+	}
+
+	_, view = get("/api/function?pkg=example.com/mod/a&name=Add")
+	if view == nil {
+		t.Fatal("/api/function failed")
+	}
+	if view.Function != "Add" || len(view.Lines) != 5 || view.Lines[0].Number != 3 {
+		t.Errorf("function view: %q with %d lines", view.Function, len(view.Lines))
+	}
+	if l := view.Lines[4]; l.Number != 7 || !l.Covered || l.Count != 2 {
+		t.Errorf("line 7 = %+v, want covered twice", l)
+	}
+
+	for _, tt := range []struct {
+		path string
+		code int
+		msg  string
+	}{
+		{"/api/file", http.StatusBadRequest, "missing path"},
+		{"/api/file?path=example.com/mod/a/b.go", http.StatusNotFound, "no coverage data for file"},
+		{"/api/function?pkg=example.com/mod/a", http.StatusBadRequest, "missing pkg or name"},
+		{"/api/function?pkg=example.com/mod/b&name=Add", http.StatusNotFound, "no coverage data for package"},
+		{"/api/function?pkg=example.com/mod/a&name=Sub", http.StatusNotFound, "no coverage data for function"},
+	} {
+		w, _ := get(tt.path)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.msg) {
+			t.Errorf("%s: got %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.msg)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/packages?q="+url.QueryEscape(`func == "Add" && func.coverage < 1`), nil))
+	var pkgs []*covtree.PackageNode
+	if err := json.NewDecoder(w.Body).Decode(&pkgs); err != nil || len(pkgs) != 1 || len(pkgs[0].Functions) != 1 {
+		t.Errorf("/api/packages with a query: %v, %+v", err, pkgs)
+	}
+	w, _ = get("/api/packages?q=" + url.QueryEscape("func.lines >"))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "column") {
+		t.Errorf("/api/packages with a bad query: got %d %q", w.Code, w.Body.String())
+	}
+
+	// Without a way to find the source, the error says so.
+	server.Sources = &covtree.SourceResolver{}
+	w, _ = get("/api/function?pkg=example.com/mod/a&name=Add")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "source not found for example.com/mod/a/a.go") ||
+		!strings.Contains(w.Body.String(), "-src") {
+		t.Errorf("missing source: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestWebServerWatch(t *testing.T) {
+	dir := t.TempDir()
+	watcher, err := covtree.NewWatcher(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &WebServer{Title: "Watch Test", Watcher: watcher}
+	mux := http.NewServeMux()
+	server.SetupRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "new EventSource('/api/events')") {
+		t.Errorf("index page does not follow /api/events")
+	}
+
+	// Coverage data written after the server started is served once the
+	// watcher picks it up.
+	p := &covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode: covutil.ModeCount,
+			Packages: []covutil.PackageMeta{{
+				Path:      "example.com/mod/a",
+				Name:      "a",
+				Functions: []covutil.FuncDesc{{FuncName: "F", SrcFile: "example.com/mod/a/a.go", Units: []covutil.CoverableUnit{{StartLine: 3, EndLine: 3, NumStmt: 1}}}},
+			}},
+		},
+		Counters: map[covutil.PkgFuncKey][]uint32{{PkgPath: "example.com/mod/a", FuncName: "F"}: {1}},
+	}
+	if err := covutil.WriteProfileToDirectory(filepath.Join(dir, "run"), p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(srv.URL + "/api/summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary covtree.CoverageSummary
+	err = json.NewDecoder(resp.Body).Decode(&summary)
+	resp.Body.Close()
+	if err != nil || summary.TotalPackages != 1 || summary.CoveredLines != 1 {
+		t.Errorf("summary = %+v, %v; want the watched package", summary, err)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event: update\n" {
+		t.Errorf("first line of /api/events = %q, %v", line, err)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+// FileView is the response of /api/file and /api/function: a source file,
+// or the part of it holding one function, annotated with the coverable
+// units recorded in the coverage data.
+type FileView struct {
+	// Path is the source file path as recorded in the coverage meta-data.
+	Path string
+	// Package is the import path of the package the file belongs to.
+	Package string
+	// Function is the function shown, or "" for a whole file.
+	Function string
+	// TotalLines, CoveredLines and CoverageRate summarize the file or
+	// function.
+	TotalLines   int
+	CoveredLines int
+	CoverageRate float64
+	// MaxCount is the highest execution count in the file, for scaling
+	// heat maps.
+	MaxCount uint32
+	// Functions lists the functions of the file and their line spans.
+	Functions []FunctionSpan
+	// Lines is the annotated source. For a function it holds only the
+	// lines spanned by the function's units.
+	Lines []covtree.SourceLine
+}
+
+// FunctionSpan locates a function within its source file.
+type FunctionSpan struct {
+	Name         string
+	StartLine    uint32
+	EndLine      uint32
+	CoverageRate float64
+}
+
+func (s *WebServer) handleAPIFile(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("path")
+	if file == "" {
+		http.Error(w, "missing path parameter", http.StatusBadRequest)
+		return
+	}
+	fc := findFile(s.tree(), file)
+	if fc == nil {
+		http.Error(w, fmt.Sprintf("no coverage data for file %s", file), http.StatusNotFound)
+		return
+	}
+	view, err := s.fileView(fc)
+	if err != nil {
+		s.sourceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+func (s *WebServer) handleAPIFunction(w http.ResponseWriter, r *http.Request) {
+	pkgPath, name := r.URL.Query().Get("pkg"), r.URL.Query().Get("name")
+	if pkgPath == "" || name == "" {
+		http.Error(w, "missing pkg or name parameter", http.StatusBadRequest)
+		return
+	}
+	pkg := s.tree().GetPackage(pkgPath)
+	if pkg == nil {
+		http.Error(w, fmt.Sprintf("no coverage data for package %s", pkgPath), http.StatusNotFound)
+		return
+	}
+	var fn *covtree.FunctionNode
+	for _, f := range pkg.Functions {
+		if f.Name == name {
+			fn = f
+			break
+		}
+	}
+	if fn == nil || len(fn.Units) == 0 {
+		http.Error(w, fmt.Sprintf("no coverage data for function %s.%s", pkgPath, name), http.StatusNotFound)
+		return
+	}
+
+	var fc *covtree.FileCoverage
+	for _, f := range pkg.Files() {
+		if f.Path == fn.File {
+			fc = f
+			break
+		}
+	}
+	view, err := s.fileView(fc)
+	if err != nil {
+		s.sourceError(w, err)
+		return
+	}
+	span := functionSpan(fn)
+	view.Function = fn.Name
+	view.TotalLines, view.CoveredLines, view.CoverageRate = fn.TotalLines, fn.CoveredLines, fn.CoverageRate
+	var lines []covtree.SourceLine
+	for _, l := range view.Lines {
+		if uint32(l.Number) >= span.StartLine && uint32(l.Number) <= span.EndLine {
+			lines = append(lines, l)
+		}
+	}
+	view.Lines = lines
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// fileView reads the source of fc and annotates it with the units of all
+// of the file's functions, so that nested function literals are shown
+// with their own coverage.
+func (s *WebServer) fileView(fc *covtree.FileCoverage) (*FileView, error) {
+	resolver := s.Sources
+	if resolver == nil {
+		resolver = covtree.NewSourceResolver()
+	}
+	src, err := resolver.ReadSource(fc.Package.ImportPath, fc.Path)
+	if err != nil {
+		return nil, err
+	}
+	units := fc.Units()
+	view := &FileView{
+		Path:         fc.Path,
+		Package:      fc.Package.ImportPath,
+		TotalLines:   fc.TotalLines,
+		CoveredLines: fc.CoveredLines,
+		CoverageRate: fc.CoverageRate,
+		MaxCount:     covtree.MaxCount(units),
+		Lines:        covtree.AnnotateLines(src, units),
+	}
+	for _, fn := range fc.Functions {
+		if len(fn.Units) > 0 {
+			view.Functions = append(view.Functions, functionSpan(fn))
+		}
+	}
+	return view, nil
+}
+
+// sourceError reports a failure to read a source file. Missing sources
+// are reported as 404 with a hint on how to locate them.
+func (s *WebServer) sourceError(w http.ResponseWriter, err error) {
+	var notFound *covtree.SourceNotFoundError
+	if errors.As(err, &notFound) {
+		http.Error(w, fmt.Sprintf("%v; use -src to name the module root containing it", err), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// findFile returns the coverage of the source file recorded as path.
+func findFile(tree *covtree.CoverageTree, path string) *covtree.FileCoverage {
+	for _, name := range tree.GetPackageNames() {
+		for _, fn := range tree.Packages[name].Functions {
+			if fn.File != path {
+				continue
+			}
+			for _, fc := range tree.Packages[name].Files() {
+				if fc.Path == path {
+					return fc
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func functionSpan(fn *covtree.FunctionNode) FunctionSpan {
+	span := FunctionSpan{Name: fn.Name, CoverageRate: fn.CoverageRate}
+	for i, u := range fn.Units {
+		if i == 0 || u.StartLine < span.StartLine {
+			span.StartLine = u.StartLine
+		}
+		span.EndLine = max(span.EndLine, u.EndLine)
+	}
+	return span
+}
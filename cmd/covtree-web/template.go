@@ -281,6 +281,92 @@ const indexTemplate = `<!DOCTYPE html>
 			border-bottom: none;
 		}
 		
+		.function[data-pkg] {
+			cursor: pointer;
+		}
+		
+		.function[data-pkg]:hover .function-name {
+			color: #667eea;
+		}
+		
+		.source-view {
+			display: none;
+			position: fixed;
+			top: 5vh;
+			left: 5vw;
+			right: 5vw;
+			bottom: 5vh;
+			background: white;
+			border-radius: 12px;
+			box-shadow: 0 8px 32px rgba(0,0,0,0.35);
+			z-index: 10;
+			flex-direction: column;
+			overflow: hidden;
+		}
+		
+		.source-view.open {
+			display: flex;
+		}
+		
+		.source-header {
+			background: linear-gradient(135deg, #0f1419 0%, #2d3748 100%);
+			color: white;
+			padding: 15px 25px;
+			display: flex;
+			justify-content: space-between;
+			align-items: center;
+			gap: 15px;
+		}
+		
+		.source-title {
+			font-family: 'SFMono-Regular', Consolas, 'Liberation Mono', Menlo, monospace;
+			font-size: 0.95em;
+			overflow: hidden;
+			text-overflow: ellipsis;
+			white-space: nowrap;
+		}
+		
+		.source-header a {
+			color: #a0aec0;
+			cursor: pointer;
+			font-size: 0.85em;
+			margin-right: 15px;
+		}
+		
+		.source-body {
+			overflow: auto;
+			flex: 1;
+			font-family: 'SFMono-Regular', Consolas, 'Liberation Mono', Menlo, monospace;
+			font-size: 0.85em;
+			line-height: 1.5;
+		}
+		
+		.source-body table {
+			border-collapse: collapse;
+			width: 100%;
+		}
+		
+		.source-body td {
+			padding: 0 10px;
+			white-space: pre;
+			vertical-align: top;
+		}
+		
+		.source-body td.num, .source-body td.hits {
+			color: #a0aec0;
+			text-align: right;
+			user-select: none;
+			width: 1%;
+		}
+		
+		.source-body .cov { background: rgba(56, 161, 105, 0.2); }
+		.source-body .uncov { background: rgba(229, 62, 62, 0.2); }
+		.source-body .partial td.hits { color: #dd6b20; }
+		.source-error {
+			padding: 40px;
+			color: #e53e3e;
+		}
+		
 		.function-name {
 			font-family: 'SFMono-Regular', Consolas, 'Liberation Mono', Menlo, monospace;
 			font-size: 0.9em;
@@ -398,30 +484,30 @@ const indexTemplate = `<!DOCTYPE html>
 				<div class="stats">
 					<div class="stat">
 						<div class="stat-label">Total Packages</div>
-						<div class="stat-value">{{.Summary.TotalPackages}}</div>
+						<div class="stat-value" id="total-packages">{{.Summary.TotalPackages}}</div>
 						<div class="progress-bar">
 							<div class="progress-fill" style="width: 100%"></div>
 						</div>
 					</div>
 					<div class="stat">
 						<div class="stat-label">Total Lines</div>
-						<div class="stat-value">{{.Summary.TotalLines}}</div>
+						<div class="stat-value" id="total-lines">{{.Summary.TotalLines}}</div>
 						<div class="progress-bar">
 							<div class="progress-fill" style="width: 100%"></div>
 						</div>
 					</div>
 					<div class="stat">
 						<div class="stat-label">Covered Lines</div>
-						<div class="stat-value">{{.Summary.CoveredLines}}</div>
+						<div class="stat-value" id="covered-lines">{{.Summary.CoveredLines}}</div>
 						<div class="progress-bar">
-							<div class="progress-fill" style="width: {{printf "%.0f" (mult .Summary.CoverageRate 100)}}%"></div>
+							<div class="progress-fill" id="covered-progress" style="width: {{printf "%.0f" (mult .Summary.CoverageRate 100)}}%"></div>
 						</div>
 					</div>
 					<div class="stat">
 						<div class="stat-label">Coverage Rate</div>
-						<div class="stat-value coverage-rate">{{printf "%.1f" (mult .Summary.CoverageRate 100)}}%</div>
+						<div class="stat-value coverage-rate" id="coverage-rate">{{printf "%.1f" (mult .Summary.CoverageRate 100)}}%</div>
 						<div class="progress-bar">
-							<div class="progress-fill" style="width: {{printf "%.0f" (mult .Summary.CoverageRate 100)}}%"></div>
+							<div class="progress-fill" id="coverage-progress" style="width: {{printf "%.0f" (mult .Summary.CoverageRate 100)}}%"></div>
 						</div>
 					</div>
 				</div>
@@ -442,6 +528,10 @@ const indexTemplate = `<!DOCTYPE html>
 						<label for="maxCov">Max Coverage %</label>
 						<input type="number" id="maxCov" placeholder="100" step="0.1" min="0" max="100">
 					</div>
+					<div class="filter-group">
+						<label for="query">Query</label>
+						<input type="text" id="query" placeholder="e.g., func.coverage &lt; 0.5 &amp;&amp; func.lines &gt; 20">
+					</div>
 					<div class="filter-group">
 						<label>&nbsp;</label>
 						<button onclick="loadPackages()">Apply Filter</button>
@@ -458,24 +548,43 @@ const indexTemplate = `<!DOCTYPE html>
 		</div>
 	</div>
 
+	<div class="source-view" id="source-view">
+		<div class="source-header">
+			<span class="source-title" id="source-title"></span>
+			<span>
+				<a id="source-file">Whole file</a>
+				<button onclick="closeSource()">Close</button>
+			</span>
+		</div>
+		<div class="source-body" id="source-body"></div>
+	</div>
+
 	<script>
 		function loadPackages() {
 			const filter = document.getElementById('filter').value;
 			const minCov = document.getElementById('minCov').value;
 			const maxCov = document.getElementById('maxCov').value;
+			const query = document.getElementById('query').value;
 			
 			let url = '/api/packages?';
 			if (filter) url += 'pattern=' + encodeURIComponent(filter) + '&';
 			if (minCov) url += 'min_coverage=' + (parseFloat(minCov) / 100) + '&';
 			if (maxCov) url += 'max_coverage=' + (parseFloat(maxCov) / 100) + '&';
+			if (query) url += 'q=' + encodeURIComponent(query) + '&';
 			
 			document.getElementById('packages-list').innerHTML = '<div class="loading">Loading packages...</div>';
 			
 			fetch(url)
-				.then(response => response.json())
+				.then(response => {
+					if (!response.ok) {
+						// Report query errors, which say where the query is wrong.
+						return response.text().then(text => { throw new Error(text); });
+					}
+					return response.json();
+				})
 				.then(data => {
 					const container = document.getElementById('packages-list');
-					if (data.length === 0) {
+					if (!data || data.length === 0) {
 						container.innerHTML = '<div class="loading">No packages match the filter criteria.</div>';
 						return;
 					}
@@ -505,8 +614,8 @@ const indexTemplate = `<!DOCTYPE html>
 								const fnCoverageClass = fn.CoverageRate > 0.8 ? 'coverage-high' : 
 													   fn.CoverageRate > 0.5 ? 'coverage-medium' : 'coverage-low';
 								html += ` + "`" + `
-									<div class="function">
-										<span class="function-name">${fn.Name}</span>
+									<div class="function" data-pkg="${escapeHTML(pkg.ImportPath)}" data-name="${escapeHTML(fn.Name)}" onclick="showFunction(this.dataset.pkg, this.dataset.name)">
+										<span class="function-name">${escapeHTML(fn.Name)}</span>
 										<span class="function-coverage ${fnCoverageClass}">${(fn.CoverageRate * 100).toFixed(1)}%</span>
 									</div>
 								` + "`" + `;
@@ -522,8 +631,10 @@ const indexTemplate = `<!DOCTYPE html>
 				})
 				.catch(error => {
 					console.error('Error loading packages:', error);
-					document.getElementById('packages-list').innerHTML = 
-						'<div class="loading">Error loading packages. Please try again.</div>';
+					const message = document.createElement('div');
+					message.className = 'loading';
+					message.textContent = 'Error loading packages: ' + error.message;
+					document.getElementById('packages-list').replaceChildren(message);
 				});
 		}
 
@@ -544,13 +655,96 @@ const indexTemplate = `<!DOCTYPE html>
 			}
 		}
 
+		function escapeHTML(s) {
+			return String(s).replace(/[&<>"']/g, c => ({'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'})[c]);
+		}
+
+		// showFunction shows the annotated source of a function.
+		function showFunction(pkg, name) {
+			showSource('/api/function?pkg=' + encodeURIComponent(pkg) + '&name=' + encodeURIComponent(name), pkg + '.' + name);
+		}
+
+		// showFile shows the annotated source of a whole file.
+		function showFile(path) {
+			showSource('/api/file?path=' + encodeURIComponent(path), path);
+		}
+
+		function showSource(url, title) {
+			const body = document.getElementById('source-body');
+			document.getElementById('source-title').textContent = title;
+			document.getElementById('source-file').style.display = 'none';
+			body.innerHTML = '<div class="loading">Loading source...</div>';
+			document.getElementById('source-view').classList.add('open');
+
+			fetch(url)
+				.then(response => response.ok ? response.json() : response.text().then(text => { throw new Error(text); }))
+				.then(view => {
+					const fileLink = document.getElementById('source-file');
+					if (view.Function) {
+						fileLink.style.display = '';
+						fileLink.onclick = () => showFile(view.Path);
+					}
+					document.getElementById('source-title').textContent =
+						title + ' \u2014 ' + (view.CoverageRate * 100).toFixed(1) + '% (' + view.CoveredLines + '/' + view.TotalLines + ')';
+
+					let html = '<table>';
+					(view.Lines || []).forEach(line => {
+						let cls = '';
+						if (line.Instrumented) {
+							cls = line.Covered ? 'cov' : 'uncov';
+							if (line.Partial) cls += ' partial';
+						}
+						let code = '';
+						(line.Segments || []).forEach(seg => {
+							const segCls = seg.Instrumented ? (seg.Covered ? 'cov' : 'uncov') : '';
+							code += segCls ? ` + "`" + `<span class="${segCls}">${escapeHTML(seg.Text)}</span>` + "`" + ` : escapeHTML(seg.Text);
+						});
+						const hits = line.Instrumented ? line.Count : '';
+						html += ` + "`" + `<tr class="${cls}"><td class="num">${line.Number}</td><td class="hits">${hits}</td><td>${code}</td></tr>` + "`" + `;
+					});
+					html += '</table>';
+					document.getElementById('source-body').innerHTML = html;
+				})
+				.catch(error => {
+					document.getElementById('source-body').innerHTML =
+						'<div class="source-error">' + escapeHTML(error.message) + '</div>';
+				});
+		}
+
+		function closeSource() {
+			document.getElementById('source-view').classList.remove('open');
+		}
+
 		// Load packages on page load
 		document.addEventListener('DOMContentLoaded', function() {
 			loadPackages();
 		});
+{{if .Watch}}
+		// Follow changes to the coverage data.
+		new EventSource('/api/events').addEventListener('update', function(event) {
+			const update = JSON.parse(event.data);
+			const s = update.Summary;
+			const percent = (s.CoverageRate * 100).toFixed(1);
+			document.getElementById('total-packages').textContent = s.TotalPackages;
+			document.getElementById('total-lines').textContent = s.TotalLines;
+			document.getElementById('covered-lines').textContent = s.CoveredLines;
+			const rate = document.getElementById('coverage-rate');
+			rate.textContent = percent + '%';
+			rate.style.color = s.CoverageRate < 0.5 ? '#e53e3e' : s.CoverageRate < 0.8 ? '#dd6b20' : '#38a169';
+			document.getElementById('covered-progress').style.width = percent + '%';
+			document.getElementById('coverage-progress').style.width = percent + '%';
+			if (update.Packages && update.Packages.length > 0) {
+				loadPackages();
+			}
+		});
+{{end}}
 
 		// Add keyboard shortcuts
 		document.addEventListener('keydown', function(e) {
+			if (e.key === 'Escape') {
+				closeSource();
+				return;
+			}
 			if (e.ctrlKey || e.metaKey) {
 				switch(e.key) {
 					case 'f':
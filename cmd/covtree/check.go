@@ -0,0 +1,123 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+var cmdCheck = &Command{
+	UsageLine: "covtree check -i=<directory> -policy=<file> [-baseline=<file> [-update]]",
+	Short:     "check coverage against a threshold policy",
+	Long: `
+Check evaluates coverage data against a policy of minimum coverage
+percentages, prints any violations, and exits with a non-zero status if
+there are any.
+
+The -i flag specifies a directory to scan recursively for coverage data.
+
+The -policy flag names a JSON or YAML policy file. A policy sets minimums
+for the whole tree, for packages matching import path patterns, and for
+directories of the package hierarchy:
+
+	overall: 70
+	packages:
+	  - pattern: example.com/app/...
+	    min: 60
+	  - pattern: example.com/app/internal/legacy
+	    min: 20
+	directories:
+	  - path: example.com/app/internal
+	    min: 75
+	ratchet:
+	  tolerance: 0.5
+
+Patterns use path.Match syntax, and a trailing "/..." matches every package
+below a path. When several patterns match a package, the last one applies.
+
+The -baseline flag names a baseline file recording earlier coverage. Check
+then also fails if overall or per-package coverage dropped below the
+baseline by more than the policy's ratchet tolerance, in percentage points.
+
+The -update flag raises the baseline to the current coverage if the check
+passes. Coverage that dropped within the ratchet tolerance leaves the
+baseline unchanged, so the baseline only ever moves up. The baseline file
+is created if it does not exist.
+
+Example:
+
+	covtree check -i=./coverage -policy=coverage-policy.yaml
+	covtree check -i=./coverage -policy=policy.json -baseline=coverage-baseline.json -update
+`,
+}
+
+var (
+	checkInputDir = cmdCheck.Flag.String("i", "", "input directory to scan recursively for coverage data")
+	checkPolicy   = cmdCheck.Flag.String("policy", "", "policy file (JSON or YAML)")
+	checkBaseline = cmdCheck.Flag.String("baseline", "", "baseline file for ratchet checks")
+	checkUpdate   = cmdCheck.Flag.Bool("update", false, "raise the baseline to the current coverage if the check passes")
+)
+
+func init() {
+	cmdCheck.Run = runCheck
+}
+
+func runCheck(ctx context.Context, args []string) error {
+	if *checkInputDir == "" {
+		return fmt.Errorf("must specify input directory with -i flag")
+	}
+	if *checkPolicy == "" {
+		return fmt.Errorf("must specify policy file with -policy flag")
+	}
+	if *checkUpdate && *checkBaseline == "" {
+		return fmt.Errorf("-update requires a -baseline file")
+	}
+	if _, err := os.Stat(*checkInputDir); os.IsNotExist(err) {
+		return fmt.Errorf("input directory does not exist: %s", *checkInputDir)
+	}
+
+	policy, err := covtree.LoadPolicy(*checkPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to load policy: %v", err)
+	}
+
+	var baseline *covtree.Baseline
+	if *checkBaseline != "" {
+		baseline, err = covtree.LoadBaseline(*checkBaseline)
+		if os.IsNotExist(err) && *checkUpdate {
+			baseline, err = nil, nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load baseline: %v", err)
+		}
+	}
+
+	tree := covtree.NewCoverageTree()
+	if err := tree.LoadFromNestedRepository(*checkInputDir); err != nil {
+		return fmt.Errorf("failed to load coverage data from %s: %v", *checkInputDir, err)
+	}
+
+	violations := policy.Check(tree, baseline)
+	for _, v := range violations {
+		fmt.Printf("FAIL %s\n", v)
+	}
+	total := 100 * tree.Summary().CoverageRate
+	if len(violations) > 0 {
+		return fmt.Errorf("coverage %.1f%%: %d policy violation(s)", total, len(violations))
+	}
+	fmt.Printf("ok: coverage %.1f%% meets policy\n", total)
+
+	if *checkUpdate {
+		if err := baseline.Raise(tree).WriteFile(*checkBaseline); err != nil {
+			return fmt.Errorf("failed to write baseline: %v", err)
+		}
+		fmt.Printf("updated baseline %s\n", *checkBaseline)
+	}
+	return nil
+}
@@ -6,11 +6,16 @@ package main
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+
+	"github.com/tmc/covutil"
 )
 
 func TestCovtreeHelp(t *testing.T) {
@@ -231,3 +236,503 @@ func TestCovtreeFuncWithSprig(t *testing.T) {
 		t.Errorf("Expected non-empty func output")
 	}
 }
+
+// writeAddProfile writes a GOCOVERDIR-style pod to dir for a package
+// example.com/mod/a holding a single function Add with three units.
+func writeAddProfile(t *testing.T, dir string, counts []uint32) {
+	t.Helper()
+	profile := &covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode:        covutil.ModeCount,
+			Granularity: covutil.GranularityBlock,
+			Packages: []covutil.PackageMeta{{
+				Path:       "example.com/mod/a",
+				Name:       "a",
+				ModulePath: "example.com/mod",
+				Functions: []covutil.FuncDesc{{
+					PackagePath: "example.com/mod/a",
+					FuncName:    "Add",
+					SrcFile:     "example.com/mod/a/a.go",
+					Units: []covutil.CoverableUnit{
+						{StartLine: 3, StartCol: 24, EndLine: 4, EndCol: 11, NumStmt: 1},
+						{StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3, NumStmt: 1},
+						{StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 14, NumStmt: 1},
+					},
+				}},
+			}},
+		},
+		Counters: map[covutil.PkgFuncKey][]uint32{
+			{PkgPath: "example.com/mod/a", FuncName: "Add"}: counts,
+		},
+	}
+	if err := covutil.WriteProfileToDirectory(dir, profile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCovtreeHTMLSource(t *testing.T) {
+	dir := t.TempDir()
+	modDir := filepath.Join(dir, "mod")
+	covDir := filepath.Join(dir, "cov")
+	src := "package a\n\nfunc Add(x, y int) int {\n\tif x < 0 {\n\t\treturn 0\n\t}\n\treturn x + y\n}\n"
+	for name, content := range map[string]string{
+		"go.mod": "module example.com/mod\n",
+		"a/a.go": src,
+	} {
+		p := filepath.Join(modDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeAddProfile(t, covDir, []uint32{12, 0, 12})
+
+	out := filepath.Join(dir, "report.html")
+	cmd := exec.Command("go", "run", ".", "html", "-i="+covDir, "-src="+modDir, "-o="+out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("covtree html failed: %v\nOutput: %s", err, output)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+	for _, want := range []string{
+		`<div class="file-view" id="file0">`,
+		`<span class="cov10">return x &#43; y</span>`,
+		`<span class="cov0">{`,
+		`<td class="hits">12</td>`,
+		`"example.com/mod/a":[{"ID":"file0","Name":"a.go"`,
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
+
+func TestCovtreeWhoCovers(t *testing.T) {
+	root := t.TempDir()
+	for name, counts := range map[string][]uint32{
+		"TestPositive": {1, 0, 1},
+		"TestNegative": {1, 1, 0},
+	} {
+		writeAddProfile(t, filepath.Join(root, name), counts)
+	}
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"a/a.go:5", "TestNegative\n"},
+		{"a.go:3", "TestNegative\nTestPositive\n"},
+		{"example.com/mod/a.Add", "TestNegative\nTestPositive\n"},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("go", "run", ".", "who-covers", "-i="+root, tt.arg)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("who-covers %s: %v", tt.arg, err)
+		}
+		if string(output) != tt.want {
+			t.Errorf("who-covers %s = %q, want %q", tt.arg, output, tt.want)
+		}
+	}
+
+	// The units printed by -v are those that selected the tests, even
+	// when the file is given with a leading slash.
+	output, err := exec.Command("go", "run", ".", "who-covers", "-i="+root, "-v", "/a/a.go:5").Output()
+	if err != nil {
+		t.Fatalf("who-covers -v: %v", err)
+	}
+	if want := "TestNegative\n\texample.com/mod/a/a.go:4.11,6.3\tAdd\t1\n"; string(output) != want {
+		t.Errorf("who-covers -v = %q, want %q", output, want)
+	}
+}
+
+func TestCovtreeTests(t *testing.T) {
+	root := t.TempDir()
+	for name, counts := range map[string][]uint32{
+		"TestAll":      {1, 1, 0},
+		"TestPositive": {1, 0, 0},
+		"TestNegative": {0, 0, 2},
+	} {
+		writeAddProfile(t, filepath.Join(root, name), counts)
+	}
+
+	output, err := exec.Command("go", "run", ".", "tests", "-i="+root, "-v").CombinedOutput()
+	if err != nil {
+		t.Fatalf("tests: %v\n%s", err, output)
+	}
+	for _, want := range []string{
+		"TestPositive  1      0       subset of TestAll",
+		"TestAll covers on its own:\n\texample.com/mod/a/a.go:4.11,6.3\tAdd\n",
+		"3 units covered by 3 tests",
+		"no unique coverage: TestPositive",
+		"minimal set (2 of 3 tests): TestAll TestNegative",
+	} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("output missing %q:\n%s", want, output)
+		}
+	}
+
+	output, err = exec.Command("go", "run", ".", "tests", "-i="+root, "-format=json").Output()
+	if err != nil {
+		t.Fatalf("tests -format=json: %v", err)
+	}
+	var r struct {
+		Units   int
+		Minimal []string
+		Tests   []struct {
+			Name   string
+			Unique []struct {
+				StartLine int `json:"start_line"`
+			}
+		}
+	}
+	if err := json.Unmarshal(output, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Units != 3 || len(r.Tests) != 3 || len(r.Tests[1].Unique) != 1 || r.Tests[1].Unique[0].StartLine != 7 {
+		t.Errorf("unexpected JSON report %+v", r)
+	}
+}
+
+func TestCovtreeSelectTests(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "per-test")
+	for name, counts := range map[string][]uint32{
+		"TestPositive": {1, 0, 0},
+		"TestNegative": {1, 1, 0},
+	} {
+		writeAddProfile(t, filepath.Join(root, name), counts)
+	}
+
+	// The patch changes the negative branch (line 5) and the final return
+	// (line 7), which no test executed.
+	patch := "--- a/a/a.go\n+++ b/a/a.go\n@@ -5,3 +5,3 @@\n-\t\treturn 0\n+\t\treturn -1\n \t}\n-\treturn x + y\n+\treturn y + x\n" +
+		"--- a/b/b.go\n+++ b/b/b.go\n@@ -1 +1 @@\n-package b\n+package b // changed\n"
+	cmd := exec.Command("go", "run", ".", "select-tests", "-i="+root)
+	cmd.Stdin = strings.NewReader(patch)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("select-tests: %v", err)
+	}
+	want := "^(TestNegative)$\n" +
+		"untested\texample.com/mod/a/a.go:7.2,7.14\tAdd\n" +
+		"no coverage data\tb/b.go\n"
+	if string(output) != want {
+		t.Errorf("select-tests = %q, want %q", output, want)
+	}
+}
+
+func TestCovtreeScrape(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeAddProfile(t, src, []uint32{2, 1, 1})
+	files := make(map[string][]byte)
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(e.Name(), "covmeta.") {
+			files["meta"] = data
+		} else {
+			files["counters"] = data
+		}
+	}
+	var resets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("reset") != "" {
+			resets++
+		}
+		w.Header().Set("X-Coverage-Pid", "42")
+		w.Write(files[filepath.Base(r.URL.Path)])
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(dir, "out")
+	cmd := exec.Command("go", "run", ".", "scrape", "-url="+srv.URL+"/debug/coverage/", "-o="+out, "-count=1", "-reset")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("scrape: %v\n%s", err, output)
+	}
+	if resets != 1 {
+		t.Errorf("counters reset %d times, want 1", resets)
+	}
+
+	snapshots, err := os.ReadDir(out)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("snapshots = %v, %v; want one", snapshots, err)
+	}
+	snap := filepath.Join(out, snapshots[0].Name())
+	set, err := covutil.LoadCoverageSet(os.DirFS(snap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Pods) != 1 {
+		t.Fatalf("loaded %d pods, want 1", len(set.Pods))
+	}
+	counts := set.Pods[0].Profile.Counters[covutil.PkgFuncKey{PkgPath: "example.com/mod/a", FuncName: "Add"}]
+	if len(counts) != 3 || counts[0] != 2 {
+		t.Errorf("counters = %v, want [2 1 1]", counts)
+	}
+	if m, _ := filepath.Glob(filepath.Join(snap, "covcounters.*.42.*")); len(m) != 1 {
+		t.Errorf("counter files named with pid 42: %v", m)
+	}
+
+	data, err := os.ReadFile(filepath.Join(snap, "pod_metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var md struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := json.Unmarshal(data, &md); err != nil {
+		t.Fatal(err)
+	}
+	if md.Labels["scrape_url"] != srv.URL+"/debug/coverage/" || md.Labels["scrape_time"] == "" {
+		t.Errorf("labels = %v", md.Labels)
+	}
+}
+
+func TestCovtreeDelta(t *testing.T) {
+	dir := t.TempDir()
+	from, to, out := filepath.Join(dir, "from"), filepath.Join(dir, "to"), filepath.Join(dir, "out")
+	writeAddProfile(t, from, []uint32{1, 0, 4})
+	writeAddProfile(t, to, []uint32{3, 2, 1})
+
+	cmd := exec.Command("go", "run", ".", "delta", "-from="+from, "-to="+to, "-o="+out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("delta: %v\n%s", err, output)
+	}
+	set, err := covutil.LoadCoverageSet(os.DirFS(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Pods) != 1 {
+		t.Fatalf("loaded %d pods, want 1", len(set.Pods))
+	}
+	counts := set.Pods[0].Profile.Counters[covutil.PkgFuncKey{PkgPath: "example.com/mod/a", FuncName: "Add"}]
+	if want := []uint32{2, 2, 0}; !slices.Equal(counts, want) {
+		t.Errorf("delta counters = %v, want %v", counts, want)
+	}
+
+	cmd = exec.Command("go", "run", ".", "delta", "-from="+filepath.Join(dir, "missing"), "-to="+to, "-o="+out)
+	if output, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(output), "does not exist") {
+		t.Errorf("delta from a missing directory: %v\n%s", err, output)
+	}
+}
+
+func TestCovtreeQuery(t *testing.T) {
+	dir := t.TempDir()
+	writeAddProfile(t, dir, []uint32{1, 0, 2})
+
+	for _, tt := range []struct {
+		expr string
+		want string
+	}{
+		{`pkg ~ "mod/*" && pkg.coverage < 1`, "example.com/mod/a\t50.0%\t3/6\n"},
+		{`pkg.module != "example.com/mod"`, ""},
+		{`func == "Add" && func.lines > 2`, "example.com/mod/a/a.go:3:\tAdd\t50.0%\n"},
+		{`!unit.covered`, "example.com/mod/a/a.go:4.11,6.3\tAdd\t0\n"},
+	} {
+		cmd := exec.Command("go", "run", ".", "query", "-i="+dir, tt.expr)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("query %s: %v\n%s", tt.expr, err, output)
+			continue
+		}
+		if string(output) != tt.want {
+			t.Errorf("query %s:\n%s\nwant:\n%s", tt.expr, output, tt.want)
+		}
+	}
+
+	cmd := exec.Command("go", "run", ".", "query", "-i="+dir, "func.lines >> 2")
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "column 13") || !strings.Contains(string(output), "\n\t            ^") {
+		t.Errorf("query with a syntax error: %v\n%s", err, output)
+	}
+}
+
+func TestCovtreeDiff(t *testing.T) {
+	dir := t.TempDir()
+	base, head := filepath.Join(dir, "base"), filepath.Join(dir, "head")
+	writeAddProfile(t, base, []uint32{2, 1, 0})
+	writeAddProfile(t, head, []uint32{2, 0, 3})
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"text", []string{
+			"example.com/mod/a\t66.7% -> 66.7% (+0.0)",
+			"example.com/mod/a/a.go:4.11,6.3\tAdd\tlost\t1 -> 0",
+			"example.com/mod/a/a.go:7.2,7.14\tAdd\tgained\t0 -> 3",
+		}},
+		{"json", []string{`"Kind": "gained"`, `"PkgPath": "example.com/mod/a"`}},
+		{"markdown", []string{"| `example.com/mod/a` | 66.7% | 66.7% | +0.0 | 1 | 1 |", "### Lost coverage", "a.Add"}},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("go", "run", ".", "diff", "-base="+base, "-head="+head, "-format="+tt.format)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("diff -format=%s: %v", tt.format, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(string(output), want) {
+				t.Errorf("diff -format=%s output missing %q:\n%s", tt.format, want, output)
+			}
+		}
+	}
+}
+
+func TestCovtreePatch(t *testing.T) {
+	dir := t.TempDir()
+	covDir := filepath.Join(dir, "cov")
+	writeAddProfile(t, covDir, []uint32{1, 0, 1})
+
+	diff := filepath.Join(dir, "change.patch")
+	patch := "--- a/a/a.go\n+++ b/a/a.go\n@@ -4,4 +4,4 @@\n-\tif x < 0 {\n+\tif x <= 0 {\n-\t\treturn 0\n+\t\treturn -1\n \t}\n \treturn x + y\n"
+	if err := os.WriteFile(diff, []byte(patch), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "run", ".", "patch", "-i="+covDir, "-diff="+diff)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("patch failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{"a/a.go\t50.0% (1/2)\tuncovered: 5", "patch coverage: 50.0% (1/2 changed lines)"} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("output missing %q:\n%s", want, output)
+		}
+	}
+
+	cmd = exec.Command("go", "run", ".", "patch", "-i="+covDir, "-min=80")
+	cmd.Stdin = strings.NewReader(patch)
+	output, err = cmd.CombinedOutput()
+	if err == nil {
+		t.Errorf("patch -min=80 succeeded below threshold:\n%s", output)
+	}
+	if !strings.Contains(string(output), "below minimum 80.0%") {
+		t.Errorf("output missing threshold error:\n%s", output)
+	}
+}
+
+func TestCovtreeExport(t *testing.T) {
+	dir := t.TempDir()
+	covDir := filepath.Join(dir, "cov")
+	writeAddProfile(t, covDir, []uint32{2, 0, 2})
+
+	tests := []struct {
+		format string
+		args   []string
+		want   []string
+	}{
+		{"cobertura", []string{"-trim", "-src=/src/mod"}, []string{
+			`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`,
+			`<source>/src/mod</source>`,
+			`<class name="a" filename="a/a.go"`,
+			`<line number="4" hits="2" branch="true" condition-coverage="50% (1/2)">`,
+		}},
+		{"lcov", []string{"-test=unit"}, []string{
+			"TN:unit\nSF:example.com/mod/a/a.go\nFN:3,Add\nFNDA:2,Add\n",
+			"DA:5,0\n",
+			"LF:5\nLH:3\nend_of_record\n",
+		}},
+		{"gocover", nil, []string{
+			"mode: count\nexample.com/mod/a/a.go:3.24,4.11 1 2\nexample.com/mod/a/a.go:4.11,6.3 1 0\n",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			out := filepath.Join(dir, tt.format+".out")
+			args := append([]string{"run", ".", "export", "-format=" + tt.format, "-i=" + covDir, "-o=" + out}, tt.args...)
+			if output, err := exec.Command("go", args...).CombinedOutput(); err != nil {
+				t.Fatalf("export failed: %v\n%s", err, output)
+			}
+			data, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("output missing %q:\n%s", want, data)
+				}
+			}
+		})
+	}
+
+	cmd := exec.Command("go", "run", ".", "export", "-format=xml", "-i="+covDir)
+	if output, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(output), "unknown format") {
+		t.Errorf("export -format=xml: err=%v\n%s", err, output)
+	}
+}
+
+func TestCovtreeCheck(t *testing.T) {
+	dir := t.TempDir()
+	covDir := filepath.Join(dir, "cov")
+	writeAddProfile(t, covDir, []uint32{1, 0, 1}) // 3 of 6 lines covered
+	policy := filepath.Join(dir, "policy.yaml")
+	baseline := filepath.Join(dir, "baseline.json")
+
+	check := func(policyText string, args ...string) (string, error) {
+		t.Helper()
+		if err := os.WriteFile(policy, []byte(policyText), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"run", ".", "check", "-i=" + covDir, "-policy=" + policy}, args...)
+		output, err := exec.Command("go", args...).CombinedOutput()
+		return string(output), err
+	}
+
+	output, err := check("overall: 50\npackages:\n  - pattern: example.com/mod/...\n    min: 45\n",
+		"-baseline="+baseline, "-update")
+	if err != nil {
+		t.Fatalf("check failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "ok: coverage 50.0% meets policy") || !strings.Contains(output, "updated baseline") {
+		t.Errorf("unexpected output:\n%s", output)
+	}
+	if _, err := os.Stat(baseline); err != nil {
+		t.Errorf("baseline not written: %v", err)
+	}
+
+	output, err = check("packages:\n  - pattern: example.com/mod/a\n    min: 80\n")
+	if err == nil {
+		t.Errorf("check succeeded below package minimum:\n%s", output)
+	}
+	if !strings.Contains(output, "FAIL package example.com/mod/a: coverage 50.0% is below 80.0%") {
+		t.Errorf("output missing violation:\n%s", output)
+	}
+
+	// Coverage dropping below the baseline trips the ratchet.
+	if err := os.RemoveAll(covDir); err != nil {
+		t.Fatal(err)
+	}
+	writeAddProfile(t, covDir, []uint32{1, 0, 0})
+	output, err = check("overall: 0\n", "-baseline="+baseline)
+	if err == nil {
+		t.Errorf("check succeeded below baseline:\n%s", output)
+	}
+	if !strings.Contains(output, "FAIL ratchet total: coverage 33.3% is below 50.0% (baseline)") {
+		t.Errorf("output missing ratchet violation:\n%s", output)
+	}
+
+	// A drop within the tolerance passes but does not lower the baseline.
+	output, err = check("ratchet:\n  tolerance: 20\n", "-baseline="+baseline, "-update")
+	if err != nil {
+		t.Fatalf("check within tolerance failed: %v\n%s", err, output)
+	}
+	output, err = check("ratchet:\n  tolerance: 10\n", "-baseline="+baseline)
+	if !strings.Contains(output, "FAIL ratchet total: coverage 33.3% is below 40.0% (baseline)") {
+		t.Errorf("baseline lowered by -update within tolerance (err %v):\n%s", err, output)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/tmc/covutil"
+)
+
+var cmdDelta = &Command{
+	UsageLine: "covtree delta -from=<directory> -to=<directory> -o=<directory>",
+	Short:     "extract the executions between two snapshots",
+	Long: `
+Delta computes what executed between two snapshots of the counters of
+the same program, such as two directories written by scrape, and writes
+it as ordinary coverage data that the other commands can read.
+
+The -from and -to flags specify the directories holding the older and the
+newer snapshot. Each binary in the newer snapshot must also appear in the
+older one, with the same meta-data. Counters that went down between the
+snapshots, as when the program restarted, count as not executed. For
+programs built with -covermode=set, the result holds the newly covered
+units.
+
+The -o flag specifies the output directory. The delta of each binary is
+written to a subdirectory named after its meta-data hash.
+
+Example:
+
+	covtree delta -from=./snapshots/20240101T120000Z -to=./snapshots/20240101T120030Z -o=./delta
+	covtree percent -i=./delta
+`,
+}
+
+var (
+	deltaFrom   = cmdDelta.Flag.String("from", "", "directory holding the older snapshot")
+	deltaTo     = cmdDelta.Flag.String("to", "", "directory holding the newer snapshot")
+	deltaOutput = cmdDelta.Flag.String("o", "", "output directory")
+)
+
+func init() {
+	cmdDelta.Run = runDelta
+}
+
+func runDelta(ctx context.Context, args []string) error {
+	if *deltaFrom == "" || *deltaTo == "" {
+		return fmt.Errorf("must specify both -from and -to directories")
+	}
+	if *deltaOutput == "" {
+		return fmt.Errorf("must specify output directory with -o flag")
+	}
+	from, err := loadSnapshot(*deltaFrom)
+	if err != nil {
+		return err
+	}
+	to, err := loadSnapshot(*deltaTo)
+	if err != nil {
+		return err
+	}
+
+	hashes := make([]string, 0, len(to))
+	for h := range to {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	for _, h := range hashes {
+		newer := to[h]
+		older, ok := from[h]
+		if !ok {
+			return fmt.Errorf("%s has no snapshot of the binary with meta-data hash %s", *deltaFrom, h)
+		}
+		p, err := covutil.CounterDelta(older.Profile, newer.Profile)
+		if err != nil {
+			return err
+		}
+		pod := &covutil.Pod{
+			ID:        h,
+			Profile:   p,
+			Timestamp: newer.Timestamp,
+			Labels: map[string]string{
+				"delta_from": *deltaFrom,
+				"delta_to":   *deltaTo,
+			},
+		}
+		if !older.Timestamp.IsZero() {
+			pod.Labels["delta_start"] = older.Timestamp.UTC().Format(time.RFC3339Nano)
+		}
+		if !newer.Timestamp.IsZero() {
+			pod.Labels["delta_end"] = newer.Timestamp.UTC().Format(time.RFC3339Nano)
+		}
+		if err := covutil.WritePodToDirectory(*deltaOutput, pod); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", filepath.Join(*deltaOutput, h))
+	}
+	return nil
+}
+
+// loadSnapshot loads the coverage data under dir, merging the pods of
+// each binary, keyed by meta-data hash.
+func loadSnapshot(dir string) (map[string]*covutil.Pod, error) {
+	set, err := loadCoverageSet(dir)
+	if err != nil {
+		return nil, err
+	}
+	pods := make(map[string]*covutil.Pod)
+	for _, pod := range set.Pods {
+		if pod.Profile == nil {
+			continue
+		}
+		h := fmt.Sprintf("%x", pod.Profile.Meta.FileHash)
+		prev, ok := pods[h]
+		if !ok {
+			pods[h] = pod
+			continue
+		}
+		p, err := covutil.MergeProfiles(prev.Profile, pod.Profile)
+		if err != nil {
+			return nil, fmt.Errorf("merging snapshots in %s: %v", dir, err)
+		}
+		ts := prev.Timestamp
+		if pod.Timestamp.After(ts) {
+			ts = pod.Timestamp
+		}
+		pods[h] = &covutil.Pod{ID: h, Profile: p, Timestamp: ts}
+	}
+	return pods, nil
+}
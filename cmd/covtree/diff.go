@@ -0,0 +1,200 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/tmc/covutil"
+)
+
+var cmdDiff = &Command{
+	UsageLine: "covtree diff -base=<directory> -head=<directory> [-format=text|json|markdown] [-o=<file>]",
+	Short:     "report coverage gained and lost between two runs",
+	Long: `
+Diff compares two sets of coverage data and reports, for each package,
+function and coverable unit, the coverage that was gained, lost, or whose
+execution count changed.
+
+The -base and -head flags specify directories to scan recursively for
+coverage data. The two runs need not come from the same build: functions
+are matched by package and name, and their units by source position.
+
+The -format flag selects the output format: text (the default), json, or
+markdown. Markdown output is suitable for posting as a review comment.
+
+The -o flag specifies an output file. If not specified, output is written
+to stdout.
+
+Example:
+
+	covtree diff -base=./coverage-main -head=./coverage-pr
+	covtree diff -base=old -head=new -format=markdown -o=coverage.md
+`,
+}
+
+var (
+	diffBaseDir = cmdDiff.Flag.String("base", "", "base directory to scan recursively for coverage data")
+	diffHeadDir = cmdDiff.Flag.String("head", "", "head directory to scan recursively for coverage data")
+	diffFormat  = cmdDiff.Flag.String("format", "text", "output format: text, json or markdown")
+	diffOutput  = cmdDiff.Flag.String("o", "", "output file (default stdout)")
+)
+
+func init() {
+	cmdDiff.Run = runDiff
+}
+
+func runDiff(ctx context.Context, args []string) error {
+	if *diffBaseDir == "" || *diffHeadDir == "" {
+		return fmt.Errorf("must specify both -base and -head directories")
+	}
+
+	var write func(io.Writer, *covutil.ProfileDiff) error
+	switch *diffFormat {
+	case "text":
+		write = writeDiffText
+	case "json":
+		write = writeDiffJSON
+	case "markdown", "md":
+		write = writeDiffMarkdown
+	default:
+		return fmt.Errorf("unknown format %q: must be text, json or markdown", *diffFormat)
+	}
+
+	base, err := loadCoverageSet(*diffBaseDir)
+	if err != nil {
+		return err
+	}
+	head, err := loadCoverageSet(*diffHeadDir)
+	if err != nil {
+		return err
+	}
+	d, err := base.Diff(head)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *diffOutput != "" {
+		f, err := os.Create(*diffOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	return write(out, d)
+}
+
+// loadCoverageSet loads the coverage data found under dir.
+func loadCoverageSet(dir string) (*covutil.CoverageSet, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("input directory does not exist: %s", dir)
+	}
+	set, err := covutil.LoadCoverageSet(os.DirFS(dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load coverage data from %s: %v", dir, err)
+	}
+	if len(set.Pods) == 0 {
+		return nil, fmt.Errorf("no coverage data found in %s", dir)
+	}
+	return set, nil
+}
+
+func unitPos(file string, u covutil.CoverableUnit) string {
+	return fmt.Sprintf("%s:%d.%d,%d.%d", file, u.StartLine, u.StartCol, u.EndLine, u.EndCol)
+}
+
+func writeDiffText(w io.Writer, d *covutil.ProfileDiff) error {
+	if d.MetaMismatch {
+		fmt.Fprintf(w, "note: base and head were built from different sources; units matched by position\n")
+	}
+	for _, f := range d.Skipped {
+		fmt.Fprintf(w, "note: skipped %d executed units of %s.%s in %s: its builds differ\n",
+			f.Executed, f.PkgPath, f.FuncName, diffSide(f.Head))
+	}
+	for _, p := range d.Changed() {
+		fmt.Fprintf(w, "%s\t%.1f%% -> %.1f%% (%+.1f)\n", p.PkgPath, p.BasePercent(), p.HeadPercent(), p.HeadPercent()-p.BasePercent())
+		for _, f := range p.Functions {
+			for _, u := range f.Units {
+				fmt.Fprintf(w, "\t%s\t%s\t%s\t%d -> %d\n", unitPos(f.File, u.Unit), f.FuncName, u.Kind, u.BaseCount, u.HeadCount)
+			}
+		}
+	}
+	fmt.Fprintf(w, "total\t%.1f%% -> %.1f%% (%+.1f)\n", d.BasePercent(), d.HeadPercent(), d.HeadPercent()-d.BasePercent())
+	return nil
+}
+
+func diffSide(head bool) string {
+	if head {
+		return "head"
+	}
+	return "base"
+}
+
+func writeDiffJSON(w io.Writer, d *covutil.ProfileDiff) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+func writeDiffMarkdown(w io.Writer, d *covutil.ProfileDiff) error {
+	fmt.Fprintf(w, "## Coverage diff\n\n")
+	fmt.Fprintf(w, "Total coverage: **%.1f%%** → **%.1f%%** (%+.1f)\n\n", d.BasePercent(), d.HeadPercent(), d.HeadPercent()-d.BasePercent())
+	if d.MetaMismatch {
+		fmt.Fprintf(w, "> Base and head were built from different sources; units were matched by position.\n\n")
+	}
+	if len(d.Skipped) > 0 {
+		fmt.Fprintf(w, "> These functions were built differently within base or head; the counters of the later builds were skipped:\n")
+		for _, f := range d.Skipped {
+			fmt.Fprintf(w, "> - `%s.%s` in %s (%d executed units)\n", path.Base(f.PkgPath), f.FuncName, diffSide(f.Head), f.Executed)
+		}
+		fmt.Fprintln(w)
+	}
+	changed := d.Changed()
+	if len(changed) == 0 {
+		fmt.Fprintf(w, "No coverage changes.\n")
+		return nil
+	}
+
+	fmt.Fprintf(w, "| Package | Base | Head | Δ | Gained | Lost |\n")
+	fmt.Fprintf(w, "|---|---:|---:|---:|---:|---:|\n")
+	for _, p := range changed {
+		fmt.Fprintf(w, "| `%s` | %.1f%% | %.1f%% | %+.1f | %d | %d |\n",
+			p.PkgPath, p.BasePercent(), p.HeadPercent(), p.HeadPercent()-p.BasePercent(), p.Gained, p.Lost)
+	}
+
+	for _, section := range []struct {
+		title string
+		kind  covutil.DiffKind
+	}{
+		{"Lost coverage", covutil.DiffLost},
+		{"Gained coverage", covutil.DiffGained},
+	} {
+		var lines []string
+		for _, p := range changed {
+			for _, f := range p.Functions {
+				for _, u := range f.Units {
+					if u.Kind == section.kind {
+						lines = append(lines, fmt.Sprintf("- `%s` %s.%s", unitPos(f.File, u.Unit), path.Base(p.PkgPath), f.FuncName))
+					}
+				}
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n### %s\n\n", section.title)
+		for _, l := range lines {
+			fmt.Fprintln(w, l)
+		}
+	}
+	return nil
+}
@@ -10,16 +10,18 @@ import (
 	"fmt"
 	"html/template"
 	"os"
+	"path"
 
 	"github.com/tmc/covutil/covtree"
 )
 
 var cmdHTML = &Command{
-	UsageLine: "covtree html -i=<directory> [-o=<file>]",
+	UsageLine: "covtree html -i=<directory> [-o=<file>] [-src=<dir>[,<dir>...]]",
 	Short:     "generate HTML coverage report",
 	Long: `
 HTML generates a static HTML coverage report showing the coverage tree
-visualization in a web browser format.
+visualization in a web browser format, together with an annotated view
+of each source file.
 
 The -i flag specifies a directory to scan recursively for coverage data.
 The directory can contain nested subdirectories with coverage data files
@@ -29,22 +31,58 @@ directories will be processed.
 The -o flag specifies an output HTML file. If not specified, the report
 is written to coverage.html in the current directory.
 
+The -src flag specifies a comma-separated list of module root directories
+(each containing a go.mod file) used to locate the source files named in
+the coverage data. Files outside those modules are looked up with
+"go list" from the current directory. Files whose source cannot be found
+are listed in the report without a source view.
+
+In the source view, uncovered code is shown in red and covered code in
+green. For binaries built with -covermode=count or atomic, the shade of
+green reflects how often the code ran, and each line shows its hit count.
+
 Example:
 
 	covtree html -i=./coverage-repo
 	covtree html -i=/path/to/nested/coverage -o=report.html
+	covtree html -i=$GOCOVERDIR -src=.
 `,
 }
 
 var (
 	htmlInputDir = cmdHTML.Flag.String("i", "", "input directory to scan recursively for coverage data")
 	htmlOutput   = cmdHTML.Flag.String("o", "coverage.html", "output HTML file")
+	htmlSrcDirs  = cmdHTML.Flag.String("src", "", "comma-separated module root directories used to locate source files")
 )
 
 func init() {
 	cmdHTML.Run = runHTML
 }
 
+// htmlFile is the annotated source view of a single file.
+type htmlFile struct {
+	ID           string
+	Path         string
+	Package      string
+	CoveredLines int
+	TotalLines   int
+	CoverageRate float64
+	// Heat is set when the counters record hit counts rather than
+	// just whether code ran.
+	Heat     bool
+	MaxCount uint32
+	Lines    []covtree.SourceLine
+	// Err describes why the source is not shown, if it is not.
+	Err string
+}
+
+// htmlFileRef links a package in the package list to one of its files.
+type htmlFileRef struct {
+	ID           string
+	Name         string
+	CoverageRate float64
+}
+
 func runHTML(ctx context.Context, args []string) error {
 	if *htmlInputDir == "" {
 		return fmt.Errorf("must specify input directory with -i flag")
@@ -55,12 +93,22 @@ func runHTML(ctx context.Context, args []string) error {
 		return fmt.Errorf("input directory does not exist: %s", *htmlInputDir)
 	}
 
+	resolver := covtree.NewSourceResolver()
+	for _, dir := range splitCommaList(*htmlSrcDirs) {
+		if err := resolver.AddModuleRoot(dir); err != nil {
+			return fmt.Errorf("invalid -src directory: %v", err)
+		}
+	}
+
 	// Load coverage data from nested repository
 	tree := covtree.NewCoverageTree()
 	if err := tree.LoadFromNestedRepository(*htmlInputDir); err != nil {
 		return fmt.Errorf("failed to load coverage data from %s: %v", *htmlInputDir, err)
 	}
 
+	packages := tree.FilterPackages(covtree.Filter{}) // Get all packages
+	files, fileIndex := buildHTMLFiles(packages, resolver)
+
 	// Create output file
 	f, err := os.Create(*htmlOutput)
 	if err != nil {
@@ -70,20 +118,27 @@ func runHTML(ctx context.Context, args []string) error {
 
 	// Prepare template data
 	data := struct {
-		Summary  covtree.CoverageSummary
-		Packages interface{}
+		Summary   covtree.CoverageSummary
+		Packages  interface{}
+		FileIndex map[string][]htmlFileRef
+		Files     []*htmlFile
 	}{
-		Summary:  tree.Summary(),
-		Packages: tree.FilterPackages(covtree.Filter{}), // Get all packages
+		Summary:   tree.Summary(),
+		Packages:  packages,
+		FileIndex: fileIndex,
+		Files:     files,
 	}
 
 	// Generate HTML report
 	tmpl := template.Must(template.New("coverage").Funcs(template.FuncMap{
 		"mult": func(a, b float64) float64 { return a * b },
-		"json": func(v interface{}) string {
+		// json returns template.JS so that html/template embeds the
+		// value as a JavaScript object rather than a quoted string.
+		"json": func(v interface{}) template.JS {
 			b, _ := json.Marshal(v)
-			return string(b)
+			return template.JS(b)
 		},
+		"covClass": covClass,
 	}).Parse(htmlTemplate))
 
 	if err := tmpl.Execute(f, data); err != nil {
@@ -94,6 +149,54 @@ func runHTML(ctx context.Context, args []string) error {
 	return nil
 }
 
+// buildHTMLFiles annotates the source of every file in packages. It
+// returns the file views and, for each package, links to its files.
+func buildHTMLFiles(packages []*covtree.PackageNode, resolver *covtree.SourceResolver) ([]*htmlFile, map[string][]htmlFileRef) {
+	var files []*htmlFile
+	index := make(map[string][]htmlFileRef)
+	for _, pkg := range packages {
+		for _, fc := range pkg.Files() {
+			hf := &htmlFile{
+				ID:           fmt.Sprintf("file%d", len(files)),
+				Path:         fc.Path,
+				Package:      pkg.ImportPath,
+				CoveredLines: fc.CoveredLines,
+				TotalLines:   fc.TotalLines,
+				CoverageRate: fc.CoverageRate,
+				Heat:         pkg.CounterMode == "count" || pkg.CounterMode == "atomic",
+			}
+			units := fc.Units()
+			if src, err := resolver.ReadSource(pkg.ImportPath, fc.Path); err != nil {
+				hf.Err = err.Error()
+			} else {
+				hf.Lines = covtree.AnnotateLines(src, units)
+				hf.MaxCount = covtree.MaxCount(units)
+			}
+			files = append(files, hf)
+			index[pkg.ImportPath] = append(index[pkg.ImportPath], htmlFileRef{
+				ID:           hf.ID,
+				Name:         path.Base(fc.Path),
+				CoverageRate: fc.CoverageRate,
+			})
+		}
+	}
+	return files, index
+}
+
+// covClass returns the CSS class for code with the given coverage state.
+// Covered code is shaded by hit count when heat is set.
+func covClass(instrumented, covered bool, count, maxCount uint32, heat bool) string {
+	switch {
+	case !instrumented:
+		return "nocov"
+	case !covered:
+		return "cov0"
+	case !heat:
+		return "cov8"
+	}
+	return fmt.Sprintf("cov%d", covtree.HeatLevel(count, maxCount))
+}
+
 const htmlTemplate = `<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -158,7 +261,7 @@ const htmlTemplate = `<!DOCTYPE html>
 			color: #24292e;
 		}
 		.coverage-rate {
-			color: {{if lt .CoverageRate 0.5}}#d73a49{{else if lt .CoverageRate 0.8}}#fb8500{{else}}#28a745{{end}};
+			color: {{if lt .Summary.CoverageRate 0.5}}#d73a49{{else if lt .Summary.CoverageRate 0.8}}#fb8500{{else}}#28a745{{end}};
 		}
 		.controls {
 			padding: 30px;
@@ -251,6 +354,64 @@ const htmlTemplate = `<!DOCTYPE html>
 			padding: 40px;
 			color: #586069;
 		}
+		.files {
+			margin-top: 10px;
+		}
+		.files a {
+			font-family: 'SFMono-Regular', Consolas, 'Liberation Mono', Menlo, monospace;
+			font-size: 0.9em;
+			color: #0366d6;
+			text-decoration: none;
+			margin-right: 15px;
+		}
+		.file-view {
+			display: none;
+			padding: 30px;
+			border-top: 1px solid #e1e4e8;
+		}
+		.file-view:target {
+			display: block;
+		}
+		.file-view h3 {
+			margin-top: 0;
+			font-family: 'SFMono-Regular', Consolas, 'Liberation Mono', Menlo, monospace;
+			font-size: 1em;
+		}
+		.file-error {
+			color: #586069;
+		}
+		table.source {
+			border-collapse: collapse;
+			font-family: 'SFMono-Regular', Consolas, 'Liberation Mono', Menlo, monospace;
+			font-size: 0.85em;
+			line-height: 1.4;
+			width: 100%;
+		}
+		table.source td {
+			padding: 0 8px;
+			vertical-align: top;
+		}
+		table.source td.num, table.source td.hits {
+			text-align: right;
+			color: #959da5;
+			user-select: none;
+			white-space: nowrap;
+		}
+		table.source td.code {
+			white-space: pre;
+		}
+		.nocov { color: #24292e; }
+		.cov0 { background: #ffdce0; color: #b31d28; }
+		.cov1 { background: #e6ffed; }
+		.cov2 { background: #dcffe4; }
+		.cov3 { background: #cdfed7; }
+		.cov4 { background: #bef5cb; }
+		.cov5 { background: #acf2bd; }
+		.cov6 { background: #97eaa9; }
+		.cov7 { background: #85e89d; }
+		.cov8 { background: #6fdd8b; }
+		.cov9 { background: #4fcc6e; }
+		.cov10 { background: #34d058; }
 	</style>
 </head>
 <body>
@@ -298,11 +459,34 @@ const htmlTemplate = `<!DOCTYPE html>
 				<!-- Packages will be populated by JavaScript -->
 			</div>
 		</div>
+
+		{{range .Files}}
+		<div class="file-view" id="{{.ID}}">
+			<h3>{{.Path}} &mdash; {{printf "%.1f" (mult .CoverageRate 100)}}% ({{.CoveredLines}}/{{.TotalLines}})</h3>
+			<p><a href="#">&larr; packages</a></p>
+			{{if .Err}}
+			<p class="file-error">{{.Err}}</p>
+			{{else}}
+			{{$file := .}}
+			<table class="source">
+			{{range .Lines}}
+				<tr>
+					<td class="num">{{.Number}}</td>
+					<td class="hits">{{if and $file.Heat .Instrumented}}{{.Count}}{{end}}</td>
+					<td class="code">{{range .Segments}}<span class="{{covClass .Instrumented .Covered .Count $file.MaxCount $file.Heat}}">{{.Text}}</span>{{end}}</td>
+				</tr>
+			{{end}}
+			</table>
+			{{end}}
+		</div>
+		{{end}}
 	</div>
 
 	<script>
 		// Package data embedded from server
 		const packagesData = {{json .Packages}};
+		// Links from each package to its annotated source files
+		const fileIndex = {{json .FileIndex}};
 
 		function mult(a, b) { return a * b; }
 
@@ -363,6 +547,15 @@ const htmlTemplate = `<!DOCTYPE html>
 				} else {
 					html += '<div class="function">No functions found</div>';
 				}
+
+				const files = fileIndex[pkg.ImportPath] || [];
+				if (files.length > 0) {
+					html += '<div class="files">';
+					files.forEach(f => {
+						html += ` + "`" + `<a href="#${f.ID}">${f.Name} (${(f.CoverageRate * 100).toFixed(1)}%)</a>` + "`" + `;
+					});
+					html += '</div>';
+				}
 				
 				html += '</div></div>';
 			});
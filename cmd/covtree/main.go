@@ -13,7 +13,18 @@
 //	percent		report coverage percentages by package
 //	func		report coverage percentages by function
 //	pkglist		report list of packages with coverage data
+//	query		list the packages, functions or units matching an expression
 //	serve		start HTTP server for interactive coverage exploration
+//	serve9p		serve coverage data as a 9P file system
+//	who-covers	report which tests executed a line or function
+//	tests		report redundant tests and a minimal test set
+//	select-tests	select the tests affected by a patch
+//	scrape		snapshot coverage from a running program
+//	delta		extract the executions between two snapshots
+//	diff		report coverage gained and lost between two runs
+//	patch		report coverage of the lines changed by a patch
+//	export		export coverage data as Cobertura XML, LCOV or a Go coverprofile
+//	check		check coverage against a threshold policy
 //	help		show help for a command
 //
 // Use "covtree help <command>" for more information about a command.
@@ -144,10 +155,21 @@ var commands = []*Command{
 	cmdPercent,
 	cmdFunc,
 	cmdPkglist,
+	cmdQuery,
 	cmdServe,
+	cmdServe9P,
 	cmdJSON,
 	cmdDebug,
 	cmdHTML,
+	cmdWhoCovers,
+	cmdTests,
+	cmdSelectTests,
+	cmdScrape,
+	cmdDelta,
+	cmdDiff,
+	cmdPatch,
+	cmdExport,
+	cmdCheck,
 }
 
 func init() {
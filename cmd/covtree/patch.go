@@ -0,0 +1,131 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/covutil/covtree"
+	"github.com/tmc/covutil/patchcov"
+)
+
+var cmdPatch = &Command{
+	UsageLine: "covtree patch -i=<directory> [-diff=<file>] [-min=<percent>]",
+	Short:     "report coverage of the lines changed by a patch",
+	Long: `
+Patch reports patch coverage: the percentage of lines added or modified
+by a unified diff that were executed, and lists the changed lines that
+were not.
+
+The -i flag specifies a directory to scan recursively for coverage data.
+
+The -diff flag names a file holding a unified diff, such as the output
+of "git diff". If it is omitted or "-", the diff is read from stdin.
+Paths in the diff are matched against the source paths recorded in the
+coverage data by suffix, so a diff taken at the repository root works
+for modules in subdirectories.
+
+Only changed lines inside a coverable unit count towards the percentage;
+test files and non-Go files are ignored. Changed files without any
+coverage data are listed separately.
+
+The -min flag sets a threshold percentage. If patch coverage is below
+it, patch exits with a non-zero status.
+
+Example:
+
+	git diff origin/main | covtree patch -i=./coverage -min=80
+	covtree patch -i=./coverage -diff=change.patch
+`,
+}
+
+var (
+	patchInputDir = cmdPatch.Flag.String("i", "", "input directory to scan recursively for coverage data")
+	patchDiffFile = cmdPatch.Flag.String("diff", "-", "unified diff file, or - for stdin")
+	patchMin      = cmdPatch.Flag.Float64("min", 0, "minimum patch coverage percentage")
+)
+
+func init() {
+	cmdPatch.Run = runPatch
+}
+
+func runPatch(ctx context.Context, args []string) error {
+	if *patchInputDir == "" {
+		return fmt.Errorf("must specify input directory with -i flag")
+	}
+	if _, err := os.Stat(*patchInputDir); os.IsNotExist(err) {
+		return fmt.Errorf("input directory does not exist: %s", *patchInputDir)
+	}
+
+	var r io.Reader = os.Stdin
+	if *patchDiffFile != "-" && *patchDiffFile != "" {
+		f, err := os.Open(*patchDiffFile)
+		if err != nil {
+			return fmt.Errorf("failed to open diff: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	diffs, err := patchcov.ParseUnifiedDiff(r)
+	if err != nil {
+		return fmt.Errorf("failed to parse diff: %v", err)
+	}
+
+	tree := covtree.NewCoverageTree()
+	if err := tree.LoadFromNestedRepository(*patchInputDir); err != nil {
+		return fmt.Errorf("failed to load coverage data from %s: %v", *patchInputDir, err)
+	}
+
+	report := patchcov.Analyze(tree, diffs)
+	for _, f := range report.Files {
+		switch {
+		case f.CoverageFile == "":
+			fmt.Printf("%s\tno coverage data\n", f.Path)
+		case f.Instrumented == 0:
+			fmt.Printf("%s\tno instrumented changes\n", f.Path)
+		case len(f.Uncovered) == 0:
+			fmt.Printf("%s\t%.1f%% (%d/%d)\n", f.Path, percentOf(f.Covered, f.Instrumented), f.Covered, f.Instrumented)
+		default:
+			fmt.Printf("%s\t%.1f%% (%d/%d)\tuncovered: %s\n", f.Path, percentOf(f.Covered, f.Instrumented),
+				f.Covered, f.Instrumented, formatLineRanges(f.Uncovered))
+		}
+	}
+	fmt.Printf("patch coverage: %.1f%% (%d/%d changed lines)\n", report.Percent(), report.Covered, report.Instrumented)
+
+	if report.Percent() < *patchMin {
+		return fmt.Errorf("patch coverage %.1f%% is below minimum %.1f%%", report.Percent(), *patchMin)
+	}
+	return nil
+}
+
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// formatLineRanges formats sorted line numbers compactly, as in "3,7-9".
+func formatLineRanges(lines []int) string {
+	var parts []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(lines[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
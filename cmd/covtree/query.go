@@ -0,0 +1,129 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+var cmdQuery = &Command{
+	UsageLine: "covtree query -i=<directory> [-format=text|json] <expression>",
+	Short:     "list the packages, functions or units matching an expression",
+	Long: `
+Query lists the packages, functions or coverable units matching a filter
+expression, such as
+
+	pkg ~ "internal/*" && func.coverage < 0.5 && func.lines > 20
+
+Comparisons of fields with strings, numbers and booleans are joined by
+&& and ||, negated by !, and grouped by parentheses. Strings are matched
+against patterns with ~ and !~. The fields are:
+
+	pkg, pkg.path, pkg.name, pkg.module, pkg.mode
+	pkg.coverage, pkg.lines, pkg.covered
+	label.<key>
+	func, func.name, func.file, func.literal
+	func.coverage, func.lines, func.covered
+	unit.line, unit.endline, unit.stmts, unit.count, unit.covered
+
+Coverage is a fraction from 0 to 1. Label fields hold the metadata of the
+package, or "" if it has none for the key. See the covtree package
+documentation for details.
+
+The query lists the most detailed nodes it refers to: if it uses unit
+fields it lists units, if it uses function fields it lists functions, and
+otherwise packages.
+
+The -i flag specifies a directory to scan recursively for coverage data.
+
+The -format flag selects the output format: text (the default) or json.
+JSON output holds the matching packages, with only the matching functions
+and units.
+
+The same expressions can be passed to the serve command's /api/packages
+endpoint in the q parameter.
+
+Example:
+
+	covtree query -i=./coverage 'pkg ~ "internal/*" && pkg.coverage < 0.8'
+	covtree query -i=./coverage 'func.coverage == 0 && func.lines > 20'
+	covtree query -i=./coverage 'func == "Parse" && !unit.covered'
+`,
+}
+
+var (
+	queryInputDir = cmdQuery.Flag.String("i", "", "input directory to scan recursively for coverage data")
+	queryFormat   = cmdQuery.Flag.String("format", "text", "output format: text or json")
+)
+
+func init() {
+	cmdQuery.Run = runQuery
+}
+
+func runQuery(ctx context.Context, args []string) error {
+	if *queryInputDir == "" {
+		return fmt.Errorf("must specify input directory with -i flag")
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("must specify exactly one query expression")
+	}
+	if *queryFormat != "text" && *queryFormat != "json" {
+		return fmt.Errorf("unknown format %q: must be text or json", *queryFormat)
+	}
+	q, err := covtree.ParseQuery(args[0])
+	if err != nil {
+		var qe *covtree.QueryError
+		if errors.As(err, &qe) {
+			// Point at the error under the query.
+			return fmt.Errorf("%v\n\t%s\n\t%s^", err, qe.Query, strings.Repeat(" ", qe.Pos))
+		}
+		return err
+	}
+
+	if _, err := os.Stat(*queryInputDir); os.IsNotExist(err) {
+		return fmt.Errorf("input directory does not exist: %s", *queryInputDir)
+	}
+	tree := covtree.NewCoverageTree()
+	if err := tree.LoadFromNestedRepository(*queryInputDir); err != nil {
+		return fmt.Errorf("failed to load coverage data from %s: %v", *queryInputDir, err)
+	}
+
+	pkgs := tree.Query(q)
+	if *queryFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if pkgs == nil {
+			pkgs = []*covtree.PackageNode{}
+		}
+		return enc.Encode(pkgs)
+	}
+	for _, pkg := range pkgs {
+		if q.Level() == covtree.PackageLevel {
+			fmt.Printf("%s\t%.1f%%\t%d/%d\n", pkg.ImportPath, pkg.CoverageRate*100, pkg.CoveredLines, pkg.TotalLines)
+			continue
+		}
+		for _, fn := range pkg.Functions {
+			if q.Level() == covtree.FunctionLevel {
+				var line uint32
+				if len(fn.Units) > 0 {
+					line = fn.Units[0].StartLine
+				}
+				fmt.Printf("%s:%d:\t%s\t%.1f%%\n", fn.File, line, fn.Name, fn.CoverageRate*100)
+				continue
+			}
+			for _, u := range fn.Units {
+				fmt.Printf("%s:%d.%d,%d.%d\t%s\t%d\n", fn.File, u.StartLine, u.StartCol, u.EndLine, u.EndCol, fn.Name, u.Count)
+			}
+		}
+	}
+	return nil
+}
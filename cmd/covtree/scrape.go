@@ -0,0 +1,185 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/covhttp"
+)
+
+var cmdScrape = &Command{
+	UsageLine: "covtree scrape -url=<address> -o=<directory> [-interval=<duration>] [-count=<n>] [-reset]",
+	Short:     "snapshot coverage from a running program",
+	Long: `
+Scrape periodically fetches the coverage data of a running program that
+serves it with the covhttp package, and writes each snapshot to its own
+GOCOVERDIR-compatible directory.
+
+The -url flag specifies the address the covhttp handler is mounted at,
+such as http://localhost:6060/debug/coverage/.
+
+The -o flag specifies the output directory. Each snapshot is written to a
+subdirectory named after the time it was taken, along with a
+pod_metadata.json file labelling it with that time and the address.
+
+The -interval flag sets the time between snapshots (default 30s).
+
+The -count flag sets the number of snapshots to take. If zero, the
+default, scrape runs until interrupted.
+
+The -reset flag resets the program's counters after each snapshot, so
+that each holds only the executions since the previous one. The handler
+must allow it.
+
+Example:
+
+	covtree scrape -url=http://localhost:6060/debug/coverage/ -o=./coverage/staging
+	covtree scrape -url=http://localhost:6060/debug/coverage/ -o=./snapshots -interval=1m -reset
+`,
+}
+
+var (
+	scrapeURL      = cmdScrape.Flag.String("url", "", "address of the covhttp handler")
+	scrapeOutput   = cmdScrape.Flag.String("o", "", "output directory")
+	scrapeInterval = cmdScrape.Flag.Duration("interval", 30*time.Second, "time between snapshots")
+	scrapeCount    = cmdScrape.Flag.Int("count", 0, "number of snapshots to take (0 for no limit)")
+	scrapeReset    = cmdScrape.Flag.Bool("reset", false, "reset the counters after each snapshot")
+)
+
+func init() {
+	cmdScrape.Run = runScrape
+}
+
+func runScrape(ctx context.Context, args []string) error {
+	if *scrapeURL == "" {
+		return fmt.Errorf("must specify the handler address with -url flag")
+	}
+	if *scrapeOutput == "" {
+		return fmt.Errorf("must specify output directory with -o flag")
+	}
+	if *scrapeInterval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(*scrapeInterval)
+	defer ticker.Stop()
+	for n := 1; ; n++ {
+		dir, err := scrapeOnce(ctx, *scrapeURL, *scrapeOutput, *scrapeReset)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", dir)
+		if n == *scrapeCount {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrapeOnce fetches the meta-data and counters served at base and writes
+// them to a new subdirectory of out, which it returns.
+func scrapeOnce(ctx context.Context, base, out string, reset bool) (string, error) {
+	now := time.Now().UTC()
+	meta, _, err := fetch(ctx, base, "meta", "")
+	if err != nil {
+		return "", err
+	}
+	query := ""
+	if reset {
+		query = "reset=1"
+	}
+	counters, header, err := fetch(ctx, base, "counters", query)
+	if err != nil {
+		return "", err
+	}
+
+	mf, err := covutil.LoadMetaFile(bytes.NewReader(meta), "meta")
+	if err != nil {
+		return "", fmt.Errorf("invalid meta-data from %s: %v", base, err)
+	}
+	cf, err := covutil.LoadCounterFile(bytes.NewReader(counters), "counters")
+	if err != nil {
+		return "", fmt.Errorf("invalid counter data from %s: %v", base, err)
+	}
+	if cf.MetaFileHash != mf.FileHash {
+		// The program was restarted with a different binary in between.
+		return "", fmt.Errorf("counter data from %s does not match its meta-data", base)
+	}
+
+	pod := &covutil.Pod{
+		ID:        now.Format("20060102T150405.000000000Z"),
+		Timestamp: now,
+		Labels: map[string]string{
+			"scrape_url":  base,
+			"scrape_time": now.Format(time.RFC3339Nano),
+		},
+	}
+	dir := filepath.Join(out, pod.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	pid, _ := strconv.Atoi(header.Get(covhttp.PidHeader))
+	files := map[string][]byte{
+		fmt.Sprintf("covmeta.%x", mf.FileHash):                                meta,
+		fmt.Sprintf("covcounters.%x.%d.%d", mf.FileHash, pid, now.UnixNano()): counters,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return "", err
+		}
+	}
+	if err := covutil.WritePodMetadata(dir, pod); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// fetch returns the body and header of the response for the named
+// element of the handler at base.
+func fetch(ctx context.Context, base, elem, query string) ([]byte, http.Header, error) {
+	u, err := url.JoinPath(base, elem)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid URL %q: %v", base, err)
+	}
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %v", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("GET %s: %s: %s", u, resp.Status, bytes.TrimSpace(data))
+	}
+	return data, resp.Header, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/patchcov"
+	"github.com/tmc/covutil/testimpact"
+)
+
+var cmdSelectTests = &Command{
+	UsageLine: "covtree select-tests -i=<directory> [-diff=<file>] [-format=text|json]",
+	Short:     "select the tests affected by a patch",
+	Long: `
+Select-tests reports the tests that executed the code changed by a
+unified diff, so that they can be run before the full test suite.
+
+The -i flag specifies a directory holding one coverage directory per test,
+as for who-covers.
+
+The -diff flag names a file holding a unified diff, such as the output
+of "git diff". If it is omitted or "-", the diff is read from stdin.
+Paths in the diff are matched against the source paths recorded in the
+coverage data by suffix, as for patch. Test files are ignored.
+
+In text format, the first line of output is a regular expression for the
+-run flag of "go test" matching the selected tests, or "^$" if no test
+executed the change. It is followed by the changed units no test
+executed, and the changed files without any coverage data, whose tests
+cannot be selected.
+
+The -format flag selects the output format: text (the default) or json.
+
+Example:
+
+	git diff origin/main | covtree select-tests -i=./coverage/per-test
+	go test -run "$(covtree select-tests -i=./coverage/per-test -diff=change.patch | head -1)" ./...
+`,
+}
+
+var (
+	selectTestsInputDir = cmdSelectTests.Flag.String("i", "", "input directory with one coverage directory per test")
+	selectTestsDiffFile = cmdSelectTests.Flag.String("diff", "-", "unified diff file, or - for stdin")
+	selectTestsFormat   = cmdSelectTests.Flag.String("format", "text", "output format: text or json")
+)
+
+func init() {
+	cmdSelectTests.Run = runSelectTests
+}
+
+func runSelectTests(ctx context.Context, args []string) error {
+	if *selectTestsInputDir == "" {
+		return fmt.Errorf("must specify input directory with -i flag")
+	}
+	if *selectTestsFormat != "text" && *selectTestsFormat != "json" {
+		return fmt.Errorf("unknown format %q: must be text or json", *selectTestsFormat)
+	}
+	if _, err := os.Stat(*selectTestsInputDir); os.IsNotExist(err) {
+		return fmt.Errorf("input directory does not exist: %s", *selectTestsInputDir)
+	}
+
+	var r io.Reader = os.Stdin
+	if *selectTestsDiffFile != "-" && *selectTestsDiffFile != "" {
+		f, err := os.Open(*selectTestsDiffFile)
+		if err != nil {
+			return fmt.Errorf("failed to open diff: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	diffs, err := patchcov.ParseUnifiedDiff(r)
+	if err != nil {
+		return fmt.Errorf("failed to parse diff: %v", err)
+	}
+
+	set, err := covutil.LoadCoverageSet(os.DirFS(*selectTestsInputDir), covutil.WithTestDirectories())
+	if err != nil {
+		return fmt.Errorf("failed to load coverage data from %s: %v", *selectTestsInputDir, err)
+	}
+	if len(set.TestNames()) == 0 {
+		return fmt.Errorf("no per-test coverage data found in %s", *selectTestsInputDir)
+	}
+
+	s := testimpact.Select(set, diffs)
+	if *selectTestsFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Run string `json:"run"`
+			*testimpact.Selection
+		}{s.RunPattern(), s})
+	}
+
+	fmt.Println(s.RunPattern())
+	for _, u := range s.Untested {
+		fmt.Printf("untested\t%s\t%s\n", u, u.FuncName)
+	}
+	for _, f := range s.Unmatched {
+		fmt.Printf("no coverage data\t%s\n", f)
+	}
+	return nil
+}
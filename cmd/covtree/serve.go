@@ -19,7 +19,7 @@ import (
 )
 
 var cmdServe = &Command{
-	UsageLine: "covtree serve -i=<directory> -http=<addr>",
+	UsageLine: "covtree serve -i=<directory> -http=<addr> [-watch]",
 	Short:     "start HTTP server for interactive coverage exploration",
 	Long: `
 Serve starts an HTTP server that provides an interactive web interface
@@ -32,16 +32,26 @@ directories will be processed.
 
 The -http flag specifies the address and port to listen on (e.g., ":8080").
 
+The /api/packages endpoint accepts the filter expressions of the query
+command in the q parameter, such as ?q=func.coverage<0.5.
+
+The -watch flag reloads the data as it changes, such as while "go test"
+writes to the directory in another terminal, and updates the open page
+without reloading it. Only the coverage directories that changed are read
+again. The changes are streamed as server-sent events from /api/events.
+
 Example:
 
 	covtree serve -i=./coverage-repo -http=:8080
 	covtree serve -i=/path/to/nested/coverage -http=localhost:9000
+	covtree serve -i=./coverage -watch
 `,
 }
 
 var (
 	serveInputDir = cmdServe.Flag.String("i", "", "input directory to scan recursively for coverage data")
 	serveHTTPAddr = cmdServe.Flag.String("http", ":8080", "HTTP server address")
+	serveWatch    = cmdServe.Flag.Bool("watch", false, "reload the data and update the page as it changes")
 )
 
 func init() {
@@ -58,30 +68,51 @@ func runServe(ctx context.Context, args []string) error {
 		return fmt.Errorf("input directory does not exist: %s", *serveInputDir)
 	}
 
-	// Load coverage data from nested repository
-	tree := covtree.NewCoverageTree()
-	if err := tree.LoadFromNestedRepository(*serveInputDir); err != nil {
-		return fmt.Errorf("failed to load coverage data from %s: %v", *serveInputDir, err)
+	// Load coverage data from nested repository. When watching, the
+	// directory may not hold any yet.
+	var current func() *covtree.CoverageTree
+	mux := http.NewServeMux()
+	if *serveWatch {
+		watcher, err := covtree.NewWatcher(*serveInputDir, 0)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %v", *serveInputDir, err)
+		}
+		go watcher.Run(ctx)
+		current = watcher.Tree
+		mux.HandleFunc("/api/events", watcher.ServeEvents)
+	} else {
+		tree := covtree.NewCoverageTree()
+		if err := tree.LoadFromNestedRepository(*serveInputDir); err != nil {
+			return fmt.Errorf("failed to load coverage data from %s: %v", *serveInputDir, err)
+		}
+		current = func() *covtree.CoverageTree { return tree }
 	}
 
 	// Set up HTTP handlers
-	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		serveIndex(w, r, tree)
+		serveIndex(w, r, current(), *serveWatch)
 	})
 	mux.HandleFunc("/api/summary", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(tree.Summary())
+		json.NewEncoder(w).Encode(current().Summary())
 	})
 	mux.HandleFunc("/api/packages", func(w http.ResponseWriter, r *http.Request) {
 		filterObj := parseFilterFromQuery(r)
-		packages := tree.FilterPackages(filterObj)
+		packages := current().FilterPackages(filterObj)
+		if expr := r.URL.Query().Get("q"); expr != "" {
+			q, err := covtree.ParseQuery(expr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			packages = q.Filter(packages)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(packages)
 	})
 	mux.HandleFunc("/api/package/", func(w http.ResponseWriter, r *http.Request) {
 		packagePath := strings.TrimPrefix(r.URL.Path, "/api/package/")
-		pkg := tree.GetPackage(packagePath)
+		pkg := current().GetPackage(packagePath)
 		if pkg == nil {
 			http.NotFound(w, r)
 			return
@@ -91,7 +122,7 @@ func runServe(ctx context.Context, args []string) error {
 	})
 
 	log.Printf("covtree: serving coverage data at http://%s", *serveHTTPAddr)
-	log.Printf("covtree: loaded %d packages", len(tree.Packages))
+	log.Printf("covtree: loaded %d packages", len(current().Packages))
 
 	server := &http.Server{
 		Addr:    *serveHTTPAddr,
@@ -123,12 +154,16 @@ func parseFilterFromQuery(r *http.Request) covtree.Filter {
 	return filterObj
 }
 
-func serveIndex(w http.ResponseWriter, r *http.Request, tree *covtree.CoverageTree) {
+func serveIndex(w http.ResponseWriter, r *http.Request, tree *covtree.CoverageTree, watch bool) {
 	tmpl := template.Must(template.New("index").Funcs(template.FuncMap{
 		"mult": func(a, b float64) float64 { return a * b },
 	}).Parse(indexTemplate))
+	data := struct {
+		covtree.CoverageSummary
+		Watch bool
+	}{tree.Summary(), watch}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := tmpl.Execute(w, tree.Summary()); err != nil {
+	if err := tmpl.Execute(w, data); err != nil {
 		log.Printf("covtree: template error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
@@ -304,19 +339,19 @@ const indexTemplate = `<!DOCTYPE html>
 			<div class="stats">
 				<div class="stat">
 					<div class="stat-label">Total Packages</div>
-					<div class="stat-value">{{.TotalPackages}}</div>
+					<div class="stat-value" id="total-packages">{{.TotalPackages}}</div>
 				</div>
 				<div class="stat">
 					<div class="stat-label">Total Lines</div>
-					<div class="stat-value">{{.TotalLines}}</div>
+					<div class="stat-value" id="total-lines">{{.TotalLines}}</div>
 				</div>
 				<div class="stat">
 					<div class="stat-label">Covered Lines</div>
-					<div class="stat-value">{{.CoveredLines}}</div>
+					<div class="stat-value" id="covered-lines">{{.CoveredLines}}</div>
 				</div>
 				<div class="stat">
 					<div class="stat-label">Coverage</div>
-					<div class="stat-value coverage-rate">{{printf "%.1f" (mult .CoverageRate 100)}}%</div>
+					<div class="stat-value coverage-rate" id="coverage-rate">{{printf "%.1f" (mult .CoverageRate 100)}}%</div>
 				</div>
 			</div>
 		</div>
@@ -327,6 +362,7 @@ const indexTemplate = `<!DOCTYPE html>
 				<input type="text" id="filter" placeholder="Package pattern (e.g., github.com/*)">
 				<input type="number" id="minCov" placeholder="Min coverage %" step="0.1" min="0" max="100">
 				<input type="number" id="maxCov" placeholder="Max coverage %" step="0.1" min="0" max="100">
+				<input type="text" id="query" placeholder="Query (e.g., func.coverage &lt; 0.5)">
 				<button onclick="loadPackages()">Apply Filter</button>
 			</div>
 		</div>
@@ -346,17 +382,25 @@ const indexTemplate = `<!DOCTYPE html>
 			const filter = document.getElementById('filter').value;
 			const minCov = document.getElementById('minCov').value;
 			const maxCov = document.getElementById('maxCov').value;
+			const query = document.getElementById('query').value;
 			
 			let url = '/api/packages?';
 			if (filter) url += 'pattern=' + encodeURIComponent(filter) + '&';
 			if (minCov) url += 'min_coverage=' + (parseFloat(minCov) / 100) + '&';
 			if (maxCov) url += 'max_coverage=' + (parseFloat(maxCov) / 100) + '&';
+			if (query) url += 'q=' + encodeURIComponent(query) + '&';
 			
 			fetch(url)
-				.then(response => response.json())
+				.then(response => {
+					if (!response.ok) {
+						// Report query errors, which say where the query is wrong.
+						return response.text().then(text => { throw new Error(text); });
+					}
+					return response.json();
+				})
 				.then(data => {
 					const container = document.getElementById('packages-list');
-					if (data.length === 0) {
+					if (!data || data.length === 0) {
 						container.innerHTML = '<div class="loading">No packages match the filter criteria.</div>';
 						return;
 					}
@@ -400,8 +444,10 @@ const indexTemplate = `<!DOCTYPE html>
 				})
 				.catch(error => {
 					console.error('Error loading packages:', error);
-					document.getElementById('packages-list').innerHTML = 
-						'<div class="loading">Error loading packages. Please try again.</div>';
+					const message = document.createElement('div');
+					message.className = 'loading';
+					message.textContent = 'Error loading packages: ' + error.message;
+					document.getElementById('packages-list').replaceChildren(message);
 				});
 		}
 
@@ -412,6 +458,22 @@ const indexTemplate = `<!DOCTYPE html>
 
 		// Load packages on page load
 		loadPackages();
+{{if .Watch}}
+		// Follow changes to the coverage data.
+		new EventSource('/api/events').addEventListener('update', event => {
+			const update = JSON.parse(event.data);
+			const s = update.Summary;
+			document.getElementById('total-packages').textContent = s.TotalPackages;
+			document.getElementById('total-lines').textContent = s.TotalLines;
+			document.getElementById('covered-lines').textContent = s.CoveredLines;
+			const rate = document.getElementById('coverage-rate');
+			rate.textContent = (s.CoverageRate * 100).toFixed(1) + '%';
+			rate.style.color = s.CoverageRate < 0.5 ? '#d73a49' : s.CoverageRate < 0.8 ? '#fb8500' : '#28a745';
+			if (update.Packages && update.Packages.length > 0) {
+				loadPackages();
+			}
+		});
+{{end}}
 	</script>
 </body>
 </html>`
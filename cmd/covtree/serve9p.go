@@ -0,0 +1,98 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/cov9p"
+)
+
+var cmdServe9P = &Command{
+	UsageLine: "covtree serve9p -i=<directory> [-net=unix|tcp] [-addr=<address>]",
+	Short:     "serve coverage data as a 9P file system",
+	Long: `
+Serve9p serves the coverage data in a directory as a file system over the
+9P2000 protocol, so that it can be mounted and browsed with ls, cat, grep
+and editors. The tree holds the pods, their labels, packages, functions
+and a summary. Writing a label expression such as "team=core suite=unit"
+to ctl/filter creates a view of the matching pods under views/, and
+writing one to ctl/merge creates a view of their merge. See the cov9p
+package documentation for details.
+
+The -i flag specifies a directory to scan recursively for coverage data.
+Labels written to pod_metadata.json files are loaded with the data.
+
+The -net flag selects the network to listen on: unix (the default) or tcp.
+
+The -addr flag specifies the address to listen on: a socket path for unix
+(default covtree.sock in the temporary directory), or a host and port for
+tcp.
+
+Example:
+
+	covtree serve9p -i=./coverage -addr=/tmp/cov.sock
+	mount -t 9p -o trans=unix,version=9p2000,uname=$USER /tmp/cov.sock /mnt/cov
+	ls /mnt/cov/by-package/example.com/mod
+`,
+}
+
+var (
+	serve9PInputDir = cmdServe9P.Flag.String("i", "", "input directory to scan recursively for coverage data")
+	serve9PNet      = cmdServe9P.Flag.String("net", "unix", "network to listen on: unix or tcp")
+	serve9PAddr     = cmdServe9P.Flag.String("addr", filepath.Join(os.TempDir(), "covtree.sock"), "address to listen on")
+)
+
+func init() {
+	cmdServe9P.Run = runServe9P
+}
+
+func runServe9P(ctx context.Context, args []string) error {
+	if *serve9PInputDir == "" {
+		return fmt.Errorf("must specify input directory with -i flag")
+	}
+	if *serve9PNet != "unix" && *serve9PNet != "tcp" {
+		return fmt.Errorf("unknown network %q: must be unix or tcp", *serve9PNet)
+	}
+	if _, err := os.Stat(*serve9PInputDir); os.IsNotExist(err) {
+		return fmt.Errorf("input directory does not exist: %s", *serve9PInputDir)
+	}
+	set, err := covutil.LoadCoverageSet(os.DirFS(*serve9PInputDir))
+	if err != nil {
+		return fmt.Errorf("failed to load coverage data from %s: %v", *serve9PInputDir, err)
+	}
+
+	if *serve9PNet == "unix" {
+		// Remove the socket left by a previous run.
+		if fi, err := os.Stat(*serve9PAddr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(*serve9PAddr)
+		}
+	}
+	l, err := net.Listen(*serve9PNet, *serve9PAddr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		l.Close() // also removes the unix socket
+	}()
+
+	log.Printf("serving %d pods from %s on %s!%s", len(set.Pods), *serve9PInputDir, *serve9PNet, *serve9PAddr)
+	err = cov9p.NewServer(set).Serve(l)
+	if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/testimpact"
+)
+
+var cmdTests = &Command{
+	UsageLine: "covtree tests -i=<directory> [-format=text|json] [-v]",
+	Short:     "report redundant tests and a minimal test set",
+	Long: `
+Tests analyzes the coverage of individual tests. For each test it reports
+the number of units it covers, how many of them no other test covers, and
+the tests that cover the same units or a strict superset of them. Tests
+that cover no unit of their own add nothing to the total coverage.
+
+It also reports a minimal set of tests that together reach the same
+coverage as all of them, chosen greedily by the number of units each test
+adds.
+
+The -i flag specifies a directory holding one coverage directory per test,
+as for who-covers.
+
+The -format flag selects the output format: text (the default) or json.
+
+The -v flag also prints the units each test covers on its own.
+
+Example:
+
+	covtree tests -i=./coverage/per-test
+	covtree tests -i=./coverage/per-test -format=json
+`,
+}
+
+var (
+	testsInputDir = cmdTests.Flag.String("i", "", "input directory with one coverage directory per test")
+	testsFormat   = cmdTests.Flag.String("format", "text", "output format: text or json")
+	testsVerbose  = cmdTests.Flag.Bool("v", false, "print the units only each test covers")
+)
+
+func init() {
+	cmdTests.Run = runTests
+}
+
+func runTests(ctx context.Context, args []string) error {
+	if *testsInputDir == "" {
+		return fmt.Errorf("must specify input directory with -i flag")
+	}
+	if *testsFormat != "text" && *testsFormat != "json" {
+		return fmt.Errorf("unknown format %q: must be text or json", *testsFormat)
+	}
+	if _, err := os.Stat(*testsInputDir); os.IsNotExist(err) {
+		return fmt.Errorf("input directory does not exist: %s", *testsInputDir)
+	}
+
+	set, err := covutil.LoadCoverageSet(os.DirFS(*testsInputDir), covutil.WithTestDirectories())
+	if err != nil {
+		return fmt.Errorf("failed to load coverage data from %s: %v", *testsInputDir, err)
+	}
+	if len(set.TestNames()) == 0 {
+		return fmt.Errorf("no per-test coverage data found in %s", *testsInputDir)
+	}
+
+	r := testimpact.Analyze(set)
+	if *testsFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	return writeTestsText(os.Stdout, r, *testsVerbose)
+}
+
+func writeTestsText(w io.Writer, r *testimpact.Report, verbose bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TEST\tUNITS\tUNIQUE\tREDUNDANT WITH")
+	for _, t := range r.Tests {
+		var with []string
+		if len(t.SameAs) > 0 {
+			with = append(with, "same as "+strings.Join(t.SameAs, ", "))
+		}
+		if len(t.SubsetOf) > 0 {
+			with = append(with, "subset of "+strings.Join(t.SubsetOf, ", "))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", t.Name, t.Units, len(t.Unique), strings.Join(with, "; "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if verbose {
+		for _, t := range r.Tests {
+			if len(t.Unique) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\n%s covers on its own:\n", t.Name)
+			for _, u := range t.Unique {
+				fmt.Fprintf(w, "\t%s\t%s\n", u, u.FuncName)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d units covered by %d tests\n", r.Units, len(r.Tests))
+	if zero := r.ZeroValue(); len(zero) > 0 {
+		names := make([]string, len(zero))
+		for i, t := range zero {
+			names[i] = t.Name
+		}
+		fmt.Fprintf(w, "no unique coverage: %s\n", strings.Join(names, " "))
+	}
+	_, err := fmt.Fprintf(w, "minimal set (%d of %d tests): %s\n", len(r.Minimal), len(r.Tests), strings.Join(r.Minimal, " "))
+	return err
+}
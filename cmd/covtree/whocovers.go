@@ -0,0 +1,129 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/covutil"
+)
+
+var cmdWhoCovers = &Command{
+	UsageLine: "covtree who-covers -i=<directory> [-v] <file>:<line> | <pkg>.<func>",
+	Short:     "report which tests executed a line or function",
+	Long: `
+Who-covers reports the tests that executed a source line or function.
+
+The -i flag specifies a directory holding one coverage directory per test,
+as written when GOCOVERDIR is set separately for each test. Each
+subdirectory's path, relative to the input directory, is taken as the
+test name unless the pod carries a GoTestName label.
+
+The argument is either a source position of the form file:line, where
+file is the path recorded in the coverage data or any suffix of it
+(such as "pkg/file.go"), or a function of the form importpath.Func.
+
+The -v flag also prints the units each test executed along with their
+hit counts.
+
+Example:
+
+	covtree who-covers -i=./coverage/per-test parser/lex.go:120
+	covtree who-covers -i=./coverage/per-test example.com/mod/parser.Parse
+`,
+}
+
+var (
+	whoCoversInputDir = cmdWhoCovers.Flag.String("i", "", "input directory with one coverage directory per test")
+	whoCoversVerbose  = cmdWhoCovers.Flag.Bool("v", false, "print the units executed by each test")
+)
+
+func init() {
+	cmdWhoCovers.Run = runWhoCovers
+}
+
+func runWhoCovers(ctx context.Context, args []string) error {
+	if *whoCoversInputDir == "" {
+		return fmt.Errorf("must specify input directory with -i flag")
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("must specify a single file:line or function")
+	}
+	if _, err := os.Stat(*whoCoversInputDir); os.IsNotExist(err) {
+		return fmt.Errorf("input directory does not exist: %s", *whoCoversInputDir)
+	}
+
+	set, err := covutil.LoadCoverageSet(os.DirFS(*whoCoversInputDir), covutil.WithTestDirectories())
+	if err != nil {
+		return fmt.Errorf("failed to load coverage data from %s: %v", *whoCoversInputDir, err)
+	}
+	if len(set.TestNames()) == 0 {
+		return fmt.Errorf("no per-test coverage data found in %s", *whoCoversInputDir)
+	}
+
+	var tests []string
+	var match func(covutil.CoveredUnit) bool
+	if file, line, ok := parseFileLine(args[0]); ok {
+		tests = set.TestsCovering(file, line)
+		match = func(u covutil.CoveredUnit) bool {
+			return u.Spans(file, line)
+		}
+	} else {
+		pkgPath, funcName, ok := splitFuncName(args[0])
+		if !ok {
+			return fmt.Errorf("invalid argument %q: want file:line or importpath.Func", args[0])
+		}
+		tests = set.TestsCoveringFunc(pkgPath, funcName)
+		match = func(u covutil.CoveredUnit) bool {
+			return u.PkgPath == pkgPath && u.FuncName == funcName
+		}
+	}
+
+	if len(tests) == 0 {
+		fmt.Fprintf(os.Stderr, "no tests executed %s\n", args[0])
+		return nil
+	}
+	for _, test := range tests {
+		fmt.Println(test)
+		if !*whoCoversVerbose {
+			continue
+		}
+		for _, u := range set.UnitsCoveredBy(test) {
+			if match(u) {
+				fmt.Printf("\t%s\t%s\t%d\n", u, u.FuncName, u.Count)
+			}
+		}
+	}
+	return nil
+}
+
+// parseFileLine splits an argument of the form file:line.
+func parseFileLine(arg string) (string, uint32, bool) {
+	i := strings.LastIndex(arg, ":")
+	if i <= 0 {
+		return "", 0, false
+	}
+	n, err := strconv.ParseUint(arg[i+1:], 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+	return arg[:i], uint32(n), true
+}
+
+// splitFuncName splits an argument of the form importpath.Func, where the
+// function name may itself contain dots (methods and closures).
+func splitFuncName(arg string) (string, string, bool) {
+	slash := strings.LastIndex(arg, "/")
+	i := strings.Index(arg[slash+1:], ".")
+	if i < 0 {
+		return "", "", false
+	}
+	i += slash + 1
+	return arg[:i], arg[i+1:], i > 0 && i < len(arg)-1
+}
@@ -0,0 +1,588 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cov9p serves the virtual filesystem of a covutil.CoverageSet
+// over the 9P2000 protocol, so that coverage data can be mounted and
+// browsed with ls, cat, grep and editors.
+//
+// The served tree is that of the set, with package and file paths split
+// into directories at their slashes, and these additions:
+//
+//	/ctl/filter  write a label expression to create a view of the matching pods
+//	/ctl/merge   write a label expression to create a view of their merge
+//	/views/<n>/  the views, each with the tree of a coverage set
+//
+// A label expression is a list of key=value pairs, separated by spaces or
+// commas, that matches the pods having all the labels. An empty expression
+// written to /ctl/merge merges all pods, including pods of different
+// binaries. After a write, reading the control file through the same open
+// file returns the path of the new view; otherwise reading either control
+// file lists the views and the commands that created them. Views are
+// snapshots of the set taken when they are created.
+//
+// To serve a directory of coverage data on a Unix socket:
+//
+//	set, err := covutil.LoadCoverageSet(os.DirFS("coverage"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	l, err := net.Listen("unix", "/tmp/cov.sock")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(cov9p.NewServer(set).Serve(l))
+//
+// On Linux the tree can then be mounted with
+//
+//	mount -t 9p -o trans=unix,version=9p2000,uname=$USER /tmp/cov.sock /mnt/cov
+package cov9p
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/tmc/covutil"
+)
+
+// Server serves a coverage set over 9P.
+type Server struct {
+	set   *covutil.CoverageSet
+	mtime uint32
+
+	mu    sync.Mutex
+	views []*view
+}
+
+// view is a coverage set created through a control file.
+type view struct {
+	name string
+	cmd  string // the command that created the view, such as "filter team=core"
+	set  *covutil.CoverageSet
+}
+
+// NewServer returns a server for set.
+func NewServer(set *covutil.CoverageSet) *Server {
+	return &Server{set: set, mtime: uint32(time.Now().Unix())}
+}
+
+// Serve accepts connections on l and serves each in a new goroutine. It
+// returns the error of Accept.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn serves the 9P requests read from rwc until the client
+// disconnects, then closes rwc.
+func (s *Server) ServeConn(rwc io.ReadWriteCloser) error {
+	defer rwc.Close()
+	c := &conn{srv: s, msize: maxMsize, fids: make(map[uint32]*fid)}
+	for {
+		b, err := readMsg(rwc, c.msize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		req, err := unmarshal(b)
+		var resp *fcall
+		if err != nil {
+			resp = rerr(err)
+		} else {
+			resp = c.handle(req)
+		}
+		resp.Tag = req.Tag
+		if _, err := rwc.Write(marshal(resp)); err != nil {
+			return err
+		}
+	}
+}
+
+// conn is the state of a client connection.
+type conn struct {
+	srv   *Server
+	msize uint32
+	uname string
+	fids  map[uint32]*fid
+}
+
+// fid is a client's reference to a file.
+type fid struct {
+	n       *node
+	open    bool
+	mode    uint8
+	data    []byte   // contents of the open file
+	entries [][]byte // entries of the open directory
+}
+
+var (
+	errUnknownFid = errors.New("unknown fid")
+	errFidInUse   = errors.New("fid in use")
+	errOpen       = errors.New("fid is open")
+	errNotOpen    = errors.New("fid not open for this operation")
+	errNotDir     = errors.New("not a directory")
+)
+
+func rerr(err error) *fcall {
+	// Clients such as the Linux kernel map the plain messages of these
+	// errors to error numbers.
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		err = fs.ErrNotExist
+	case errors.Is(err, fs.ErrPermission):
+		err = fs.ErrPermission
+	}
+	return &fcall{Type: rerror, Ename: err.Error()}
+}
+
+func (c *conn) handle(req *fcall) *fcall {
+	switch req.Type {
+	case tversion:
+		if req.Msize < minMsize {
+			return rerr(fmt.Errorf("msize %d too small", req.Msize))
+		}
+		c.msize = min(req.Msize, maxMsize)
+		clear(c.fids)
+		v := "unknown"
+		if strings.HasPrefix(req.Version, version) {
+			v = version
+		}
+		return &fcall{Type: rversion, Msize: c.msize, Version: v}
+	case tauth:
+		return rerr(errors.New("authentication not required"))
+	case tattach:
+		return c.attach(req)
+	case tflush:
+		// Requests are answered in order, so there is nothing to flush.
+		return &fcall{Type: rflush}
+	}
+
+	f, ok := c.fids[req.Fid]
+	if !ok {
+		return rerr(errUnknownFid)
+	}
+	switch req.Type {
+	case twalk:
+		return c.walk(f, req)
+	case topen:
+		return c.open(f, req)
+	case tread:
+		return c.read(f, req)
+	case twrite:
+		if !f.open || f.mode&3 == oRead || f.mode&3 == oExec {
+			return rerr(errNotOpen)
+		}
+		reply, err := c.srv.ctl(f.n.path, string(req.Data))
+		if err != nil {
+			return rerr(err)
+		}
+		f.data = reply
+		return &fcall{Type: rwrite, Count: uint32(len(req.Data))}
+	case tclunk:
+		delete(c.fids, req.Fid)
+		return &fcall{Type: rclunk}
+	case tremove:
+		delete(c.fids, req.Fid)
+		return rerr(fs.ErrPermission)
+	case tstat:
+		n, err := c.srv.lookup(f.n.path)
+		if err != nil {
+			return rerr(err)
+		}
+		return &fcall{Type: rstat, Stat: c.srv.stat(n, c.uname).marshal()}
+	case twstat:
+		// Accept the truncation of control files by clients that
+		// implement O_TRUNC with a wstat.
+		if !f.n.ctl {
+			return rerr(fs.ErrPermission)
+		}
+		return &fcall{Type: rwstat}
+	case tcreate:
+		return rerr(fs.ErrPermission)
+	}
+	return rerr(fmt.Errorf("bad 9P message type %d", req.Type))
+}
+
+func (c *conn) attach(req *fcall) *fcall {
+	if req.Afid != noFid {
+		return rerr(errors.New("authentication not required"))
+	}
+	if _, ok := c.fids[req.Fid]; ok {
+		return rerr(errFidInUse)
+	}
+	n, err := c.srv.lookup("")
+	if err != nil {
+		return rerr(err)
+	}
+	c.uname = req.Uname
+	c.fids[req.Fid] = &fid{n: n}
+	return &fcall{Type: rattach, Qid: n.qid()}
+}
+
+func (c *conn) walk(f *fid, req *fcall) *fcall {
+	if f.open {
+		return rerr(errOpen)
+	}
+	if _, ok := c.fids[req.Newfid]; ok && req.Newfid != req.Fid {
+		return rerr(errFidInUse)
+	}
+	n := f.n
+	var qids []qid
+	for i, name := range req.Wname {
+		next, err := c.srv.walk(n, name)
+		if err != nil {
+			if i == 0 {
+				return rerr(err)
+			}
+			// A partial walk leaves newfid unused.
+			return &fcall{Type: rwalk, Wqid: qids}
+		}
+		n = next
+		qids = append(qids, n.qid())
+	}
+	c.fids[req.Newfid] = &fid{n: n}
+	return &fcall{Type: rwalk, Wqid: qids}
+}
+
+func (c *conn) open(f *fid, req *fcall) *fcall {
+	if f.open {
+		return rerr(errOpen)
+	}
+	write := req.Mode&3 == oWrite || req.Mode&3 == oRDWR || req.Mode&oTrunc != 0
+	if write && !f.n.ctl || req.Mode&oRClose != 0 {
+		return rerr(fs.ErrPermission)
+	}
+	n, err := c.srv.lookup(f.n.path)
+	if err != nil {
+		return rerr(err)
+	}
+	switch {
+	case n.dir:
+		names, err := c.srv.list(n.path)
+		if err != nil {
+			return rerr(err)
+		}
+		for _, name := range names {
+			child, err := c.srv.lookup(join(n.path, name))
+			if err != nil {
+				continue
+			}
+			f.entries = append(f.entries, c.srv.stat(child, c.uname).marshal())
+		}
+	case n.ctl:
+		f.data = c.srv.ctlStatus()
+	default:
+		fsys, name := c.srv.resolve(n.path)
+		if f.data, err = fs.ReadFile(fsys, name); err != nil {
+			return rerr(err)
+		}
+	}
+	f.n, f.open, f.mode = n, true, req.Mode
+	return &fcall{Type: ropen, Qid: n.qid(), Iounit: c.msize - ioHdrSz}
+}
+
+func (c *conn) read(f *fid, req *fcall) *fcall {
+	if !f.open || f.mode&3 == oWrite {
+		return rerr(errNotOpen)
+	}
+	count := int(min(req.Count, c.msize-ioHdrSz))
+	var data []byte
+	if f.n.dir {
+		// Directory reads return whole entries, starting at the offset
+		// reached by the previous read.
+		var off uint64
+		for _, e := range f.entries {
+			if off >= req.Offset {
+				if len(data)+len(e) > count {
+					break
+				}
+				data = append(data, e...)
+			}
+			off += uint64(len(e))
+		}
+	} else if req.Offset < uint64(len(f.data)) {
+		data = f.data[req.Offset:]
+		if len(data) > count {
+			data = data[:count]
+		}
+	}
+	return &fcall{Type: rread, Data: data}
+}
+
+// node describes a file of the server's tree.
+type node struct {
+	path   string // slash-separated, "" for the root
+	dir    bool
+	ctl    bool
+	length int64
+}
+
+func (n *node) qid() qid {
+	h := fnv.New64a()
+	h.Write([]byte(n.path))
+	q := qid{Path: h.Sum64()}
+	if n.dir {
+		q.Type = qtDir
+	}
+	return q
+}
+
+func (s *Server) stat(n *node, uname string) *dir {
+	if uname == "" {
+		uname = "none"
+	}
+	d := &dir{
+		Qid:    n.qid(),
+		Mode:   0444,
+		Atime:  s.mtime,
+		Mtime:  s.mtime,
+		Length: uint64(n.length),
+		Name:   path.Base(n.path),
+		Uid:    uname,
+		Gid:    uname,
+		Muid:   uname,
+	}
+	switch {
+	case n.path == "":
+		d.Name = "/"
+		fallthrough
+	case n.dir:
+		d.Mode = dmDir | 0555
+		d.Length = 0
+	case n.ctl:
+		d.Mode = 0666
+	}
+	return d
+}
+
+func join(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// walk returns the file name in the directory n.
+func (s *Server) walk(n *node, name string) (*node, error) {
+	if !n.dir {
+		return nil, errNotDir
+	}
+	switch {
+	case name == "..":
+		parent := path.Dir(n.path)
+		if parent == "." {
+			parent = ""
+		}
+		return s.lookup(parent)
+	case name == "" || name == "." || strings.Contains(name, "/"):
+		return nil, fs.ErrNotExist
+	}
+	return s.lookup(join(n.path, name))
+}
+
+// resolve returns the coverage set holding the file at p and the file's
+// name in it, or a nil set if there is none.
+func (s *Server) resolve(p string) (fs.FS, string) {
+	first, rest, _ := strings.Cut(p, "/")
+	switch first {
+	case "ctl":
+		return nil, ""
+	case "views":
+		name, rest, _ := strings.Cut(rest, "/")
+		v := s.view(name)
+		if v == nil {
+			return nil, ""
+		}
+		if rest == "" {
+			rest = "."
+		}
+		return v.set, rest
+	}
+	if p == "" {
+		p = "."
+	}
+	return s.set, p
+}
+
+func (s *Server) view(name string) *view {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.views {
+		if v.name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// lookup describes the file at p.
+func (s *Server) lookup(p string) (*node, error) {
+	switch p {
+	case "", "ctl", "views":
+		return &node{path: p, dir: true}, nil
+	case "ctl/filter", "ctl/merge":
+		return &node{path: p, ctl: true}, nil
+	}
+	fsys, name := s.resolve(p)
+	if fsys == nil {
+		return nil, fs.ErrNotExist
+	}
+	info, err := fs.Stat(fsys, name)
+	if err == nil {
+		return &node{path: p, dir: info.IsDir(), length: info.Size()}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if names, _ := prefixEntries(fsys, name); len(names) > 0 {
+		return &node{path: p, dir: true}, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+// list returns the names of the files in the directory at p.
+func (s *Server) list(p string) ([]string, error) {
+	switch p {
+	case "ctl":
+		return []string{"filter", "merge"}, nil
+	case "views":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var names []string
+		for _, v := range s.views {
+			names = append(names, v.name)
+		}
+		return names, nil
+	}
+	fsys, name := s.resolve(p)
+	if fsys == nil {
+		return nil, fs.ErrNotExist
+	}
+	var names []string
+	if entries, err := fs.ReadDir(fsys, name); err == nil {
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+	} else if names, err = prefixEntries(fsys, name); err != nil {
+		return nil, err
+	}
+
+	// Entries named by package or file paths are split into directories.
+	seen := make(map[string]bool)
+	var split []string
+	for _, n := range names {
+		first, _, _ := strings.Cut(n, "/")
+		if !seen[first] {
+			seen[first] = true
+			split = append(split, first)
+		}
+	}
+	if p == "" {
+		split = append(split, "ctl", "views")
+	}
+	return split, nil
+}
+
+// prefixEntries returns the rest of the names of the entries of the
+// nearest directory above name that start with name's remaining elements,
+// such as "a/b" for the entry "example.com/a/b" of "by-package" when name
+// is "by-package/example.com".
+func prefixEntries(fsys fs.FS, name string) ([]string, error) {
+	elems := strings.Split(name, "/")
+	for i := len(elems) - 1; i >= 1; i-- {
+		entries, err := fs.ReadDir(fsys, strings.Join(elems[:i], "/"))
+		if err != nil {
+			continue
+		}
+		prefix := strings.Join(elems[i:], "/") + "/"
+		var names []string
+		for _, e := range entries {
+			if rest, ok := strings.CutPrefix(e.Name(), prefix); ok {
+				names = append(names, rest)
+			}
+		}
+		return names, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+// ctl runs the command written to the control file at p and returns the
+// reply to read back.
+func (s *Server) ctl(p, cmd string) ([]byte, error) {
+	labels, err := parseLabels(cmd)
+	if err != nil {
+		return nil, err
+	}
+	set := s.set
+	if len(labels) > 0 {
+		if set, err = s.set.FilterByLabel(labels); err != nil {
+			return nil, err
+		}
+	}
+	switch p {
+	case "ctl/filter":
+		if len(labels) == 0 {
+			return nil, errors.New("empty label expression")
+		}
+	case "ctl/merge":
+		pod, err := set.Merge()
+		if err != nil {
+			return nil, err
+		}
+		set = &covutil.CoverageSet{Pods: []*covutil.Pod{pod}}
+	default:
+		return nil, fs.ErrPermission
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := &view{
+		name: strconv.Itoa(len(s.views) + 1),
+		cmd:  strings.TrimSpace(path.Base(p) + " " + strings.TrimSpace(cmd)),
+		set:  set,
+	}
+	s.views = append(s.views, v)
+	return []byte("views/" + v.name + "\n"), nil
+}
+
+// ctlStatus returns the list of views read from the control files.
+func (s *Server) ctlStatus() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	for _, v := range s.views {
+		fmt.Fprintf(&b, "%s\t%s\n", v.name, v.cmd)
+	}
+	return []byte(b.String())
+}
+
+// parseLabels parses a label expression.
+func parseLabels(expr string) (map[string]string, error) {
+	labels := make(map[string]string)
+	fields := strings.FieldsFunc(expr, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("bad label %q: want key=value", f)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
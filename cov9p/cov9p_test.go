@@ -0,0 +1,314 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cov9p
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tmc/covutil"
+)
+
+// client is a minimal 9P client.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	tag  uint16
+	fid  uint32 // last fid allocated; the root is 0
+}
+
+func newClient(t *testing.T, srv *Server) *client {
+	t.Helper()
+	cc, sc := net.Pipe()
+	go srv.ServeConn(sc)
+	t.Cleanup(func() { cc.Close() })
+	c := &client{t: t, conn: cc}
+	resp, err := c.rpc(&fcall{Type: tversion, Msize: 8192, Version: "9P2000"})
+	if err != nil || resp.Version != "9P2000" || resp.Msize != 8192 {
+		t.Fatalf("version: %+v, %v", resp, err)
+	}
+	if _, err := c.rpc(&fcall{Type: tattach, Fid: 0, Afid: noFid, Uname: "gopher"}); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	return c
+}
+
+func (c *client) rpc(req *fcall) (*fcall, error) {
+	c.t.Helper()
+	c.tag++
+	req.Tag = c.tag
+	if _, err := c.conn.Write(marshal(req)); err != nil {
+		c.t.Fatal(err)
+	}
+	b, err := readMsg(c.conn, maxMsize)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp, err := unmarshal(b)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.Tag != req.Tag {
+		c.t.Fatalf("response tag %d, want %d", resp.Tag, req.Tag)
+	}
+	if resp.Type == rerror {
+		return nil, errors.New(resp.Ename)
+	}
+	if resp.Type != req.Type+1 {
+		c.t.Fatalf("response type %d to request type %d", resp.Type, req.Type)
+	}
+	return resp, nil
+}
+
+// open walks to the file at p and opens it with mode.
+func (c *client) open(p string, mode uint8) (uint32, error) {
+	c.t.Helper()
+	c.fid++
+	fid := c.fid
+	var wname []string
+	if p != "" {
+		wname = strings.Split(p, "/")
+	}
+	resp, err := c.rpc(&fcall{Type: twalk, Fid: 0, Newfid: fid, Wname: wname})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Wqid) != len(wname) {
+		return 0, errors.New("file does not exist")
+	}
+	if _, err := c.rpc(&fcall{Type: topen, Fid: fid, Mode: mode}); err != nil {
+		c.rpc(&fcall{Type: tclunk, Fid: fid})
+		return 0, err
+	}
+	return fid, nil
+}
+
+// readAll reads an open file in reads of count bytes.
+func (c *client) readAll(fid uint32, count uint32) []byte {
+	c.t.Helper()
+	var data []byte
+	for {
+		resp, err := c.rpc(&fcall{Type: tread, Fid: fid, Offset: uint64(len(data)), Count: count})
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if len(resp.Data) == 0 {
+			return data
+		}
+		data = append(data, resp.Data...)
+	}
+}
+
+func (c *client) read(p string) (string, error) {
+	c.t.Helper()
+	fid, err := c.open(p, oRead)
+	if err != nil {
+		return "", err
+	}
+	defer c.rpc(&fcall{Type: tclunk, Fid: fid})
+	return string(c.readAll(fid, 8192)), nil
+}
+
+// ls lists the directory at p, reading few entries at a time.
+func (c *client) ls(p string) ([]string, error) {
+	c.t.Helper()
+	fid, err := c.open(p, oRead)
+	if err != nil {
+		return nil, err
+	}
+	defer c.rpc(&fcall{Type: tclunk, Fid: fid})
+	b := c.readAll(fid, 120)
+	var names []string
+	for len(b) > 0 {
+		d, n, err := unmarshalDir(b)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		names = append(names, d.Name)
+		b = b[n:]
+	}
+	return names, nil
+}
+
+// ctl writes cmd to the control file at p and returns the reply.
+func (c *client) ctl(p, cmd string) (string, error) {
+	c.t.Helper()
+	fid, err := c.open(p, oRDWR|oTrunc)
+	if err != nil {
+		return "", err
+	}
+	defer c.rpc(&fcall{Type: tclunk, Fid: fid})
+	if _, err := c.rpc(&fcall{Type: twrite, Fid: fid, Data: []byte(cmd)}); err != nil {
+		return "", err
+	}
+	return string(c.readAll(fid, 8192)), nil
+}
+
+// testPod returns a pod of a binary instrumenting the given packages of
+// example.com/mod, each with a function F of two units.
+func testPod(t *testing.T, id string, labels map[string]string, counts map[string][]uint32) *covutil.Pod {
+	t.Helper()
+	p := &covutil.Profile{
+		Meta:     covutil.MetaFile{Mode: covutil.ModeCount, Granularity: covutil.GranularityBlock},
+		Counters: make(map[covutil.PkgFuncKey][]uint32),
+	}
+	for _, name := range []string{"a", "b"} {
+		c, ok := counts[name]
+		if !ok {
+			continue
+		}
+		pkg := "example.com/mod/" + name
+		p.Meta.Packages = append(p.Meta.Packages, covutil.PackageMeta{
+			Path: pkg,
+			Name: name,
+			Functions: []covutil.FuncDesc{{
+				PackagePath: pkg,
+				FuncName:    "F",
+				SrcFile:     pkg + "/" + name + ".go",
+				Units: []covutil.CoverableUnit{
+					{StartLine: 3, StartCol: 1, EndLine: 3, EndCol: 9, NumStmt: 1},
+					{StartLine: 4, StartCol: 1, EndLine: 4, EndCol: 9, NumStmt: 1},
+				},
+			}},
+		})
+		p.Counters[covutil.PkgFuncKey{PkgPath: pkg, FuncName: "F"}] = c
+	}
+	hash, err := covutil.EncodeMetaFile(io.Discard, &p.Meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Meta.FileHash = hash
+	return &covutil.Pod{ID: id, Profile: p, Labels: labels}
+}
+
+func testServer(t *testing.T) *Server {
+	return NewServer(&covutil.CoverageSet{Pods: []*covutil.Pod{
+		testPod(t, "integration", map[string]string{"team": "core", "suite": "integration"},
+			map[string][]uint32{"a": {1, 1}, "b": {0, 2}}),
+		testPod(t, "unit", map[string]string{"team": "core", "suite": "unit"},
+			map[string][]uint32{"a": {3, 0}}),
+	}})
+}
+
+func TestServerBrowse(t *testing.T) {
+	c := newClient(t, testServer(t))
+
+	for _, tt := range []struct {
+		dir  string
+		want []string
+	}{
+		{"", []string{"by-label", "by-line", "by-package", "functions", "pods", "summary", "ctl", "views"}},
+		{"pods", []string{"integration", "unit"}},
+		{"pods/unit", []string{"metadata.json", "profile.json"}},
+		{"by-label/suite", []string{"integration", "unit"}},
+		// Package paths are split into directories.
+		{"by-package", []string{"example.com"}},
+		{"by-package/example.com/mod", []string{"a", "b"}},
+		{"by-package/example.com/mod/a", []string{"integration", "unit"}},
+		{"functions/example.com/mod/b", []string{"F.json"}},
+		{"ctl", []string{"filter", "merge"}},
+	} {
+		got, err := c.ls(tt.dir)
+		if err != nil {
+			t.Errorf("ls %q: %v", tt.dir, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ls %q = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+
+	summary, err := c.read("summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s struct {
+		TotalPods int `json:"total_pods"`
+	}
+	if err := json.Unmarshal([]byte(summary), &s); err != nil || s.TotalPods != 2 {
+		t.Errorf("summary = %s, %v", summary, err)
+	}
+	if md, err := c.read("by-package/example.com/mod/b/integration/metadata.json"); err != nil || !strings.Contains(md, `"suite": "integration"`) {
+		t.Errorf("metadata.json = %s, %v", md, err)
+	}
+
+	// Walking back up with "..".
+	resp, err := c.rpc(&fcall{Type: twalk, Fid: 0, Newfid: 100, Wname: []string{"pods", "unit", "..", "..", "summary"}})
+	if err != nil || len(resp.Wqid) != 5 || resp.Wqid[1].Type != qtDir || resp.Wqid[4].Type != 0 {
+		t.Errorf("walk with ..: %+v, %v", resp, err)
+	}
+	resp, err = c.rpc(&fcall{Type: tstat, Fid: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _, err := unmarshalDir(resp.Stat); err != nil || d.Name != "summary" || d.Length != uint64(len(summary)) || d.Uid != "gopher" {
+		t.Errorf("stat summary = %+v, %v", d, err)
+	}
+
+	if _, err := c.read("pods/missing"); err == nil || err.Error() != "file does not exist" {
+		t.Errorf("reading a missing file: %v", err)
+	}
+	if _, err := c.open("summary", oWrite); err == nil || err.Error() != "permission denied" {
+		t.Errorf("opening summary for writing: %v", err)
+	}
+}
+
+func TestServerCtl(t *testing.T) {
+	c := newClient(t, testServer(t))
+
+	if got, err := c.ctl("ctl/filter", "suite=unit\n"); err != nil || got != "views/1\n" {
+		t.Fatalf("filter = %q, %v", got, err)
+	}
+	if got, err := c.ls("views/1/pods"); err != nil || !reflect.DeepEqual(got, []string{"unit"}) {
+		t.Errorf("filtered pods = %q, %v", got, err)
+	}
+
+	// The pods are of different binaries.
+	if got, err := c.ctl("ctl/merge", "team=core"); err != nil || got != "views/2\n" {
+		t.Fatalf("merge = %q, %v", got, err)
+	}
+	pods, err := c.ls("views/2/pods")
+	if err != nil || len(pods) != 1 {
+		t.Fatalf("merged pods = %q, %v", pods, err)
+	}
+	data, err := c.read("views/2/pods/" + pods[0] + "/profile.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var profile struct {
+		Counters map[string][]uint32 `json:"counters"`
+	}
+	if err := json.Unmarshal([]byte(data), &profile); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]uint32{"example.com/mod/a:F": {4, 1}, "example.com/mod/b:F": {0, 2}}
+	if !reflect.DeepEqual(profile.Counters, want) {
+		t.Errorf("merged counters = %v, want %v", profile.Counters, want)
+	}
+
+	if got, err := c.read("ctl/filter"); err != nil || got != "1\tfilter suite=unit\n2\tmerge team=core\n" {
+		t.Errorf("ctl/filter = %q, %v", got, err)
+	}
+	if got, err := c.ls("views"); err != nil || !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("views = %q, %v", got, err)
+	}
+
+	for _, tt := range []struct {
+		file, cmd, err string
+	}{
+		{"ctl/filter", "suite", `bad label "suite": want key=value`},
+		{"ctl/filter", "\n", "empty label expression"},
+		{"ctl/merge", "team=none", "no pods in set to merge"},
+	} {
+		if _, err := c.ctl(tt.file, tt.cmd); err == nil || err.Error() != tt.err {
+			t.Errorf("write %q to %s: %v, want %s", tt.cmd, tt.file, err, tt.err)
+		}
+	}
+}
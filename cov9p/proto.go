@@ -0,0 +1,394 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cov9p
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 9P2000 message types.
+const (
+	tversion = 100 + iota
+	rversion
+	tauth
+	rauth
+	tattach
+	rattach
+	terror // illegal
+	rerror
+	tflush
+	rflush
+	twalk
+	rwalk
+	topen
+	ropen
+	tcreate
+	rcreate
+	tread
+	rread
+	twrite
+	rwrite
+	tclunk
+	rclunk
+	tremove
+	rremove
+	tstat
+	rstat
+	twstat
+	rwstat
+)
+
+const (
+	version  = "9P2000"
+	noFid    = ^uint32(0)
+	minMsize = 256
+	maxMsize = 64 << 10
+	ioHdrSz  = 24 // header of Rread and Twrite messages
+
+	qtDir = 0x80       // directory bit of qid types
+	dmDir = 0x80000000 // directory bit of modes
+
+	oRead   = 0
+	oWrite  = 1
+	oRDWR   = 2
+	oExec   = 3
+	oTrunc  = 0x10
+	oRClose = 0x40
+)
+
+// qid is the server's unique identification of a file.
+type qid struct {
+	Type uint8
+	Vers uint32
+	Path uint64
+}
+
+// fcall is a 9P message. Only the fields of its type are used.
+type fcall struct {
+	Type    uint8
+	Tag     uint16
+	Fid     uint32
+	Afid    uint32 // Tattach, Tauth
+	Newfid  uint32 // Twalk
+	Msize   uint32 // Tversion, Rversion
+	Version string // Tversion, Rversion
+	Oldtag  uint16 // Tflush
+	Ename   string // Rerror
+	Qid     qid    // Rattach, Ropen, Rcreate
+	Iounit  uint32 // Ropen, Rcreate
+	Uname   string // Tattach, Tauth
+	Aname   string // Tattach, Tauth
+	Perm    uint32 // Tcreate
+	Name    string // Tcreate
+	Mode    uint8  // Topen, Tcreate
+	Wname   []string
+	Wqid    []qid
+	Offset  uint64 // Tread, Twrite
+	Count   uint32 // Tread, Rwrite
+	Data    []byte // Rread, Twrite
+	Stat    []byte // Rstat, Twstat
+}
+
+var errShortMessage = errors.New("short 9P message")
+
+// readMsg reads a message of at most msize bytes from r.
+func readMsg(r io.Reader, msize uint32) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(size[:])
+	if n < 7 || n > msize {
+		return nil, fmt.Errorf("bad 9P message size %d", n)
+	}
+	b := make([]byte, n)
+	copy(b, size[:])
+	if _, err := io.ReadFull(r, b[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// marshal returns the wire form of f.
+func marshal(f *fcall) []byte {
+	e := &encoder{b: make([]byte, 4, 64)}
+	e.u8(f.Type)
+	e.u16(f.Tag)
+	switch f.Type {
+	case tversion, rversion:
+		e.u32(f.Msize)
+		e.str(f.Version)
+	case tauth:
+		e.u32(f.Afid)
+		e.str(f.Uname)
+		e.str(f.Aname)
+	case rauth, rattach:
+		e.qid(f.Qid)
+	case tattach:
+		e.u32(f.Fid)
+		e.u32(f.Afid)
+		e.str(f.Uname)
+		e.str(f.Aname)
+	case rerror:
+		e.str(f.Ename)
+	case tflush:
+		e.u16(f.Oldtag)
+	case twalk:
+		e.u32(f.Fid)
+		e.u32(f.Newfid)
+		e.u16(uint16(len(f.Wname)))
+		for _, name := range f.Wname {
+			e.str(name)
+		}
+	case rwalk:
+		e.u16(uint16(len(f.Wqid)))
+		for _, q := range f.Wqid {
+			e.qid(q)
+		}
+	case topen:
+		e.u32(f.Fid)
+		e.u8(f.Mode)
+	case ropen, rcreate:
+		e.qid(f.Qid)
+		e.u32(f.Iounit)
+	case tcreate:
+		e.u32(f.Fid)
+		e.str(f.Name)
+		e.u32(f.Perm)
+		e.u8(f.Mode)
+	case tread:
+		e.u32(f.Fid)
+		e.u64(f.Offset)
+		e.u32(f.Count)
+	case rread:
+		e.u32(uint32(len(f.Data)))
+		e.b = append(e.b, f.Data...)
+	case twrite:
+		e.u32(f.Fid)
+		e.u64(f.Offset)
+		e.u32(uint32(len(f.Data)))
+		e.b = append(e.b, f.Data...)
+	case rwrite:
+		e.u32(f.Count)
+	case tclunk, tremove, tstat:
+		e.u32(f.Fid)
+	case rstat:
+		e.u16(uint16(len(f.Stat)))
+		e.b = append(e.b, f.Stat...)
+	case twstat:
+		e.u32(f.Fid)
+		e.u16(uint16(len(f.Stat)))
+		e.b = append(e.b, f.Stat...)
+	}
+	binary.LittleEndian.PutUint32(e.b, uint32(len(e.b)))
+	return e.b
+}
+
+// unmarshal parses a message read by readMsg.
+func unmarshal(b []byte) (*fcall, error) {
+	d := &decoder{b: b[4:]}
+	f := &fcall{Type: d.u8(), Tag: d.u16()}
+	switch f.Type {
+	case tversion, rversion:
+		f.Msize = d.u32()
+		f.Version = d.str()
+	case tauth:
+		f.Afid = d.u32()
+		f.Uname = d.str()
+		f.Aname = d.str()
+	case rauth, rattach:
+		f.Qid = d.qid()
+	case tattach:
+		f.Fid = d.u32()
+		f.Afid = d.u32()
+		f.Uname = d.str()
+		f.Aname = d.str()
+	case rerror:
+		f.Ename = d.str()
+	case tflush:
+		f.Oldtag = d.u16()
+	case rflush, rclunk, rremove, rwstat:
+	case twalk:
+		f.Fid = d.u32()
+		f.Newfid = d.u32()
+		n := d.u16()
+		for i := uint16(0); i < n && d.err == nil; i++ {
+			f.Wname = append(f.Wname, d.str())
+		}
+	case rwalk:
+		n := d.u16()
+		for i := uint16(0); i < n && d.err == nil; i++ {
+			f.Wqid = append(f.Wqid, d.qid())
+		}
+	case topen:
+		f.Fid = d.u32()
+		f.Mode = d.u8()
+	case ropen, rcreate:
+		f.Qid = d.qid()
+		f.Iounit = d.u32()
+	case tcreate:
+		f.Fid = d.u32()
+		f.Name = d.str()
+		f.Perm = d.u32()
+		f.Mode = d.u8()
+	case tread:
+		f.Fid = d.u32()
+		f.Offset = d.u64()
+		f.Count = d.u32()
+	case rread:
+		f.Data = d.bytes(int(d.u32()))
+	case twrite:
+		f.Fid = d.u32()
+		f.Offset = d.u64()
+		f.Data = d.bytes(int(d.u32()))
+	case rwrite:
+		f.Count = d.u32()
+	case tclunk, tremove, tstat:
+		f.Fid = d.u32()
+	case rstat:
+		f.Stat = d.bytes(int(d.u16()))
+	case twstat:
+		f.Fid = d.u32()
+		f.Stat = d.bytes(int(d.u16()))
+	default:
+		return f, fmt.Errorf("unknown 9P message type %d", f.Type)
+	}
+	if d.err != nil {
+		return f, d.err
+	}
+	return f, nil
+}
+
+// dir is the machine-independent directory entry of a file.
+type dir struct {
+	Qid    qid
+	Mode   uint32
+	Atime  uint32
+	Mtime  uint32
+	Length uint64
+	Name   string
+	Uid    string
+	Gid    string
+	Muid   string
+}
+
+// marshal returns the wire form of d, prefixed by its size.
+func (d *dir) marshal() []byte {
+	e := &encoder{b: make([]byte, 2, 64)}
+	e.u16(0) // type
+	e.u32(0) // dev
+	e.qid(d.Qid)
+	e.u32(d.Mode)
+	e.u32(d.Atime)
+	e.u32(d.Mtime)
+	e.u64(d.Length)
+	e.str(d.Name)
+	e.str(d.Uid)
+	e.str(d.Gid)
+	e.str(d.Muid)
+	binary.LittleEndian.PutUint16(e.b, uint16(len(e.b)-2))
+	return e.b
+}
+
+// unmarshalDir parses the first directory entry of b and returns it and
+// its size.
+func unmarshalDir(b []byte) (*dir, int, error) {
+	dec := &decoder{b: b}
+	n := int(dec.u16()) + 2
+	dec.u16() // type
+	dec.u32() // dev
+	d := &dir{
+		Qid:    dec.qid(),
+		Mode:   dec.u32(),
+		Atime:  dec.u32(),
+		Mtime:  dec.u32(),
+		Length: dec.u64(),
+		Name:   dec.str(),
+		Uid:    dec.str(),
+		Gid:    dec.str(),
+		Muid:   dec.str(),
+	}
+	if dec.err != nil || len(b) < n {
+		return nil, 0, errShortMessage
+	}
+	return d, n, nil
+}
+
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) u8(v uint8)   { e.b = append(e.b, v) }
+func (e *encoder) u16(v uint16) { e.b = binary.LittleEndian.AppendUint16(e.b, v) }
+func (e *encoder) u32(v uint32) { e.b = binary.LittleEndian.AppendUint32(e.b, v) }
+func (e *encoder) u64(v uint64) { e.b = binary.LittleEndian.AppendUint64(e.b, v) }
+
+func (e *encoder) str(s string) {
+	e.u16(uint16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) qid(q qid) {
+	e.u8(q.Type)
+	e.u32(q.Vers)
+	e.u64(q.Path)
+}
+
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil || n > len(d.b) {
+		d.err = errShortMessage
+		return nil
+	}
+	b := d.b[:n:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) str() string {
+	return string(d.bytes(int(d.u16())))
+}
+
+func (d *decoder) qid() qid {
+	return qid{Type: d.u8(), Vers: d.u32(), Path: d.u64()}
+}
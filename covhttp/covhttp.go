@@ -0,0 +1,99 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package covhttp serves the coverage data of a running
+// coverage-instrumented program over HTTP, so that the coverage of
+// long-running processes such as servers can be collected without
+// stopping them.
+//
+// A program built with "go build -cover" registers the handler on its
+// debug server:
+//
+//	http.Handle("/debug/coverage/", &covhttp.Handler{AllowReset: true})
+//
+// and "covtree scrape -url=http://host/debug/coverage/" then snapshots the
+// data into GOCOVERDIR-compatible directories.
+package covhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"runtime/coverage"
+	"strconv"
+	"sync"
+)
+
+// PidHeader is the response header holding the process ID of the program
+// serving its counters, used to name counter files as the runtime does.
+const PidHeader = "X-Coverage-Pid"
+
+// The runtime coverage API, replaced in tests.
+var (
+	writeMeta     = coverage.WriteMeta
+	writeCounters = coverage.WriteCounters
+	clearCounters = coverage.ClearCounters
+)
+
+// Handler serves the coverage data of the running program:
+//
+//	GET .../meta             the meta-data file, as written to covmeta.<hash>
+//	GET .../counters         a counter data file, as written to covcounters.<hash>.<pid>.<time>
+//	GET .../counters?reset=1 the same, then resets the counters to zero
+//
+// Requests are matched on the last element of the path, so the handler
+// can be mounted under any prefix. Serving counters requires the program
+// to have been built with -covermode=atomic.
+type Handler struct {
+	// AllowReset permits resetting the counters after they are read, so
+	// that each snapshot holds only the executions since the previous
+	// one. Executions between reading and resetting the counters are
+	// lost.
+	AllowReset bool
+
+	mu sync.Mutex // serializes reading and resetting the counters
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	switch path.Base(r.URL.Path) {
+	case "meta":
+		if err := writeMeta(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "counters":
+		reset := r.URL.Query().Get("reset")
+		if reset != "" && reset != "0" && !h.AllowReset {
+			http.Error(w, "resetting counters is not allowed", http.StatusForbidden)
+			return
+		}
+		h.mu.Lock()
+		err := writeCounters(&buf)
+		if err == nil && reset != "" && reset != "0" {
+			err = clearCounters()
+		}
+		h.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(PidHeader, strconv.Itoa(os.Getpid()))
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	io.Copy(w, &buf)
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	defer func(m, w func(io.Writer) error, c func() error) {
+		writeMeta, writeCounters, clearCounters = m, w, c
+	}(writeMeta, writeCounters, clearCounters)
+	counters, resets := "counters-1", 0
+	writeMeta = func(w io.Writer) error { _, err := io.WriteString(w, "meta"); return err }
+	writeCounters = func(w io.Writer) error { _, err := io.WriteString(w, counters); return err }
+	clearCounters = func() error { resets++; counters = "counters-0"; return nil }
+
+	get := func(h *Handler, target string) (int, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	h := &Handler{}
+	if code, body := get(h, "/debug/coverage/meta"); code != 200 || body != "meta" {
+		t.Errorf("meta: %d %q", code, body)
+	}
+	if code, body := get(h, "/debug/coverage/counters"); code != 200 || body != "counters-1" {
+		t.Errorf("counters: %d %q", code, body)
+	}
+	if code, _ := get(h, "/debug/coverage/counters?reset=1"); code != http.StatusForbidden || resets != 0 {
+		t.Errorf("reset without AllowReset: status %d, %d resets", code, resets)
+	}
+	if code, _ := get(h, "/debug/coverage/other"); code != http.StatusNotFound {
+		t.Errorf("unknown path: status %d, want 404", code)
+	}
+
+	h = &Handler{AllowReset: true}
+	if code, body := get(h, "/counters?reset=1"); code != 200 || body != "counters-1" || resets != 1 {
+		t.Errorf("reset: %d %q, %d resets", code, body, resets)
+	}
+	if _, body := get(h, "/counters"); body != "counters-0" {
+		t.Errorf("counters after reset = %q", body)
+	}
+
+	writeCounters = func(io.Writer) error { return errors.New("program not built with -cover") }
+	if code, _ := get(h, "/counters?reset=1"); code != http.StatusInternalServerError || resets != 1 {
+		t.Errorf("failed read: status %d, %d resets", code, resets)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/counters", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+}
@@ -195,6 +195,45 @@
 //	}
 //	walk(tree.Root, "")
 //
+// # Exporting
+//
+// WriteCobertura, WriteLCOV and WriteGoCover write a tree in formats read
+// by CI dashboards and other coverage tools. NewCoverageTreeFromProfile
+// builds a tree from an in-memory covutil.Profile so it can be exported
+// the same way:
+//
+//	f, _ := os.Create("coverage.xml")
+//	defer f.Close()
+//	err := covtree.WriteCobertura(f, tree, &covtree.ExportOptions{TrimModule: true})
+//
+// # Querying
+//
+// ParseQuery parses filter expressions that compare package, function and
+// unit fields, for use where a Filter is not expressive enough:
+//
+//	q, err := covtree.ParseQuery(`pkg ~ "internal/*" && func.coverage < 0.5 && func.lines > 20`)
+//	if err != nil {
+//		log.Fatal(err) // query: column N: ...
+//	}
+//	for _, pkg := range tree.Query(q) {
+//		for _, fn := range pkg.Functions {
+//			fmt.Println(fn.Name)
+//		}
+//	}
+//
+// # Watching
+//
+// A Watcher keeps a tree up to date while coverage data is being written,
+// decoding again only the pods whose files changed. Servers can stream its
+// updates to browsers as server-sent events:
+//
+//	w, err := covtree.NewWatcher("/path/to/gocoverdir", 0)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go w.Run(ctx)
+//	http.HandleFunc("/api/events", w.ServeEvents)
+//
 // # Integration with covforest
 //
 // covtree works seamlessly with the covforest package to manage
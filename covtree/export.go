@@ -0,0 +1,425 @@
+package covtree
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tmc/covutil"
+)
+
+// ExportOptions configures the Cobertura, LCOV and gocover exporters.
+type ExportOptions struct {
+	// TrimModule rewrites file paths in Cobertura and LCOV output to be
+	// relative to their package's module root, so that they resolve
+	// against a checkout of the module. The gocover format always uses
+	// "<import path>/<file>", as go tool cover expects.
+	TrimModule bool
+	// Sources lists the source roots recorded in the Cobertura <sources>
+	// element.
+	Sources []string
+	// TestName is recorded in the LCOV TN record of each file.
+	TestName string
+	// Timestamp is recorded in the Cobertura output. If zero, the
+	// current time is used.
+	Timestamp time.Time
+}
+
+// NewCoverageTreeFromProfile builds a CoverageTree from an in-memory
+// covutil.Profile, so that profiles can be exported and analyzed like
+// coverage data loaded from disk.
+func NewCoverageTreeFromProfile(p *covutil.Profile) *CoverageTree {
+	ct := NewCoverageTree()
+	for _, pm := range p.Meta.Packages {
+		pkg := &PackageNode{
+			ImportPath:  pm.Path,
+			Name:        pm.Name,
+			ModulePath:  pm.ModulePath,
+			Functions:   make([]*FunctionNode, 0, len(pm.Functions)),
+			MetaFile:    p.Meta.FilePath,
+			CounterMode: p.Meta.Mode.String(),
+			Metadata:    make(map[string]string),
+		}
+		for k, v := range p.Args {
+			pkg.Metadata[k] = v
+		}
+		if pkg.ModulePath != "" {
+			pkg.Metadata["GoModuleName"] = pkg.ModulePath
+		}
+		for _, fd := range pm.Functions {
+			counts := p.Counters[covutil.PkgFuncKey{PkgPath: pm.Path, FuncName: fd.FuncName}]
+			fn := &FunctionNode{
+				Name:      fd.FuncName,
+				File:      fd.SrcFile,
+				Units:     make([]CoverableUnitNode, len(fd.Units)),
+				IsLiteral: fd.IsLiteral,
+			}
+			for j, u := range fd.Units {
+				var count uint32
+				if j < len(counts) {
+					count = counts[j]
+				}
+				fn.Units[j] = CoverableUnitNode{
+					StartLine: u.StartLine,
+					StartCol:  u.StartCol,
+					EndLine:   u.EndLine,
+					EndCol:    u.EndCol,
+					NumStmts:  u.NumStmt,
+					Count:     count,
+					Covered:   count > 0,
+				}
+			}
+			pkg.Functions = append(pkg.Functions, fn)
+		}
+		ct.Packages[pkg.ImportPath] = pkg
+		ct.addToDirectoryTree(pkg)
+	}
+	ct.calculateCoverage()
+	return ct
+}
+
+// lineHits is the coverage of a single source line, derived from the
+// coverable units that touch it.
+type lineHits struct {
+	Line uint32
+	// Hits is the largest execution count of the units touching the line.
+	Hits uint32
+	// Blocks is the number of units touching the line. A line touched by
+	// more than one unit, such as "if x {", is reported as a branch point.
+	Blocks int
+	// Covered is the number of those units that executed.
+	Covered int
+	// Counts are the execution counts of the units, in unit order.
+	Counts []uint32
+}
+
+// lineCoverage folds units into per-line hits, sorted by line.
+func lineCoverage(units []CoverableUnitNode) []lineHits {
+	byLine := make(map[uint32]*lineHits)
+	for _, u := range units {
+		for l := u.StartLine; l <= u.EndLine; l++ {
+			lh := byLine[l]
+			if lh == nil {
+				lh = &lineHits{Line: l}
+				byLine[l] = lh
+			}
+			lh.Hits = max(lh.Hits, u.Count)
+			lh.Blocks++
+			if u.Count > 0 {
+				lh.Covered++
+			}
+			lh.Counts = append(lh.Counts, u.Count)
+		}
+	}
+	lines := make([]lineHits, 0, len(byLine))
+	for _, lh := range byLine {
+		lines = append(lines, *lh)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Line < lines[j].Line })
+	return lines
+}
+
+// lineTotals counts the lines, covered lines, branches and covered
+// branches among lines.
+func lineTotals(lines []lineHits) (valid, covered, branches, branchesCovered int) {
+	for _, lh := range lines {
+		valid++
+		if lh.Hits > 0 {
+			covered++
+		}
+		if lh.Blocks > 1 {
+			branches += lh.Blocks
+			branchesCovered += lh.Covered
+		}
+	}
+	return
+}
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// exportPath returns the path to report for file in pkg.
+func exportPath(pkg *PackageNode, file string, opts *ExportOptions) string {
+	if opts.TrimModule && pkg.ModulePath != "" {
+		if rel, ok := strings.CutPrefix(file, pkg.ModulePath+"/"); ok {
+			return rel
+		}
+	}
+	return file
+}
+
+// sortedPackages returns the tree's packages sorted by import path.
+func (ct *CoverageTree) sortedPackages() []*PackageNode {
+	pkgs := make([]*PackageNode, 0, len(ct.Packages))
+	for _, pkg := range ct.Packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].ImportPath < pkgs[j].ImportPath })
+	return pkgs
+}
+
+// Cobertura XML elements, following
+// http://cobertura.sourceforge.net/xml/coverage-04.dtd.
+type (
+	coberturaCoverage struct {
+		XMLName         xml.Name           `xml:"coverage"`
+		LineRate        string             `xml:"line-rate,attr"`
+		BranchRate      string             `xml:"branch-rate,attr"`
+		LinesCovered    int                `xml:"lines-covered,attr"`
+		LinesValid      int                `xml:"lines-valid,attr"`
+		BranchesCovered int                `xml:"branches-covered,attr"`
+		BranchesValid   int                `xml:"branches-valid,attr"`
+		Complexity      string             `xml:"complexity,attr"`
+		Version         string             `xml:"version,attr"`
+		Timestamp       int64              `xml:"timestamp,attr"`
+		Sources         *coberturaSources  `xml:"sources,omitempty"`
+		Packages        []coberturaPackage `xml:"packages>package"`
+	}
+	coberturaSources struct {
+		Source []string `xml:"source"`
+	}
+	coberturaPackage struct {
+		Name       string           `xml:"name,attr"`
+		LineRate   string           `xml:"line-rate,attr"`
+		BranchRate string           `xml:"branch-rate,attr"`
+		Complexity string           `xml:"complexity,attr"`
+		Classes    []coberturaClass `xml:"classes>class"`
+	}
+	coberturaClass struct {
+		Name       string            `xml:"name,attr"`
+		Filename   string            `xml:"filename,attr"`
+		LineRate   string            `xml:"line-rate,attr"`
+		BranchRate string            `xml:"branch-rate,attr"`
+		Complexity string            `xml:"complexity,attr"`
+		Methods    []coberturaMethod `xml:"methods>method"`
+		Lines      []coberturaLine   `xml:"lines>line"`
+	}
+	coberturaMethod struct {
+		Name       string          `xml:"name,attr"`
+		Signature  string          `xml:"signature,attr"`
+		LineRate   string          `xml:"line-rate,attr"`
+		BranchRate string          `xml:"branch-rate,attr"`
+		Complexity string          `xml:"complexity,attr"`
+		Lines      []coberturaLine `xml:"lines>line"`
+	}
+	coberturaLine struct {
+		Number            uint32 `xml:"number,attr"`
+		Hits              uint32 `xml:"hits,attr"`
+		Branch            string `xml:"branch,attr"`
+		ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+	}
+)
+
+func formatRate(r float64) string {
+	return strconv.FormatFloat(r, 'f', 4, 64)
+}
+
+func coberturaLines(lines []lineHits) []coberturaLine {
+	out := make([]coberturaLine, 0, len(lines))
+	for _, lh := range lines {
+		cl := coberturaLine{Number: lh.Line, Hits: lh.Hits, Branch: "false"}
+		if lh.Blocks > 1 {
+			cl.Branch = "true"
+			cl.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", 100*lh.Covered/lh.Blocks, lh.Covered, lh.Blocks)
+		}
+		out = append(out, cl)
+	}
+	return out
+}
+
+// WriteCobertura writes the tree as a Cobertura XML report. Each package
+// becomes a <package>, each source file a <class> and each function a
+// <method>. Line hits are the largest count of the units touching the
+// line; lines touched by several units are reported as branches, with
+// one branch per unit.
+func WriteCobertura(w io.Writer, ct *CoverageTree, opts *ExportOptions) error {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	ts := opts.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	doc := coberturaCoverage{
+		Complexity: "0",
+		Version:    "covtree",
+		Timestamp:  ts.UnixMilli(),
+	}
+	if len(opts.Sources) > 0 {
+		doc.Sources = &coberturaSources{Source: opts.Sources}
+	}
+
+	var lv, lc, bv, bc int
+	for _, pkg := range ct.sortedPackages() {
+		cp := coberturaPackage{Name: pkg.ImportPath, Complexity: "0"}
+		var plv, plc, pbv, pbc int
+		for _, f := range pkg.Files() {
+			filename := exportPath(pkg, f.Path, opts)
+			cls := coberturaClass{
+				Name:       strings.TrimSuffix(path.Base(f.Path), ".go"),
+				Filename:   filename,
+				Complexity: "0",
+			}
+			for _, fn := range f.Functions {
+				lines := lineCoverage(fn.Units)
+				v, c, b, bcov := lineTotals(lines)
+				cls.Methods = append(cls.Methods, coberturaMethod{
+					Name:       fn.Name,
+					LineRate:   formatRate(rate(c, v)),
+					BranchRate: formatRate(rate(bcov, b)),
+					Complexity: "0",
+					Lines:      coberturaLines(lines),
+				})
+			}
+			lines := lineCoverage(f.Units())
+			v, c, b, bcov := lineTotals(lines)
+			cls.LineRate = formatRate(rate(c, v))
+			cls.BranchRate = formatRate(rate(bcov, b))
+			cls.Lines = coberturaLines(lines)
+			cp.Classes = append(cp.Classes, cls)
+			plv, plc, pbv, pbc = plv+v, plc+c, pbv+b, pbc+bcov
+		}
+		cp.LineRate = formatRate(rate(plc, plv))
+		cp.BranchRate = formatRate(rate(pbc, pbv))
+		doc.Packages = append(doc.Packages, cp)
+		lv, lc, bv, bc = lv+plv, lc+plc, bv+pbv, bc+pbc
+	}
+	doc.LinesValid, doc.LinesCovered = lv, lc
+	doc.BranchesValid, doc.BranchesCovered = bv, bc
+	doc.LineRate = formatRate(rate(lc, lv))
+	doc.BranchRate = formatRate(rate(bc, bv))
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">` + "\n")
+	enc := xml.NewEncoder(bw)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	bw.WriteString("\n")
+	return bw.Flush()
+}
+
+// WriteLCOV writes the tree as an LCOV tracefile, as read by genhtml.
+// Each source file becomes one record whose entries are written in the
+// order geninfo produces them: TN, SF, FN, FNDA, FNF, FNH, BRDA, BRF,
+// BRH, DA, LF, LH, end_of_record. A function's hit count is the count of
+// its first unit; branch data is derived as for WriteCobertura.
+func WriteLCOV(w io.Writer, ct *CoverageTree, opts *ExportOptions) error {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	bw := bufio.NewWriter(w)
+	for _, pkg := range ct.sortedPackages() {
+		for _, f := range pkg.Files() {
+			fmt.Fprintf(bw, "TN:%s\n", opts.TestName)
+			fmt.Fprintf(bw, "SF:%s\n", exportPath(pkg, f.Path, opts))
+
+			fns := make([]*FunctionNode, 0, len(f.Functions))
+			for _, fn := range f.Functions {
+				if len(fn.Units) > 0 {
+					fns = append(fns, fn)
+				}
+			}
+			sort.SliceStable(fns, func(i, j int) bool { return fns[i].Units[0].StartLine < fns[j].Units[0].StartLine })
+			fnHit := 0
+			for _, fn := range fns {
+				fmt.Fprintf(bw, "FN:%d,%s\n", fn.Units[0].StartLine, fn.Name)
+			}
+			for _, fn := range fns {
+				fmt.Fprintf(bw, "FNDA:%d,%s\n", fn.Units[0].Count, fn.Name)
+				if fn.Units[0].Count > 0 {
+					fnHit++
+				}
+			}
+			fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(fns), fnHit)
+
+			lines := lineCoverage(f.Units())
+			var brf, brh int
+			for _, lh := range lines {
+				if lh.Blocks < 2 {
+					continue
+				}
+				for i, c := range lh.Counts {
+					taken := "-"
+					if lh.Hits > 0 {
+						taken = strconv.FormatUint(uint64(c), 10)
+					}
+					fmt.Fprintf(bw, "BRDA:%d,0,%d,%s\n", lh.Line, i, taken)
+					brf++
+					if c > 0 {
+						brh++
+					}
+				}
+			}
+			fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", brf, brh)
+
+			var lh int
+			for _, l := range lines {
+				fmt.Fprintf(bw, "DA:%d,%d\n", l.Line, l.Hits)
+				if l.Hits > 0 {
+					lh++
+				}
+			}
+			fmt.Fprintf(bw, "LF:%d\nLH:%d\n", len(lines), lh)
+			fmt.Fprintf(bw, "end_of_record\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteGoCover writes the tree in the textual coverage profile format
+// produced by "go test -coverprofile" and read by go tool cover. The mode
+// line is taken from the packages' counter mode, defaulting to "set".
+func WriteGoCover(w io.Writer, ct *CoverageTree, opts *ExportOptions) error {
+	pkgs := ct.sortedPackages()
+	mode := "set"
+	for _, pkg := range pkgs {
+		if pkg.CounterMode != "" && pkg.CounterMode != "<invalid>" {
+			mode = pkg.CounterMode
+			break
+		}
+	}
+
+	type block struct {
+		file string
+		u    CoverableUnitNode
+	}
+	var blocks []block
+	for _, pkg := range pkgs {
+		for _, fn := range pkg.Functions {
+			for _, u := range fn.Units {
+				blocks = append(blocks, block{fn.File, u})
+			}
+		}
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		a, b := blocks[i], blocks[j]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		if a.u.StartLine != b.u.StartLine {
+			return a.u.StartLine < b.u.StartLine
+		}
+		return a.u.StartCol < b.u.StartCol
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", mode)
+	for _, b := range blocks {
+		fmt.Fprintf(bw, "%s:%d.%d,%d.%d %d %d\n", b.file,
+			b.u.StartLine, b.u.StartCol, b.u.EndLine, b.u.EndCol, b.u.NumStmts, b.u.Count)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,238 @@
+package covtree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/covutil"
+)
+
+// testProfile returns a profile for a package with two functions in one
+// file: Add, whose early return never ran, and Sub, which never ran.
+func testProfile() *covutil.Profile {
+	return &covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode: covutil.ModeCount,
+			Packages: []covutil.PackageMeta{{
+				Path:       "example.com/mod/a",
+				Name:       "a",
+				ModulePath: "example.com/mod",
+				Functions: []covutil.FuncDesc{
+					{
+						FuncName: "Add",
+						SrcFile:  "example.com/mod/a/a.go",
+						Units: []covutil.CoverableUnit{
+							{StartLine: 3, StartCol: 24, EndLine: 4, EndCol: 11, NumStmt: 1},
+							{StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3, NumStmt: 1},
+							{StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 14, NumStmt: 1},
+						},
+					},
+					{
+						FuncName: "Sub",
+						SrcFile:  "example.com/mod/a/a.go",
+						Units: []covutil.CoverableUnit{
+							{StartLine: 10, StartCol: 24, EndLine: 12, EndCol: 2, NumStmt: 2},
+						},
+					},
+				},
+			}},
+		},
+		Counters: map[covutil.PkgFuncKey][]uint32{
+			{PkgPath: "example.com/mod/a", FuncName: "Add"}: {3, 0, 3},
+		},
+	}
+}
+
+func TestNewCoverageTreeFromProfile(t *testing.T) {
+	ct := NewCoverageTreeFromProfile(testProfile())
+	pkg := ct.GetPackage("example.com/mod/a")
+	if pkg == nil {
+		t.Fatal("package not found")
+	}
+	if pkg.CounterMode != "count" {
+		t.Errorf("CounterMode = %q, want count", pkg.CounterMode)
+	}
+	if len(pkg.Functions) != 2 || pkg.Functions[0].Units[0].Count != 3 || pkg.Functions[1].Units[0].Covered {
+		t.Errorf("unexpected functions: %+v", pkg.Functions)
+	}
+	if pkg.TotalLines != 9 || pkg.CoveredLines != 3 {
+		t.Errorf("lines = %d/%d, want 3/9", pkg.CoveredLines, pkg.TotalLines)
+	}
+}
+
+// coberturaDTD describes coverage-04.dtd: the permitted child elements of
+// each element, in order, and its required attributes.
+var coberturaDTD = map[string]struct {
+	children []string
+	attrs    []string
+}{
+	"coverage": {[]string{"sources", "packages"}, []string{"line-rate", "branch-rate", "lines-covered", "lines-valid", "branches-covered", "branches-valid", "complexity", "version", "timestamp"}},
+	"sources":  {[]string{"source"}, nil},
+	"source":   {nil, nil},
+	"packages": {[]string{"package"}, nil},
+	"package":  {[]string{"classes"}, []string{"name", "line-rate", "branch-rate", "complexity"}},
+	"classes":  {[]string{"class"}, nil},
+	"class":    {[]string{"methods", "lines"}, []string{"name", "filename", "line-rate", "branch-rate", "complexity"}},
+	"methods":  {[]string{"method"}, nil},
+	"method":   {[]string{"lines"}, []string{"name", "signature", "line-rate", "branch-rate"}},
+	"lines":    {[]string{"line"}, nil},
+	"line":     {[]string{"conditions"}, []string{"number", "hits"}},
+}
+
+// validateCobertura checks that the document's elements nest, order and
+// carry attributes as coberturaDTD requires.
+func validateCobertura(t *testing.T, data []byte) {
+	t.Helper()
+	if !bytes.Contains(data, []byte(`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`)) {
+		t.Error("missing DOCTYPE")
+	}
+	type frame struct {
+		name string
+		next int // index into the parent's permitted children
+	}
+	var stack []*frame
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid XML: %v", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			name := tok.Name.Local
+			spec, ok := coberturaDTD[name]
+			if !ok {
+				t.Fatalf("unknown element <%s>", name)
+			}
+			if len(stack) == 0 {
+				if name != "coverage" {
+					t.Fatalf("root element is <%s>, want <coverage>", name)
+				}
+			} else {
+				parent := stack[len(stack)-1]
+				allowed := coberturaDTD[parent.name].children
+				i := parent.next
+				for i < len(allowed) && allowed[i] != name {
+					i++
+				}
+				if i == len(allowed) {
+					t.Fatalf("<%s> not permitted here in <%s>", name, parent.name)
+				}
+				parent.next = i
+			}
+			have := make(map[string]bool)
+			for _, a := range tok.Attr {
+				have[a.Name.Local] = true
+			}
+			for _, a := range spec.attrs {
+				if !have[a] {
+					t.Errorf("<%s> missing required attribute %q", name, a)
+				}
+			}
+			stack = append(stack, &frame{name: name})
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+func TestWriteCobertura(t *testing.T) {
+	ct := NewCoverageTreeFromProfile(testProfile())
+	var buf bytes.Buffer
+	opts := &ExportOptions{TrimModule: true, Sources: []string{"/src/mod"}, Timestamp: time.UnixMilli(1700000000000)}
+	if err := WriteCobertura(&buf, ct, opts); err != nil {
+		t.Fatal(err)
+	}
+	validateCobertura(t, buf.Bytes())
+
+	out := buf.String()
+	for _, want := range []string{
+		`lines-covered="3" lines-valid="8" branches-covered="1" branches-valid="2"`,
+		`timestamp="1700000000000"`,
+		`<source>/src/mod</source>`,
+		`<package name="example.com/mod/a" line-rate="0.3750"`,
+		`<class name="a" filename="a/a.go"`,
+		`<method name="Add" signature="" line-rate="0.6000" branch-rate="0.5000"`,
+		`<line number="4" hits="3" branch="true" condition-coverage="50% (1/2)"></line>`,
+		`<line number="11" hits="0" branch="false"></line>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteLCOV(t *testing.T) {
+	ct := NewCoverageTreeFromProfile(testProfile())
+	var buf bytes.Buffer
+	if err := WriteLCOV(&buf, ct, &ExportOptions{TestName: "unit"}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	// genhtml expects each record's entries in this order.
+	order := []string{"TN", "SF", "FN", "FNDA", "FNF", "FNH", "BRDA", "BRF", "BRH", "DA", "LF", "LH", "end_of_record"}
+	rank := make(map[string]int)
+	for i, k := range order {
+		rank[k] = i
+	}
+	var records int
+	last := -1
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		key, _, _ := strings.Cut(sc.Text(), ":")
+		r, ok := rank[key]
+		if !ok {
+			t.Fatalf("unknown record %q", sc.Text())
+		}
+		if r < last {
+			t.Errorf("%q out of order after %s", sc.Text(), order[last])
+		}
+		last = r
+		if key == "end_of_record" {
+			records++
+			last = -1
+		}
+	}
+	if records != 1 || last != -1 {
+		t.Errorf("got %d complete records, want 1", records)
+	}
+
+	for _, want := range []string{
+		"TN:unit\nSF:example.com/mod/a/a.go\n",
+		"FN:3,Add\nFN:10,Sub\nFNDA:3,Add\nFNDA:0,Sub\nFNF:2\nFNH:1\n",
+		"BRDA:4,0,0,3\nBRDA:4,0,1,0\nBRF:2\nBRH:1\n",
+		"DA:4,3\nDA:5,0\n",
+		"DA:12,0\nLF:8\nLH:3\nend_of_record\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteGoCover(t *testing.T) {
+	ct := NewCoverageTreeFromProfile(testProfile())
+	var buf bytes.Buffer
+	if err := WriteGoCover(&buf, ct, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `mode: count
+example.com/mod/a/a.go:3.24,4.11 1 3
+example.com/mod/a/a.go:4.11,6.3 1 0
+example.com/mod/a/a.go:7.2,7.14 1 3
+example.com/mod/a/a.go:10.24,12.2 2 0
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covtree
+
+import "math"
+
+// MergeTrees returns a new tree holding the union of the coverage in trees.
+//
+// Units are matched by package, function, file and source position, so a
+// line covered in any of the trees is covered in the result and counted
+// once. Execution counts are summed, saturating at the maximum counter
+// value, except for packages built in "set" mode, where a unit is 1 if
+// any tree executed it. Packages, functions and units present in only
+// some of the trees are carried over as they are.
+//
+// Matching by position assumes the trees were collected from the same
+// source. Where a file differs between them, as for trees of different
+// commits, its units no longer line up: a statement that moved is counted
+// once for each position it occupies, inflating the totals of the result.
+//
+// The input trees are not modified.
+func MergeTrees(trees ...*CoverageTree) *CoverageTree {
+	type funcKey struct{ name, file string }
+	type unitKey struct{ stLine, stCol, enLine, enCol uint32 }
+
+	merged := NewCoverageTree()
+	funcs := make(map[string]map[funcKey]*FunctionNode)
+	units := make(map[*FunctionNode]map[unitKey]int)
+
+	for _, t := range trees {
+		if t == nil {
+			continue
+		}
+		for _, name := range t.GetPackageNames() {
+			src := t.Packages[name]
+			pkg := merged.Packages[name]
+			if pkg == nil {
+				pkg = &PackageNode{
+					ImportPath:  src.ImportPath,
+					Name:        src.Name,
+					ModulePath:  src.ModulePath,
+					MetaFile:    src.MetaFile,
+					CounterMode: src.CounterMode,
+					Metadata:    make(map[string]string),
+				}
+				for k, v := range src.Metadata {
+					pkg.Metadata[k] = v
+				}
+				merged.Packages[name] = pkg
+				funcs[name] = make(map[funcKey]*FunctionNode)
+			}
+			setMode := pkg.CounterMode == "set"
+
+			for _, sf := range src.Functions {
+				fk := funcKey{sf.Name, sf.File}
+				fn := funcs[name][fk]
+				if fn == nil {
+					fn = &FunctionNode{Name: sf.Name, File: sf.File, IsLiteral: sf.IsLiteral}
+					funcs[name][fk] = fn
+					units[fn] = make(map[unitKey]int)
+					pkg.Functions = append(pkg.Functions, fn)
+				}
+				for _, su := range sf.Units {
+					uk := unitKey{su.StartLine, su.StartCol, su.EndLine, su.EndCol}
+					i, ok := units[fn][uk]
+					if !ok {
+						units[fn][uk] = len(fn.Units)
+						u := su
+						u.Covered = su.Covered || su.Count > 0
+						fn.Units = append(fn.Units, u)
+						continue
+					}
+					u := &fn.Units[i]
+					switch {
+					case setMode:
+						u.Count = max(u.Count, su.Count)
+					case uint64(u.Count)+uint64(su.Count) > math.MaxUint32:
+						u.Count = math.MaxUint32
+					default:
+						u.Count += su.Count
+					}
+					u.Covered = u.Covered || su.Covered || su.Count > 0
+				}
+			}
+		}
+	}
+
+	for _, name := range merged.GetPackageNames() {
+		merged.addToDirectoryTree(merged.Packages[name])
+	}
+	merged.calculateCoverage()
+	return merged
+}
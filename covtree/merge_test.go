@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covtree
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tmc/covutil"
+)
+
+func TestMergeTrees(t *testing.T) {
+	a := testProfile()
+	b := testProfile()
+	b.Counters = map[covutil.PkgFuncKey][]uint32{
+		{PkgPath: "example.com/mod/a", FuncName: "Add"}: {math.MaxUint32, 1, 0},
+	}
+	b.Meta.Packages = append(b.Meta.Packages, covutil.PackageMeta{
+		Path: "example.com/mod/b",
+		Name: "b",
+		Functions: []covutil.FuncDesc{{
+			FuncName: "F",
+			SrcFile:  "example.com/mod/b/b.go",
+			Units:    []covutil.CoverableUnit{{StartLine: 1, EndLine: 2, NumStmt: 1}},
+		}},
+	})
+	ta, tb := NewCoverageTreeFromProfile(a), NewCoverageTreeFromProfile(b)
+
+	merged := MergeTrees(ta, tb, ta)
+	pkg := merged.GetPackage("example.com/mod/a")
+	if pkg == nil {
+		t.Fatal("package not found")
+	}
+	// Add is now fully covered and Sub still uncovered: lines are
+	// counted once however many trees cover them.
+	if pkg.TotalLines != 9 || pkg.CoveredLines != 6 {
+		t.Errorf("lines = %d/%d, want 6/9", pkg.CoveredLines, pkg.TotalLines)
+	}
+	var counts []uint32
+	for _, u := range pkg.Functions[0].Units {
+		counts = append(counts, u.Count)
+	}
+	if want := []uint32{math.MaxUint32, 1, 6}; len(counts) != 3 || counts[0] != want[0] || counts[1] != want[1] || counts[2] != want[2] {
+		t.Errorf("Add counts = %v, want %v", counts, want)
+	}
+	if merged.GetPackage("example.com/mod/b") == nil || merged.GetDirectory("example.com/mod/b") == nil {
+		t.Errorf("package present in one tree only is missing")
+	}
+	if s := merged.Summary(); s.TotalLines != 11 || s.CoveredLines != 6 {
+		t.Errorf("summary = %d/%d, want 6/11", s.CoveredLines, s.TotalLines)
+	}
+	if s := ta.Summary(); s.TotalLines != 9 || s.CoveredLines != 3 {
+		t.Errorf("input tree modified: %d/%d", s.CoveredLines, s.TotalLines)
+	}
+
+	// In set mode a unit stays at 1.
+	a.Meta.Mode = covutil.ModeSet
+	a.Counters = map[covutil.PkgFuncKey][]uint32{{PkgPath: "example.com/mod/a", FuncName: "Add"}: {1, 0, 1}}
+	ts := NewCoverageTreeFromProfile(a)
+	if u := MergeTrees(ts, ts).GetPackage("example.com/mod/a").Functions[0].Units[0]; u.Count != 1 {
+		t.Errorf("set mode count = %d, want 1", u.Count)
+	}
+}
@@ -0,0 +1,316 @@
+package covtree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tmc/covutil/internal/miniyaml"
+)
+
+// Policy declares minimum coverage requirements for a CoverageTree.
+// Coverage is measured in lines, as reported by Summary, and all
+// thresholds are percentages between 0 and 100.
+//
+// Policies are written in JSON or YAML:
+//
+//	overall: 70
+//	packages:
+//	  - pattern: example.com/app/...
+//	    min: 60
+//	  - pattern: example.com/app/internal/legacy/*
+//	    min: 20
+//	directories:
+//	  - path: example.com/app/internal
+//	    min: 75
+//	ratchet:
+//	  tolerance: 0.5
+type Policy struct {
+	// Overall is the minimum coverage of the whole tree.
+	Overall float64 `json:"overall,omitempty"`
+	// Packages sets minimums for individual packages. When several rules
+	// match a package, the last one applies, so general rules should come
+	// before more specific ones.
+	Packages []PackageRule `json:"packages,omitempty"`
+	// Directories sets minimums for the aggregate coverage of the
+	// packages under a directory of the package hierarchy.
+	Directories []DirectoryRule `json:"directories,omitempty"`
+	// Ratchet configures comparison against a baseline.
+	Ratchet RatchetRule `json:"ratchet"`
+}
+
+// PackageRule sets the minimum coverage of the packages matching Pattern.
+// Patterns are matched with path.Match against import paths; a pattern
+// ending in "/..." also matches every package below that path.
+type PackageRule struct {
+	Pattern string  `json:"pattern"`
+	Min     float64 `json:"min"`
+}
+
+// DirectoryRule sets the minimum coverage of a DirectoryNode, named by
+// its slash-separated path such as "example.com/app/internal".
+type DirectoryRule struct {
+	Path string  `json:"path"`
+	Min  float64 `json:"min"`
+}
+
+// RatchetRule configures the ratchet: when a baseline is given, overall
+// and per-package coverage may not drop below the baseline by more than
+// Tolerance percentage points.
+type RatchetRule struct {
+	Tolerance float64 `json:"tolerance,omitempty"`
+}
+
+// ParsePolicy parses a policy written in JSON or YAML. Unknown fields are
+// rejected, so that misspelled rules do not silently go unchecked.
+func ParsePolicy(data []byte) (*Policy, error) {
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		v, err := miniyaml.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			v = map[string]any{}
+		}
+		if trimmed, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// LoadPolicy reads and parses the policy file at name.
+func LoadPolicy(name string) (*Policy, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return p, nil
+}
+
+func (p *Policy) validate() error {
+	check := func(what string, v float64) error {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("%s: minimum %g is not a percentage between 0 and 100", what, v)
+		}
+		return nil
+	}
+	if err := check("overall", p.Overall); err != nil {
+		return err
+	}
+	for _, r := range p.Packages {
+		if r.Pattern == "" {
+			return fmt.Errorf("package rule without pattern")
+		}
+		if _, err := path.Match(strings.TrimSuffix(r.Pattern, "/..."), ""); err != nil {
+			return fmt.Errorf("package rule %q: %v", r.Pattern, err)
+		}
+		if err := check("package rule "+r.Pattern, r.Min); err != nil {
+			return err
+		}
+	}
+	for _, r := range p.Directories {
+		if r.Path == "" {
+			return fmt.Errorf("directory rule without path")
+		}
+		if err := check("directory rule "+r.Path, r.Min); err != nil {
+			return err
+		}
+	}
+	if p.Ratchet.Tolerance < 0 {
+		return fmt.Errorf("ratchet tolerance %g is negative", p.Ratchet.Tolerance)
+	}
+	return nil
+}
+
+// MatchPackagePattern reports whether importPath matches pattern, a
+// path.Match glob. A pattern ending in "/..." also matches every package
+// below the directories the rest of it matches.
+func MatchPackagePattern(pattern, importPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/..."); ok {
+		for dir := importPath; ; dir = path.Dir(dir) {
+			if ok, _ := path.Match(prefix, dir); ok {
+				return true
+			}
+			// Importers name packages by absolute directories, for which
+			// path.Dir stops at "/" rather than ".".
+			if path.Dir(dir) == dir {
+				return false
+			}
+		}
+	}
+	ok, _ := path.Match(pattern, importPath)
+	return ok
+}
+
+// Violation describes a coverage requirement that a tree does not meet.
+type Violation struct {
+	// Kind is "overall", "package", "directory" or "ratchet".
+	Kind string
+	// Target is the package or directory concerned, or "" for the tree.
+	Target string
+	// Rule is the package pattern that set the minimum, if any.
+	Rule string
+	// Coverage is the measured coverage, and Min the required minimum,
+	// in percent.
+	Coverage float64
+	Min      float64
+}
+
+func (v Violation) String() string {
+	target := v.Target
+	if target == "" {
+		target = "total"
+	}
+	s := fmt.Sprintf("%s %s: coverage %.1f%% is below %.1f%%", v.Kind, target, v.Coverage, v.Min)
+	if v.Rule != "" && v.Rule != v.Target {
+		s += fmt.Sprintf(" (rule %s)", v.Rule)
+	}
+	if v.Kind == "ratchet" {
+		s += " (baseline)"
+	}
+	return s
+}
+
+// Baseline records the coverage of a tree for later ratchet checks.
+type Baseline struct {
+	// Overall is the coverage of the whole tree, in percent.
+	Overall float64 `json:"overall"`
+	// Packages maps import paths to their coverage, in percent.
+	Packages map[string]float64 `json:"packages"`
+}
+
+// NewBaseline records the current coverage of ct.
+func NewBaseline(ct *CoverageTree) *Baseline {
+	b := &Baseline{
+		Overall:  100 * ct.Summary().CoverageRate,
+		Packages: make(map[string]float64),
+	}
+	for path, pkg := range ct.Packages {
+		if pkg.TotalLines > 0 {
+			b.Packages[path] = 100 * pkg.CoverageRate
+		}
+	}
+	return b
+}
+
+// Raise returns a baseline recording, for the whole tree and for each
+// package, the higher of b's coverage and the current coverage of ct, so
+// that drops allowed by the ratchet tolerance never lower the baseline.
+// Packages missing from ct keep their recorded coverage. A nil b is
+// equivalent to an empty baseline.
+func (b *Baseline) Raise(ct *CoverageTree) *Baseline {
+	cur := NewBaseline(ct)
+	if b == nil {
+		return cur
+	}
+	cur.Overall = max(cur.Overall, b.Overall)
+	for path, cov := range b.Packages {
+		cur.Packages[path] = max(cur.Packages[path], cov)
+	}
+	return cur
+}
+
+// LoadBaseline reads a baseline written by WriteFile.
+func LoadBaseline(name string) (*Baseline, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return &b, nil
+}
+
+// WriteFile writes the baseline as JSON to name.
+func (b *Baseline) WriteFile(name string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// Check evaluates the policy against ct and returns the violations,
+// sorted by kind and target. If baseline is non-nil, coverage is also
+// ratcheted against it; packages absent from the baseline are not.
+func (p *Policy) Check(ct *CoverageTree, baseline *Baseline) []Violation {
+	var vs []Violation
+	overall := 100 * ct.Summary().CoverageRate
+	if overall < p.Overall {
+		vs = append(vs, Violation{Kind: "overall", Coverage: overall, Min: p.Overall})
+	}
+
+	for _, name := range ct.GetPackageNames() {
+		pkg := ct.Packages[name]
+		if pkg.TotalLines == 0 {
+			continue
+		}
+		var rule *PackageRule
+		for i := range p.Packages {
+			if MatchPackagePattern(p.Packages[i].Pattern, name) {
+				rule = &p.Packages[i]
+			}
+		}
+		if cov := 100 * pkg.CoverageRate; rule != nil && cov < rule.Min {
+			vs = append(vs, Violation{Kind: "package", Target: name, Rule: rule.Pattern, Coverage: cov, Min: rule.Min})
+		}
+	}
+
+	for _, r := range p.Directories {
+		var cov float64
+		if dir := ct.GetDirectory(r.Path); dir != nil && dir.TotalLines > 0 {
+			cov = 100 * float64(dir.CoveredLines) / float64(dir.TotalLines)
+		}
+		// A directory without coverage data fails any non-zero minimum.
+		if cov < r.Min {
+			vs = append(vs, Violation{Kind: "directory", Target: r.Path, Coverage: cov, Min: r.Min})
+		}
+	}
+
+	if baseline != nil {
+		tol := p.Ratchet.Tolerance
+		if overall < baseline.Overall-tol {
+			vs = append(vs, Violation{Kind: "ratchet", Coverage: overall, Min: baseline.Overall - tol})
+		}
+		for _, name := range ct.GetPackageNames() {
+			pkg := ct.Packages[name]
+			want, ok := baseline.Packages[name]
+			if !ok || pkg.TotalLines == 0 {
+				continue
+			}
+			if cov := 100 * pkg.CoverageRate; cov < want-tol {
+				vs = append(vs, Violation{Kind: "ratchet", Target: name, Coverage: cov, Min: want - tol})
+			}
+		}
+	}
+
+	kindOrder := map[string]int{"overall": 0, "directory": 1, "package": 2, "ratchet": 3}
+	sort.SliceStable(vs, func(i, j int) bool {
+		if vs[i].Kind != vs[j].Kind {
+			return kindOrder[vs[i].Kind] < kindOrder[vs[j].Kind]
+		}
+		return vs[i].Target < vs[j].Target
+	})
+	return vs
+}
@@ -0,0 +1,213 @@
+package covtree
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tmc/covutil"
+)
+
+const testPolicyYAML = `# Coverage policy.
+overall: 50
+packages:
+  - pattern: example.com/app/...
+    min: 40
+  - pattern: "example.com/app/legacy"   # tolerated for now
+    min: 10
+directories:
+- path: example.com/app/core
+  min: 90
+ratchet:
+  tolerance: 0.5
+`
+
+func TestParsePolicy(t *testing.T) {
+	want := &Policy{
+		Overall: 50,
+		Packages: []PackageRule{
+			{Pattern: "example.com/app/...", Min: 40},
+			{Pattern: "example.com/app/legacy", Min: 10},
+		},
+		Directories: []DirectoryRule{{Path: "example.com/app/core", Min: 90}},
+		Ratchet:     RatchetRule{Tolerance: 0.5},
+	}
+	got, err := ParsePolicy([]byte(testPolicyYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("YAML policy = %+v, want %+v", got, want)
+	}
+
+	got, err = ParsePolicy([]byte(`{"overall": 50, "packages": [{"pattern": "example.com/app/...", "min": 40},
+		{"pattern": "example.com/app/legacy", "min": 10}], "directories": [{"path": "example.com/app/core", "min": 90}],
+		"ratchet": {"tolerance": 0.5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON policy = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{
+		"overall: 150\n",
+		"overal: 50\n",
+		"packages:\n  - min: 10\n",
+		"packages:\n  - pattern: '[a'\n    min: 10\n",
+		"overall: 50\n  min: 10\n",
+		"overall: .inf\n",
+	} {
+		if _, err := ParsePolicy([]byte(bad)); err == nil {
+			t.Errorf("ParsePolicy(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+// policyTree returns a tree with three packages: core (2/4 lines covered),
+// legacy (1/4) and util (4/4).
+func policyTree(t *testing.T) *CoverageTree {
+	t.Helper()
+	p := &covutil.Profile{
+		Meta:     covutil.MetaFile{Mode: covutil.ModeSet},
+		Counters: map[covutil.PkgFuncKey][]uint32{},
+	}
+	for _, pkg := range []struct {
+		name   string
+		counts []uint32
+	}{
+		{"core", []uint32{1, 1, 0, 0}},
+		{"legacy", []uint32{1, 0, 0, 0}},
+		{"util", []uint32{1, 1, 1, 1}},
+	} {
+		path := "example.com/app/" + pkg.name
+		var units []covutil.CoverableUnit
+		for i := range pkg.counts {
+			l := uint32(10 + i)
+			units = append(units, covutil.CoverableUnit{StartLine: l, StartCol: 1, EndLine: l, EndCol: 5, NumStmt: 1})
+		}
+		p.Meta.Packages = append(p.Meta.Packages, covutil.PackageMeta{
+			Path: path,
+			Name: pkg.name,
+			Functions: []covutil.FuncDesc{{
+				FuncName: "F",
+				SrcFile:  path + "/f.go",
+				Units:    units,
+			}},
+		})
+		p.Counters[covutil.PkgFuncKey{PkgPath: path, FuncName: "F"}] = pkg.counts
+	}
+	return NewCoverageTreeFromProfile(p)
+}
+
+func violationStrings(vs []Violation) []string {
+	var s []string
+	for _, v := range vs {
+		s = append(s, v.String())
+	}
+	return s
+}
+
+func TestPolicyCheck(t *testing.T) {
+	ct := policyTree(t)
+	policy, err := ParsePolicy([]byte(testPolicyYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy falls short of the general rule but meets its own, later
+	// rule, so only the directory fails.
+	got := violationStrings(policy.Check(ct, nil))
+	want := []string{"directory example.com/app/core: coverage 50.0% is below 90.0%"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	policy.Overall = 80
+	policy.Packages = policy.Packages[:1]
+	got = violationStrings(policy.Check(ct, nil))
+	want = []string{
+		"overall total: coverage 58.3% is below 80.0%",
+		"directory example.com/app/core: coverage 50.0% is below 90.0%",
+		"package example.com/app/legacy: coverage 25.0% is below 40.0% (rule example.com/app/...)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if vs := (&Policy{Directories: []DirectoryRule{{Path: "example.com/missing", Min: 1}}}).Check(ct, nil); len(vs) != 1 {
+		t.Errorf("missing directory: got %v, want one violation", vs)
+	}
+}
+
+func TestPolicyRatchet(t *testing.T) {
+	ct := policyTree(t)
+	baseline := NewBaseline(ct)
+	name := filepath.Join(t.TempDir(), "baseline.json")
+	if err := baseline.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBaseline(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, baseline) {
+		t.Errorf("round trip: got %+v, want %+v", loaded, baseline)
+	}
+
+	policy := &Policy{}
+	if vs := policy.Check(ct, loaded); len(vs) != 0 {
+		t.Errorf("unchanged tree violates its own baseline: %v", violationStrings(vs))
+	}
+
+	// A package that was better covered in the baseline trips the ratchet,
+	// unless the drop is within the tolerance.
+	loaded.Packages["example.com/app/core"] = 60
+	got := violationStrings(policy.Check(ct, loaded))
+	want := []string{"ratchet example.com/app/core: coverage 50.0% is below 60.0% (baseline)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %q, want %q", got, want)
+	}
+	policy.Ratchet.Tolerance = 10
+	if vs := policy.Check(ct, loaded); len(vs) != 0 {
+		t.Errorf("drop within tolerance: %v", violationStrings(vs))
+	}
+
+	// Updating after a drop within the tolerance keeps the higher
+	// baseline, while improvements raise it.
+	loaded.Overall = 60
+	loaded.Packages["example.com/app/util"] = 90
+	raised := loaded.Raise(ct)
+	if raised.Overall != 60 || raised.Packages["example.com/app/core"] != 60 {
+		t.Errorf("Raise lowered the baseline: %+v", raised)
+	}
+	if raised.Packages["example.com/app/util"] != 100 {
+		t.Errorf("Raise did not record improved coverage: %+v", raised)
+	}
+	if got := (*Baseline)(nil).Raise(ct); !reflect.DeepEqual(got, baseline) {
+		t.Errorf("nil Raise = %+v, want %+v", got, baseline)
+	}
+}
+
+func TestMatchPackagePattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"example.com/app/...", "example.com/app", true},
+		{"example.com/app/...", "example.com/app/a/b", true},
+		{"example.com/app/...", "example.com/application", false},
+		{"example.com/*/internal/...", "example.com/x/internal/y", true},
+		{"example.com/app/*", "example.com/app/a", true},
+		{"example.com/app/*", "example.com/app/a/b", false},
+		{"example.com/...", "/home/u/src/a.go", false},
+		{"/home/u/...", "/home/u/src/a.go", true},
+		{"example.com/...", "a", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPackagePattern(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("MatchPackagePattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
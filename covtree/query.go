@@ -0,0 +1,537 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covtree
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A Query is a compiled filter expression over the packages, functions and
+// coverable units of a tree, such as
+//
+//	pkg ~ "internal/*" && func.coverage < 0.5 && func.lines > 20 && label.GOOS == "linux"
+//
+// A query is a boolean expression of comparisons joined by && and ||,
+// negated by !, and grouped by parentheses. A comparison compares a field
+// with a quoted string, a number, or true or false, using ==, !=, <, <=,
+// > or >=. Strings can also be matched against a path.Match pattern with
+// ~ and !~; a pattern matches a string if it matches the whole string or
+// any trailing sequence of its slash-separated elements, so that
+// "internal/*" matches every package directly in an internal directory.
+// A boolean field can be used as a condition on its own.
+//
+// The fields are:
+//
+//	pkg, pkg.path      import path of the package
+//	pkg.name           package name
+//	pkg.module         module path of the package
+//	pkg.mode           counter mode: set, count or atomic
+//	pkg.coverage       fraction of the package's lines covered, from 0 to 1
+//	pkg.lines          lines in the package
+//	pkg.covered        lines covered in the package
+//	label.<key>        the package's metadata value for key, or ""
+//	func, func.name    function name
+//	func.file          source file of the function
+//	func.coverage      fraction of the function's lines covered
+//	func.lines         lines in the function
+//	func.covered       lines covered in the function
+//	func.literal       whether the function is a function literal
+//	unit.line          first line of the unit
+//	unit.endline       last line of the unit
+//	unit.stmts         statements in the unit
+//	unit.count         execution count of the unit
+//	unit.covered       whether the unit executed
+//
+// A query selects the most detailed nodes any of its fields refers to:
+// packages, functions, or units.
+type Query struct {
+	src   string
+	root  queryNode
+	level QueryLevel
+}
+
+// QueryLevel is the kind of node a query selects.
+type QueryLevel int
+
+const (
+	PackageLevel QueryLevel = iota
+	FunctionLevel
+	UnitLevel
+)
+
+// A QueryError reports a syntax error in a query.
+type QueryError struct {
+	Query string
+	Pos   int // byte offset of the error in Query
+	Msg   string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query: column %d: %s", e.Pos+1, e.Msg)
+}
+
+// ParseQuery compiles a query. Errors are of type *QueryError.
+func ParseQuery(s string) (*Query, error) {
+	p := &queryParser{src: s}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf(p.tok.pos, "unexpected %s", p.tok)
+	}
+	return &Query{src: s, root: root, level: p.level}, nil
+}
+
+// String returns the source of the query.
+func (q *Query) String() string { return q.src }
+
+// Level reports the kind of node q selects.
+func (q *Query) Level() QueryLevel { return q.level }
+
+// Filter returns the packages of pkgs that match q, in order. For
+// function-level queries, each package is a copy holding only the matching
+// functions, and for unit-level queries each function is a copy holding
+// only the matching units. The totals and coverage rates of the copies are
+// those of the whole package or function.
+func (q *Query) Filter(pkgs []*PackageNode) []*PackageNode {
+	var result []*PackageNode
+	for _, pkg := range pkgs {
+		if q.level == PackageLevel {
+			if q.root.eval(&queryEnv{pkg: pkg}) {
+				result = append(result, pkg)
+			}
+			continue
+		}
+		var fns []*FunctionNode
+		for _, fn := range pkg.Functions {
+			if q.level == FunctionLevel {
+				if q.root.eval(&queryEnv{pkg: pkg, fn: fn}) {
+					fns = append(fns, fn)
+				}
+				continue
+			}
+			var units []CoverableUnitNode
+			for i := range fn.Units {
+				if q.root.eval(&queryEnv{pkg: pkg, fn: fn, unit: &fn.Units[i]}) {
+					units = append(units, fn.Units[i])
+				}
+			}
+			if len(units) > 0 {
+				cp := *fn
+				cp.Units = units
+				fns = append(fns, &cp)
+			}
+		}
+		if len(fns) > 0 {
+			cp := *pkg
+			cp.Functions = fns
+			result = append(result, &cp)
+		}
+	}
+	return result
+}
+
+// Query returns the packages of ct matching q, sorted by import path, as
+// filtered by q.Filter.
+func (ct *CoverageTree) Query(q *Query) []*PackageNode {
+	return q.Filter(ct.FilterPackages(Filter{}))
+}
+
+// queryEnv is the node a query is evaluated on. Fields of the levels
+// below the query's level are nil.
+type queryEnv struct {
+	pkg  *PackageNode
+	fn   *FunctionNode
+	unit *CoverableUnitNode
+}
+
+type queryNode interface {
+	eval(env *queryEnv) bool
+}
+
+type andNode struct{ x, y queryNode }
+type orNode struct{ x, y queryNode }
+type notNode struct{ x queryNode }
+
+func (n *andNode) eval(env *queryEnv) bool { return n.x.eval(env) && n.y.eval(env) }
+func (n *orNode) eval(env *queryEnv) bool  { return n.x.eval(env) || n.y.eval(env) }
+func (n *notNode) eval(env *queryEnv) bool { return !n.x.eval(env) }
+
+// cmpNode compares a field with a constant. A boolean field used on its
+// own compares equal to true.
+type cmpNode struct {
+	field *queryField
+	op    string
+	str   string
+	num   float64
+	b     bool
+}
+
+func (n *cmpNode) eval(env *queryEnv) bool {
+	switch v := n.field.get(env).(type) {
+	case string:
+		switch n.op {
+		case "==":
+			return v == n.str
+		case "!=":
+			return v != n.str
+		case "~":
+			return matchGlob(n.str, v)
+		case "!~":
+			return !matchGlob(n.str, v)
+		}
+	case float64:
+		switch n.op {
+		case "==":
+			return v == n.num
+		case "!=":
+			return v != n.num
+		case "<":
+			return v < n.num
+		case "<=":
+			return v <= n.num
+		case ">":
+			return v > n.num
+		case ">=":
+			return v >= n.num
+		}
+	case bool:
+		return (v == n.b) == (n.op == "==")
+	}
+	return false
+}
+
+// matchGlob reports whether pattern matches s or a trailing sequence of
+// its slash-separated elements.
+func matchGlob(pattern, s string) bool {
+	for {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+		i := strings.IndexByte(s, '/')
+		if i < 0 {
+			return false
+		}
+		s = s[i+1:]
+	}
+}
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	numberField
+	boolField
+)
+
+type queryField struct {
+	level QueryLevel
+	kind  fieldKind
+	get   func(env *queryEnv) any
+}
+
+var queryFields = map[string]*queryField{
+	"pkg":          {PackageLevel, stringField, func(e *queryEnv) any { return e.pkg.ImportPath }},
+	"pkg.path":     {PackageLevel, stringField, func(e *queryEnv) any { return e.pkg.ImportPath }},
+	"pkg.name":     {PackageLevel, stringField, func(e *queryEnv) any { return e.pkg.Name }},
+	"pkg.module":   {PackageLevel, stringField, func(e *queryEnv) any { return e.pkg.ModulePath }},
+	"pkg.mode":     {PackageLevel, stringField, func(e *queryEnv) any { return e.pkg.CounterMode }},
+	"pkg.coverage": {PackageLevel, numberField, func(e *queryEnv) any { return e.pkg.CoverageRate }},
+	"pkg.lines":    {PackageLevel, numberField, func(e *queryEnv) any { return float64(e.pkg.TotalLines) }},
+	"pkg.covered":  {PackageLevel, numberField, func(e *queryEnv) any { return float64(e.pkg.CoveredLines) }},
+
+	"func":          {FunctionLevel, stringField, func(e *queryEnv) any { return e.fn.Name }},
+	"func.name":     {FunctionLevel, stringField, func(e *queryEnv) any { return e.fn.Name }},
+	"func.file":     {FunctionLevel, stringField, func(e *queryEnv) any { return e.fn.File }},
+	"func.coverage": {FunctionLevel, numberField, func(e *queryEnv) any { return e.fn.CoverageRate }},
+	"func.lines":    {FunctionLevel, numberField, func(e *queryEnv) any { return float64(e.fn.TotalLines) }},
+	"func.covered":  {FunctionLevel, numberField, func(e *queryEnv) any { return float64(e.fn.CoveredLines) }},
+	"func.literal":  {FunctionLevel, boolField, func(e *queryEnv) any { return e.fn.IsLiteral }},
+
+	"unit.line":    {UnitLevel, numberField, func(e *queryEnv) any { return float64(e.unit.StartLine) }},
+	"unit.endline": {UnitLevel, numberField, func(e *queryEnv) any { return float64(e.unit.EndLine) }},
+	"unit.stmts":   {UnitLevel, numberField, func(e *queryEnv) any { return float64(e.unit.NumStmts) }},
+	"unit.count":   {UnitLevel, numberField, func(e *queryEnv) any { return float64(e.unit.Count) }},
+	"unit.covered": {UnitLevel, boolField, func(e *queryEnv) any { return e.unit.Covered }},
+}
+
+// lookupField returns the field named name, including label fields.
+func lookupField(name string) *queryField {
+	if key, ok := strings.CutPrefix(name, "label."); ok && key != "" {
+		return &queryField{PackageLevel, stringField, func(e *queryEnv) any { return e.pkg.Metadata[key] }}
+	}
+	return queryFields[name]
+}
+
+// Lexing.
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp     // comparison operator
+	tokAnd    // &&
+	tokOr     // ||
+	tokNot    // !
+	tokLparen // (
+	tokRparen // )
+)
+
+type token struct {
+	kind tokKind
+	pos  int
+	text string // operator, identifier, number, or unquoted string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type queryParser struct {
+	src   string
+	pos   int
+	tok   token
+	err   *QueryError // lexing error, reported by the parser
+	level QueryLevel
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...any) error {
+	return &QueryError{Query: p.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// next advances to the next token.
+func (p *queryParser) next() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+	c := p.src[p.pos]
+	two := ""
+	if p.pos+1 < len(p.src) {
+		two = p.src[p.pos : p.pos+2]
+	}
+	switch {
+	case two == "&&":
+		p.pos += 2
+		p.tok = token{tokAnd, start, two}
+	case two == "||":
+		p.pos += 2
+		p.tok = token{tokOr, start, two}
+	case two == "==" || two == "!=" || two == "<=" || two == ">=" || two == "!~":
+		p.pos += 2
+		p.tok = token{tokOp, start, two}
+	case c == '<' || c == '>' || c == '~':
+		p.pos++
+		p.tok = token{tokOp, start, string(c)}
+	case c == '!':
+		p.pos++
+		p.tok = token{tokNot, start, "!"}
+	case c == '(':
+		p.pos++
+		p.tok = token{tokLparen, start, "("}
+	case c == ')':
+		p.pos++
+		p.tok = token{tokRparen, start, ")"}
+	case c == '"':
+		end := start + 1
+		for end < len(p.src) && p.src[end] != '"' {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			p.setError(start, "unterminated string")
+			return
+		}
+		s, err := strconv.Unquote(p.src[start : end+1])
+		if err != nil {
+			p.setError(start, "invalid string %s", p.src[start:end+1])
+			return
+		}
+		p.pos = end + 1
+		p.tok = token{tokString, start, s}
+	case c == '-' || c == '.' || '0' <= c && c <= '9':
+		end := start + 1
+		for end < len(p.src) && (p.src[end] == '.' || '0' <= p.src[end] && p.src[end] <= '9') {
+			end++
+		}
+		p.pos = end
+		p.tok = token{tokNumber, start, p.src[start:end]}
+	case isIdentByte(c):
+		end := start
+		for end < len(p.src) && (isIdentByte(p.src[end]) || '0' <= p.src[end] && p.src[end] <= '9' || p.src[end] == '.') {
+			end++
+		}
+		p.pos = end
+		p.tok = token{tokIdent, start, p.src[start:end]}
+	default:
+		p.setError(start, "unexpected character %q", c)
+	}
+}
+
+// setError records a lexing error and ends the token stream.
+func (p *queryParser) setError(pos int, format string, args ...any) {
+	if p.err == nil {
+		p.err = p.errorf(pos, format, args...).(*QueryError)
+	}
+	p.pos = len(p.src)
+	p.tok = token{kind: tokEOF, pos: pos}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// Parsing.
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOr {
+		p.next()
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &orNode{x, y}
+	}
+	return x, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokAnd {
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &andNode{x, y}
+	}
+	return x, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch p.tok.kind {
+	case tokNot:
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x}, nil
+	case tokLparen:
+		open := p.tok.pos
+		p.next()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		if p.tok.kind != tokRparen {
+			return nil, p.errorf(p.tok.pos, "expected ) to close ( at column %d, found %s", open+1, p.tok)
+		}
+		p.next()
+		return x, nil
+	case tokIdent:
+		return p.parseComparison()
+	}
+	return nil, p.errorf(p.tok.pos, "expected field, ! or (, found %s", p.tok)
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	name := p.tok
+	f := lookupField(name.text)
+	if f == nil {
+		return nil, p.errorf(name.pos, "unknown field %s", name.text)
+	}
+	p.level = max(p.level, f.level)
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokOp {
+		if f.kind == boolField {
+			return &cmpNode{field: f, op: "==", b: true}, nil
+		}
+		return nil, p.errorf(p.tok.pos, "expected comparison operator after %s, found %s", name.text, p.tok)
+	}
+	op := p.tok
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	val := p.tok
+	n := &cmpNode{field: f, op: op.text}
+	switch f.kind {
+	case stringField:
+		if op.text != "==" && op.text != "!=" && op.text != "~" && op.text != "!~" {
+			return nil, p.errorf(op.pos, "operator %s does not apply to string field %s", op.text, name.text)
+		}
+		if val.kind != tokString {
+			return nil, p.errorf(val.pos, "expected quoted string after %s, found %s", op.text, val)
+		}
+		if op.text == "~" || op.text == "!~" {
+			if _, err := path.Match(val.text, ""); err != nil {
+				return nil, p.errorf(val.pos, "invalid pattern %s", val)
+			}
+		}
+		n.str = val.text
+	case numberField:
+		if op.text == "~" || op.text == "!~" {
+			return nil, p.errorf(op.pos, "operator %s does not apply to number field %s", op.text, name.text)
+		}
+		if val.kind != tokNumber {
+			return nil, p.errorf(val.pos, "expected number after %s, found %s", op.text, val)
+		}
+		v, err := strconv.ParseFloat(val.text, 64)
+		if err != nil {
+			return nil, p.errorf(val.pos, "invalid number %s", val.text)
+		}
+		n.num = v
+	case boolField:
+		if op.text != "==" && op.text != "!=" {
+			return nil, p.errorf(op.pos, "operator %s does not apply to boolean field %s", op.text, name.text)
+		}
+		if val.kind != tokIdent || val.text != "true" && val.text != "false" {
+			return nil, p.errorf(val.pos, "expected true or false after %s, found %s", op.text, val)
+		}
+		n.b = val.text == "true"
+	}
+	p.next()
+	return n, nil
+}
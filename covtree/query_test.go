@@ -0,0 +1,142 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covtree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func queryTestPackages() []*PackageNode {
+	unit := func(line, count uint32) CoverableUnitNode {
+		return CoverableUnitNode{StartLine: line, EndLine: line, NumStmts: 1, Count: count, Covered: count > 0}
+	}
+	pkgs := []*PackageNode{
+		{
+			ImportPath: "example.com/mod/internal/cache",
+			Name:       "cache",
+			Metadata:   map[string]string{"GOOS": "linux"},
+			Functions: []*FunctionNode{
+				{Name: "Get", File: "cache.go", Units: []CoverableUnitNode{unit(3, 1), unit(4, 0), unit(5, 0)}},
+				{Name: "Put", File: "cache.go", Units: []CoverableUnitNode{unit(8, 2)}},
+			},
+		},
+		{
+			ImportPath: "example.com/mod/api",
+			Name:       "api",
+			Metadata:   map[string]string{"GOOS": "darwin"},
+			Functions: []*FunctionNode{
+				{Name: "Serve", File: "api.go", Units: []CoverableUnitNode{unit(10, 0), unit(11, 0)}},
+			},
+		},
+	}
+	for _, pkg := range pkgs {
+		calculatePackageCoverage(pkg)
+	}
+	return pkgs
+}
+
+// describe lists the nodes selected by a query.
+func describe(pkgs []*PackageNode, level QueryLevel) string {
+	var parts []string
+	for _, pkg := range pkgs {
+		if level == PackageLevel {
+			parts = append(parts, pkg.Name)
+			continue
+		}
+		for _, fn := range pkg.Functions {
+			if level == FunctionLevel {
+				parts = append(parts, fn.Name)
+				continue
+			}
+			for _, u := range fn.Units {
+				parts = append(parts, fmt.Sprintf("%s:%d", fn.Name, u.StartLine))
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestQuery(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		level QueryLevel
+		want  string
+	}{
+		{`pkg ~ "internal/*"`, PackageLevel, "cache"},
+		{`pkg ~ "mod/*" && pkg !~ "internal/*"`, PackageLevel, "api"},
+		{`label.GOOS == "linux" || pkg.coverage == 0`, PackageLevel, "cache api"},
+		{`label.missing != ""`, PackageLevel, ""},
+		{`func.coverage < 0.5 && func.lines >= 2`, FunctionLevel, "Get Serve"},
+		{`!(func == "Get") && label.GOOS == "linux"`, FunctionLevel, "Put"},
+		{`unit.covered && pkg.name == "cache"`, UnitLevel, "Get:3 Put:8"},
+		{`unit.covered == false && unit.line > 4`, UnitLevel, "Get:5 Serve:10 Serve:11"},
+		{`unit.count >= 2 || func.name == "Serve" && unit.line == 11`, UnitLevel, "Put:8 Serve:11"},
+	} {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if q.Level() != tt.level {
+			t.Errorf("%q: level %d, want %d", tt.query, q.Level(), tt.level)
+		}
+		if got := describe(q.Filter(queryTestPackages()), q.Level()); got != tt.want {
+			t.Errorf("%q selects %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryFilterCopies(t *testing.T) {
+	pkgs := queryTestPackages()
+	q, err := ParseQuery(`func == "Get" && unit.covered`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := q.Filter(pkgs)
+	if len(got) != 1 || got[0] == pkgs[0] || len(got[0].Functions[0].Units) != 1 {
+		t.Fatalf("Filter = %+v", got)
+	}
+	if got[0].CoveredLines != pkgs[0].CoveredLines || len(pkgs[0].Functions) != 2 || len(pkgs[0].Functions[0].Units) != 3 {
+		t.Errorf("Filter modified its input or the copy's totals")
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{``, 0, "expected field, ! or (, found end of query"},
+		{`pkg ~ "internal/*`, 6, "unterminated string"},
+		{`pkg == "a" @`, 11, "unexpected character '@'"},
+		{`fun.coverage < 0.5`, 0, "unknown field fun.coverage"},
+		{`func.coverage < "high"`, 16, `expected number after <, found "high"`},
+		{`pkg < "a"`, 4, "operator < does not apply to string field pkg"},
+		{`func.lines ~ "1*"`, 11, "operator ~ does not apply to number field func.lines"},
+		{`unit.covered == yes`, 16, `expected true or false after ==, found "yes"`},
+		{`pkg.coverage`, 12, "expected comparison operator after pkg.coverage, found end of query"},
+		{`(pkg == "a" || pkg == "b"`, 25, "expected ) to close ( at column 1, found end of query"},
+		{`pkg == "a" pkg == "b"`, 11, `unexpected "pkg"`},
+		{`pkg ~ "[a"`, 6, `invalid pattern "[a"`},
+		{`pkg == "a" &&`, 13, "expected field, ! or (, found end of query"},
+	} {
+		_, err := ParseQuery(tt.query)
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ParseQuery(%q) = %v, want *QueryError", tt.query, err)
+			continue
+		}
+		if qe.Pos != tt.pos || qe.Msg != tt.msg {
+			t.Errorf("ParseQuery(%q): error at %d: %s; want at %d: %s", tt.query, qe.Pos, qe.Msg, tt.pos, tt.msg)
+		}
+	}
+	if _, err := ParseQuery(`x == 1`); err == nil || err.Error() != "query: column 1: unknown field x" {
+		t.Errorf("error text = %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+package covtree
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileCoverage groups the functions of a package that live in a single
+// source file.
+type FileCoverage struct {
+	// Path is the source file path as recorded in the coverage meta-data,
+	// typically "<import path>/<file>.go".
+	Path string
+	// Package is the package the file belongs to
+	Package *PackageNode
+	// Functions are the functions declared in the file, in meta-data order
+	Functions []*FunctionNode
+	// TotalLines is the total number of executable lines in the file
+	TotalLines int
+	// CoveredLines is the number of lines that were executed
+	CoveredLines int
+	// CoverageRate is the percentage of lines covered (0.0 to 1.0)
+	CoverageRate float64
+}
+
+// Units returns all coverable units in the file.
+func (f *FileCoverage) Units() []CoverableUnitNode {
+	var units []CoverableUnitNode
+	for _, fn := range f.Functions {
+		units = append(units, fn.Units...)
+	}
+	return units
+}
+
+// Files returns the package's functions grouped by source file, sorted
+// by file path.
+func (pkg *PackageNode) Files() []*FileCoverage {
+	byPath := make(map[string]*FileCoverage)
+	var files []*FileCoverage
+	for _, fn := range pkg.Functions {
+		f := byPath[fn.File]
+		if f == nil {
+			f = &FileCoverage{Path: fn.File, Package: pkg}
+			byPath[fn.File] = f
+			files = append(files, f)
+		}
+		f.Functions = append(f.Functions, fn)
+		f.TotalLines += fn.TotalLines
+		f.CoveredLines += fn.CoveredLines
+	}
+	for _, f := range files {
+		if f.TotalLines > 0 {
+			f.CoverageRate = float64(f.CoveredLines) / float64(f.TotalLines)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// SourceNotFoundError is returned when the source of a file named in the
+// coverage meta-data cannot be located on disk.
+type SourceNotFoundError struct {
+	File string
+}
+
+func (e *SourceNotFoundError) Error() string {
+	return fmt.Sprintf("source not found for %s", e.File)
+}
+
+// SourceResolver maps source file paths recorded in coverage meta-data
+// back to files on disk. Paths are resolved, in order, as absolute paths,
+// relative to a registered module root, and finally through "go list"
+// when UseGoList is set.
+type SourceResolver struct {
+	// UseGoList enables looking up package directories with "go list".
+	UseGoList bool
+
+	mu      sync.Mutex
+	modules map[string]string // module path -> directory
+	pkgDirs map[string]string // import path -> directory, "" if unknown
+}
+
+// NewSourceResolver returns a SourceResolver with "go list" lookup enabled.
+func NewSourceResolver() *SourceResolver {
+	return &SourceResolver{UseGoList: true}
+}
+
+// AddModule registers dir as the root of the module with the given path.
+func (r *SourceResolver) AddModule(modulePath, dir string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.modules == nil {
+		r.modules = make(map[string]string)
+	}
+	r.modules[modulePath] = dir
+}
+
+// AddModuleRoot registers dir as a module root, reading the module path
+// from dir/go.mod.
+func (r *SourceResolver) AddModuleRoot(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return err
+	}
+	modulePath := modulePathFromGoMod(data)
+	if modulePath == "" {
+		return fmt.Errorf("no module directive in %s", filepath.Join(dir, "go.mod"))
+	}
+	r.AddModule(modulePath, dir)
+	return nil
+}
+
+// modulePathFromGoMod returns the module path declared in a go.mod file.
+func modulePathFromGoMod(data []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if rest, ok := strings.CutPrefix(line, "module"); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			rest = strings.TrimSpace(rest)
+			if i := strings.Index(rest, "//"); i >= 0 {
+				rest = strings.TrimSpace(rest[:i])
+			}
+			return strings.Trim(rest, `"`+"`")
+		}
+	}
+	return ""
+}
+
+// Resolve returns the on-disk location of file, which belongs to the
+// package with the given import path.
+func (r *SourceResolver) Resolve(importPath, file string) (string, error) {
+	if filepath.IsAbs(file) {
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+
+	r.mu.Lock()
+	var best, bestDir string
+	for mod, dir := range r.modules {
+		if (file == mod || strings.HasPrefix(file, mod+"/")) && len(mod) > len(best) {
+			best, bestDir = mod, dir
+		}
+	}
+	r.mu.Unlock()
+	if best != "" {
+		p := filepath.Join(bestDir, filepath.FromSlash(strings.TrimPrefix(file, best)))
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	if r.UseGoList && importPath != "" {
+		if dir := r.packageDir(importPath); dir != "" {
+			p := filepath.Join(dir, path.Base(filepath.ToSlash(file)))
+			if _, err := os.Stat(p); err == nil {
+				return p, nil
+			}
+		}
+	}
+	return "", &SourceNotFoundError{File: file}
+}
+
+// ReadSource reads the source of file, which belongs to the package with
+// the given import path.
+func (r *SourceResolver) ReadSource(importPath, file string) ([]byte, error) {
+	p, err := r.Resolve(importPath, file)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+// packageDir returns the directory of the package with the given import
+// path as reported by "go list", or "" if it cannot be determined.
+// Results are cached.
+func (r *SourceResolver) packageDir(importPath string) string {
+	r.mu.Lock()
+	dir, ok := r.pkgDirs[importPath]
+	r.mu.Unlock()
+	if ok {
+		return dir
+	}
+
+	out, err := exec.Command("go", "list", "-e", "-f", "{{.Dir}}", importPath).Output()
+	if err == nil {
+		dir = strings.TrimSpace(string(out))
+	}
+
+	r.mu.Lock()
+	if r.pkgDirs == nil {
+		r.pkgDirs = make(map[string]string)
+	}
+	r.pkgDirs[importPath] = dir
+	r.mu.Unlock()
+	return dir
+}
+
+// SourceSegment is a contiguous run of source text sharing the same
+// coverage state.
+type SourceSegment struct {
+	// Text is the source text of the segment
+	Text string
+	// Instrumented reports whether the segment lies inside a coverable unit
+	Instrumented bool
+	// Count is the execution count of the innermost enclosing unit
+	Count uint32
+	// Covered indicates whether the enclosing unit was executed
+	Covered bool
+}
+
+// AnnotateSource splits src into segments according to the line and
+// column ranges of units. Where units nest, as with function literals,
+// the innermost unit determines the segment's coverage. Columns are
+// 1-based byte offsets within a line, as recorded by the compiler.
+func AnnotateSource(src []byte, units []CoverableUnitNode) []SourceSegment {
+	lineStarts := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	// Columns past the end of a line, as used by units imported from
+	// line-based formats, are clamped to the line's newline.
+	offset := func(line, col uint32) int {
+		if line == 0 || int(line) > len(lineStarts) {
+			return len(src)
+		}
+		end := len(src)
+		if int(line) < len(lineStarts) {
+			end = lineStarts[line] - 1
+		}
+		off := lineStarts[line-1] + int(col) - 1
+		return min(max(off, 0), end)
+	}
+
+	type boundary struct {
+		off   int
+		start bool
+		unit  int
+	}
+	var bounds []boundary
+	for i, u := range units {
+		st, en := offset(u.StartLine, u.StartCol), offset(u.EndLine, u.EndCol)
+		if st >= en {
+			continue
+		}
+		bounds = append(bounds, boundary{st, true, i}, boundary{en, false, i})
+	}
+	sort.SliceStable(bounds, func(i, j int) bool {
+		if bounds[i].off != bounds[j].off {
+			return bounds[i].off < bounds[j].off
+		}
+		// Close units before opening the next one at the same offset.
+		return !bounds[i].start && bounds[j].start
+	})
+
+	var segs []SourceSegment
+	var active []int
+	emit := func(from, to int) {
+		if from >= to {
+			return
+		}
+		seg := SourceSegment{Text: string(src[from:to])}
+		if len(active) > 0 {
+			u := units[active[len(active)-1]]
+			seg.Instrumented = true
+			seg.Count = u.Count
+			seg.Covered = u.Covered
+		}
+		if n := len(segs); n > 0 && segs[n-1].Instrumented == seg.Instrumented &&
+			segs[n-1].Count == seg.Count && segs[n-1].Covered == seg.Covered {
+			segs[n-1].Text += seg.Text
+			return
+		}
+		segs = append(segs, seg)
+	}
+
+	pos := 0
+	for _, b := range bounds {
+		emit(pos, b.off)
+		pos = max(pos, b.off)
+		if b.start {
+			active = append(active, b.unit)
+			continue
+		}
+		for i := len(active) - 1; i >= 0; i-- {
+			if active[i] == b.unit {
+				active = append(active[:i], active[i+1:]...)
+				break
+			}
+		}
+	}
+	emit(pos, len(src))
+	return segs
+}
+
+// HeatLevel maps an execution count to an intensity between 1 and 10 on
+// a logarithmic scale relative to maxCount. Zero counts map to 0.
+func HeatLevel(count, maxCount uint32) int {
+	if count == 0 {
+		return 0
+	}
+	if maxCount <= 1 || count >= maxCount {
+		return 10
+	}
+	level := 1 + int(9*math.Log(float64(count))/math.Log(float64(maxCount)))
+	return min(level, 10)
+}
+
+// MaxCount returns the largest execution count among units.
+func MaxCount(units []CoverableUnitNode) uint32 {
+	var m uint32
+	for _, u := range units {
+		m = max(m, u.Count)
+	}
+	return m
+}
+
+// SourceLine is a single line of annotated source.
+type SourceLine struct {
+	// Number is the 1-based line number
+	Number int
+	// Segments are the line's segments, without the trailing newline
+	Segments []SourceSegment
+	// Instrumented reports whether any part of the line is coverable
+	Instrumented bool
+	// Count is the highest execution count of any unit on the line
+	Count uint32
+	// Covered indicates whether any unit on the line was executed
+	Covered bool
+	// Partial indicates the line has both covered and uncovered units
+	Partial bool
+}
+
+// AnnotateLines is like AnnotateSource but splits the result into lines
+// and summarizes the hit count of each line.
+func AnnotateLines(src []byte, units []CoverableUnitNode) []SourceLine {
+	lines := []SourceLine{{Number: 1}}
+	var uncovered bool
+	finish := func(l *SourceLine) {
+		l.Partial = l.Covered && uncovered
+		uncovered = false
+	}
+	for _, seg := range AnnotateSource(src, units) {
+		parts := strings.Split(seg.Text, "\n")
+		for i, text := range parts {
+			if i > 0 {
+				finish(&lines[len(lines)-1])
+				lines = append(lines, SourceLine{Number: len(lines) + 1})
+			}
+			if text == "" {
+				continue
+			}
+			l := &lines[len(lines)-1]
+			part := seg
+			part.Text = text
+			l.Segments = append(l.Segments, part)
+			if !seg.Instrumented || strings.TrimSpace(text) == "" {
+				continue
+			}
+			l.Instrumented = true
+			l.Count = max(l.Count, seg.Count)
+			if seg.Covered {
+				l.Covered = true
+			} else {
+				uncovered = true
+			}
+		}
+	}
+	finish(&lines[len(lines)-1])
+	if n := len(lines); n > 1 && len(lines[n-1].Segments) == 0 && len(src) > 0 && src[len(src)-1] == '\n' {
+		lines = lines[:n-1]
+	}
+	return lines
+}
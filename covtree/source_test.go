@@ -0,0 +1,143 @@
+package covtree
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const annotateSrc = `package a
+
+func Add(x, y int) int {
+	if x < 0 {
+		return 0
+	}
+	return x + y
+}
+`
+
+func TestAnnotateLines(t *testing.T) {
+	units := []CoverableUnitNode{
+		{StartLine: 3, StartCol: 24, EndLine: 4, EndCol: 11, Count: 5, Covered: true},
+		{StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3, Count: 0},
+		{StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 14, Count: 5, Covered: true},
+	}
+	lines := AnnotateLines([]byte(annotateSrc), units)
+	if len(lines) != 8 {
+		t.Fatalf("got %d lines, want 8", len(lines))
+	}
+
+	var rebuilt []string
+	for _, l := range lines {
+		var sb strings.Builder
+		for _, seg := range l.Segments {
+			sb.WriteString(seg.Text)
+		}
+		rebuilt = append(rebuilt, sb.String())
+	}
+	if got := strings.Join(rebuilt, "\n") + "\n"; got != annotateSrc {
+		t.Errorf("segments do not reproduce the source:\n%s", got)
+	}
+
+	tests := []struct {
+		line         int
+		instrumented bool
+		covered      bool
+		partial      bool
+		count        uint32
+	}{
+		{1, false, false, false, 0},
+		{3, true, true, false, 5},
+		{4, true, true, true, 5},
+		{5, true, false, false, 0},
+		{7, true, true, false, 5},
+	}
+	for _, tt := range tests {
+		l := lines[tt.line-1]
+		if l.Number != tt.line || l.Instrumented != tt.instrumented || l.Covered != tt.covered ||
+			l.Partial != tt.partial || l.Count != tt.count {
+			t.Errorf("line %d: got %+v", tt.line, l)
+		}
+	}
+}
+
+func TestAnnotateSourceNested(t *testing.T) {
+	src := []byte("0123456789")
+	segs := AnnotateSource(src, []CoverableUnitNode{
+		{StartLine: 1, StartCol: 1, EndLine: 1, EndCol: 11, Count: 1, Covered: true},
+		{StartLine: 1, StartCol: 4, EndLine: 1, EndCol: 7},
+	})
+	want := []string{"012", "345", "6789"}
+	if len(segs) != len(want) {
+		t.Fatalf("got %d segments, want %d: %+v", len(segs), len(want), segs)
+	}
+	for i, seg := range segs {
+		if seg.Text != want[i] {
+			t.Errorf("segment %d: got %q, want %q", i, seg.Text, want[i])
+		}
+	}
+	if !segs[0].Covered || segs[1].Covered || !segs[2].Covered {
+		t.Errorf("inner unit did not override outer coverage: %+v", segs)
+	}
+}
+
+func TestAnnotateSourceLineUnits(t *testing.T) {
+	src := []byte("a := 1\nb := 2\n")
+	segs := AnnotateSource(src, []CoverableUnitNode{
+		{StartLine: 1, StartCol: 1, EndLine: 1, EndCol: 1 << 16, Count: 1, Covered: true},
+	})
+	if len(segs) != 2 || segs[0].Text != "a := 1" || !segs[0].Covered || segs[1].Instrumented {
+		t.Errorf("unit past end of line spilled onto the next: %+v", segs)
+	}
+}
+
+func TestHeatLevel(t *testing.T) {
+	tests := []struct {
+		count, max uint32
+		want       int
+	}{
+		{0, 100, 0},
+		{1, 1, 10},
+		{1, 100, 1},
+		{10, 100, 5},
+		{100, 100, 10},
+	}
+	for _, tt := range tests {
+		if got := HeatLevel(tt.count, tt.max); got != tt.want {
+			t.Errorf("HeatLevel(%d, %d) = %d, want %d", tt.count, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSourceResolver(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/mod // comment\n\ngo 1.23\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "a.go"), []byte(annotateSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &SourceResolver{}
+	if err := r.AddModuleRoot(dir); err != nil {
+		t.Fatal(err)
+	}
+	src, err := r.ReadSource("example.com/mod/a", "example.com/mod/a/a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != annotateSrc {
+		t.Errorf("read wrong source: %q", src)
+	}
+
+	_, err = r.ReadSource("example.com/other", "example.com/other/b.go")
+	var nf *SourceNotFoundError
+	if !errors.As(err, &nf) || nf.File != "example.com/other/b.go" {
+		t.Errorf("got error %v, want SourceNotFoundError", err)
+	}
+}
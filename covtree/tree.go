@@ -5,10 +5,13 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 
+	"github.com/tmc/covutil"
 	"github.com/tmc/covutil/internal/coverage"
+	"github.com/tmc/covutil/internal/coverage/cmerge"
 	"github.com/tmc/covutil/internal/coverage/decodecounter"
 	"github.com/tmc/covutil/internal/coverage/decodemeta"
 	"github.com/tmc/covutil/internal/coverage/pods"
@@ -64,6 +67,9 @@ type PackageNode struct {
 	CoverageRate float64
 	// MetaFile is the path to the coverage metadata file
 	MetaFile string
+	// CounterMode is the counter mode the package was built with:
+	// "set", "count" or "atomic".
+	CounterMode string
 	// Metadata contains extended metadata for this package
 	// Common keys: GoTestName, GoTestPackage, TestType, TestRunID
 	Metadata map[string]string
@@ -99,6 +105,8 @@ type CoverableUnitNode struct {
 	EndLine uint32
 	// EndCol is the ending column number
 	EndCol uint32
+	// NumStmts is the number of statements in this unit
+	NumStmts uint32
 	// Count is the number of times this unit was executed
 	Count uint32
 	// Covered indicates whether this unit was executed at least once
@@ -320,30 +328,89 @@ func (ct *CoverageTree) containsCoverageFilesFS(fsys fs.FS, dir string) bool {
 }
 
 func (ct *CoverageTree) loadPod(pod pods.Pod) error {
-	file, err := os.Open(pod.MetaFile)
+	pkgs, err := ct.decodePod(pod)
 	if err != nil {
 		return err
 	}
+	for _, pkg := range pkgs {
+		ct.Packages[pkg.ImportPath] = pkg
+		ct.addToDirectoryTree(pkg)
+	}
+	return nil
+}
+
+// podMetadataFiles returns the pod metadata files in the directories of
+// pod's files.
+func podMetadataFiles(pod pods.Pod) []string {
+	dirs := []string{filepath.Dir(pod.MetaFile)}
+	for _, name := range pod.CounterDataFiles {
+		dirs = append(dirs, filepath.Dir(name))
+	}
+	slices.Sort(dirs)
+	var files []string
+	for _, dir := range slices.Compact(dirs) {
+		name := filepath.Join(dir, covutil.PodMetadataFile)
+		if _, err := os.Stat(name); err == nil {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// podLabels returns the labels of pod's metadata files.
+func podLabels(pod pods.Pod) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, name := range podMetadataFiles(pod) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		md, err := covutil.LoadPodMetadata(f, name)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range md.Labels {
+			labels[k] = v
+		}
+	}
+	return labels, nil
+}
+
+// decodePod reads the packages of a pod, with the tree's metadata and the
+// labels of the pod's metadata files.
+func (ct *CoverageTree) decodePod(pod pods.Pod) ([]*PackageNode, error) {
+	file, err := os.Open(pod.MetaFile)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
 	metaFileReader, err := decodemeta.NewCoverageMetaFileReader(file, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	labels, err := podLabels(pod)
+	if err != nil {
+		return nil, err
 	}
 
+	var pkgs []*PackageNode
 	for pkgIdx := uint32(0); pkgIdx < uint32(metaFileReader.NumPackages()); pkgIdx++ {
 		metaData, _, err := metaFileReader.GetPackageDecoder(pkgIdx, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		pkg := &PackageNode{
-			ImportPath: metaData.PackagePath(),
-			Name:       metaData.PackageName(),
-			ModulePath: metaData.ModulePath(),
-			Functions:  make([]*FunctionNode, 0, metaData.NumFuncs()),
-			MetaFile:   pod.MetaFile,
-			Metadata:   make(map[string]string),
+			ImportPath:  metaData.PackagePath(),
+			Name:        metaData.PackageName(),
+			ModulePath:  metaData.ModulePath(),
+			Functions:   make([]*FunctionNode, 0, metaData.NumFuncs()),
+			MetaFile:    pod.MetaFile,
+			CounterMode: metaFileReader.CounterMode().String(),
+			Metadata:    make(map[string]string),
 		}
 
 		// Copy tree-level metadata to package
@@ -351,14 +418,26 @@ func (ct *CoverageTree) loadPod(pod pods.Pod) error {
 			pkg.Metadata[k] = v
 		}
 
+		// Labels written with the pod take precedence over the tree's.
+		for k, v := range labels {
+			pkg.Metadata[k] = v
+		}
+
 		// Add module-specific metadata if not already set
 		if pkg.Metadata["GoModuleName"] == "" && pkg.ModulePath != "" {
 			pkg.Metadata["GoModuleName"] = pkg.ModulePath
 		}
 
+		// Counters from several segments or files are merged the way
+		// "go tool covdata merge" does: or-ed in set mode and summed
+		// otherwise.
+		merger := &cmerge.Merger{}
+		if err := merger.SetModeAndGranularity(pod.MetaFile, metaFileReader.CounterMode(), metaFileReader.CounterGranularity()); err != nil {
+			return nil, err
+		}
 		counters := make(map[uint32]map[uint32][]uint32)
 		for _, counterFile := range pod.CounterDataFiles {
-			if err := ct.loadCounterFile(counterFile, counters); err != nil {
+			if err := ct.loadCounterFile(counterFile, merger, counters); err != nil {
 				// Skip counter loading if it fails - we can still provide function info
 				// This is a workaround for "short read on string table" issue
 				break
@@ -368,7 +447,7 @@ func (ct *CoverageTree) loadPod(pod pods.Pod) error {
 		for i := uint32(0); i < metaData.NumFuncs(); i++ {
 			var funcDesc coverage.FuncDesc
 			if err := metaData.ReadFunc(i, &funcDesc); err != nil {
-				return err
+				return nil, err
 			}
 
 			fn := &FunctionNode{
@@ -389,6 +468,7 @@ func (ct *CoverageTree) loadPod(pod pods.Pod) error {
 					StartCol:  unit.StCol,
 					EndLine:   unit.EnLine,
 					EndCol:    unit.EnCol,
+					NumStmts:  unit.NxStmts,
 					Count:     count,
 					Covered:   count > 0,
 				}
@@ -397,13 +477,12 @@ func (ct *CoverageTree) loadPod(pod pods.Pod) error {
 			pkg.Functions = append(pkg.Functions, fn)
 		}
 
-		ct.Packages[pkg.ImportPath] = pkg
-		ct.addToDirectoryTree(pkg)
+		pkgs = append(pkgs, pkg)
 	}
-	return nil
+	return pkgs, nil
 }
 
-func (ct *CoverageTree) loadCounterFile(filename string, counters map[uint32]map[uint32][]uint32) error {
+func (ct *CoverageTree) loadCounterFile(filename string, merger *cmerge.Merger, counters map[uint32]map[uint32][]uint32) error {
 	file := openFile(filename)
 	if closer, ok := file.(io.Closer); ok {
 		defer closer.Close()
@@ -414,15 +493,16 @@ func (ct *CoverageTree) loadCounterFile(filename string, counters map[uint32]map
 		return err
 	}
 
-	for {
-		hasNext, err := reader.BeginNextSegment()
-		if err != nil {
-			return err
-		}
-		if !hasNext {
-			break
+	// The reader is positioned at the first segment on creation;
+	// BeginNextSegment advances to the ones that follow.
+	for seg := uint32(0); seg < reader.NumSegments(); seg++ {
+		if seg > 0 {
+			if ok, err := reader.BeginNextSegment(); err != nil {
+				return err
+			} else if !ok {
+				break
+			}
 		}
-
 		for {
 			var payload decodecounter.FuncPayload
 			hasFunc, err := reader.NextFunc(&payload)
@@ -436,7 +516,14 @@ func (ct *CoverageTree) loadCounterFile(filename string, counters map[uint32]map
 			if counters[payload.PkgIdx] == nil {
 				counters[payload.PkgIdx] = make(map[uint32][]uint32)
 			}
-			counters[payload.PkgIdx][payload.FuncIdx] = payload.Counters
+			existing := counters[payload.PkgIdx][payload.FuncIdx]
+			if len(existing) != len(payload.Counters) {
+				counters[payload.PkgIdx][payload.FuncIdx] = append([]uint32(nil), payload.Counters...)
+				continue
+			}
+			if err, _ := merger.MergeCounters(existing, payload.Counters); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -458,30 +545,42 @@ func (ct *CoverageTree) addToDirectoryTree(pkg *PackageNode) {
 		current = current.Children[part]
 	}
 
+	// A package loaded again from another pod replaces the earlier node,
+	// as it does in ct.Packages.
+	for i, p := range current.Packages {
+		if p.ImportPath == pkg.ImportPath {
+			current.Packages[i] = pkg
+			return
+		}
+	}
 	current.Packages = append(current.Packages, pkg)
 }
 
 func (ct *CoverageTree) calculateCoverage() {
 	for _, pkg := range ct.Packages {
-		for _, fn := range pkg.Functions {
-			for _, unit := range fn.Units {
-				fn.TotalLines += int(unit.EndLine - unit.StartLine + 1)
-				if unit.Covered {
-					fn.CoveredLines += int(unit.EndLine - unit.StartLine + 1)
-				}
-			}
-			if fn.TotalLines > 0 {
-				fn.CoverageRate = float64(fn.CoveredLines) / float64(fn.TotalLines)
+		calculatePackageCoverage(pkg)
+	}
+
+	ct.calculateDirectoryCoverage(ct.Root)
+}
+
+func calculatePackageCoverage(pkg *PackageNode) {
+	for _, fn := range pkg.Functions {
+		for _, unit := range fn.Units {
+			fn.TotalLines += int(unit.EndLine - unit.StartLine + 1)
+			if unit.Covered {
+				fn.CoveredLines += int(unit.EndLine - unit.StartLine + 1)
 			}
-			pkg.TotalLines += fn.TotalLines
-			pkg.CoveredLines += fn.CoveredLines
 		}
-		if pkg.TotalLines > 0 {
-			pkg.CoverageRate = float64(pkg.CoveredLines) / float64(pkg.TotalLines)
+		if fn.TotalLines > 0 {
+			fn.CoverageRate = float64(fn.CoveredLines) / float64(fn.TotalLines)
 		}
+		pkg.TotalLines += fn.TotalLines
+		pkg.CoveredLines += fn.CoveredLines
+	}
+	if pkg.TotalLines > 0 {
+		pkg.CoverageRate = float64(pkg.CoveredLines) / float64(pkg.TotalLines)
 	}
-
-	ct.calculateDirectoryCoverage(ct.Root)
 }
 
 func (ct *CoverageTree) calculateDirectoryCoverage(dir *DirectoryNode) {
@@ -561,6 +660,22 @@ func (ct *CoverageTree) GetPackage(importPath string) *PackageNode {
 	return ct.Packages[importPath]
 }
 
+// GetDirectory returns the directory node at the slash-separated path,
+// or nil if the tree has no packages under it.
+func (ct *CoverageTree) GetDirectory(dir string) *DirectoryNode {
+	node := ct.Root
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		node = node.Children[part]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
 func (ct *CoverageTree) GetPackageNames() []string {
 	var names []string
 	for name := range ct.Packages {
@@ -2,7 +2,11 @@ package covtree
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/tmc/covutil"
 )
 
 func TestLoadFromFS(t *testing.T) {
@@ -53,3 +57,92 @@ func TestLoadFromFS(t *testing.T) {
 		}
 	})
 }
+
+func TestLoadMergesCounterFiles(t *testing.T) {
+	for _, tt := range []struct {
+		mode covutil.CounterMode
+		want []uint32
+	}{
+		{covutil.ModeSet, []uint32{1, 0, 1}},
+		{covutil.ModeCount, []uint32{4, 0, 2}},
+	} {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			p := &covutil.Profile{
+				Meta: covutil.MetaFile{
+					Mode: tt.mode,
+					Packages: []covutil.PackageMeta{{
+						Path: "example.com/mod/a",
+						Name: "a",
+						Functions: []covutil.FuncDesc{{
+							FuncName: "F",
+							SrcFile:  "example.com/mod/a/a.go",
+							Units: []covutil.CoverableUnit{
+								{StartLine: 3, StartCol: 1, EndLine: 3, EndCol: 9, NumStmt: 1},
+								{StartLine: 4, StartCol: 1, EndLine: 4, EndCol: 9, NumStmt: 1},
+								{StartLine: 5, StartCol: 1, EndLine: 5, EndCol: 9, NumStmt: 1},
+							},
+						}},
+					}},
+				},
+			}
+			// Two runs of the same binary leave two counter files.
+			dir := t.TempDir()
+			for _, counts := range [][]uint32{{3, 0, 1}, {1, 0, 1}} {
+				p.Counters = map[covutil.PkgFuncKey][]uint32{{PkgPath: "example.com/mod/a", FuncName: "F"}: counts}
+				if err := covutil.WriteProfileToDirectory(dir, p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n, _ := filepath.Glob(filepath.Join(dir, "covcounters.*")); len(n) != 2 {
+				t.Fatalf("got %d counter files, want 2", len(n))
+			}
+
+			tree := NewCoverageTree()
+			if err := tree.LoadFromDirectory(dir); err != nil {
+				t.Fatal(err)
+			}
+			var got []uint32
+			for _, u := range tree.GetPackage("example.com/mod/a").Functions[0].Units {
+				got = append(got, u.Count)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("counts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPodMetadata(t *testing.T) {
+	dir := t.TempDir()
+	pod := &covutil.Pod{
+		ID: "unit",
+		Profile: &covutil.Profile{
+			Meta: covutil.MetaFile{
+				Mode: covutil.ModeCount,
+				Packages: []covutil.PackageMeta{{
+					Path: "example.com/mod/a",
+					Name: "a",
+					Functions: []covutil.FuncDesc{{
+						FuncName: "F",
+						SrcFile:  "example.com/mod/a/a.go",
+						Units:    []covutil.CoverableUnit{{StartLine: 3, StartCol: 1, EndLine: 3, EndCol: 9, NumStmt: 1}},
+					}},
+				}},
+			},
+			Counters: map[covutil.PkgFuncKey][]uint32{{PkgPath: "example.com/mod/a", FuncName: "F"}: {1}},
+		},
+		Labels: map[string]string{"TestType": "integration"},
+	}
+	if err := covutil.WritePodToDirectory(dir, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := NewCoverageTree()
+	tree.SetMetadata("TestType", "unit")
+	if err := tree.LoadFromNestedRepository(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.GetPackage("example.com/mod/a").Metadata["TestType"]; got != "integration" {
+		t.Errorf("TestType = %q, want the pod's label", got)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covtree
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/covutil/internal/coverage/pods"
+)
+
+// DefaultWatchInterval is the polling interval of a Watcher created with a
+// zero interval.
+const DefaultWatchInterval = 500 * time.Millisecond
+
+// A Watcher keeps a coverage tree up to date with the coverage data under
+// a directory, such as the GOCOVERDIR of tests that are still running.
+//
+// The watcher polls the directory rather than relying on file system
+// notifications, which do not reach into new subdirectories and are not
+// available everywhere. Changes are applied once the coverage files have
+// not changed for a whole interval, so that files still being written are
+// not read, and only the pods whose meta-data or counter files changed
+// are decoded again.
+type Watcher struct {
+	dir      string
+	interval time.Duration
+
+	mu      sync.Mutex
+	tree    *CoverageTree
+	pods    map[string]*watchedPod // by meta-data file
+	applied string                 // signature of the files in tree
+	subs    map[chan *Update]bool
+}
+
+// watchedPod holds the packages decoded from a pod.
+type watchedPod struct {
+	sig      string
+	packages []*PackageNode
+}
+
+// scannedPod is a pod found by a scan of the watched directory.
+type scannedPod struct {
+	pod pods.Pod
+	sig string // names, sizes and modification times of its files
+}
+
+// Update describes a change of the watched coverage data.
+type Update struct {
+	Time time.Time
+	// Summary and Previous summarize the tree after and before the change.
+	Summary  CoverageSummary
+	Previous CoverageSummary
+	// Packages lists the packages whose coverage changed, including
+	// those added and removed, sorted by import path.
+	Packages []PackageUpdate
+	// Reloaded is the number of pods decoded again.
+	Reloaded int
+	// Tree is the tree after the change.
+	Tree *CoverageTree `json:"-"`
+}
+
+// PackageUpdate is the coverage of a package after a change.
+type PackageUpdate struct {
+	ImportPath   string
+	TotalLines   int
+	CoveredLines int
+	CoverageRate float64
+	Removed      bool `json:",omitempty"`
+}
+
+// NewWatcher returns a watcher of the coverage data under dir, polling
+// every interval, or DefaultWatchInterval if it is zero. The data found
+// at the time of the call is loaded immediately; the directory need not
+// hold any yet.
+func NewWatcher(dir string, interval time.Duration) (*Watcher, error) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	w := &Watcher{
+		dir:      dir,
+		interval: interval,
+		tree:     NewCoverageTree(),
+		subs:     make(map[chan *Update]bool),
+	}
+	scanned, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	w.apply(scanned)
+	return w, nil
+}
+
+// Tree returns the current coverage tree. Trees are replaced rather than
+// modified, so the result can be used while the watcher runs.
+func (w *Watcher) Tree() *CoverageTree {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.tree
+}
+
+// Run polls the directory and applies changes until ctx is done.
+// Errors scanning the directory, such as when it is being removed, are
+// retried at the next poll.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	w.mu.Lock()
+	last := w.applied
+	w.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		scanned, err := w.scan()
+		if err != nil {
+			continue
+		}
+		sig := signature(scanned)
+		if sig != last {
+			// Still changing: wait until it settles.
+			last = sig
+			continue
+		}
+		w.mu.Lock()
+		changed := sig != w.applied
+		w.mu.Unlock()
+		if changed {
+			w.apply(scanned)
+		}
+	}
+}
+
+// Reload scans the directory and applies any changes immediately,
+// without waiting for them to settle. It returns nil if nothing changed.
+func (w *Watcher) Reload() (*Update, error) {
+	scanned, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	changed := signature(scanned) != w.applied
+	w.mu.Unlock()
+	if !changed {
+		return nil, nil
+	}
+	return w.apply(scanned), nil
+}
+
+// Subscribe returns a channel receiving the updates applied from now on,
+// and a function that ends the subscription. A subscriber that falls
+// behind receives only the latest update.
+func (w *Watcher) Subscribe() (<-chan *Update, func()) {
+	ch := make(chan *Update, 1)
+	w.mu.Lock()
+	w.subs[ch] = true
+	w.mu.Unlock()
+	return ch, func() {
+		w.mu.Lock()
+		delete(w.subs, ch)
+		w.mu.Unlock()
+	}
+}
+
+// ServeEvents streams updates to the client as server-sent events named
+// "update", holding an Update in JSON. The first event is sent on
+// connection and holds the current summary, with no packages.
+func (w *Watcher) ServeEvents(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	updates, cancel := w.Subscribe()
+	defer cancel()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	summary := w.Tree().Summary()
+	u := &Update{Time: time.Now(), Summary: summary, Previous: summary}
+	for {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(rw, "event: update\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case u = <-updates:
+		}
+	}
+}
+
+// scan finds the pods under the watched directory, sorted by meta-data
+// file so that packages found in several pods are taken from the same
+// one as by LoadFromNestedRepository.
+func (w *Watcher) scan() ([]scannedPod, error) {
+	dirs, err := ScanForCoverageDirectories(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var scanned []scannedPod
+	for _, dir := range dirs {
+		dirPods, err := pods.CollectPods([]string{dir}, true)
+		if err != nil {
+			continue
+		}
+		for _, pod := range dirPods {
+			var sig strings.Builder
+			names := append([]string{pod.MetaFile}, pod.CounterDataFiles...)
+			for _, name := range append(names, podMetadataFiles(pod)...) {
+				info, err := os.Stat(name)
+				if err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(&sig, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+			}
+			scanned = append(scanned, scannedPod{pod: pod, sig: sig.String()})
+		}
+	}
+	sort.Slice(scanned, func(i, j int) bool { return scanned[i].pod.MetaFile < scanned[j].pod.MetaFile })
+	return scanned, nil
+}
+
+func signature(scanned []scannedPod) string {
+	var sig strings.Builder
+	for _, sp := range scanned {
+		sig.WriteString(sp.sig)
+	}
+	return sig.String()
+}
+
+// apply replaces the tree with one built from scanned, decoding only the
+// pods that changed, and notifies subscribers.
+func (w *Watcher) apply(scanned []scannedPod) *Update {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tree := NewCoverageTree()
+	watched := make(map[string]*watchedPod, len(scanned))
+	reloaded := 0
+	for _, sp := range scanned {
+		wp := w.pods[sp.pod.MetaFile]
+		if wp == nil || wp.sig != sp.sig {
+			pkgs, err := tree.decodePod(sp.pod)
+			if err != nil {
+				continue
+			}
+			for _, pkg := range pkgs {
+				calculatePackageCoverage(pkg)
+			}
+			wp = &watchedPod{sig: sp.sig, packages: pkgs}
+			reloaded++
+		}
+		watched[sp.pod.MetaFile] = wp
+		for _, pkg := range wp.packages {
+			tree.Packages[pkg.ImportPath] = pkg
+			tree.addToDirectoryTree(pkg)
+		}
+	}
+	tree.calculateDirectoryCoverage(tree.Root)
+
+	u := &Update{
+		Time:     time.Now(),
+		Summary:  tree.Summary(),
+		Previous: w.tree.Summary(),
+		Packages: packageUpdates(w.tree, tree),
+		Reloaded: reloaded,
+		Tree:     tree,
+	}
+	w.tree, w.pods, w.applied = tree, watched, signature(scanned)
+	for ch := range w.subs {
+		// Replace an update the subscriber has not received yet.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- u
+	}
+	return u
+}
+
+// packageUpdates returns the packages whose coverage differs between
+// old and new.
+func packageUpdates(old, new *CoverageTree) []PackageUpdate {
+	var updates []PackageUpdate
+	for path, pkg := range new.Packages {
+		prev := old.Packages[path]
+		if prev != nil && prev.TotalLines == pkg.TotalLines && prev.CoveredLines == pkg.CoveredLines {
+			continue
+		}
+		updates = append(updates, PackageUpdate{
+			ImportPath:   path,
+			TotalLines:   pkg.TotalLines,
+			CoveredLines: pkg.CoveredLines,
+			CoverageRate: pkg.CoverageRate,
+		})
+	}
+	for path := range old.Packages {
+		if new.Packages[path] == nil {
+			updates = append(updates, PackageUpdate{ImportPath: path, Removed: true})
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].ImportPath < updates[j].ImportPath })
+	return updates
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covtree
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/covutil"
+)
+
+// writeWatchProfile writes a counter file for package pkg, whose function
+// F has two units, to dir.
+func writeWatchProfile(t *testing.T, dir, pkg string, counts ...uint32) {
+	t.Helper()
+	p := &covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode: covutil.ModeCount,
+			Packages: []covutil.PackageMeta{{
+				Path: "example.com/mod/" + pkg,
+				Name: pkg,
+				Functions: []covutil.FuncDesc{{
+					FuncName: "F",
+					SrcFile:  "example.com/mod/" + pkg + "/" + pkg + ".go",
+					Units: []covutil.CoverableUnit{
+						{StartLine: 3, StartCol: 1, EndLine: 3, EndCol: 9, NumStmt: 1},
+						{StartLine: 4, StartCol: 1, EndLine: 4, EndCol: 9, NumStmt: 1},
+					},
+				}},
+			}},
+		},
+		Counters: map[covutil.PkgFuncKey][]uint32{{PkgPath: "example.com/mod/" + pkg, FuncName: "F"}: counts},
+	}
+	if err := covutil.WriteProfileToDirectory(dir, p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	dir := t.TempDir()
+	writeWatchProfile(t, filepath.Join(dir, "a"), "a", 1, 0)
+
+	w, err := NewWatcher(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := w.Tree().Summary(); s.TotalPackages != 1 || s.CoveredLines != 1 {
+		t.Fatalf("initial summary = %+v", s)
+	}
+	updates, cancel := w.Subscribe()
+	defer cancel()
+
+	// Another run of a's binary, and a new binary.
+	writeWatchProfile(t, filepath.Join(dir, "a"), "a", 0, 1)
+	writeWatchProfile(t, filepath.Join(dir, "b"), "b", 0, 0)
+	u, err := w.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u == nil || u.Reloaded != 2 || u.Summary.CoveredLines != 2 || u.Previous.CoveredLines != 1 {
+		t.Fatalf("update = %+v", u)
+	}
+	var paths []string
+	for _, p := range u.Packages {
+		paths = append(paths, p.ImportPath)
+	}
+	if got := strings.Join(paths, " "); got != "example.com/mod/a example.com/mod/b" {
+		t.Errorf("updated packages = %s", got)
+	}
+	if got := <-updates; got != u {
+		t.Errorf("subscriber received %+v, want %+v", got, u)
+	}
+
+	if u, err := w.Reload(); u != nil || err != nil {
+		t.Errorf("Reload without changes = %+v, %v; want nil", u, err)
+	}
+
+	// Only the changed pod is decoded again.
+	a := w.Tree().GetPackage("example.com/mod/a")
+	writeWatchProfile(t, filepath.Join(dir, "b"), "b", 1, 1)
+	if u, err = w.Reload(); err != nil || u == nil {
+		t.Fatalf("Reload = %+v, %v", u, err)
+	}
+	if u.Reloaded != 1 || len(u.Packages) != 1 || u.Packages[0].CoveredLines != 2 {
+		t.Errorf("update = %+v", u)
+	}
+	if w.Tree().GetPackage("example.com/mod/a") != a {
+		t.Errorf("unchanged package was decoded again")
+	}
+	if s := w.Tree().Summary(); s.CoveredLines != 4 || w.Tree().Root.CoveredLines != 4 {
+		t.Errorf("summary = %+v, root covers %d lines", s, w.Tree().Root.CoveredLines)
+	}
+}
+
+func TestWatcherEvents(t *testing.T) {
+	dir := t.TempDir()
+	writeWatchProfile(t, filepath.Join(dir, "a"), "a", 1, 0)
+	w, err := NewWatcher(dir, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	srv := httptest.NewServer(http.HandlerFunc(w.ServeEvents))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	events := bufio.NewScanner(resp.Body)
+	next := func() *Update {
+		t.Helper()
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				var u Update
+				if err := json.Unmarshal([]byte(data), &u); err != nil {
+					t.Fatal(err)
+				}
+				return &u
+			}
+		}
+		t.Fatalf("event stream ended: %v", events.Err())
+		return nil
+	}
+
+	if u := next(); u.Summary.CoveredLines != 1 || len(u.Packages) != 0 {
+		t.Errorf("first event = %+v", u)
+	}
+	writeWatchProfile(t, filepath.Join(dir, "a"), "a", 1, 1)
+	if u := next(); u.Summary.CoveredLines != 2 || u.Previous.CoveredLines != 1 || len(u.Packages) != 1 {
+		t.Errorf("update event = %+v", u)
+	}
+}
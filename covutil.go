@@ -7,14 +7,17 @@ package covutil
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/fs"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -24,10 +27,14 @@ import (
 	"github.com/tmc/covutil/coverage"
 
 	// Internal stubs (representing the existing library)
+	icoverage "github.com/tmc/covutil/internal/coverage"
 	icfile "github.com/tmc/covutil/internal/coverage/cfile"
 	icformat "github.com/tmc/covutil/internal/coverage/cformat"
 	icmerge "github.com/tmc/covutil/internal/coverage/cmerge"
+	iencodecounter "github.com/tmc/covutil/internal/coverage/encodecounter"
+	iencodemeta "github.com/tmc/covutil/internal/coverage/encodemeta"
 	ipods "github.com/tmc/covutil/internal/coverage/pods"
+	islicewriter "github.com/tmc/covutil/internal/coverage/slicewriter"
 )
 
 // --- Basic Coverage Types (Exported from covutil/coverage) ---
@@ -121,6 +128,7 @@ func PkgFuncKeyString(p PkgFuncKey) string {
 //	/by-label/<key>/<value>/    - Pods filtered by label
 //	/by-package/<path>/         - Data for specific package
 //	/functions/<pkg>/<func>/    - Individual function data
+//	/by-line/<file>/<line>/     - Tests that executed a source line
 //	/summary/                   - Aggregate summaries
 type CoverageSet struct {
 	Pods []*Pod
@@ -145,6 +153,7 @@ type LoadOption func(*loadConfig)
 type loadConfig struct {
 	logger   *slog.Logger
 	maxDepth int
+	testDirs bool
 }
 
 // WithLogger sets the logger for warnings and diagnostics
@@ -161,6 +170,17 @@ func WithMaxDepth(depth int) LoadOption {
 	}
 }
 
+// WithTestDirectories loads each directory as a separate set of pods and
+// labels them with TestNameLabel set to the directory's path. This suits
+// layouts with one GOCOVERDIR per test, such as those written by the
+// testing overlay, where pods from different tests would otherwise be
+// merged because they share a meta-data hash.
+func WithTestDirectories() LoadOption {
+	return func(c *loadConfig) {
+		c.testDirs = true
+	}
+}
+
 // LoadCoverageSet scans an fs.FS for coverage files and loads them.
 // It identifies groups of meta and counter files (internal pods) and
 // transforms them into public Pod structures containing Profiles.
@@ -217,138 +237,192 @@ func loadCoverageSetFromFS(fsys fs.FS, config *loadConfig) (*CoverageSet, error)
 		return nil, fmt.Errorf("walking filesystem: %w", err)
 	}
 
-	internalPods := ipods.CollectPodsFromFiles(filePaths, false) // This needs careful path handling for FS
-	if len(internalPods) == 0 {
-		return &CoverageSet{}, nil
+	// By default pods are formed across the whole tree, so that counter
+	// files for the same binary found in different directories are
+	// merged. With WithTestDirectories each directory is kept separate.
+	groups := map[string][]string{".": filePaths}
+	if config.testDirs {
+		groups = make(map[string][]string)
+		for _, p := range filePaths {
+			dir := path.Dir(p)
+			groups[dir] = append(groups[dir], p)
+		}
+	}
+	dirs := make([]string, 0, len(groups))
+	for dir := range groups {
+		dirs = append(dirs, dir)
 	}
+	sort.Strings(dirs)
 
-	set := &CoverageSet{Pods: make([]*Pod, 0, len(internalPods))}
+	set := &CoverageSet{}
+	for _, dir := range dirs {
+		for _, ipod := range ipods.CollectPodsFromFiles(groups[dir], false) {
+			pod, err := loadPodFromFS(fsys, ipod, config)
+			if err != nil {
+				return nil, err
+			}
+			if config.testDirs && dir != "." {
+				pod.Labels[TestNameLabel] = dir
+			}
+			set.Pods = append(set.Pods, pod)
+		}
+	}
+	sort.Slice(set.Pods, func(i, j int) bool { return set.Pods[i].ID < set.Pods[j].ID })
+	return set, nil
+}
 
-	for _, ipod := range internalPods {
-		// ipod.MetaFile and ipod.CounterDataFiles are paths relative to how CollectPodsFromFiles saw them.
-		// If CollectPodsFromFiles was given paths already suitable for fsys.Open (e.g. relative from root), this is fine.
-		metaFSPath := ipod.MetaFile
+// loadPodFromFS reads the meta-data and counter files of ipod from fsys.
+func loadPodFromFS(fsys fs.FS, ipod ipods.Pod, config *loadConfig) (*Pod, error) {
+	// ipod.MetaFile and ipod.CounterDataFiles are paths relative to how CollectPodsFromFiles saw them.
+	// If CollectPodsFromFiles was given paths already suitable for fsys.Open (e.g. relative from root), this is fine.
+	metaFSPath := ipod.MetaFile
 
-		metaReader, err := fsys.Open(metaFSPath)
-		if err != nil {
-			return nil, fmt.Errorf("opening meta file %s from fsys: %w", metaFSPath, err)
-		}
+	metaReader, err := fsys.Open(metaFSPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening meta file %s from fsys: %w", metaFSPath, err)
+	}
 
-		parsedMetaFile, err := LoadMetaFile(metaReader, metaFSPath) // Use the public LoadMetaFile
-		metaReader.Close()
-		if err != nil {
-			return nil, fmt.Errorf("parsing meta file %s: %w", metaFSPath, err)
-		}
+	parsedMetaFile, err := LoadMetaFile(metaReader, metaFSPath) // Use the public LoadMetaFile
+	metaReader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("parsing meta file %s: %w", metaFSPath, err)
+	}
 
-		profile := &Profile{
-			Meta:     *parsedMetaFile,
-			Counters: make(map[PkgFuncKey][]uint32),
-			Args:     make(map[string]string),
-		}
+	profile := &Profile{
+		Meta:     *parsedMetaFile,
+		Counters: make(map[PkgFuncKey][]uint32),
+		Args:     make(map[string]string),
+	}
 
-		merger := &icmerge.Merger{} // Using internal merger for now
-		if err := merger.SetModeAndGranularity("", coverage.InternalCounterMode(parsedMetaFile.Mode), coverage.InternalCounterGranularity(parsedMetaFile.Granularity)); err != nil {
-			return nil, fmt.Errorf("setting merge policy for pod %s: %w", metaFSPath, err)
+	merger := &icmerge.Merger{} // Using internal merger for now
+	if err := merger.SetModeAndGranularity("", coverage.InternalCounterMode(parsedMetaFile.Mode), coverage.InternalCounterGranularity(parsedMetaFile.Granularity)); err != nil {
+		return nil, fmt.Errorf("setting merge policy for pod %s: %w", metaFSPath, err)
+	}
+
+	var firstCounterFileTimestamp time.Time
+	var counterFileCount int
+
+	for i, counterFSPath := range ipod.CounterDataFiles {
+		counterReader, err := fsys.Open(counterFSPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening counter file %s from fsys: %w", counterFSPath, err)
 		}
 
-		var firstCounterFileTimestamp time.Time
-		var counterFileCount int
+		parsedCounterFile, err := LoadCounterFile(counterReader, counterFSPath) // Use public LoadCounterFile
+		counterReader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing counter file %s: %w", counterFSPath, err)
+		}
 
-		for i, counterFSPath := range ipod.CounterDataFiles {
-			counterReader, err := fsys.Open(counterFSPath)
-			if err != nil {
-				return nil, fmt.Errorf("opening counter file %s from fsys: %w", counterFSPath, err)
+		if !bytes.Equal(parsedCounterFile.MetaFileHash[:], parsedMetaFile.FileHash[:]) {
+			if config.logger != nil {
+				config.logger.Warn("counter file meta hash mismatch",
+					"counter_file", counterFSPath,
+					"counter_hash", fmt.Sprintf("%x", parsedCounterFile.MetaFileHash),
+					"meta_hash", fmt.Sprintf("%x", parsedMetaFile.FileHash),
+					"meta_file", metaFSPath)
+			} else {
+				fmt.Fprintf(os.Stderr, "warning: counter file %s meta hash %x mismatches %x for meta %s\n",
+					counterFSPath, parsedCounterFile.MetaFileHash, parsedMetaFile.FileHash, metaFSPath)
 			}
+			continue
+		}
+		counterFileCount++
 
-			parsedCounterFile, err := LoadCounterFile(counterReader, counterFSPath) // Use public LoadCounterFile
-			counterReader.Close()
-			if err != nil {
-				return nil, fmt.Errorf("parsing counter file %s: %w", counterFSPath, err)
+		// Try to get timestamp from counter file name (standard format)
+		// Format: covcounters.<hash>.<pid>.<nanotime>
+		if i == 0 { // Use timestamp from first counter file of the pod
+			base := filepath.Base(counterFSPath)
+			parts := strings.Split(base, ".")
+			if len(parts) == 4 {
+				if nano, err := parseNanos(parts[3]); err == nil {
+					firstCounterFileTimestamp = time.Unix(0, nano)
+				}
 			}
+			profile.Args = parsedCounterFile.Segments[0].Args
+		}
 
-			if !bytes.Equal(parsedCounterFile.MetaFileHash[:], parsedMetaFile.FileHash[:]) {
-				if config.logger != nil {
-					config.logger.Warn("counter file meta hash mismatch",
-						"counter_file", counterFSPath,
-						"counter_hash", fmt.Sprintf("%x", parsedCounterFile.MetaFileHash),
-						"meta_hash", fmt.Sprintf("%x", parsedMetaFile.FileHash),
-						"meta_file", metaFSPath)
-				} else {
-					fmt.Fprintf(os.Stderr, "warning: counter file %s meta hash %x mismatches %x for meta %s\n",
-						counterFSPath, parsedCounterFile.MetaFileHash, parsedMetaFile.FileHash, metaFSPath)
+		for _, segment := range parsedCounterFile.Segments {
+			for _, fCounters := range segment.Functions {
+				if int(fCounters.PackageIndex) >= len(parsedMetaFile.Packages) {
+					continue
 				}
-				continue
-			}
-			counterFileCount++
-
-			// Try to get timestamp from counter file name (standard format)
-			// Format: covcounters.<hash>.<pid>.<nanotime>
-			if i == 0 { // Use timestamp from first counter file of the pod
-				base := filepath.Base(counterFSPath)
-				parts := strings.Split(base, ".")
-				if len(parts) == 4 {
-					if nano, err := parseNanos(parts[3]); err == nil {
-						firstCounterFileTimestamp = time.Unix(0, nano)
-					}
+				pkgMeta := parsedMetaFile.Packages[fCounters.PackageIndex]
+				if int(fCounters.FunctionIndex) >= len(pkgMeta.Functions) {
+					continue
 				}
-				profile.Args = parsedCounterFile.Segments[0].Args
-			}
+				fnDesc := pkgMeta.Functions[fCounters.FunctionIndex]
+				key := PkgFuncKey{PkgPath: pkgMeta.Path, FuncName: fnDesc.FuncName}
 
-			for _, segment := range parsedCounterFile.Segments {
-				for _, fCounters := range segment.Functions {
-					if int(fCounters.PackageIndex) >= len(parsedMetaFile.Packages) {
-						continue
-					}
-					pkgMeta := parsedMetaFile.Packages[fCounters.PackageIndex]
-					if int(fCounters.FunctionIndex) >= len(pkgMeta.Functions) {
-						continue
-					}
-					fnDesc := pkgMeta.Functions[fCounters.FunctionIndex]
-					key := PkgFuncKey{PkgPath: pkgMeta.Path, FuncName: fnDesc.FuncName}
-
-					if len(fCounters.Counts) != len(fnDesc.Units) {
-						continue
-					}
+				if len(fCounters.Counts) != len(fnDesc.Units) {
+					continue
+				}
 
-					if existing, ok := profile.Counters[key]; ok {
-						_, _ = merger.MergeCounters(existing, fCounters.Counts)
-					} else {
-						newCounts := make([]uint32, len(fCounters.Counts))
-						copy(newCounts, fCounters.Counts)
-						profile.Counters[key] = newCounts
-					}
+				if existing, ok := profile.Counters[key]; ok {
+					_, _ = merger.MergeCounters(existing, fCounters.Counts)
+				} else {
+					newCounts := make([]uint32, len(fCounters.Counts))
+					copy(newCounts, fCounters.Counts)
+					profile.Counters[key] = newCounts
 				}
 			}
 		}
+	}
 
-		podID := fmt.Sprintf("%x", parsedMetaFile.FileHash)
-		if len(ipod.CounterDataFiles) > 0 && !firstCounterFileTimestamp.IsZero() {
-			podID = fmt.Sprintf("%s-%d", podID, firstCounterFileTimestamp.UnixNano()) // Make ID more unique if counters exist
-		}
+	podID := fmt.Sprintf("%x", parsedMetaFile.FileHash)
+	if len(ipod.CounterDataFiles) > 0 && !firstCounterFileTimestamp.IsZero() {
+		podID = fmt.Sprintf("%s-%d", podID, firstCounterFileTimestamp.UnixNano()) // Make ID more unique if counters exist
+	}
 
-		pod := &Pod{
-			ID:               podID,
-			Profile:          profile,
-			Labels:           make(map[string]string),
-			Timestamp:        firstCounterFileTimestamp, // Timestamp of first counter file as pod time
-			metaFilePath:     metaFSPath,
-			counterFilePaths: ipod.CounterDataFiles,
-		}
-		if goos, ok := profile.Args["GOOS"]; ok {
-			pod.Labels["GOOS"] = goos
+	pod := &Pod{
+		ID:               podID,
+		Profile:          profile,
+		Labels:           make(map[string]string),
+		Timestamp:        firstCounterFileTimestamp, // Timestamp of first counter file as pod time
+		metaFilePath:     metaFSPath,
+		counterFilePaths: ipod.CounterDataFiles,
+	}
+	if goos, ok := profile.Args["GOOS"]; ok {
+		pod.Labels["GOOS"] = goos
+	}
+	if goarch, ok := profile.Args["GOARCH"]; ok {
+		pod.Labels["GOARCH"] = goarch
+	}
+	if err := loadPodMetadataFromFS(fsys, pod); err != nil {
+		return nil, err
+	}
+	if counterFileCount == 0 && len(ipod.CounterDataFiles) > 0 {
+		// All counter files were mismatched or unparsable for this meta.
+		// Decide if such a pod (meta-only) should be added. For now, it is.
+	}
+	return pod, nil
+}
+
+// loadPodMetadataFromFS applies the pod metadata files found in the
+// directories of pod's files to pod.
+func loadPodMetadataFromFS(fsys fs.FS, pod *Pod) error {
+	dirs := []string{path.Dir(pod.metaFilePath)}
+	for _, p := range pod.counterFilePaths {
+		dirs = append(dirs, path.Dir(p))
+	}
+	slices.Sort(dirs)
+	for _, dir := range slices.Compact(dirs) {
+		name := path.Join(dir, PodMetadataFile)
+		f, err := fsys.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
 		}
-		if goarch, ok := profile.Args["GOARCH"]; ok {
-			pod.Labels["GOARCH"] = goarch
+		if err != nil {
+			return fmt.Errorf("opening pod metadata %s: %w", name, err)
 		}
-		if counterFileCount == 0 && len(ipod.CounterDataFiles) > 0 {
-			// All counter files were mismatched or unparsable for this meta.
-			// Decide if such a pod (meta-only) should be added. For now, it is.
+		md, err := LoadPodMetadata(f, name)
+		f.Close()
+		if err != nil {
+			return err
 		}
-
-		set.Pods = append(set.Pods, pod)
+		md.apply(pod)
 	}
-	sort.Slice(set.Pods, func(i, j int) bool { return set.Pods[i].ID < set.Pods[j].ID })
-	return set, nil
+	return nil
 }
 
 // Helper to parse nanoseconds from counter file names.
@@ -495,6 +569,8 @@ func deepCopyPod(original *Pod) (*Pod, error) {
 }
 
 // Merge aggregates all pods in the CoverageSet into a single summary Pod.
+// If the pods hold data of different binaries, their profiles are merged
+// with MergePackages.
 func (cs *CoverageSet) Merge() (*Pod, error) {
 	cs.mu.RLock()
 	defer cs.mu.RUnlock()
@@ -542,7 +618,16 @@ func (cs *CoverageSet) Merge() (*Pod, error) {
 		return &Pod{ID: "merged-" + originalPodForMeta.ID, Profile: copiedProfile, Labels: originalPodForMeta.Labels, Links: originalPodForMeta.Links, Source: originalPodForMeta.Source, Timestamp: originalPodForMeta.Timestamp}, nil
 	}
 
-	mergedProfile, err := MergeProfiles(allProfiles...)
+	// Pods of different binaries have different meta-data files; merge
+	// them package by package.
+	merge := MergeProfiles
+	for _, p := range allProfiles[1:] {
+		if p.Meta.FileHash != allProfiles[0].Meta.FileHash {
+			merge = MergePackages
+			break
+		}
+	}
+	mergedProfile, err := merge(allProfiles...)
 	if err != nil {
 		return nil, fmt.Errorf("merging profiles in set: %w", err)
 	}
@@ -620,6 +705,8 @@ func (cs *CoverageSet) Open(name string) (fs.File, error) {
 		return cs.openByPackagePath(parts[1:], name)
 	case "functions":
 		return cs.openFunctionsPath(parts[1:], name)
+	case "by-line":
+		return cs.openByLinePath(parts[1:], name)
 	case "summary":
 		return cs.openSummaryPath(parts[1:], name)
 	default:
@@ -1125,6 +1212,7 @@ func (r *plan9Root) ReadDir(n int) ([]fs.DirEntry, error) {
 		&podDirEntry{name: "by-label"},
 		&podDirEntry{name: "by-package"},
 		&podDirEntry{name: "functions"},
+		&podDirEntry{name: "by-line"},
 		&podDirEntry{name: "summary"},
 	}
 
@@ -1141,6 +1229,7 @@ func (r *plan9Root) ReadDir(n int) ([]fs.DirEntry, error) {
 type virtualDir struct {
 	name    string
 	entries []fs.DirEntry
+	offset  int
 }
 
 func (d *virtualDir) Stat() (fs.FileInfo, error) {
@@ -1156,13 +1245,19 @@ func (d *virtualDir) Close() error {
 }
 
 func (d *virtualDir) ReadDir(n int) ([]fs.DirEntry, error) {
-	if n < 0 {
-		return d.entries, nil
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
 	}
-	if n > len(d.entries) {
-		n = len(d.entries)
+	if n > len(rest) {
+		n = len(rest)
 	}
-	return d.entries[:n], nil
+	d.offset += n
+	return rest[:n], nil
 }
 
 // coverageDir represents the root directory or a pod directory
@@ -1304,13 +1399,14 @@ func (e *podDirEntry) Info() (fs.FileInfo, error) { return &dirInfo{name: e.name
 
 type fileEntry struct {
 	name string
+	size int64
 }
 
 func (e *fileEntry) Name() string      { return e.name }
 func (e *fileEntry) IsDir() bool       { return false }
 func (e *fileEntry) Type() fs.FileMode { return 0 }
 func (e *fileEntry) Info() (fs.FileInfo, error) {
-	return &fileInfo{name: e.name, size: 0}, nil
+	return &fileInfo{name: e.name, size: e.size}, nil
 }
 
 // --- Profile Operations (helpers and main operations) ---
@@ -1574,7 +1670,10 @@ func (f *Formatter) WriteFuncSummaryReport(w io.Writer, opts FuncSummaryReportOp
 
 // --- Writing Functions ---
 
-// WriteProfileToDirectory writes a profile's metadata and counter data to separate files in the specified directory
+// WriteProfileToDirectory writes a profile as a covmeta.* meta-data file and
+// a covcounters.* counter data file in the specified directory, in the same
+// binary format emitted by coverage-instrumented programs. The directory can
+// be read back with LoadCoverageSet or processed by "go tool covdata".
 func WriteProfileToDirectory(dirPath string, p *Profile) error {
 	if p == nil {
 		return fmt.Errorf("cannot write nil profile")
@@ -1584,52 +1683,149 @@ func WriteProfileToDirectory(dirPath string, p *Profile) error {
 		return fmt.Errorf("creating directory %s: %w", dirPath, err)
 	}
 
-	// Write meta file - try to copy existing file first, then fallback to custom format
-	metaFileName := fmt.Sprintf("covmeta.%x", p.Meta.FileHash)
-	metaPath := filepath.Join(dirPath, metaFileName)
+	var metaBuf bytes.Buffer
+	hash, err := EncodeMetaFile(&metaBuf, &p.Meta)
+	if err != nil {
+		return fmt.Errorf("encoding meta file: %w", err)
+	}
+	metaPath := filepath.Join(dirPath, fmt.Sprintf("covmeta.%x", hash))
+	if err := os.WriteFile(metaPath, metaBuf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing meta file %s: %w", metaPath, err)
+	}
 
-	// Try to find existing meta file to copy
-	existingMetaPath := ""
-	if p.Meta.FilePath != "" {
-		// Check if original meta file exists in covdata_simple
-		candidatePath := filepath.Join("covdata_simple", metaFileName)
-		if _, err := os.Stat(candidatePath); err == nil {
-			existingMetaPath = candidatePath
-		}
+	var counterBuf bytes.Buffer
+	if err := EncodeCounterFile(&counterBuf, p, hash); err != nil {
+		return fmt.Errorf("encoding counter file: %w", err)
+	}
+	counterPath := filepath.Join(dirPath, fmt.Sprintf("covcounters.%x.%d.%d", hash, os.Getpid(), time.Now().UnixNano()))
+	if err := os.WriteFile(counterPath, counterBuf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing counter file %s: %w", counterPath, err)
 	}
 
-	if existingMetaPath != "" {
-		// Copy existing binary meta file
-		sourceFile, err := os.Open(existingMetaPath)
-		if err != nil {
-			return fmt.Errorf("opening existing meta file %s: %w", existingMetaPath, err)
-		}
-		defer sourceFile.Close()
+	return nil
+}
+
+// EncodeMetaFile writes meta to w in the binary covmeta format and returns
+// the meta-data file hash. The hash is computed the same way the runtime
+// computes it, so re-encoding meta-data that was read from a meta file
+// yields the original hash. Counter files must reference this hash.
+func EncodeMetaFile(w io.Writer, meta *MetaFile) ([16]byte, error) {
+	var hash [16]byte
+	if meta == nil {
+		return hash, fmt.Errorf("cannot encode nil meta file")
+	}
+	mode, gran := encodingModeAndGranularity(meta)
 
-		destFile, err := os.Create(metaPath)
+	blobs := make([][]byte, 0, len(meta.Packages))
+	h := fnv.New128a()
+	for i := range meta.Packages {
+		blob, pkgHash, err := encodePackageMeta(&meta.Packages[i])
 		if err != nil {
-			return fmt.Errorf("creating meta file %s: %w", metaPath, err)
+			return hash, err
 		}
-		defer destFile.Close()
+		h.Write(pkgHash[:])
+		blobs = append(blobs, blob)
+	}
+	h.Write([]byte(mode.String()))
+	h.Write([]byte(gran.String()))
+	copy(hash[:], h.Sum(nil))
+
+	mfw := iencodemeta.NewCoverageMetaFileWriter(meta.FilePath, w)
+	if err := mfw.Write(hash, blobs, mode, gran); err != nil {
+		return hash, err
+	}
+	return hash, nil
+}
 
-		if _, err := io.Copy(destFile, sourceFile); err != nil {
-			return fmt.Errorf("copying meta file: %w", err)
+// encodePackageMeta returns the meta-data blob of pkg and its hash, which
+// identifies the package's instrumentation across meta-data files.
+func encodePackageMeta(pkg *PackageMeta) ([]byte, [16]byte, error) {
+	b, err := iencodemeta.NewCoverageMetaDataBuilder(pkg.Path, pkg.Name, pkg.ModulePath)
+	if err != nil {
+		return nil, [16]byte{}, fmt.Errorf("encoding package %q: %w", pkg.Path, err)
+	}
+	for _, fn := range pkg.Functions {
+		fd := icoverage.FuncDesc{
+			Funcname: fn.FuncName,
+			Srcfile:  fn.SrcFile,
+			Units:    make([]icoverage.CoverableUnit, len(fn.Units)),
+			Lit:      fn.IsLiteral,
+		}
+		for i, u := range fn.Units {
+			fd.Units[i] = icoverage.CoverableUnit{
+				StLine: u.StartLine, StCol: u.StartCol,
+				EnLine: u.EndLine, EnCol: u.EndCol,
+				NxStmts: u.NumStmt,
+			}
 		}
-	} else {
-		// Fallback to custom binary format for synthetic data
-		metaFile, err := os.Create(metaPath)
-		if err != nil {
-			return fmt.Errorf("creating meta file %s: %w", metaPath, err)
+		b.AddFunc(fd)
+	}
+	ws := &islicewriter.WriteSeeker{}
+	pkgHash, err := b.Emit(ws)
+	if err != nil {
+		return nil, [16]byte{}, fmt.Errorf("encoding package %q: %w", pkg.Path, err)
+	}
+	return ws.BytesWritten(), pkgHash, nil
+}
+
+// EncodeCounterFile writes the counters of p to w in the binary covcounters
+// format, as a single segment referring to the meta-data file with the given
+// hash. Functions without any executed units are omitted, as the runtime
+// does, and counters for functions absent from p.Meta cannot be encoded and
+// are dropped.
+func EncodeCounterFile(w io.Writer, p *Profile, metaHash [16]byte) error {
+	if p == nil {
+		return fmt.Errorf("cannot encode nil profile")
+	}
+	args := p.Args
+	if args == nil {
+		args = map[string]string{}
+	}
+	cfw := iencodecounter.NewCoverageDataWriter(w, icoverage.CtrULeb128)
+	return cfw.Write(metaHash, args, profileCounterVisitor{p})
+}
+
+// profileCounterVisitor emits a profile's counters in meta-data order.
+type profileCounterVisitor struct {
+	p *Profile
+}
+
+func (v profileCounterVisitor) VisitFuncs(f iencodecounter.CounterVisitorFn) error {
+	for pkgIdx, pkg := range v.p.Meta.Packages {
+		for fnIdx, fn := range pkg.Functions {
+			counts, ok := v.p.Counters[PkgFuncKey{PkgPath: pkg.Path, FuncName: fn.FuncName}]
+			if !ok || len(counts) != len(fn.Units) || !anyNonZero(counts) {
+				continue
+			}
+			if err := f(uint32(pkgIdx), uint32(fnIdx), counts); err != nil {
+				return err
+			}
 		}
-		defer metaFile.Close()
+	}
+	return nil
+}
 
-		// Write proper binary meta file format
-		if err := writeBinaryMetaFile(metaFile, &p.Meta); err != nil {
-			return fmt.Errorf("writing binary meta file: %w", err)
+func anyNonZero(counts []uint32) bool {
+	for _, c := range counts {
+		if c != 0 {
+			return true
 		}
 	}
+	return false
+}
 
-	return nil
+// encodingModeAndGranularity returns the counter mode and granularity to
+// record for meta, substituting defaults for unset values.
+func encodingModeAndGranularity(meta *MetaFile) (icoverage.CounterMode, icoverage.CounterGranularity) {
+	mode := meta.Mode
+	if mode == ModeInvalid {
+		mode = ModeDefault
+	}
+	gran := meta.Granularity
+	if gran == GranularityInvalid {
+		gran = GranularityDefault
+	}
+	return coverage.InternalCounterMode(mode), coverage.InternalCounterGranularity(gran)
 }
 
 // WritePodToDirectory writes a pod's data to files in the specified directory
@@ -1642,21 +1838,29 @@ func WritePodToDirectory(baseDirPath string, pod *Pod) error {
 	if err := WriteProfileToDirectory(podDir, pod.Profile); err != nil {
 		return fmt.Errorf("writing pod profile: %w", err)
 	}
+	return WritePodMetadata(podDir, pod)
+}
 
-	// Write pod metadata (labels, source info, etc.)
-	metadataPath := filepath.Join(podDir, "pod_metadata.json")
+// WritePodMetadata writes a pod's labels, source info, timestamp and links
+// to the pod_metadata.json file of podDir, which must exist. It suits
+// directories whose coverage files were written by other means, such as a
+// GOCOVERDIR. LoadCoverageSet applies the file to the pods it finds in
+// podDir.
+func WritePodMetadata(podDir string, pod *Pod) error {
+	metadataPath := filepath.Join(podDir, PodMetadataFile)
 	metadataFile, err := os.Create(metadataPath)
 	if err != nil {
 		return fmt.Errorf("creating metadata file: %w", err)
 	}
 	defer metadataFile.Close()
 
-	metadata := map[string]interface{}{
-		"id":        pod.ID,
-		"labels":    pod.Labels,
-		"timestamp": pod.Timestamp,
-		"source":    pod.Source,
-		"links":     pod.Links,
+	metadata := &PodMetadata{
+		Version:   PodMetadataVersion,
+		ID:        pod.ID,
+		Labels:    pod.Labels,
+		Timestamp: pod.Timestamp,
+		Source:    pod.Source,
+		Links:     pod.Links,
 	}
 
 	encoder := json.NewEncoder(metadataFile)
@@ -1668,6 +1872,77 @@ func WritePodToDirectory(baseDirPath string, pod *Pod) error {
 	return nil
 }
 
+// PodMetadataFile is the name of the file holding the metadata of the pods
+// in a directory.
+const PodMetadataFile = "pod_metadata.json"
+
+// PodMetadataVersion is the version of the pod metadata schema written by
+// WritePodMetadata. Readers accept files of the same major version and
+// ignore fields added by later minor versions.
+const PodMetadataVersion = "1.0"
+
+// PodMetadata is the content of a pod metadata file.
+type PodMetadata struct {
+	Version   string            `json:"version"`
+	ID        string            `json:"id"`
+	Labels    map[string]string `json:"labels"`
+	Timestamp time.Time         `json:"timestamp"`
+	Source    *SourceInfo       `json:"source"`
+	Links     []Link            `json:"links"`
+}
+
+// PodMetadataVersionError is returned when a pod metadata file has a
+// schema version this package cannot read.
+type PodMetadataVersionError struct {
+	Path    string // path of the file
+	Version string // version found in the file
+}
+
+func (e *PodMetadataVersionError) Error() string {
+	return fmt.Sprintf("%s: unsupported pod metadata version %q (want %s)", e.Path, e.Version, PodMetadataVersion)
+}
+
+// LoadPodMetadata parses a pod metadata file. Files without a version,
+// written before the schema was versioned, are read as version 1.0. If the
+// file has a different major version, the error is a
+// *PodMetadataVersionError.
+func LoadPodMetadata(r io.Reader, filePath string) (*PodMetadata, error) {
+	var md PodMetadata
+	if err := json.NewDecoder(r).Decode(&md); err != nil {
+		return nil, fmt.Errorf("parsing pod metadata %s: %w", filePath, err)
+	}
+	if md.Version == "" {
+		md.Version = "1.0"
+	}
+	major, _, _ := strings.Cut(md.Version, ".")
+	if want, _, _ := strings.Cut(PodMetadataVersion, "."); major != want {
+		return nil, &PodMetadataVersionError{Path: filePath, Version: md.Version}
+	}
+	return &md, nil
+}
+
+// apply merges md into pod. Labels in md override the pod's, and links
+// are added unless the pod already has them.
+func (md *PodMetadata) apply(pod *Pod) {
+	if pod.Labels == nil {
+		pod.Labels = make(map[string]string)
+	}
+	for k, v := range md.Labels {
+		pod.Labels[k] = v
+	}
+	for _, l := range md.Links {
+		if !slices.Contains(pod.Links, l) {
+			pod.Links = append(pod.Links, l)
+		}
+	}
+	if md.Source != nil {
+		pod.Source = md.Source
+	}
+	if !md.Timestamp.IsZero() {
+		pod.Timestamp = md.Timestamp
+	}
+}
+
 // WriteCoverageSetToDirectory writes all pods in a coverage set to subdirectories
 func WriteCoverageSetToDirectory(baseDirPath string, set *CoverageSet) error {
 	if set == nil {
@@ -1691,98 +1966,3 @@ func WriteCoverageSetToDirectory(baseDirPath string, set *CoverageSet) error {
 func WriteMetaFileContent(w io.Writer) error    { return icfile.WriteMeta(w) }
 func WriteCounterFileContent(w io.Writer) error { return icfile.WriteCounters(w) }
 func ClearCoverageCounters() error              { return icfile.ClearCounters() }
-
-// writeBinaryMetaFile writes a MetaFile to a writer in Go's binary covmeta format
-func writeBinaryMetaFile(w io.Writer, meta *MetaFile) error {
-	// Write magic number (matches Go's internal format)
-	if err := binary.Write(w, binary.LittleEndian, [4]byte{0, 'c', 'v', 'm'}); err != nil {
-		return err
-	}
-
-	// Write version
-	if err := binary.Write(w, binary.LittleEndian, uint32(1)); err != nil {
-		return err
-	}
-
-	// Write file hash
-	if err := binary.Write(w, binary.LittleEndian, meta.FileHash); err != nil {
-		return err
-	}
-
-	// Write mode and granularity
-	if err := binary.Write(w, binary.LittleEndian, uint8(meta.Mode)); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.LittleEndian, uint8(meta.Granularity)); err != nil {
-		return err
-	}
-
-	// Write number of packages
-	if err := binary.Write(w, binary.LittleEndian, uint32(len(meta.Packages))); err != nil {
-		return err
-	}
-
-	// Write each package
-	for _, pkg := range meta.Packages {
-		// Write package path length and path
-		pathBytes := []byte(pkg.Path)
-		if err := binary.Write(w, binary.LittleEndian, uint32(len(pathBytes))); err != nil {
-			return err
-		}
-		if _, err := w.Write(pathBytes); err != nil {
-			return err
-		}
-
-		// Write number of functions
-		if err := binary.Write(w, binary.LittleEndian, uint32(len(pkg.Functions))); err != nil {
-			return err
-		}
-
-		// Write each function
-		for _, fn := range pkg.Functions {
-			// Write function name length and name
-			nameBytes := []byte(fn.FuncName)
-			if err := binary.Write(w, binary.LittleEndian, uint32(len(nameBytes))); err != nil {
-				return err
-			}
-			if _, err := w.Write(nameBytes); err != nil {
-				return err
-			}
-
-			// Write source file length and file
-			srcBytes := []byte(fn.SrcFile)
-			if err := binary.Write(w, binary.LittleEndian, uint32(len(srcBytes))); err != nil {
-				return err
-			}
-			if _, err := w.Write(srcBytes); err != nil {
-				return err
-			}
-
-			// Write number of units
-			if err := binary.Write(w, binary.LittleEndian, uint32(len(fn.Units))); err != nil {
-				return err
-			}
-
-			// Write each unit
-			for _, unit := range fn.Units {
-				if err := binary.Write(w, binary.LittleEndian, unit.StartLine); err != nil {
-					return err
-				}
-				if err := binary.Write(w, binary.LittleEndian, unit.StartCol); err != nil {
-					return err
-				}
-				if err := binary.Write(w, binary.LittleEndian, unit.EndLine); err != nil {
-					return err
-				}
-				if err := binary.Write(w, binary.LittleEndian, unit.EndCol); err != nil {
-					return err
-				}
-				if err := binary.Write(w, binary.LittleEndian, unit.NumStmt); err != nil {
-					return err
-				}
-			}
-		}
-	}
-
-	return nil
-}
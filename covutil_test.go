@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
@@ -304,6 +306,82 @@ func TestWritingFunctions(t *testing.T) {
 	}
 }
 
+func TestPodMetadataRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	profile := mergeTestProfile(ModeCount, map[string]map[string][]uint32{
+		"example.com/a": {"F": {1, 2}},
+	}, map[string][]uint32{"example.com/a.F": {1, 0}})
+	pod := &Pod{
+		ID:        "unit",
+		Profile:   profile,
+		Labels:    map[string]string{"team": "core", "suite": "unit"},
+		Links:     []Link{{Type: "git_commit", URI: "abc123"}},
+		Source:    &SourceInfo{RepoURI: "https://example.com/a.git", CommitSHA: "abc123", Branch: "main"},
+		Timestamp: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	if err := WritePodToDirectory(dir, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := LoadCoverageSet(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Pods) != 1 {
+		t.Fatalf("loaded %d pods, want 1", len(set.Pods))
+	}
+	got := set.Pods[0]
+	if got.Labels["team"] != "core" || got.Labels["suite"] != "unit" {
+		t.Errorf("labels = %v", got.Labels)
+	}
+	if len(got.Links) != 1 || got.Links[0] != pod.Links[0] {
+		t.Errorf("links = %v, want %v", got.Links, pod.Links)
+	}
+	if got.Source == nil || *got.Source != *pod.Source {
+		t.Errorf("source = %+v, want %+v", got.Source, pod.Source)
+	}
+	if !got.Timestamp.Equal(pod.Timestamp) {
+		t.Errorf("timestamp = %v, want %v", got.Timestamp, pod.Timestamp)
+	}
+
+	filtered, err := set.FilterByLabel(map[string]string{"team": "core"})
+	if err != nil || len(filtered.Pods) != 1 {
+		t.Errorf("FilterByLabel(team=core) = %v, %v", filtered, err)
+	}
+	entries, err := fs.ReadDir(set, "by-label/team/core")
+	if err != nil || len(entries) != 1 {
+		t.Errorf("by-label/team/core = %v, %v", entries, err)
+	}
+
+	// Files written before the schema was versioned are read, as are
+	// later minor versions; other major versions are rejected.
+	mdPath := filepath.Join(dir, "unit", PodMetadataFile)
+	for _, tt := range []struct {
+		version string
+		ok      bool
+	}{
+		{"", true},
+		{"1.3", true},
+		{"2.0", false},
+	} {
+		md := fmt.Sprintf(`{"labels": {"team": "core"}, "version": %q}`, tt.version)
+		if tt.version == "" {
+			md = `{"labels": {"team": "core"}}`
+		}
+		if err := os.WriteFile(mdPath, []byte(md), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadCoverageSet(os.DirFS(dir))
+		var verr *PodMetadataVersionError
+		if tt.ok && err != nil {
+			t.Errorf("version %q: %v", tt.version, err)
+		}
+		if !tt.ok && (!errors.As(err, &verr) || verr.Version != tt.version) {
+			t.Errorf("version %q: got error %v, want a PodMetadataVersionError", tt.version, err)
+		}
+	}
+}
+
 func TestMergeProfiles(t *testing.T) {
 	// Test merging profiles
 	profile1 := &Profile{
@@ -0,0 +1,54 @@
+package covutil
+
+import "fmt"
+
+// --- Counter Deltas ---
+
+// CounterDelta returns the executions recorded by newer that had not yet
+// been recorded by older, where both are snapshots of the counters of the
+// same binary, such as two scrapes of a running program. The result has
+// newer's meta-data and arguments, so it can be written and read like any
+// other profile.
+//
+// Counters are subtracted unit by unit. A counter that went down, as when
+// the program reset its counters or restarted between the snapshots, yields
+// zero rather than wrapping around. In set mode, where counters only record
+// whether a unit executed, a unit is set in the result if it is set in
+// newer but not in older, that is, if it was newly covered.
+//
+// CounterDelta returns an error if the profiles have different meta-data
+// hashes, modes or granularities.
+func CounterDelta(older, newer *Profile) (*Profile, error) {
+	if older == nil || newer == nil {
+		return nil, fmt.Errorf("nil profile for delta")
+	}
+	if _, err := checkCompatibility(newer, older); err != nil {
+		return nil, fmt.Errorf("compatibility check for delta: %w", err)
+	}
+	result, err := copyProfile(newer)
+	if err != nil {
+		return nil, err
+	}
+	for key, counts := range result.Counters {
+		prev := older.Counters[key]
+		if len(prev) != len(counts) {
+			continue
+		}
+		for i, c := range counts {
+			switch {
+			case result.Meta.Mode == ModeSet:
+				if prev[i] != 0 {
+					counts[i] = 0
+				}
+			case c > prev[i]:
+				counts[i] = c - prev[i]
+			default:
+				counts[i] = 0
+			}
+		}
+		if !anyNonZero(counts) {
+			delete(result.Counters, key)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,47 @@
+package covutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	units := map[string][]CoverableUnit{
+		"F": {{StartLine: 1, EndLine: 2, NumStmt: 1}, {StartLine: 3, EndLine: 4, NumStmt: 1}, {StartLine: 5, EndLine: 6, NumStmt: 1}},
+		"G": {{StartLine: 10, EndLine: 11, NumStmt: 1}},
+		"H": {{StartLine: 20, EndLine: 21, NumStmt: 1}},
+	}
+	older := diffTestProfile(1, units, map[string][]uint32{"F": {2, 0, 7}, "G": {3}})
+	// F's third counter went down, as after a reset; G did not execute
+	// again; H executed for the first time.
+	newer := diffTestProfile(1, units, map[string][]uint32{"F": {5, 1, 2}, "G": {3}, "H": {4}})
+
+	d, err := CounterDelta(older, newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[PkgFuncKey][]uint32{
+		{PkgPath: "example.com/a", FuncName: "F"}: {3, 1, 0},
+		{PkgPath: "example.com/a", FuncName: "H"}: {4},
+	}
+	if !reflect.DeepEqual(d.Counters, want) {
+		t.Errorf("counters = %v, want %v", d.Counters, want)
+	}
+	if newer.Counters[PkgFuncKey{PkgPath: "example.com/a", FuncName: "F"}][0] != 5 {
+		t.Errorf("CounterDelta modified its input")
+	}
+
+	older.Meta.Mode, newer.Meta.Mode = ModeSet, ModeSet
+	older.Counters[PkgFuncKey{PkgPath: "example.com/a", FuncName: "F"}] = []uint32{1, 0, 1}
+	newer.Counters[PkgFuncKey{PkgPath: "example.com/a", FuncName: "F"}] = []uint32{1, 1, 1}
+	if d, err = CounterDelta(older, newer); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Counters[PkgFuncKey{PkgPath: "example.com/a", FuncName: "F"}]; !reflect.DeepEqual(got, []uint32{0, 1, 0}) {
+		t.Errorf("set mode: F = %v, want only the newly covered unit", got)
+	}
+
+	if _, err := CounterDelta(older, diffTestProfile(2, units, nil)); err == nil {
+		t.Errorf("CounterDelta of profiles with different meta hashes succeeded")
+	}
+}
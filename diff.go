@@ -0,0 +1,397 @@
+package covutil
+
+import (
+	"fmt"
+	"sort"
+)
+
+// --- Coverage Diffs ---
+
+// DiffKind classifies how the coverage of a unit changed between two
+// profiles.
+type DiffKind int
+
+const (
+	// DiffGained marks a unit that is covered in head but was not in base,
+	// including units that only exist in head.
+	DiffGained DiffKind = iota + 1
+	// DiffLost marks a unit that was covered in base but is not in head,
+	// including units that no longer exist.
+	DiffLost
+	// DiffCountChanged marks a unit covered in both whose count changed.
+	DiffCountChanged
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffGained:
+		return "gained"
+	case DiffLost:
+		return "lost"
+	case DiffCountChanged:
+		return "count-changed"
+	}
+	return fmt.Sprintf("DiffKind(%d)", int(k))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k DiffKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnitDiff describes a change in the coverage of a single unit. Unit is
+// the unit's position in head, or in base if it no longer exists.
+type UnitDiff struct {
+	Kind      DiffKind
+	Unit      CoverableUnit
+	BaseCount uint32
+	HeadCount uint32
+}
+
+// FuncDiff lists the changed units of a function.
+type FuncDiff struct {
+	FuncName string
+	File     string
+	Added    bool // the function only exists in head
+	Removed  bool // the function only exists in base
+	Units    []UnitDiff
+}
+
+// PackageDiff summarizes the coverage change of a package.
+type PackageDiff struct {
+	PkgPath string
+	// Statement totals in base and head.
+	BaseStmts, BaseCovered int
+	HeadStmts, HeadCovered int
+	// Number of units gained, lost and with changed counts.
+	Gained, Lost, CountChanged int
+	// Functions lists functions with at least one changed unit.
+	Functions []FuncDiff
+}
+
+// BasePercent returns the statement coverage of the package in base.
+func (d *PackageDiff) BasePercent() float64 { return percent(d.BaseCovered, d.BaseStmts) }
+
+// HeadPercent returns the statement coverage of the package in head.
+func (d *PackageDiff) HeadPercent() float64 { return percent(d.HeadCovered, d.HeadStmts) }
+
+// Changed reports whether any unit of the package changed.
+func (d *PackageDiff) Changed() bool { return len(d.Functions) > 0 }
+
+// ProfileDiff is the result of comparing a base profile with a head
+// profile.
+type ProfileDiff struct {
+	// Packages holds every package present in either profile, sorted by
+	// import path.
+	Packages []PackageDiff
+	// MetaMismatch is set when base and head were built from different
+	// meta-data, in which case functions were matched by package and
+	// name, and units by source position.
+	MetaMismatch bool
+	// Skipped lists the function builds whose counters were left out.
+	// Where the profiles of one side hold builds of a function with
+	// different units, as when combining binaries built from different
+	// sources, only the first build is compared; the executed builds
+	// passed over are listed here.
+	Skipped []SkippedFunc
+	// Statement totals over all packages.
+	BaseStmts, BaseCovered int
+	HeadStmts, HeadCovered int
+}
+
+// SkippedFunc identifies a build of a function whose counters a diff
+// left out.
+type SkippedFunc struct {
+	PkgPath  string
+	FuncName string
+	File     string
+	Head     bool // the build was in head rather than base
+	Executed int  // number of its units that executed
+}
+
+// BasePercent returns the overall statement coverage of base.
+func (d *ProfileDiff) BasePercent() float64 { return percent(d.BaseCovered, d.BaseStmts) }
+
+// HeadPercent returns the overall statement coverage of head.
+func (d *ProfileDiff) HeadPercent() float64 { return percent(d.HeadCovered, d.HeadStmts) }
+
+// Changed returns the packages with at least one changed unit.
+func (d *ProfileDiff) Changed() []PackageDiff {
+	var changed []PackageDiff
+	for _, p := range d.Packages {
+		if p.Changed() {
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// DiffProfiles compares the coverage of base and head, reporting for each
+// package, function and unit the coverage that was gained, lost or whose
+// count changed. The profiles need not share meta-data: functions are
+// matched on package path and name, and their units by index when the
+// function's shape is unchanged or by source position otherwise.
+func DiffProfiles(base, head *Profile) (*ProfileDiff, error) {
+	if base == nil || head == nil {
+		return nil, fmt.Errorf("nil profile for diff")
+	}
+	return diffProfiles([]*Profile{base}, []*Profile{head}), nil
+}
+
+// Diff compares the coverage of cs, taken as the base, with head. Pods
+// from different binaries on either side are combined by function name,
+// as in DiffProfiles.
+func (cs *CoverageSet) Diff(head *CoverageSet) (*ProfileDiff, error) {
+	base := cs.profiles()
+	heads := head.profiles()
+	if len(base) == 0 || len(heads) == 0 {
+		return nil, fmt.Errorf("no profiles to diff")
+	}
+	return diffProfiles(base, heads), nil
+}
+
+// profiles returns the profiles of all pods and sub-pods in cs.
+func (cs *CoverageSet) profiles() []*Profile {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	var profiles []*Profile
+	var collect func(pods []*Pod)
+	collect = func(pods []*Pod) {
+		for _, p := range pods {
+			if p.Profile != nil {
+				profiles = append(profiles, p.Profile)
+			}
+			collect(p.SubPods)
+		}
+	}
+	collect(cs.Pods)
+	return profiles
+}
+
+// diffFunc is a function's meta-data with counters summed over profiles.
+type diffFunc struct {
+	desc   FuncDesc
+	counts []uint32
+}
+
+// diffIndex maps package paths to their functions, keyed by name.
+type diffIndex map[string]map[string]*diffFunc
+
+// newDiffIndex indexes the functions of profiles, the head side if head
+// is set, reporting the executed builds it skips in d.
+func newDiffIndex(d *ProfileDiff, profiles []*Profile, head bool) diffIndex {
+	idx := make(diffIndex)
+	for _, p := range profiles {
+		for _, pkg := range p.Meta.Packages {
+			funcs := idx[pkg.Path]
+			if funcs == nil {
+				funcs = make(map[string]*diffFunc)
+				idx[pkg.Path] = funcs
+			}
+			for _, fd := range pkg.Functions {
+				counts := p.Counters[PkgFuncKey{PkgPath: pkg.Path, FuncName: fd.FuncName}]
+				if len(counts) != len(fd.Units) {
+					counts = nil
+				}
+				f := funcs[fd.FuncName]
+				if f == nil {
+					f = &diffFunc{desc: fd, counts: make([]uint32, len(fd.Units))}
+					funcs[fd.FuncName] = f
+				}
+				if !sameUnits(f.desc.Units, fd.Units) {
+					// A different build of the function; keep the first.
+					sf := SkippedFunc{PkgPath: pkg.Path, FuncName: fd.FuncName, File: fd.SrcFile, Head: head}
+					for _, c := range counts {
+						if c > 0 {
+							sf.Executed++
+						}
+					}
+					if sf.Executed > 0 {
+						d.Skipped = append(d.Skipped, sf)
+					}
+					continue
+				}
+				for i, c := range counts {
+					f.counts[i] = saturatingAdd(f.counts[i], c)
+				}
+			}
+		}
+	}
+	return idx
+}
+
+func sameUnits(a, b []CoverableUnit) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func saturatingAdd(a, b uint32) uint32 {
+	if s := a + b; s >= a {
+		return s
+	}
+	return ^uint32(0)
+}
+
+func diffProfiles(base, head []*Profile) *ProfileDiff {
+	d := &ProfileDiff{MetaMismatch: !sameMetaHashes(base, head)}
+	bi, hi := newDiffIndex(d, base, false), newDiffIndex(d, head, true)
+
+	pkgs := make(map[string]bool)
+	for p := range bi {
+		pkgs[p] = true
+	}
+	for p := range hi {
+		pkgs[p] = true
+	}
+	for _, pkgPath := range sortedKeys(pkgs) {
+		pd := diffPackage(pkgPath, bi[pkgPath], hi[pkgPath])
+		d.BaseStmts += pd.BaseStmts
+		d.BaseCovered += pd.BaseCovered
+		d.HeadStmts += pd.HeadStmts
+		d.HeadCovered += pd.HeadCovered
+		d.Packages = append(d.Packages, pd)
+	}
+	return d
+}
+
+func sameMetaHashes(base, head []*Profile) bool {
+	hashes := make(map[[16]byte]int)
+	for _, p := range base {
+		hashes[p.Meta.FileHash] |= 1
+	}
+	for _, p := range head {
+		hashes[p.Meta.FileHash] |= 2
+	}
+	for _, sides := range hashes {
+		if sides != 3 {
+			return false
+		}
+	}
+	return true
+}
+
+func diffPackage(pkgPath string, base, head map[string]*diffFunc) PackageDiff {
+	pd := PackageDiff{PkgPath: pkgPath}
+	names := make(map[string]bool)
+	for n, f := range base {
+		names[n] = true
+		for i, u := range f.desc.Units {
+			pd.BaseStmts += int(u.NumStmt)
+			if f.counts[i] > 0 {
+				pd.BaseCovered += int(u.NumStmt)
+			}
+		}
+	}
+	for n, f := range head {
+		names[n] = true
+		for i, u := range f.desc.Units {
+			pd.HeadStmts += int(u.NumStmt)
+			if f.counts[i] > 0 {
+				pd.HeadCovered += int(u.NumStmt)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(names) {
+		fd := diffFunction(name, base[name], head[name])
+		if len(fd.Units) == 0 {
+			continue
+		}
+		for _, u := range fd.Units {
+			switch u.Kind {
+			case DiffGained:
+				pd.Gained++
+			case DiffLost:
+				pd.Lost++
+			case DiffCountChanged:
+				pd.CountChanged++
+			}
+		}
+		pd.Functions = append(pd.Functions, fd)
+	}
+	sort.SliceStable(pd.Functions, func(i, j int) bool {
+		a, b := pd.Functions[i], pd.Functions[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Units[0].Unit.StartLine < b.Units[0].Unit.StartLine
+	})
+	return pd
+}
+
+// diffFunction compares the units of a function. Either side may be nil.
+func diffFunction(name string, base, head *diffFunc) FuncDiff {
+	fd := FuncDiff{FuncName: name, Added: base == nil, Removed: head == nil}
+	if head != nil {
+		fd.File = head.desc.SrcFile
+	} else {
+		fd.File = base.desc.SrcFile
+	}
+
+	type pair struct {
+		unit       CoverableUnit
+		base, head uint32
+	}
+	var pairs []pair
+	switch {
+	case base != nil && head != nil && len(base.desc.Units) == len(head.desc.Units):
+		// Same shape: the function may have moved, but units correspond.
+		for i, u := range head.desc.Units {
+			pairs = append(pairs, pair{u, base.counts[i], head.counts[i]})
+		}
+	default:
+		byPos := make(map[CoverableUnit]int)
+		if base != nil {
+			for i, u := range base.desc.Units {
+				byPos[u] = len(pairs)
+				pairs = append(pairs, pair{unit: u, base: base.counts[i]})
+			}
+		}
+		if head != nil {
+			for i, u := range head.desc.Units {
+				if j, ok := byPos[u]; ok {
+					pairs[j].head = head.counts[i]
+					continue
+				}
+				pairs = append(pairs, pair{unit: u, head: head.counts[i]})
+			}
+		}
+	}
+
+	for _, p := range pairs {
+		var kind DiffKind
+		switch {
+		case p.base == 0 && p.head > 0:
+			kind = DiffGained
+		case p.base > 0 && p.head == 0:
+			kind = DiffLost
+		case p.base != p.head:
+			kind = DiffCountChanged
+		default:
+			continue
+		}
+		fd.Units = append(fd.Units, UnitDiff{Kind: kind, Unit: p.unit, BaseCount: p.base, HeadCount: p.head})
+	}
+	sort.Slice(fd.Units, func(i, j int) bool {
+		a, b := fd.Units[i].Unit, fd.Units[j].Unit
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		return a.StartCol < b.StartCol
+	})
+	return fd
+}
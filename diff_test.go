@@ -0,0 +1,139 @@
+package covutil
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func diffTestProfile(hash byte, funcs map[string][]CoverableUnit, counts map[string][]uint32) *Profile {
+	p := &Profile{
+		Meta: MetaFile{
+			FileHash: [16]byte{hash},
+			Mode:     ModeCount,
+			Packages: []PackageMeta{{Path: "example.com/a", Name: "a"}},
+		},
+		Counters: make(map[PkgFuncKey][]uint32),
+	}
+	for _, name := range sortedFuncNames(funcs) {
+		p.Meta.Packages[0].Functions = append(p.Meta.Packages[0].Functions, FuncDesc{
+			PackagePath: "example.com/a",
+			FuncName:    name,
+			SrcFile:     "example.com/a/a.go",
+			Units:       funcs[name],
+		})
+		if c, ok := counts[name]; ok {
+			p.Counters[PkgFuncKey{PkgPath: "example.com/a", FuncName: name}] = c
+		}
+	}
+	return p
+}
+
+func sortedFuncNames(funcs map[string][]CoverableUnit) []string {
+	m := make(map[string]bool)
+	for k := range funcs {
+		m[k] = true
+	}
+	return sortedKeys(m)
+}
+
+func TestDiffProfilesSameMeta(t *testing.T) {
+	units := map[string][]CoverableUnit{
+		"F": {{StartLine: 1, EndLine: 2, NumStmt: 2}, {StartLine: 3, EndLine: 4, NumStmt: 1}, {StartLine: 5, EndLine: 6, NumStmt: 1}},
+		"G": {{StartLine: 10, EndLine: 11, NumStmt: 1}},
+	}
+	base := diffTestProfile(1, units, map[string][]uint32{"F": {1, 0, 4}, "G": {2}})
+	head := diffTestProfile(1, units, map[string][]uint32{"F": {0, 3, 4}, "G": {5}})
+
+	d, err := DiffProfiles(base, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.MetaMismatch {
+		t.Errorf("MetaMismatch set for identical meta-data")
+	}
+	if len(d.Packages) != 1 {
+		t.Fatalf("got %d packages, want 1", len(d.Packages))
+	}
+	pd := d.Packages[0]
+	if pd.Gained != 1 || pd.Lost != 1 || pd.CountChanged != 1 {
+		t.Errorf("got gained=%d lost=%d changed=%d, want 1/1/1", pd.Gained, pd.Lost, pd.CountChanged)
+	}
+	if pd.BaseCovered != 4 || pd.HeadCovered != 3 || pd.BaseStmts != 5 {
+		t.Errorf("got base %d/%d head %d/%d", pd.BaseCovered, pd.BaseStmts, pd.HeadCovered, pd.HeadStmts)
+	}
+	if len(pd.Functions) != 2 || pd.Functions[0].FuncName != "F" {
+		t.Fatalf("unexpected functions %+v", pd.Functions)
+	}
+	f := pd.Functions[0]
+	if f.Units[0].Kind != DiffLost || f.Units[1].Kind != DiffGained || f.Units[1].HeadCount != 3 {
+		t.Errorf("unexpected unit diffs %+v", f.Units)
+	}
+	if g := pd.Functions[1]; g.Units[0].Kind != DiffCountChanged || g.Units[0].BaseCount != 2 || g.Units[0].HeadCount != 5 {
+		t.Errorf("unexpected unit diffs for G %+v", g.Units)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Kind":"lost"`) {
+		t.Errorf("JSON does not name diff kinds: %s", data)
+	}
+}
+
+func TestDiffProfilesMetaMismatch(t *testing.T) {
+	base := diffTestProfile(1, map[string][]CoverableUnit{
+		"F":   {{StartLine: 1, EndLine: 2, NumStmt: 1}, {StartLine: 3, EndLine: 4, NumStmt: 1}},
+		"Old": {{StartLine: 20, EndLine: 21, NumStmt: 1}},
+	}, map[string][]uint32{"F": {1, 1}, "Old": {1}})
+	// F gained a unit in head; Old was deleted and New added.
+	head := diffTestProfile(2, map[string][]CoverableUnit{
+		"F":   {{StartLine: 1, EndLine: 2, NumStmt: 1}, {StartLine: 3, EndLine: 4, NumStmt: 1}, {StartLine: 5, EndLine: 6, NumStmt: 1}},
+		"New": {{StartLine: 30, EndLine: 31, NumStmt: 1}},
+	}, map[string][]uint32{"F": {1, 0, 1}, "New": {1}})
+
+	d, err := DiffProfiles(base, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.MetaMismatch {
+		t.Errorf("MetaMismatch not set")
+	}
+	got := make(map[string]string)
+	for _, fd := range d.Packages[0].Functions {
+		var kinds []string
+		for _, u := range fd.Units {
+			kinds = append(kinds, u.Kind.String())
+		}
+		got[fd.FuncName] = strings.Join(kinds, ",")
+		if fd.FuncName == "Old" && !fd.Removed || fd.FuncName == "New" && !fd.Added {
+			t.Errorf("%s: added/removed not reported: %+v", fd.FuncName, fd)
+		}
+	}
+	want := map[string]string{"F": "lost,gained", "Old": "lost", "New": "gained"}
+	for name, kinds := range want {
+		if got[name] != kinds {
+			t.Errorf("%s: got %q, want %q", name, got[name], kinds)
+		}
+	}
+}
+
+func TestDiffSkippedBuilds(t *testing.T) {
+	units := map[string][]CoverableUnit{"F": {{StartLine: 1, EndLine: 2, NumStmt: 1}}}
+	other := map[string][]CoverableUnit{"F": {{StartLine: 1, EndLine: 2, NumStmt: 1}, {StartLine: 3, EndLine: 4, NumStmt: 1}}}
+	base := []*Profile{
+		diffTestProfile(1, units, map[string][]uint32{"F": {1}}),
+		diffTestProfile(2, other, map[string][]uint32{"F": {1, 1}}),
+	}
+	head := []*Profile{
+		diffTestProfile(1, units, map[string][]uint32{"F": {1}}),
+		diffTestProfile(2, other, map[string][]uint32{"F": {0, 0}}), // not executed
+	}
+	d := diffProfiles(base, head)
+	want := []SkippedFunc{{PkgPath: "example.com/a", FuncName: "F", File: "example.com/a/a.go", Executed: 2}}
+	if !reflect.DeepEqual(d.Skipped, want) {
+		t.Errorf("Skipped = %+v, want %+v", d.Skipped, want)
+	}
+}
@@ -1,3 +1,8 @@
+// Package covdup reports tests whose covered lines are all covered by
+// other tests, from the text coverage profiles of a delta directory.
+//
+// Deprecated: Use the testimpact package or "covtree tests", which work on
+// per-test coverage pods.
 package covdup
 
 import (
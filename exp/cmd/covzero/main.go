@@ -1,3 +1,8 @@
+// Package covzero reports tests that cover no lines or few lines, from the
+// text coverage profiles of a delta directory.
+//
+// Deprecated: Use the testimpact package or "covtree tests", which work on
+// per-test coverage pods.
 package covzero
 
 import (
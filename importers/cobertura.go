@@ -0,0 +1,94 @@
+package importers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tmc/covutil"
+)
+
+type coberturaReport struct {
+	Sources  []string `xml:"sources>source"`
+	Packages []struct {
+		Name    string `xml:"name,attr"`
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Methods  []struct {
+				Name  string          `xml:"name,attr"`
+				Lines []coberturaLine `xml:"lines>line"`
+			} `xml:"methods>method"`
+			Lines []coberturaLine `xml:"lines>line"`
+		} `xml:"classes>class"`
+	} `xml:"packages>package"`
+}
+
+type coberturaLine struct {
+	Number uint32  `xml:"number,attr"`
+	Hits   float64 `xml:"hits,attr"`
+}
+
+// parseCobertura parses a Cobertura XML report. Each class contributes
+// the lines of its source file; lines listed under a method are
+// attributed to it, and the remaining class lines to TopLevelFunc.
+// Classes sharing a file, as with Java inner classes, are combined.
+//
+// Relative class filenames are joined to the first root listed in the
+// report's <sources> element, so that files line up with those of reports
+// that record full paths, such as LCOV tracefiles.
+func parseCobertura(data []byte) (*builder, error) {
+	var report coberturaReport
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&report); err != nil {
+		return nil, err
+	}
+	var root string
+	if len(report.Sources) > 0 {
+		root = strings.TrimSpace(report.Sources[0])
+	}
+	filename := func(name string) string {
+		if root == "" || path.IsAbs(name) {
+			return name
+		}
+		return path.Join(root, name)
+	}
+
+	b := newBuilder(covutil.ModeCount)
+	type lineKey struct {
+		file string
+		line uint32
+	}
+	hits := make(map[lineKey]float64)
+	owner := make(map[lineKey]string)
+	for _, pkg := range report.Packages {
+		for _, cls := range pkg.Classes {
+			if cls.Filename == "" {
+				return nil, fmt.Errorf("class in package %q has no filename", pkg.Name)
+			}
+			file := filename(cls.Filename)
+			for _, m := range cls.Methods {
+				for _, l := range m.Lines {
+					k := lineKey{file, l.Number}
+					hits[k] = max(hits[k], l.Hits)
+					if _, ok := owner[k]; !ok {
+						owner[k] = m.Name
+					}
+				}
+			}
+			for _, l := range cls.Lines {
+				k := lineKey{file, l.Number}
+				hits[k] = max(hits[k], l.Hits)
+			}
+		}
+	}
+
+	for k, n := range hits {
+		fn := owner[k]
+		if fn == "" {
+			fn = TopLevelFunc
+		}
+		b.addLine(k.file, fn, k.line, saturate(0, n))
+	}
+	return b, nil
+}
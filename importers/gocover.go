@@ -0,0 +1,190 @@
+package importers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/covutil"
+)
+
+// parseGoCover parses a text profile:
+//
+//	mode: set
+//	example.com/m/a/a.go:3.24,5.2 2 1
+//
+// Each line names a block as file:startLine.startCol,endLine.endCol
+// followed by its statement count and execution count.
+func parseGoCover(data []byte, opts *Options) (*builder, error) {
+	var b *builder
+	funcs := make(map[string]*goFuncIndex)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(trimCR(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if b == nil {
+			modeStr, ok := strings.CutPrefix(line, "mode:")
+			if !ok {
+				return nil, fmt.Errorf("line %d: missing mode line", lineNo)
+			}
+			mode, err := parseMode(strings.TrimSpace(modeStr))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			b = newBuilder(mode)
+			continue
+		}
+		if strings.HasPrefix(line, "mode:") {
+			// Concatenated profiles repeat the mode line.
+			continue
+		}
+		file, blk, count, err := parseGoCoverLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		idx, ok := funcs[file]
+		if !ok {
+			idx = loadGoFuncIndex(file, opts)
+			funcs[file] = idx
+		}
+		b.add(file, idx.funcAt(file, blk.startLine), blk, count)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("empty profile")
+	}
+	return b, nil
+}
+
+func parseMode(s string) (covutil.CounterMode, error) {
+	switch s {
+	case "set":
+		return covutil.ModeSet, nil
+	case "count":
+		return covutil.ModeCount, nil
+	case "atomic":
+		return covutil.ModeAtomic, nil
+	}
+	return covutil.ModeInvalid, fmt.Errorf("unknown mode %q", s)
+}
+
+// parseGoCoverLine parses "file:sl.sc,el.ec nstmt count".
+func parseGoCoverLine(line string) (file string, blk block, count uint32, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", block{}, 0, fmt.Errorf("malformed block %q", line)
+	}
+	i := strings.LastIndexByte(fields[0], ':')
+	if i < 0 {
+		return "", block{}, 0, fmt.Errorf("malformed block %q", line)
+	}
+	file = fields[0][:i]
+	var n int
+	n, err = fmt.Sscanf(fields[0][i+1:], "%d.%d,%d.%d", &blk.startLine, &blk.startCol, &blk.endLine, &blk.endCol)
+	if err != nil || n != 4 {
+		return "", block{}, 0, fmt.Errorf("malformed block position %q", fields[0][i+1:])
+	}
+	stmts, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return "", block{}, 0, fmt.Errorf("malformed statement count %q", fields[1])
+	}
+	blk.numStmt = uint32(stmts)
+	c, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return "", block{}, 0, fmt.Errorf("malformed count %q", fields[2])
+	}
+	return file, blk, uint32(c), nil
+}
+
+// goFuncIndex records the line extents of the functions declared in a Go
+// source file.
+type goFuncIndex struct {
+	funcs []goFunc
+}
+
+type goFunc struct {
+	name       string
+	start, end uint32
+}
+
+// loadGoFuncIndex parses the source of file, if opts.Resolver can find
+// it. It returns an empty index otherwise.
+func loadGoFuncIndex(file string, opts *Options) *goFuncIndex {
+	idx := &goFuncIndex{}
+	if opts.Resolver == nil {
+		return idx
+	}
+	src, err := opts.Resolver.ReadSource(path.Dir(file), file)
+	if err != nil {
+		return idx
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.SkipObjectResolution)
+	if err != nil {
+		return idx
+	}
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		idx.funcs = append(idx.funcs, goFunc{
+			name:  goFuncName(fd),
+			start: uint32(fset.Position(fd.Pos()).Line),
+			end:   uint32(fset.Position(fd.End()).Line),
+		})
+	}
+	return idx
+}
+
+// goFuncName returns the name of fd, qualified by its receiver type for
+// methods, as in "T.M".
+func goFuncName(fd *ast.FuncDecl) string {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 {
+		return fd.Name.Name
+	}
+	typ := fd.Recv.List[0].Type
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+			continue
+		case *ast.IndexExpr:
+			typ = t.X
+			continue
+		case *ast.IndexListExpr:
+			typ = t.X
+			continue
+		case *ast.Ident:
+			return t.Name + "." + fd.Name.Name
+		}
+		return fd.Name.Name
+	}
+}
+
+// funcAt returns the name of the function containing line. Blocks outside
+// any known function are attributed to a function named after the file.
+func (idx *goFuncIndex) funcAt(file string, line uint32) string {
+	for _, f := range idx.funcs {
+		if f.start <= line && line <= f.end {
+			return f.name
+		}
+	}
+	if len(idx.funcs) > 0 {
+		return TopLevelFunc
+	}
+	return path.Base(file)
+}
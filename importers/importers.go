@@ -0,0 +1,369 @@
+// Package importers converts coverage reports written by other tools into
+// covutil Pods, so that they can be merged, filtered and browsed with the
+// same CoverageSet and covtree APIs as native Go coverage data.
+//
+// Three formats are supported:
+//
+//   - gocover: the text profile written by "go test -coverprofile"
+//   - LCOV: tracefiles as written by lcov, c8, nyc, coverage.py and others
+//   - Cobertura: XML reports as read by most CI dashboards
+//
+// Each report is turned into a Profile with a synthesized MetaFile: files
+// are grouped into packages by directory, lines are grouped into functions
+// where the report names them, and every coverage block (gocover) or
+// source line (LCOV, Cobertura) becomes a coverable unit with its hit count
+// as counter. The meta-data hash is computed from the synthesized layout
+// the same way the Go runtime computes it, so importing two reports for
+// the same sources yields profiles that covutil.MergeProfiles accepts.
+//
+// Typical use:
+//
+//	set, err := importers.LoadFiles("cover.out", "js/lcov.info", "py/coverage.xml")
+//	...
+//	tree := covtree.NewCoverageTreeFromProfile(set.Pods[0].Profile)
+package importers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/covtree"
+)
+
+// Format identifies a coverage report format.
+type Format string
+
+const (
+	FormatGoCover   Format = "gocover"
+	FormatLCOV      Format = "lcov"
+	FormatCobertura Format = "cobertura"
+)
+
+// FormatLabel is the pod label recording the format a pod was imported from.
+const FormatLabel = "import_format"
+
+// TopLevelFunc names the synthesized function holding lines that a report
+// does not attribute to any function.
+const TopLevelFunc = "(top-level)"
+
+// lineEndCol is the end column of units synthesized for whole source
+// lines. It lies past the end of any realistic line; covtree clamps it to
+// the line's length.
+const lineEndCol = 1 << 16
+
+// Options configures an import.
+type Options struct {
+	// ID is the ID of the resulting pod. If empty, an ID is derived from
+	// the format and the meta-data hash.
+	ID string
+	// Labels are added to the pod's labels.
+	Labels map[string]string
+	// Timestamp is recorded on the pod. ReadFile defaults it to the file's
+	// modification time.
+	Timestamp time.Time
+	// Resolver, if set, is used to read the Go sources named in gocover
+	// profiles so that blocks can be attributed to the functions that
+	// contain them. Without it, or if a source file cannot be read, all
+	// blocks of a file are attributed to a single function named after
+	// the file.
+	Resolver *covtree.SourceResolver
+}
+
+// DetectFormat guesses the format of a coverage report from its contents.
+func DetectFormat(data []byte) (Format, error) {
+	trimmed := bytes.TrimLeft(data, " \t\r\n\ufeff")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		return FormatGoCover, nil
+	case bytes.HasPrefix(trimmed, []byte("<")) && bytes.Contains(trimmed, []byte("<coverage")):
+		return FormatCobertura, nil
+	case bytes.HasPrefix(trimmed, []byte("TN:")) || bytes.HasPrefix(trimmed, []byte("SF:")) ||
+		bytes.Contains(trimmed, []byte("\nSF:")):
+		return FormatLCOV, nil
+	}
+	return "", fmt.Errorf("unrecognized coverage report format")
+}
+
+// Read imports a coverage report in the given format. If format is empty,
+// it is detected from the contents.
+func Read(r io.Reader, format Format, opts *Options) (*covutil.Pod, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		if format, err = DetectFormat(data); err != nil {
+			return nil, err
+		}
+	}
+	var b *builder
+	switch format {
+	case FormatGoCover:
+		b, err = parseGoCover(data, opts)
+	case FormatLCOV:
+		b, err = parseLCOV(data)
+	case FormatCobertura:
+		b, err = parseCobertura(data)
+	default:
+		return nil, fmt.Errorf("unknown coverage report format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s report: %w", format, err)
+	}
+	return b.pod(format, opts)
+}
+
+// ReadGoCover imports a text profile as written by "go test -coverprofile".
+func ReadGoCover(r io.Reader, opts *Options) (*covutil.Pod, error) {
+	return Read(r, FormatGoCover, opts)
+}
+
+// ReadLCOV imports an LCOV tracefile.
+func ReadLCOV(r io.Reader, opts *Options) (*covutil.Pod, error) {
+	return Read(r, FormatLCOV, opts)
+}
+
+// ReadCobertura imports a Cobertura XML report.
+func ReadCobertura(r io.Reader, opts *Options) (*covutil.Pod, error) {
+	return Read(r, FormatCobertura, opts)
+}
+
+// ReadFile imports the coverage report in the named file, detecting its
+// format from the contents.
+func ReadFile(name string, opts *Options) (*covutil.Pod, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timestamp.IsZero() {
+		if fi, err := f.Stat(); err == nil {
+			o.Timestamp = fi.ModTime()
+		}
+	}
+	if o.ID == "" {
+		// Pod IDs name directories in the CoverageSet file system.
+		o.ID = strings.ReplaceAll(filepath.ToSlash(filepath.Clean(name)), "/", "_")
+	}
+	pod, err := Read(f, "", &o)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return pod, nil
+}
+
+// LoadFiles imports each named coverage report into a pod of a new
+// CoverageSet.
+func LoadFiles(names ...string) (*covutil.CoverageSet, error) {
+	set := &covutil.CoverageSet{}
+	for _, name := range names {
+		pod, err := ReadFile(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		set.Pods = append(set.Pods, pod)
+	}
+	return set, nil
+}
+
+// builder accumulates the blocks of a report before they are turned into
+// a Profile.
+type builder struct {
+	mode     covutil.CounterMode
+	files    map[string]*fileData
+	testName string
+}
+
+type fileData struct {
+	funcs map[string]*funcData
+}
+
+type funcData struct {
+	blocks map[block]uint32
+}
+
+type block struct {
+	startLine, startCol, endLine, endCol, numStmt uint32
+}
+
+func newBuilder(mode covutil.CounterMode) *builder {
+	return &builder{mode: mode, files: make(map[string]*fileData)}
+}
+
+// add records count executions of blk, attributed to function fn of file.
+// Repeated blocks are combined as go tool cover does: or-ed in set mode
+// and summed otherwise.
+func (b *builder) add(file, fn string, blk block, count uint32) {
+	fd := b.files[file]
+	if fd == nil {
+		fd = &fileData{funcs: make(map[string]*funcData)}
+		b.files[file] = fd
+	}
+	f := fd.funcs[fn]
+	if f == nil {
+		f = &funcData{blocks: make(map[block]uint32)}
+		fd.funcs[fn] = f
+	}
+	old, seen := f.blocks[blk]
+	switch {
+	case !seen:
+		f.blocks[blk] = count
+	case b.mode == covutil.ModeSet:
+		if count > 0 {
+			f.blocks[blk] = 1
+		}
+	default:
+		if sum := old + count; sum >= old {
+			f.blocks[blk] = sum
+		} else {
+			f.blocks[blk] = ^uint32(0)
+		}
+	}
+}
+
+// addLine records a whole-line block.
+func (b *builder) addLine(file, fn string, line, count uint32) {
+	b.add(file, fn, block{line, 1, line, lineEndCol, 1}, count)
+}
+
+// profile builds a Profile from the accumulated blocks. Files are grouped
+// into packages by directory; functions within a package are ordered by
+// file and position, and units by position.
+func (b *builder) profile() (*covutil.Profile, error) {
+	byPkg := make(map[string][]string)
+	for file := range b.files {
+		dir := path.Dir(filepath.ToSlash(file))
+		byPkg[dir] = append(byPkg[dir], file)
+	}
+	pkgPaths := make([]string, 0, len(byPkg))
+	for p := range byPkg {
+		pkgPaths = append(pkgPaths, p)
+	}
+	sort.Strings(pkgPaths)
+
+	p := &covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode:        b.mode,
+			Granularity: covutil.GranularityBlock,
+		},
+		Counters: make(map[covutil.PkgFuncKey][]uint32),
+		Args:     make(map[string]string),
+	}
+	for _, pkgPath := range pkgPaths {
+		pm := covutil.PackageMeta{Path: pkgPath, Name: path.Base(pkgPath)}
+		files := byPkg[pkgPath]
+		sort.Strings(files)
+
+		type fn struct {
+			file, name string
+			units      []covutil.CoverableUnit
+			counts     []uint32
+		}
+		var fns []fn
+		for _, file := range files {
+			for name, fd := range b.files[file].funcs {
+				blocks := make([]block, 0, len(fd.blocks))
+				for blk := range fd.blocks {
+					blocks = append(blocks, blk)
+				}
+				sort.Slice(blocks, func(i, j int) bool {
+					if blocks[i].startLine != blocks[j].startLine {
+						return blocks[i].startLine < blocks[j].startLine
+					}
+					return blocks[i].startCol < blocks[j].startCol
+				})
+				f := fn{file: file, name: name}
+				for _, blk := range blocks {
+					f.units = append(f.units, covutil.CoverableUnit{
+						StartLine: blk.startLine, StartCol: blk.startCol,
+						EndLine: blk.endLine, EndCol: blk.endCol,
+						NumStmt: blk.numStmt,
+					})
+					f.counts = append(f.counts, fd.blocks[blk])
+				}
+				fns = append(fns, f)
+			}
+		}
+		sort.Slice(fns, func(i, j int) bool {
+			if fns[i].file != fns[j].file {
+				return fns[i].file < fns[j].file
+			}
+			return fns[i].units[0].StartLine < fns[j].units[0].StartLine
+		})
+
+		// Counters are keyed by package and function name, so names
+		// repeated across the files of a package are qualified.
+		seen := make(map[string]bool)
+		for _, f := range fns {
+			name := f.name
+			if seen[name] {
+				name = path.Base(f.file) + ":" + name
+			}
+			seen[name] = true
+			pm.Functions = append(pm.Functions, covutil.FuncDesc{
+				PackagePath: pkgPath,
+				FuncName:    name,
+				SrcFile:     f.file,
+				Units:       f.units,
+			})
+			p.Counters[covutil.PkgFuncKey{PkgPath: pkgPath, FuncName: name}] = f.counts
+		}
+		p.Meta.Packages = append(p.Meta.Packages, pm)
+	}
+
+	hash, err := covutil.EncodeMetaFile(io.Discard, &p.Meta)
+	if err != nil {
+		return nil, err
+	}
+	p.Meta.FileHash = hash
+	return p, nil
+}
+
+// pod wraps the accumulated blocks in a Pod.
+func (b *builder) pod(format Format, opts *Options) (*covutil.Pod, error) {
+	if len(b.files) == 0 {
+		return nil, fmt.Errorf("no coverage data in %s report", format)
+	}
+	p, err := b.profile()
+	if err != nil {
+		return nil, err
+	}
+	p.Args[FormatLabel] = string(format)
+
+	pod := &covutil.Pod{
+		ID:        opts.ID,
+		Profile:   p,
+		Labels:    map[string]string{FormatLabel: string(format)},
+		Timestamp: opts.Timestamp,
+	}
+	if pod.ID == "" {
+		pod.ID = fmt.Sprintf("%s-%x", format, p.Meta.FileHash[:8])
+	}
+	if b.testName != "" {
+		pod.Labels[covutil.TestNameLabel] = b.testName
+	}
+	for k, v := range opts.Labels {
+		pod.Labels[k] = v
+	}
+	return pod, nil
+}
+
+// trimCR removes a trailing carriage return left by CRLF line endings.
+func trimCR(line string) string {
+	return strings.TrimSuffix(line, "\r")
+}
@@ -0,0 +1,277 @@
+package importers
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/covtree"
+)
+
+const goSrc = `package a
+
+func Add(x, y int) int {
+	if x < 0 {
+		return 0
+	}
+	return x + y
+}
+
+type T struct{}
+
+func (t *T) M() {}
+`
+
+const goProfile = `mode: count
+example.com/mod/a/a.go:3.24,4.11 1 2
+example.com/mod/a/a.go:4.11,6.3 1 0
+example.com/mod/a/a.go:7.2,7.14 1 2
+example.com/mod/a/a.go:12.17,12.18 0 0
+example.com/mod/a/a.go:3.24,4.11 1 3
+`
+
+// funcCounts returns the counters of p keyed by "pkg.func".
+func funcCounts(p *covutil.Profile) map[string][]uint32 {
+	m := make(map[string][]uint32)
+	for k, v := range p.Counters {
+		m[k.PkgPath+"."+k.FuncName] = v
+	}
+	return m
+}
+
+func TestReadGoCover(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "a.go"), []byte(goSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := covtree.NewSourceResolver()
+	r.AddModule("example.com/mod", dir)
+
+	pod, err := ReadGoCover(strings.NewReader(goProfile), &Options{Resolver: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pod.Profile
+	if p.Meta.Mode != covutil.ModeCount {
+		t.Errorf("mode = %v, want count", p.Meta.Mode)
+	}
+	if len(p.Meta.Packages) != 1 || p.Meta.Packages[0].Path != "example.com/mod/a" || p.Meta.Packages[0].Name != "a" {
+		t.Fatalf("packages = %+v", p.Meta.Packages)
+	}
+	want := map[string][]uint32{
+		"example.com/mod/a.Add": {5, 0, 2},
+		"example.com/mod/a.T.M": {0},
+	}
+	if got := funcCounts(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("counters = %v, want %v", got, want)
+	}
+	if pod.Labels[FormatLabel] != "gocover" {
+		t.Errorf("labels = %v", pod.Labels)
+	}
+
+	// The hash is that of the synthesized meta-data, so the profile
+	// round-trips through the binary format.
+	covDir := t.TempDir()
+	if err := covutil.WriteProfileToDirectory(covDir, p); err != nil {
+		t.Fatal(err)
+	}
+	tree := covtree.NewCoverageTree()
+	if err := tree.LoadFromDirectory(covDir); err != nil {
+		t.Fatal(err)
+	}
+	if pkg := tree.GetPackage("example.com/mod/a"); pkg == nil || len(pkg.Functions) != 2 {
+		t.Errorf("round-tripped tree: %+v", tree.Packages)
+	}
+}
+
+func TestReadGoCoverNoSource(t *testing.T) {
+	pod, err := ReadGoCover(strings.NewReader(goProfile), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]uint32{"example.com/mod/a.a.go": {5, 0, 2, 0}}
+	if got := funcCounts(pod.Profile); !reflect.DeepEqual(got, want) {
+		t.Errorf("counters = %v, want %v", got, want)
+	}
+
+	if _, err := ReadGoCover(strings.NewReader("mode: set\nbad line\n"), nil); err == nil {
+		t.Error("expected error for malformed block")
+	}
+}
+
+const lcovReport = `TN:unit
+SF:src/lib/math.js
+FN:1,add
+FN:5,sub
+FNDA:2,add
+FNDA:0,sub
+DA:1,2
+DA:2,2
+DA:5,0
+DA:6,0
+LF:4
+LH:2
+end_of_record
+TN:unit
+SF:src/index.js
+DA:1,1
+DA:2,0
+end_of_record
+`
+
+func TestReadLCOV(t *testing.T) {
+	pod, err := Read(strings.NewReader(lcovReport), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]uint32{
+		"src/lib.add":         {2, 2},
+		"src/lib.sub":         {0, 0},
+		"src." + TopLevelFunc: {1, 0},
+	}
+	if got := funcCounts(pod.Profile); !reflect.DeepEqual(got, want) {
+		t.Errorf("counters = %v, want %v", got, want)
+	}
+	if got := covutil.PodTestName(pod); got != "unit" {
+		t.Errorf("test name = %q, want unit", got)
+	}
+
+	// Reports for the same sources can be merged.
+	again := strings.ReplaceAll(lcovReport, "DA:6,0", "DA:6,4")
+	pod2, err := ReadLCOV(strings.NewReader(again), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := covutil.MergeProfiles(pod.Profile, pod2.Profile)
+	if err != nil {
+		t.Fatalf("MergeProfiles: %v", err)
+	}
+	if got := funcCounts(merged)["src/lib.sub"]; !reflect.DeepEqual(got, []uint32{0, 4}) {
+		t.Errorf("merged sub = %v, want [0 4]", got)
+	}
+}
+
+func TestReadCobertura(t *testing.T) {
+	// Export a tree as Cobertura and import it back.
+	tree := covtree.NewCoverageTreeFromProfile(&covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode: covutil.ModeCount,
+			Packages: []covutil.PackageMeta{{
+				Path: "example.com/mod/a",
+				Name: "a",
+				Functions: []covutil.FuncDesc{{
+					FuncName: "Add",
+					SrcFile:  "example.com/mod/a/a.go",
+					Units: []covutil.CoverableUnit{
+						{StartLine: 3, StartCol: 24, EndLine: 4, EndCol: 11, NumStmt: 1},
+						{StartLine: 5, StartCol: 3, EndLine: 5, EndCol: 11, NumStmt: 1},
+					},
+				}},
+			}},
+		},
+		Counters: map[covutil.PkgFuncKey][]uint32{
+			{PkgPath: "example.com/mod/a", FuncName: "Add"}: {7, 0},
+		},
+	})
+	var buf bytes.Buffer
+	if err := covtree.WriteCobertura(&buf, tree, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := DetectFormat(buf.Bytes()); err != nil || f != FormatCobertura {
+		t.Fatalf("DetectFormat = %q, %v", f, err)
+	}
+
+	pod, err := ReadCobertura(&buf, &Options{ID: "ci", Labels: map[string]string{"os": "linux"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]uint32{"example.com/mod/a.Add": {7, 7, 0}}
+	if got := funcCounts(pod.Profile); !reflect.DeepEqual(got, want) {
+		t.Errorf("counters = %v, want %v", got, want)
+	}
+	if pod.ID != "ci" || pod.Labels["os"] != "linux" || pod.Labels[FormatLabel] != "cobertura" {
+		t.Errorf("pod = %+v", pod)
+	}
+}
+
+func TestReadCoberturaSources(t *testing.T) {
+	const report = `<?xml version="1.0" ?>
+<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">
+<coverage line-rate="0.5">
+	<sources>
+		<source>/home/ci/proj</source>
+		<source>/ignored</source>
+	</sources>
+	<packages>
+		<package name="lib">
+			<classes>
+				<class name="math.py" filename="lib/math.py">
+					<methods/>
+					<lines>
+						<line number="1" hits="3"/>
+						<line number="2" hits="0"/>
+					</lines>
+				</class>
+			</classes>
+		</package>
+	</packages>
+</coverage>
+`
+	pod, err := ReadCobertura(strings.NewReader(report), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]uint32{"/home/ci/proj/lib." + TopLevelFunc: {3, 0}}
+	if got := funcCounts(pod.Profile); !reflect.DeepEqual(got, want) {
+		t.Errorf("counters = %v, want %v", got, want)
+	}
+
+	malformed := strings.Replace(report, "</classes>", "", 1)
+	if _, err := ReadCobertura(strings.NewReader(malformed), nil); err == nil {
+		t.Error("expected error for malformed XML")
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cover.out": goProfile,
+		"lcov.info": lcovReport,
+	}
+	var names []string
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, p)
+	}
+	set, err := LoadFiles(names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Pods) != 2 {
+		t.Fatalf("got %d pods, want 2", len(set.Pods))
+	}
+	js, err := set.FilterByPath("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(js.Pods) != 1 || js.Pods[0].Labels[FormatLabel] != "lcov" {
+		t.Errorf("FilterByPath(src) = %+v", js.Pods)
+	}
+
+	if _, err := LoadFiles(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing file")
+	}
+	if _, err := DetectFormat([]byte("hello")); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
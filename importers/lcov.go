@@ -0,0 +1,155 @@
+package importers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/covutil"
+)
+
+// lcovFunc is a function declared by an FN record.
+type lcovFunc struct {
+	name       string
+	start, end uint32 // end is 0 if the record gives no end line
+}
+
+// parseLCOV parses an LCOV tracefile. Each SF ... end_of_record section
+// describes one source file: FN records declare functions by start line
+// (and, in newer versions, end line), and DA records give per-line hit
+// counts. Branch and summary records are ignored; they are derived from
+// the line data. Lines are attributed to the function that starts closest
+// before them, or to TopLevelFunc.
+func parseLCOV(data []byte) (*builder, error) {
+	b := newBuilder(covutil.ModeCount)
+	tests := make(map[string]bool)
+
+	var (
+		file  string
+		funcs []lcovFunc
+		lines map[uint32]uint32
+	)
+	flush := func() {
+		sort.SliceStable(funcs, func(i, j int) bool { return funcs[i].start < funcs[j].start })
+		for line, count := range lines {
+			b.addLine(file, lcovFuncAt(funcs, line), line, count)
+		}
+		file, funcs, lines = "", nil, nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(trimCR(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if line == "end_of_record" {
+			if file == "" {
+				return nil, fmt.Errorf("line %d: end_of_record outside of a record", lineNo)
+			}
+			flush()
+			continue
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: malformed record %q", lineNo, line)
+		}
+		switch key {
+		case "TN":
+			if val != "" {
+				tests[val] = true
+			}
+		case "SF":
+			if file != "" {
+				// A missing end_of_record; be lenient.
+				flush()
+			}
+			file, lines = val, make(map[uint32]uint32)
+		case "FN":
+			if file == "" {
+				return nil, fmt.Errorf("line %d: FN record outside of a file", lineNo)
+			}
+			// FN:<start>,<name> or FN:<start>,<end>,<name>
+			parts := strings.SplitN(val, ",", 3)
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("line %d: malformed FN record %q", lineNo, line)
+			}
+			start, err := strconv.ParseUint(parts[0], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: malformed FN record %q", lineNo, line)
+			}
+			fn := lcovFunc{name: parts[len(parts)-1], start: uint32(start)}
+			if len(parts) == 3 {
+				if end, err := strconv.ParseUint(parts[1], 10, 32); err == nil {
+					fn.end = uint32(end)
+				} else {
+					// A function name containing a comma.
+					fn.name = parts[1] + "," + parts[2]
+				}
+			}
+			funcs = append(funcs, fn)
+		case "DA":
+			if file == "" {
+				return nil, fmt.Errorf("line %d: DA record outside of a file", lineNo)
+			}
+			// DA:<line>,<count>[,<checksum>]
+			parts := strings.Split(val, ",")
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("line %d: malformed DA record %q", lineNo, line)
+			}
+			ln, err1 := strconv.ParseUint(parts[0], 10, 32)
+			// Some tools write negative or fractional counts.
+			count, err2 := strconv.ParseFloat(parts[1], 64)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("line %d: malformed DA record %q", lineNo, line)
+			}
+			lines[uint32(ln)] = saturate(lines[uint32(ln)], count)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if file != "" {
+		flush()
+	}
+	if len(tests) == 1 {
+		for name := range tests {
+			b.testName = name
+		}
+	}
+	return b, nil
+}
+
+// lcovFuncAt returns the name of the function containing line. funcs must
+// be sorted by start line.
+func lcovFuncAt(funcs []lcovFunc, line uint32) string {
+	name := TopLevelFunc
+	for _, fn := range funcs {
+		if fn.start > line {
+			break
+		}
+		if fn.end == 0 || line <= fn.end {
+			name = fn.name
+		}
+	}
+	return name
+}
+
+// saturate adds a non-negative float count to n, clamping at the largest
+// counter value.
+func saturate(n uint32, count float64) uint32 {
+	if count <= 0 {
+		return n
+	}
+	sum := float64(n) + count
+	if sum >= float64(^uint32(0)) {
+		return ^uint32(0)
+	}
+	return uint32(sum)
+}
@@ -0,0 +1,253 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/covtree"
+	"github.com/tmc/covutil/internal/testenv"
+)
+
+// testProfile returns an in-memory profile covering two packages.
+func testProfile(counts map[covutil.PkgFuncKey][]uint32) *covutil.Profile {
+	return &covutil.Profile{
+		Meta: covutil.MetaFile{
+			Mode:        covutil.ModeCount,
+			Granularity: covutil.GranularityBlock,
+			Packages: []covutil.PackageMeta{
+				{
+					Path:       "example.com/mod/a",
+					Name:       "a",
+					ModulePath: "example.com/mod",
+					Functions: []covutil.FuncDesc{
+						{
+							PackagePath: "example.com/mod/a",
+							FuncName:    "Add",
+							SrcFile:     "example.com/mod/a/a.go",
+							Units: []covutil.CoverableUnit{
+								{StartLine: 3, StartCol: 23, EndLine: 4, EndCol: 14, NumStmt: 1},
+								{StartLine: 4, StartCol: 14, EndLine: 6, EndCol: 3, NumStmt: 1},
+								{StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 14, NumStmt: 1},
+							},
+						},
+						{
+							PackagePath: "example.com/mod/a",
+							FuncName:    "Add.func1",
+							SrcFile:     "example.com/mod/a/a.go",
+							IsLiteral:   true,
+							Units: []covutil.CoverableUnit{
+								{StartLine: 9, StartCol: 10, EndLine: 11, EndCol: 3, NumStmt: 2},
+							},
+						},
+					},
+				},
+				{
+					Path:       "example.com/mod/b",
+					Name:       "b",
+					ModulePath: "example.com/mod",
+					Functions: []covutil.FuncDesc{
+						{
+							PackagePath: "example.com/mod/b",
+							FuncName:    "Hello",
+							SrcFile:     "example.com/mod/b/b.go",
+							Units: []covutil.CoverableUnit{
+								{StartLine: 5, StartCol: 20, EndLine: 7, EndCol: 2, NumStmt: 2},
+							},
+						},
+					},
+				},
+			},
+		},
+		Counters: counts,
+		Args:     map[string]string{"GOOS": "linux", "GOARCH": "amd64"},
+	}
+}
+
+var (
+	keyAdd   = covutil.PkgFuncKey{PkgPath: "example.com/mod/a", FuncName: "Add"}
+	keyFunc1 = covutil.PkgFuncKey{PkgPath: "example.com/mod/a", FuncName: "Add.func1"}
+	keyHello = covutil.PkgFuncKey{PkgPath: "example.com/mod/b", FuncName: "Hello"}
+)
+
+func loadSingleProfile(t *testing.T, dir string) *covutil.Profile {
+	t.Helper()
+	set, err := covutil.LoadCoverageSet(os.DirFS(dir))
+	if err != nil {
+		t.Fatalf("LoadCoverageSet(%s): %v", dir, err)
+	}
+	if len(set.Pods) != 1 {
+		t.Fatalf("LoadCoverageSet(%s): got %d pods, want 1", dir, len(set.Pods))
+	}
+	return set.Pods[0].Profile
+}
+
+// metaWithoutPath strips fields that legitimately differ after a round trip.
+func metaWithoutPath(m covutil.MetaFile) covutil.MetaFile {
+	m.FilePath = ""
+	return m
+}
+
+func TestProfileRoundTrip(t *testing.T) {
+	want := testProfile(map[covutil.PkgFuncKey][]uint32{
+		keyAdd:   {3, 0, 7},
+		keyFunc1: {2},
+	})
+
+	dir := t.TempDir()
+	if err := covutil.WriteProfileToDirectory(dir, want); err != nil {
+		t.Fatalf("WriteProfileToDirectory: %v", err)
+	}
+	got := loadSingleProfile(t, dir)
+
+	wantMeta := metaWithoutPath(want.Meta)
+	gotMeta := metaWithoutPath(got.Meta)
+	wantMeta.FileHash = gotMeta.FileHash
+	if !reflect.DeepEqual(gotMeta, wantMeta) {
+		t.Errorf("meta mismatch:\ngot  %+v\nwant %+v", gotMeta, wantMeta)
+	}
+	if !reflect.DeepEqual(got.Counters, want.Counters) {
+		t.Errorf("counters mismatch:\ngot  %v\nwant %v", got.Counters, want.Counters)
+	}
+	if got.Args["GOOS"] != "linux" || got.Args["GOARCH"] != "amd64" {
+		t.Errorf("args not preserved: %v", got.Args)
+	}
+
+	// Writing the loaded profile again must reproduce the same meta hash,
+	// so that rewritten pods stay compatible with the originals.
+	dir2 := t.TempDir()
+	if err := covutil.WriteProfileToDirectory(dir2, got); err != nil {
+		t.Fatalf("rewriting profile: %v", err)
+	}
+	again := loadSingleProfile(t, dir2)
+	if again.Meta.FileHash != got.Meta.FileHash {
+		t.Errorf("meta hash changed on rewrite: %x != %x", again.Meta.FileHash, got.Meta.FileHash)
+	}
+}
+
+func TestEncodeMetaFileDeterministic(t *testing.T) {
+	p := testProfile(nil)
+	var b1, b2 bytes.Buffer
+	h1, err := covutil.EncodeMetaFile(&b1, &p.Meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := covutil.EncodeMetaFile(&b2, &p.Meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 != h2 || !bytes.Equal(b1.Bytes(), b2.Bytes()) {
+		t.Errorf("encoding the same meta-data twice produced different output")
+	}
+
+	p.Meta.Packages[1].Functions[0].Units[0].EndLine++
+	var b3 bytes.Buffer
+	h3, err := covutil.EncodeMetaFile(&b3, &p.Meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h3 == h1 {
+		t.Errorf("different meta-data produced the same hash %x", h1)
+	}
+}
+
+func TestDerivedProfileRoundTrip(t *testing.T) {
+	p1 := testProfile(map[covutil.PkgFuncKey][]uint32{keyAdd: {1, 0, 1}})
+	p2 := testProfile(map[covutil.PkgFuncKey][]uint32{keyAdd: {2, 5, 0}, keyHello: {4}})
+
+	merged, err := covutil.MergeProfiles(p1, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtracted, err := covutil.SubtractProfile(p2, p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		profile *covutil.Profile
+		want    map[covutil.PkgFuncKey][]uint32
+	}{
+		{"merge", merged, map[covutil.PkgFuncKey][]uint32{keyAdd: {3, 5, 1}, keyHello: {4}}},
+		{"subtract", subtracted, map[covutil.PkgFuncKey][]uint32{keyAdd: {0, 5, 0}, keyHello: {4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := covutil.WriteProfileToDirectory(dir, tt.profile); err != nil {
+				t.Fatal(err)
+			}
+			got := loadSingleProfile(t, dir)
+			if !reflect.DeepEqual(got.Counters, tt.want) {
+				t.Errorf("counters mismatch:\ngot  %v\nwant %v", got.Counters, tt.want)
+			}
+
+			// covtree reads the same files through its own loader.
+			tree := covtree.NewCoverageTree()
+			if err := tree.LoadFromDirectory(dir); err != nil {
+				t.Fatalf("covtree: %v", err)
+			}
+			if pkg := tree.GetPackage("example.com/mod/b"); pkg == nil || pkg.CoveredLines != 3 {
+				t.Errorf("covtree package b: got %+v, want 3 covered lines", pkg)
+			}
+		})
+	}
+}
+
+func TestWrittenProfileWithCovdata(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	gotool := testenv.GoToolPath(t)
+
+	p := testProfile(map[covutil.PkgFuncKey][]uint32{
+		keyAdd:   {3, 0, 7},
+		keyHello: {1},
+	})
+	dir := t.TempDir()
+	if err := covutil.WriteProfileToDirectory(dir, p); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(gotool, "tool", "covdata", "percent", "-i="+dir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go tool covdata percent: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"example.com/mod/a\t\tcoverage: 40.0% of statements",
+		"example.com/mod/b\t\tcoverage: 100.0% of statements",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("covdata percent output missing %q:\n%s", want, out)
+		}
+	}
+
+	textfmt := filepath.Join(t.TempDir(), "cover.out")
+	out, err = exec.Command(gotool, "tool", "covdata", "textfmt", "-i="+dir, "-o="+textfmt).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go tool covdata textfmt: %v\n%s", err, out)
+	}
+	data, err := os.ReadFile(textfmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"mode: count",
+		"example.com/mod/a/a.go:3.23,4.14 1 3",
+		"example.com/mod/a/a.go:4.14,6.3 1 0",
+		"example.com/mod/a/a.go:9.10,11.3 2 0",
+		"example.com/mod/b/b.go:5.20,7.2 2 1",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("textfmt output missing %q:\n%s", want, data)
+		}
+	}
+}
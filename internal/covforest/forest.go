@@ -28,21 +28,42 @@ type Tree struct {
 	ID           string                 `json:"id"`
 	Name         string                 `json:"name"`
 	Source       TreeSource             `json:"source"`
-	CoverageTree *covtree.CoverageTree  `json:"coverage_tree"`
+	CoverageTree *covtree.CoverageTree  `json:"coverage_tree,omitempty"`
 	Metadata     map[string]interface{} `json:"metadata"`
 	LastUpdated  time.Time              `json:"last_updated"`
 	CreatedAt    time.Time              `json:"created_at"`
+
+	// Summary holds the coverage totals of CoverageTree. It is set for
+	// trees read from a Storage index, whose CoverageTree is not loaded.
+	Summary *covtree.CoverageSummary `json:"summary,omitempty"`
+}
+
+// CoverageSummary returns the coverage totals of t, from its coverage
+// data if loaded and from its stored summary otherwise. It reports false
+// if neither is available.
+func (t *Tree) CoverageSummary() (covtree.CoverageSummary, bool) {
+	switch {
+	case t.CoverageTree != nil:
+		return t.CoverageTree.Summary(), true
+	case t.Summary != nil:
+		return *t.Summary, true
+	}
+	return covtree.CoverageSummary{}, false
 }
 
 // TreeSource contains information about where a coverage tree originated
 type TreeSource struct {
-	Type       string    `json:"type"`       // "local", "remote", "ci", "git"
-	Machine    string    `json:"machine"`    // hostname or CI worker ID
-	Repository string    `json:"repository"` // repo URL or path
-	Branch     string    `json:"branch"`     // git branch
-	Commit     string    `json:"commit"`     // git commit hash
-	Timestamp  time.Time `json:"timestamp"`  // when coverage was collected
-	Path       string    `json:"path"`       // original path to coverage data
+	Type       string    `json:"type"`            // "local", "remote", "ci", "git"
+	Machine    string    `json:"machine"`         // hostname or CI worker ID
+	Repository string    `json:"repository"`      // repo URL or path
+	Branch     string    `json:"branch"`          // git branch
+	Commit     string    `json:"commit"`          // git commit hash
+	CommitTime time.Time `json:"commit_time"`     // time of the commit
+	Tag        string    `json:"tag,omitempty"`   // git tag of the commit
+	Dirty      bool      `json:"dirty,omitempty"` // working tree had uncommitted changes
+	Timestamp  time.Time `json:"timestamp"`       // when coverage was collected
+	Path       string    `json:"path"`            // original path to coverage data
+	OS         string    `json:"os,omitempty"`    // GOOS of the machine
 }
 
 // ForestMetadata contains overall forest information
@@ -118,92 +139,9 @@ func (f *Forest) ListTrees() []*Tree {
 	return trees
 }
 
-// Summary returns aggregate statistics across all trees
-func (f *Forest) Summary() ForestSummary {
-	summary := ForestSummary{
-		TreeCount: len(f.Trees),
-		Metadata:  f.Metadata,
-	}
-
-	packageMap := make(map[string]*PackageSummary)
-
-	for _, tree := range f.Trees {
-		if tree.CoverageTree == nil {
-			continue
-		}
-
-		treeSummary := tree.CoverageTree.Summary()
-		summary.TotalLines += treeSummary.TotalLines
-		summary.CoveredLines += treeSummary.CoveredLines
-
-		// Track per-package stats across trees
-		for _, pkg := range tree.CoverageTree.Packages {
-			if existing, ok := packageMap[pkg.ImportPath]; ok {
-				existing.Trees = append(existing.Trees, TreeRef{
-					ID:           tree.ID,
-					Name:         tree.Name,
-					TotalLines:   pkg.TotalLines,
-					CoveredLines: pkg.CoveredLines,
-					CoverageRate: pkg.CoverageRate,
-				})
-			} else {
-				packageMap[pkg.ImportPath] = &PackageSummary{
-					ImportPath: pkg.ImportPath,
-					Trees: []TreeRef{{
-						ID:           tree.ID,
-						Name:         tree.Name,
-						TotalLines:   pkg.TotalLines,
-						CoveredLines: pkg.CoveredLines,
-						CoverageRate: pkg.CoverageRate,
-					}},
-				}
-			}
-		}
-	}
-
-	// Convert map to slice
-	for _, pkgSummary := range packageMap {
-		summary.Packages = append(summary.Packages, pkgSummary)
-	}
-
-	// Sort packages by import path
-	sort.Slice(summary.Packages, func(i, j int) bool {
-		return summary.Packages[i].ImportPath < summary.Packages[j].ImportPath
-	})
-
-	if summary.TotalLines > 0 {
-		summary.CoverageRate = float64(summary.CoveredLines) / float64(summary.TotalLines)
-	}
-
-	return summary
-}
-
-// ForestSummary provides aggregate statistics across all trees
-type ForestSummary struct {
-	TreeCount    int               `json:"tree_count"`
-	TotalLines   int               `json:"total_lines"`
-	CoveredLines int               `json:"covered_lines"`
-	CoverageRate float64           `json:"coverage_rate"`
-	Packages     []*PackageSummary `json:"packages"`
-	Metadata     ForestMetadata    `json:"metadata"`
-}
-
-// PackageSummary shows how a package appears across different trees
-type PackageSummary struct {
-	ImportPath string    `json:"import_path"`
-	Trees      []TreeRef `json:"trees"`
-}
-
-// TreeRef references a tree with coverage stats for a specific package
-type TreeRef struct {
-	ID           string  `json:"id"`
-	Name         string  `json:"name"`
-	TotalLines   int     `json:"total_lines"`
-	CoveredLines int     `json:"covered_lines"`
-	CoverageRate float64 `json:"coverage_rate"`
-}
-
-// SaveToFile saves the forest to a JSON file
+// SaveToFile saves the forest to a single JSON file.
+// Forests managed by the covforest command are kept in a Storage instead;
+// OpenDirStorage imports files written by SaveToFile.
 func (f *Forest) SaveToFile(filename string) error {
 	data, err := json.MarshalIndent(f, "", "  ")
 	if err != nil {
@@ -240,11 +178,13 @@ func LoadFromFile(filename string) (*Forest, error) {
 	return &forest, nil
 }
 
-// DefaultForestPath returns the default path for storing forest data
+// DefaultForestPath returns the default directory for storing forest data.
+// Forests stored by earlier versions in the file of the same name with a
+// ".json" extension are migrated when opened with OpenDirStorage.
 func DefaultForestPath() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return "./covforest.json"
+		return "./covforest"
 	}
-	return filepath.Join(home, ".covforest", "forest.json")
+	return filepath.Join(home, ".covforest", "forest")
 }
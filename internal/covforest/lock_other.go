@@ -0,0 +1,35 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package covforest
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// On systems without flock, the lock is held by creating f's name with a
+// ".held" suffix exclusively. A process that dies holding the lock leaves
+// the file behind, and it must be removed by hand.
+
+func lockFile(f *os.File) error {
+	for {
+		h, err := os.OpenFile(f.Name()+".held", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return h.Close()
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return os.Remove(f.Name() + ".held")
+}
@@ -0,0 +1,566 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covforest
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/covtree"
+)
+
+// Storage persists the trees of a forest.
+//
+// Implementations keep the metadata of all trees in an index that can be
+// read without loading any coverage data, so that listing a large forest
+// is cheap.
+type Storage interface {
+	// Index returns the forest metadata and the stored trees. The trees'
+	// CoverageTree is nil; their Summary holds the coverage totals.
+	Index() (*Index, error)
+	// Load returns the tree with the given ID, including its coverage.
+	Load(id string) (*Tree, error)
+	// Put stores tree, replacing any stored tree with the same ID. The
+	// coverage data and pod metadata files found under each of podDirs
+	// are stored with the tree, by their path relative to it; a file in
+	// a later directory replaces one at the same path in an earlier one.
+	Put(tree *Tree, podDirs ...string) error
+	// Delete removes the tree with the given ID.
+	Delete(id string) error
+	// Lock acquires an exclusive lock on the storage, waiting until it
+	// is available, and returns a function that releases it. Writers
+	// hold the lock for the whole of a read-modify-write sequence.
+	Lock() (unlock func() error, err error)
+}
+
+// Index is the metadata of a stored forest.
+type Index struct {
+	Version  int            `json:"version"`
+	Metadata ForestMetadata `json:"metadata"`
+	Trees    []*Tree        `json:"trees"`
+}
+
+// indexVersion is the version of the DirStorage layout.
+const indexVersion = 2
+
+// DirStorage is a Storage kept in a directory:
+//
+//	index.json              Index: forest and tree metadata
+//	lock                    lock file taken by Lock
+//	trees/<id>/coverage.gz  gzip-compressed JSON of the tree's CoverageTree
+//	trees/<id>/pods.json    the tree's raw coverage and pod metadata files,
+//	                        as a list of paths and the objects holding
+//	                        their contents
+//	objects/<xx>/<sha256>   raw files, by the SHA-256 of their content
+//
+// Raw coverage files are content-addressed, so a meta-data file shared by
+// many runs of the same binary is stored once. Every file is written to a
+// temporary file and renamed into place. The files of a new tree are
+// written before the index, so a crash while adding a tree leaves either
+// the old or the new state. The files of a replaced tree are staged under
+// a ".new" suffix and renamed into place once the index is written; a
+// crash in between leaves the new index entry with the old coverage and
+// raw files until the tree is stored again.
+type DirStorage struct {
+	dir string
+}
+
+// podFile is an entry of pods.json.
+type podFile struct {
+	Path   string `json:"path"`
+	Object string `json:"object"`
+	Size   int64  `json:"size"`
+}
+
+// OpenDirStorage opens the forest stored in dir. The directory is created
+// when a tree is first stored; until then the forest is empty.
+//
+// Earlier versions stored a forest as a single JSON file. If dir holds no
+// index but dir+".json", or a "forest.json" file within dir, is such a
+// file, its trees are imported and the file is renamed with a ".migrated"
+// suffix. If dir itself is such a file, the forest is stored in the
+// directory named by dir without its ".json" extension.
+func OpenDirStorage(dir string) (*DirStorage, error) {
+	var legacy []string
+	if fi, err := os.Stat(dir); err == nil && fi.Mode().IsRegular() {
+		legacy = append(legacy, dir)
+		dir = strings.TrimSuffix(dir, ".json")
+	} else {
+		legacy = append(legacy, dir+".json", filepath.Join(dir, "forest.json"))
+	}
+
+	s := &DirStorage{dir: dir}
+	for _, name := range legacy {
+		if _, err := os.Stat(s.indexPath()); err == nil {
+			break
+		}
+		if fi, err := os.Stat(name); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		unlock, err := s.Lock()
+		if err != nil {
+			return nil, err
+		}
+		// Another process may have migrated the file while we waited.
+		if _, err := os.Stat(name); err == nil {
+			err = s.migrate(name)
+		} else {
+			err = nil
+		}
+		unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate %s: %v", name, err)
+		}
+		break
+	}
+	return s, nil
+}
+
+// Dir returns the directory holding the storage.
+func (s *DirStorage) Dir() string {
+	return s.dir
+}
+
+// migrate imports the trees of a forest saved with SaveToFile.
+func (s *DirStorage) migrate(name string) error {
+	f, err := LoadFromFile(name)
+	if err != nil {
+		return err
+	}
+	idx := &Index{Version: indexVersion, Metadata: f.Metadata}
+	for _, id := range sortedIDs(f.Trees) {
+		t := f.Trees[id]
+		if err := s.writeTree(t, nil, ""); err != nil {
+			return err
+		}
+		idx.Trees = append(idx.Trees, indexEntry(t))
+	}
+	idx.Metadata.TreeCount = len(idx.Trees)
+	if err := s.writeIndex(idx); err != nil {
+		return err
+	}
+	return os.Rename(name, name+".migrated")
+}
+
+func (s *DirStorage) indexPath() string {
+	return filepath.Join(s.dir, "index.json")
+}
+
+func (s *DirStorage) treeDir(id string) string {
+	return filepath.Join(s.dir, "trees", url.PathEscape(id))
+}
+
+func (s *DirStorage) objectPath(sum string) string {
+	return filepath.Join(s.dir, "objects", sum[:2], sum)
+}
+
+// Index implements Storage.
+func (s *DirStorage) Index() (*Index, error) {
+	data, err := os.ReadFile(s.indexPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Index{Version: indexVersion, Metadata: NewForest().Metadata}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read forest index: %v", err)
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse forest index: %v", err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("unsupported forest index version %d", idx.Version)
+	}
+	return &idx, nil
+}
+
+// Load implements Storage.
+func (s *DirStorage) Load(id string) (*Tree, error) {
+	idx, err := s.Index()
+	if err != nil {
+		return nil, err
+	}
+	var tree *Tree
+	for _, t := range idx.Trees {
+		if t.ID == id {
+			tree = t
+			break
+		}
+	}
+	if tree == nil {
+		return nil, fmt.Errorf("tree %s not found", id)
+	}
+
+	f, err := os.Open(filepath.Join(s.treeDir(id), "coverage.gz"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read coverage of tree %s: %v", id, err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read coverage of tree %s: %v", id, err)
+	}
+	var ct covtree.CoverageTree
+	if err := json.NewDecoder(zr).Decode(&ct); err != nil {
+		return nil, fmt.Errorf("failed to parse coverage of tree %s: %v", id, err)
+	}
+	tree.CoverageTree = &ct
+	return tree, nil
+}
+
+// Put implements Storage. The caller must hold the lock.
+func (s *DirStorage) Put(tree *Tree, podDirs ...string) error {
+	if tree.ID == "" || tree.ID == "." || tree.ID == ".." {
+		return fmt.Errorf("invalid tree ID %q", tree.ID)
+	}
+	if tree.CoverageTree == nil {
+		return fmt.Errorf("tree %s has no coverage data", tree.ID)
+	}
+	idx, err := s.Index()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if tree.CreatedAt.IsZero() {
+		tree.CreatedAt = now
+	}
+	tree.LastUpdated = now
+
+	replaced := false
+	for i, t := range idx.Trees {
+		if t.ID == tree.ID {
+			idx.Trees[i] = indexEntry(tree)
+			replaced = true
+		}
+	}
+	if !replaced {
+		idx.Trees = append(idx.Trees, indexEntry(tree))
+	}
+	// The files of a replaced tree must not change until its index entry
+	// does, so they are staged and renamed into place afterwards.
+	ext := ""
+	if replaced {
+		ext = ".new"
+	}
+	if err := s.writeTree(tree, podDirs, ext); err != nil {
+		return err
+	}
+	idx.Metadata.TreeCount = len(idx.Trees)
+	idx.Metadata.LastUpdated = now
+	if err := s.writeIndex(idx); err != nil {
+		return err
+	}
+	if !replaced {
+		return nil
+	}
+	dir := s.treeDir(tree.ID)
+	for _, name := range []string{"pods.json", "coverage.gz"} {
+		if err := os.Rename(filepath.Join(dir, name+ext), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return s.collectObjects()
+}
+
+// Delete implements Storage. The caller must hold the lock.
+// Raw coverage files no longer used by any tree are removed.
+func (s *DirStorage) Delete(id string) error {
+	idx, err := s.Index()
+	if err != nil {
+		return err
+	}
+	trees := idx.Trees[:0]
+	for _, t := range idx.Trees {
+		if t.ID != id {
+			trees = append(trees, t)
+		}
+	}
+	if len(trees) == len(idx.Trees) {
+		return fmt.Errorf("tree %s not found", id)
+	}
+	idx.Trees = trees
+	idx.Metadata.TreeCount = len(trees)
+	idx.Metadata.LastUpdated = time.Now()
+	if err := s.writeIndex(idx); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.treeDir(id)); err != nil {
+		return err
+	}
+	return s.collectObjects()
+}
+
+// Lock implements Storage.
+func (s *DirStorage) Lock() (func() error, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create forest directory: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, "lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock forest: %v", err)
+	}
+	return func() error {
+		err := unlockFile(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+// ExtractPods copies the raw files stored with tree id into dir, recreating
+// the layout they had under the directories passed to Put.
+func (s *DirStorage) ExtractPods(id, dir string) error {
+	files, err := s.podFiles(id)
+	if err != nil {
+		return err
+	}
+	for _, pf := range files {
+		data, err := os.ReadFile(s.objectPath(pf.Object))
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, filepath.FromSlash(pf.Path))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DirStorage) podFiles(id string) ([]podFile, error) {
+	data, err := os.ReadFile(filepath.Join(s.treeDir(id), "pods.json"))
+	if err != nil {
+		return nil, err
+	}
+	var files []podFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse pods of tree %s: %v", id, err)
+	}
+	return files, nil
+}
+
+// writeTree writes the coverage and raw files of tree, adding ext to the
+// names of the files in its tree directory.
+func (s *DirStorage) writeTree(tree *Tree, podDirs []string, ext string) error {
+	dir := s.treeDir(tree.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	files := []podFile{}
+	index := make(map[string]int) // by path
+	for _, podDir := range podDirs {
+		if podDir == "" {
+			continue
+		}
+		err := filepath.WalkDir(podDir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !isPodFile(d.Name()) {
+				return err
+			}
+			sum, size, err := s.writeObject(p)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(podDir, p)
+			pf := podFile{Path: filepath.ToSlash(rel), Object: sum, Size: size}
+			if i, ok := index[pf.Path]; ok {
+				files[i] = pf
+				return nil
+			}
+			index[pf.Path] = len(files)
+			files = append(files, pf)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store coverage files of tree %s: %v", tree.ID, err)
+		}
+	}
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "pods.json"+ext), data); err != nil {
+		return err
+	}
+
+	return writeAtomic(filepath.Join(dir, "coverage.gz"+ext), func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if err := json.NewEncoder(zw).Encode(tree.CoverageTree); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// writeObject stores the content of the file name as an object and
+// returns its digest and size.
+func (s *DirStorage) writeObject(name string) (string, int64, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", 0, err
+	}
+	h := sha256.Sum256(data)
+	sum := hex.EncodeToString(h[:])
+	obj := s.objectPath(sum)
+	if _, err := os.Stat(obj); err == nil {
+		return sum, int64(len(data)), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+		return "", 0, err
+	}
+	return sum, int64(len(data)), writeFileAtomic(obj, data)
+}
+
+// collectObjects removes the objects not referenced by any stored tree.
+func (s *DirStorage) collectObjects() error {
+	idx, err := s.Index()
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, t := range idx.Trees {
+		files, err := s.podFiles(t.ID)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		for _, pf := range files {
+			used[pf.Object] = true
+		}
+	}
+	objects, _ := filepath.Glob(filepath.Join(s.dir, "objects", "*", "*"))
+	for _, obj := range objects {
+		if !used[filepath.Base(obj)] {
+			if err := os.Remove(obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *DirStorage) writeIndex(idx *Index) error {
+	idx.Version = indexVersion
+	sort.Slice(idx.Trees, func(i, j int) bool { return idx.Trees[i].ID < idx.Trees[j].ID })
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal forest index: %v", err)
+	}
+	return writeFileAtomic(s.indexPath(), data)
+}
+
+// indexEntry returns a copy of t without coverage data, for the index.
+func indexEntry(t *Tree) *Tree {
+	e := *t
+	e.CoverageTree = nil
+	if t.CoverageTree != nil {
+		sum := t.CoverageTree.Summary()
+		e.Summary = &sum
+	}
+	return &e
+}
+
+// isPodFile reports whether name is a coverage data or pod metadata file.
+func isPodFile(name string) bool {
+	return strings.HasPrefix(name, "covmeta.") || strings.HasPrefix(name, "covcounters.") ||
+		name == covutil.PodMetadataFile
+}
+
+func sortedIDs(trees map[string]*Tree) []string {
+	ids := make([]string, 0, len(trees))
+	for id := range trees {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// writeFileAtomic writes data to name by way of a temporary file in the
+// same directory, so that name never holds partially written content.
+func writeFileAtomic(name string, data []byte) error {
+	return writeAtomic(name, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func writeAtomic(name string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// LoadForest loads every tree stored in s, with its coverage data.
+func LoadForest(s Storage) (*Forest, error) {
+	f, err := LoadForestIndex(s)
+	if err != nil {
+		return nil, err
+	}
+	for id := range f.Trees {
+		t, err := s.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		f.Trees[id] = t
+	}
+	return f, nil
+}
+
+// LoadForestIndex returns the forest stored in s without loading any
+// coverage data: the trees' CoverageTree is nil.
+func LoadForestIndex(s Storage) (*Forest, error) {
+	idx, err := s.Index()
+	if err != nil {
+		return nil, err
+	}
+	f := &Forest{Trees: make(map[string]*Tree, len(idx.Trees)), Metadata: idx.Metadata}
+	for _, t := range idx.Trees {
+		f.Trees[t.ID] = t
+	}
+	return f, nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covforest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+func loadTestTree(t *testing.T, id, dir string) *Tree {
+	t.Helper()
+	ct := covtree.NewCoverageTree()
+	if err := ct.LoadFromNestedRepository(dir); err != nil {
+		t.Fatal(err)
+	}
+	return &Tree{ID: id, Name: id, Source: TreeSource{Machine: "m"}, CoverageTree: ct}
+}
+
+func countFiles(t *testing.T, pattern string) int {
+	t.Helper()
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(names)
+}
+
+func TestDirStorage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "forest")
+	s, err := OpenDirStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two runs of the same binary share their meta-data file.
+	run1 := filepath.Join(t.TempDir(), "run1")
+	run2 := filepath.Join(t.TempDir(), "run2")
+	writeTestCoverage(t, run1, testCounters{{1, 0}, {0}})
+	writeTestCoverage(t, run2, testCounters{{1, 1}, {1}})
+	for id, run := range map[string]string{"a": run1, "b/c": run2} {
+		if err := s.Put(loadTestTree(t, id, run), run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countFiles(t, filepath.Join(dir, "objects", "*", "*")); n != 3 {
+		t.Errorf("stored %d objects, want 3 (one meta-data and two counter files)", n)
+	}
+	if n := countFiles(t, filepath.Join(dir, "*", ".*tmp*")) + countFiles(t, filepath.Join(dir, "trees", "*", ".*tmp*")); n != 0 {
+		t.Errorf("%d temporary files left behind", n)
+	}
+
+	idx, err := s.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Trees) != 2 || idx.Metadata.TreeCount != 2 {
+		t.Fatalf("index has %d trees, want 2", len(idx.Trees))
+	}
+	for _, tree := range idx.Trees {
+		if tree.CoverageTree != nil || tree.Summary == nil || tree.Summary.TotalLines != 9 {
+			t.Errorf("index entry %s: coverage %v, summary %+v", tree.ID, tree.CoverageTree, tree.Summary)
+		}
+	}
+
+	tree, err := s.Load("b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.CoverageTree.Summary().CoveredLines; got != 9 || tree.Source.Machine != "m" {
+		t.Errorf("loaded tree covers %d lines from %q, want 9 from m", got, tree.Source.Machine)
+	}
+
+	// The raw coverage files can be recovered and loaded again.
+	out := t.TempDir()
+	if err := s.ExtractPods("a", out); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadTestTree(t, "a", out).CoverageTree.Summary().CoveredLines; got != 3 {
+		t.Errorf("extracted pod covers %d lines, want 3", got)
+	}
+
+	// Deleting a tree drops the objects only it used.
+	if err := s.Delete("b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countFiles(t, filepath.Join(dir, "objects", "*", "*")); n != 2 {
+		t.Errorf("%d objects after delete, want 2", n)
+	}
+	if _, err := s.Load("b/c"); err == nil {
+		t.Errorf("deleted tree still loads")
+	}
+	if err := s.Delete("b/c"); err == nil {
+		t.Errorf("deleting a missing tree succeeded")
+	}
+
+	f, err := LoadForest(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Trees) != 1 || f.Trees["a"].CoverageTree == nil {
+		t.Errorf("LoadForest = %+v", f.Trees)
+	}
+}
+
+func TestDirStorageReplace(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "forest")
+	s, err := OpenDirStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run1 := filepath.Join(t.TempDir(), "run1")
+	run2 := filepath.Join(t.TempDir(), "run2")
+	writeTestCoverage(t, run1, testCounters{{1, 0}, {0}})
+	writeTestCoverage(t, run2, testCounters{{1, 1}, {1}})
+	for _, run := range []string{run1, run2} {
+		if err := s.Put(loadTestTree(t, "a", run), run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree, err := s.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.CoverageTree.Summary().CoveredLines; got != 9 {
+		t.Errorf("replaced tree covers %d lines, want 9", got)
+	}
+	if n := countFiles(t, filepath.Join(dir, "trees", "a", "*.new")); n != 0 {
+		t.Errorf("%d staged files left behind", n)
+	}
+	if n := countFiles(t, filepath.Join(dir, "objects", "*", "*")); n != 2 {
+		t.Errorf("%d objects after replace, want 2", n)
+	}
+}
+
+func TestDirStorageMigrate(t *testing.T) {
+	run := filepath.Join(t.TempDir(), "run")
+	writeTestCoverage(t, run, testCounters{{1, 0}, {1}})
+	legacy := NewForest()
+	if err := legacy.AddTree(loadTestTree(t, "old", run)); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	name := filepath.Join(dir, "forest.json")
+	if err := legacy.SaveToFile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	// Naming the old file opens the directory beside it.
+	s, err := OpenDirStorage(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Dir() != filepath.Join(dir, "forest") {
+		t.Errorf("Dir() = %s", s.Dir())
+	}
+	if _, err := os.Stat(name + ".migrated"); err != nil {
+		t.Errorf("legacy file not renamed: %v", err)
+	}
+	tree, err := s.Load("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.CoverageTree.Summary().CoveredLines; got != 6 {
+		t.Errorf("migrated tree covers %d lines, want 6", got)
+	}
+
+	// Opening the directory again does not migrate anything.
+	if _, err := OpenDirStorage(filepath.Join(dir, "forest")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirStorageLock(t *testing.T) {
+	s, err := OpenDirStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := s.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func() error)
+	go func() {
+		unlock2, err := s.Lock()
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- unlock2
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second Lock acquired while the first was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case unlock2 := <-acquired:
+		unlock2()
+	case <-time.After(5 * time.Second):
+		t.Fatal("second Lock not acquired after unlock")
+	}
+}
+
+func TestDirStorageRejectsBadIDs(t *testing.T) {
+	s, err := OpenDirStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", ".", ".."} {
+		err := s.Put(&Tree{ID: id, CoverageTree: covtree.NewCoverageTree()})
+		if err == nil || !strings.Contains(err.Error(), "invalid tree ID") {
+			t.Errorf("Put(%q) error = %v", id, err)
+		}
+	}
+}
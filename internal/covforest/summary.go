@@ -0,0 +1,218 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covforest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+// SummaryMode selects how the trees of a forest are combined by SummaryBy.
+type SummaryMode string
+
+const (
+	// SummaryUnion merges all trees: a line is covered if any tree
+	// covers it, and each line is counted once.
+	SummaryUnion SummaryMode = "union"
+	// SummaryLatest merges the most recent tree of each branch.
+	SummaryLatest SummaryMode = "latest"
+	// SummaryPerMachine, SummaryPerOS and SummaryPerBranch merge all
+	// trees, and in addition report the union of the trees of each
+	// machine, operating system or branch as a group.
+	SummaryPerMachine SummaryMode = "per-machine"
+	SummaryPerOS      SummaryMode = "per-os"
+	SummaryPerBranch  SummaryMode = "per-branch"
+)
+
+// SummaryModes lists the supported summary modes.
+var SummaryModes = []SummaryMode{SummaryUnion, SummaryLatest, SummaryPerMachine, SummaryPerOS, SummaryPerBranch}
+
+// ForestSummary provides aggregate statistics across all trees
+type ForestSummary struct {
+	Mode         SummaryMode       `json:"mode"`
+	TreeCount    int               `json:"tree_count"`
+	TotalLines   int               `json:"total_lines"`
+	CoveredLines int               `json:"covered_lines"`
+	CoverageRate float64           `json:"coverage_rate"`
+	Packages     []*PackageSummary `json:"packages"`
+	Groups       []*GroupSummary   `json:"groups,omitempty"`
+	Metadata     ForestMetadata    `json:"metadata"`
+}
+
+// PackageSummary shows how a package appears across different trees.
+// The totals are those of the package merged across the trees.
+type PackageSummary struct {
+	ImportPath   string    `json:"import_path"`
+	TotalLines   int       `json:"total_lines"`
+	CoveredLines int       `json:"covered_lines"`
+	CoverageRate float64   `json:"coverage_rate"`
+	Trees        []TreeRef `json:"trees"`
+}
+
+// GroupSummary is the merged coverage of the trees sharing a machine,
+// operating system or branch. For SummaryLatest there is one group per
+// branch, holding the tree selected for it.
+type GroupSummary struct {
+	Key          string   `json:"key"`
+	TreeIDs      []string `json:"tree_ids"`
+	TotalLines   int      `json:"total_lines"`
+	CoveredLines int      `json:"covered_lines"`
+	CoverageRate float64  `json:"coverage_rate"`
+}
+
+// TreeRef references a tree with coverage stats for a specific package
+type TreeRef struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	TotalLines   int     `json:"total_lines"`
+	CoveredLines int     `json:"covered_lines"`
+	CoverageRate float64 `json:"coverage_rate"`
+}
+
+// Summary returns the union of the coverage of all trees.
+// It is equivalent to SummaryBy(SummaryUnion).
+func (f *Forest) Summary() ForestSummary {
+	s, _ := f.SummaryBy(SummaryUnion)
+	return s
+}
+
+// SummaryBy returns aggregate statistics for the trees of the forest
+// combined as mode describes. Trees are merged at the level of coverable
+// units with covtree.MergeTrees, so the same lines collected by several
+// runs are counted once rather than once per tree. Units are matched by
+// source position, so the totals are only exact for trees of the same
+// commit: code that moved between the commits of the merged trees is
+// counted at each of its positions.
+func (f *Forest) SummaryBy(mode SummaryMode) (ForestSummary, error) {
+	var trees []*Tree
+	for _, t := range f.sortedTrees() {
+		if t.CoverageTree != nil {
+			trees = append(trees, t)
+		}
+	}
+
+	var groups map[string][]*Tree
+	switch mode {
+	case SummaryUnion:
+	case SummaryLatest:
+		groups = groupTrees(trees, func(t *Tree) string { return t.Source.Branch })
+		trees = trees[:0:0]
+		for key, g := range groups {
+			latest := g[0]
+			for _, t := range g[1:] {
+				if collectedAt(t).After(collectedAt(latest)) {
+					latest = t
+				}
+			}
+			groups[key] = []*Tree{latest}
+			trees = append(trees, latest)
+		}
+		sortTrees(trees)
+	case SummaryPerMachine:
+		groups = groupTrees(trees, func(t *Tree) string { return t.Source.Machine })
+	case SummaryPerOS:
+		groups = groupTrees(trees, func(t *Tree) string { return t.Source.OS })
+	case SummaryPerBranch:
+		groups = groupTrees(trees, func(t *Tree) string { return t.Source.Branch })
+	default:
+		return ForestSummary{}, fmt.Errorf("unknown summary mode %q", mode)
+	}
+
+	summary := ForestSummary{
+		Mode:      mode,
+		TreeCount: len(trees),
+		Metadata:  f.Metadata,
+	}
+	merged := mergeTrees(trees)
+	s := merged.Summary()
+	summary.TotalLines, summary.CoveredLines, summary.CoverageRate = s.TotalLines, s.CoveredLines, s.CoverageRate
+
+	for _, name := range merged.GetPackageNames() {
+		pkg := merged.Packages[name]
+		ps := &PackageSummary{
+			ImportPath:   pkg.ImportPath,
+			TotalLines:   pkg.TotalLines,
+			CoveredLines: pkg.CoveredLines,
+			CoverageRate: pkg.CoverageRate,
+		}
+		for _, t := range trees {
+			if p := t.CoverageTree.GetPackage(name); p != nil {
+				ps.Trees = append(ps.Trees, TreeRef{
+					ID:           t.ID,
+					Name:         t.Name,
+					TotalLines:   p.TotalLines,
+					CoveredLines: p.CoveredLines,
+					CoverageRate: p.CoverageRate,
+				})
+			}
+		}
+		summary.Packages = append(summary.Packages, ps)
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		g := &GroupSummary{Key: key}
+		for _, t := range groups[key] {
+			g.TreeIDs = append(g.TreeIDs, t.ID)
+		}
+		s := mergeTrees(groups[key]).Summary()
+		g.TotalLines, g.CoveredLines, g.CoverageRate = s.TotalLines, s.CoveredLines, s.CoverageRate
+		summary.Groups = append(summary.Groups, g)
+	}
+	return summary, nil
+}
+
+func mergeTrees(trees []*Tree) *covtree.CoverageTree {
+	cts := make([]*covtree.CoverageTree, len(trees))
+	for i, t := range trees {
+		cts[i] = t.CoverageTree
+	}
+	return covtree.MergeTrees(cts...)
+}
+
+// groupTrees groups trees by key, keeping their order within each group.
+// Trees with an empty key are grouped under "unknown".
+func groupTrees(trees []*Tree, key func(*Tree) string) map[string][]*Tree {
+	groups := make(map[string][]*Tree)
+	for _, t := range trees {
+		k := key(t)
+		if k == "" {
+			k = "unknown"
+		}
+		groups[k] = append(groups[k], t)
+	}
+	return groups
+}
+
+// sortedTrees returns the trees of the forest ordered by ID, so that
+// summaries do not depend on map iteration order.
+func (f *Forest) sortedTrees() []*Tree {
+	trees := make([]*Tree, 0, len(f.Trees))
+	for _, t := range f.Trees {
+		trees = append(trees, t)
+	}
+	sortTrees(trees)
+	return trees
+}
+
+func sortTrees(trees []*Tree) {
+	sort.Slice(trees, func(i, j int) bool { return trees[i].ID < trees[j].ID })
+}
+
+// collectedAt returns when the coverage of t was collected, falling back
+// to when the tree was added for trees without a source timestamp.
+func collectedAt(t *Tree) time.Time {
+	if !t.Source.Timestamp.IsZero() {
+		return t.Source.Timestamp
+	}
+	return t.CreatedAt
+}
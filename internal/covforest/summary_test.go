@@ -0,0 +1,85 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covforest
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+func TestSummaryBy(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	forest := NewForest()
+	add := func(id string, counts testCounters, source TreeSource) {
+		t.Helper()
+		dir := filepath.Join(t.TempDir(), id)
+		writeTestCoverage(t, dir, counts)
+		ct := covtree.NewCoverageTree()
+		if err := ct.LoadFromNestedRepository(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := forest.AddTree(&Tree{ID: id, Name: id, Source: source, CoverageTree: ct}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each tree covers 3 of the 9 lines; "old" and "linux" cover the
+	// same ones.
+	add("old", testCounters{{1, 0}, {0}}, TreeSource{Machine: "m1", OS: "linux", Branch: "main", Timestamp: base})
+	add("linux", testCounters{{2, 0}, {0}}, TreeSource{Machine: "m1", OS: "linux", Branch: "main", Timestamp: base.Add(time.Hour)})
+	add("darwin", testCounters{{0, 1}, {0}}, TreeSource{Machine: "m2", OS: "darwin", Branch: "dev", Timestamp: base})
+	add("branch", testCounters{{0, 0}, {1}}, TreeSource{Machine: "m2", OS: "darwin", Branch: "main", Timestamp: base.Add(-time.Hour)})
+
+	type group struct {
+		key            string
+		trees          int
+		covered, total int
+	}
+	tests := []struct {
+		mode           SummaryMode
+		trees          int
+		covered, total int
+		groups         []group
+	}{
+		{SummaryUnion, 4, 9, 9, nil},
+		{SummaryLatest, 2, 6, 9, []group{{"dev", 1, 3, 9}, {"main", 1, 3, 9}}},
+		{SummaryPerMachine, 4, 9, 9, []group{{"m1", 2, 3, 9}, {"m2", 2, 6, 9}}},
+		{SummaryPerOS, 4, 9, 9, []group{{"darwin", 2, 6, 9}, {"linux", 2, 3, 9}}},
+		{SummaryPerBranch, 4, 9, 9, []group{{"dev", 1, 3, 9}, {"main", 3, 6, 9}}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			s, err := forest.SummaryBy(tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.TreeCount != tt.trees || s.CoveredLines != tt.covered || s.TotalLines != tt.total {
+				t.Errorf("got %d trees, %d/%d lines; want %d trees, %d/%d lines",
+					s.TreeCount, s.CoveredLines, s.TotalLines, tt.trees, tt.covered, tt.total)
+			}
+			if len(s.Packages) != 1 || s.Packages[0].CoveredLines != tt.covered || len(s.Packages[0].Trees) != tt.trees {
+				t.Errorf("unexpected packages %+v", s.Packages)
+			}
+			if len(s.Groups) != len(tt.groups) {
+				t.Fatalf("got %d groups, want %d", len(s.Groups), len(tt.groups))
+			}
+			for i, g := range s.Groups {
+				want := tt.groups[i]
+				if g.Key != want.key || len(g.TreeIDs) != want.trees || g.CoveredLines != want.covered || g.TotalLines != want.total {
+					t.Errorf("group %d = %+v, want %+v", i, g, want)
+				}
+			}
+		})
+	}
+
+	if s, _ := forest.SummaryBy(SummaryLatest); s.Groups[1].TreeIDs[0] != "linux" {
+		t.Errorf("latest tree of main = %s, want linux", s.Groups[1].TreeIDs[0])
+	}
+	if _, err := forest.SummaryBy("bogus"); err == nil {
+		t.Errorf("SummaryBy accepted an unknown mode")
+	}
+}
@@ -0,0 +1,629 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covforest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+// SyncConfig describes the sources that Sync pulls coverage data from.
+// It is usually read from a JSON file with LoadSyncConfig.
+type SyncConfig struct {
+	// CacheDir is where fetched snapshots are stored.
+	// If empty, DefaultCacheDir is used.
+	CacheDir string `json:"cache_dir,omitempty"`
+	// Sources lists the sources to synchronize, in order.
+	Sources []SourceConfig `json:"sources"`
+}
+
+// SourceConfig describes a single sync source.
+//
+// The meaning of URL, Path and Ref depends on Type:
+//
+//	dir      Path is a local directory containing GOCOVERDIR data
+//	tarball  Path is a local .tar or .tar.gz archive of GOCOVERDIR data
+//	http     URL serves a .tar or .tar.gz archive of GOCOVERDIR data
+//	git      URL is a git repository, Ref a branch, tag or commit (default HEAD)
+//	         and Path the directory within the repository holding the data
+type SourceConfig struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	URL        string `json:"url,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+	Machine    string `json:"machine,omitempty"`
+	OS         string `json:"os,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+}
+
+// Snapshot is a fetched copy of the coverage data of a source.
+type Snapshot struct {
+	// Key identifies the content of the snapshot, such as a commit hash
+	// or a content digest. Fetching unchanged data yields the same key.
+	Key string
+	// Dir is a local directory containing the GOCOVERDIR data.
+	Dir string
+	// Source describes where the snapshot came from.
+	Source TreeSource
+}
+
+// A Fetcher retrieves the current snapshot of a source into a cache directory.
+type Fetcher interface {
+	Fetch(ctx context.Context, cacheDir string) (*Snapshot, error)
+}
+
+// FetcherFactory creates a Fetcher for a source configuration.
+type FetcherFactory func(cfg SourceConfig) (Fetcher, error)
+
+var (
+	fetchersMu sync.RWMutex
+	fetchers   = map[string]FetcherFactory{
+		"dir":     newDirFetcher,
+		"tarball": newTarballFetcher,
+		"http":    newHTTPFetcher,
+		"git":     newGitFetcher,
+	}
+)
+
+// RegisterFetcher makes a source type available to Sync.
+// Registering an existing type replaces it.
+func RegisterFetcher(typ string, factory FetcherFactory) {
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	fetchers[typ] = factory
+}
+
+// NewFetcher returns a Fetcher for the source configuration.
+func NewFetcher(cfg SourceConfig) (Fetcher, error) {
+	fetchersMu.RLock()
+	factory, ok := fetchers[cfg.Type]
+	fetchersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown source type %q", cfg.Type)
+	}
+	return factory(cfg)
+}
+
+// LoadSyncConfig reads a sync configuration from a JSON file.
+func LoadSyncConfig(filename string) (*SyncConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync config: %v", err)
+	}
+
+	var cfg SyncConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse sync config %s: %v", filename, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, src := range cfg.Sources {
+		if src.Name == "" {
+			return nil, fmt.Errorf("sync config %s: source %d has no name", filename, i)
+		}
+		if seen[src.Name] {
+			return nil, fmt.Errorf("sync config %s: duplicate source name %q", filename, src.Name)
+		}
+		seen[src.Name] = true
+
+		// Resolve local paths relative to the config file.
+		if src.Type == "dir" || src.Type == "tarball" {
+			if src.Path != "" && !filepath.IsAbs(src.Path) {
+				cfg.Sources[i].Path = filepath.Join(filepath.Dir(filename), src.Path)
+			}
+		}
+	}
+	if cfg.CacheDir != "" && !filepath.IsAbs(cfg.CacheDir) {
+		cfg.CacheDir = filepath.Join(filepath.Dir(filename), cfg.CacheDir)
+	}
+
+	return &cfg, nil
+}
+
+// DefaultCacheDir returns the default directory for fetched snapshots.
+func DefaultCacheDir() string {
+	return filepath.Join(filepath.Dir(DefaultForestPath()), "cache")
+}
+
+// SyncResult reports the outcome of synchronizing a single source.
+type SyncResult struct {
+	Name   string
+	TreeID string
+	Key    string
+	// Dir is the local directory holding the fetched coverage data.
+	Dir string
+	// Added is false if the snapshot was already present in the forest.
+	Added bool
+	Err   error
+}
+
+// Sync fetches every source in cfg and adds the resulting coverage trees
+// to the forest. A snapshot whose key is already present in the forest
+// is not added again, so running Sync repeatedly is safe.
+//
+// Errors for individual sources are reported in the results and do not
+// stop the remaining sources from being synchronized.
+func (f *Forest) Sync(ctx context.Context, cfg *SyncConfig) []SyncResult {
+	cacheDir := cfg.CacheDir
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir()
+	}
+
+	results := make([]SyncResult, 0, len(cfg.Sources))
+	for _, src := range cfg.Sources {
+		result := SyncResult{Name: src.Name}
+		result.Err = f.syncSource(ctx, src, cacheDir, &result)
+		results = append(results, result)
+	}
+	return results
+}
+
+func (f *Forest) syncSource(ctx context.Context, src SourceConfig, cacheDir string, result *SyncResult) error {
+	fetcher, err := NewFetcher(src)
+	if err != nil {
+		return err
+	}
+
+	snap, err := fetcher.Fetch(ctx, cacheDir)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", src.Name, err)
+	}
+	result.Key, result.Dir = snap.Key, snap.Dir
+
+	if existing := f.syncedTree(snap.Key); existing != "" {
+		result.TreeID = existing
+		return nil
+	}
+	result.TreeID = syncTreeID(src.Name, snap.Key)
+
+	tree := covtree.NewCoverageTree()
+	if err := tree.LoadFromNestedRepository(snap.Dir); err != nil {
+		return fmt.Errorf("failed to load coverage data for %s: %v", src.Name, err)
+	}
+
+	source := snap.Source
+	if src.Machine != "" {
+		source.Machine = src.Machine
+	}
+	if src.OS != "" {
+		source.OS = src.OS
+	}
+	if src.Repository != "" {
+		source.Repository = src.Repository
+	}
+	if src.Branch != "" {
+		source.Branch = src.Branch
+	}
+	if source.Timestamp.IsZero() {
+		source.Timestamp = time.Now()
+	}
+
+	err = f.AddTree(&Tree{
+		ID:           result.TreeID,
+		Name:         src.Name,
+		Source:       source,
+		CoverageTree: tree,
+		Metadata: map[string]interface{}{
+			"added_by": "covforest sync",
+			"sync_key": snap.Key,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	result.Added = true
+	return nil
+}
+
+// syncedTree returns the ID of the tree already synchronized from the
+// snapshot with the given key, or "" if there is none. Trees are matched
+// by their recorded sync key rather than by ID, so that the same commit or
+// artifact reached through a renamed source, or through two different
+// sources, is only added once.
+func (f *Forest) syncedTree(key string) string {
+	var ids []string
+	for id, tree := range f.Trees {
+		if k, ok := tree.Metadata["sync_key"].(string); ok && k == key {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	sort.Strings(ids)
+	return ids[0]
+}
+
+// syncTreeID derives a stable tree ID from a source name and snapshot key.
+func syncTreeID(name, key string) string {
+	id := strings.ToLower(name)
+	id = strings.ReplaceAll(id, " ", "-")
+	id = strings.ReplaceAll(id, "_", "-")
+	if len(key) > 12 {
+		key = key[:12]
+	}
+	return id + "-" + key
+}
+
+// dirFetcher reads coverage data from a local directory in place.
+type dirFetcher struct {
+	cfg SourceConfig
+}
+
+func newDirFetcher(cfg SourceConfig) (Fetcher, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("source %s: dir source requires a path", cfg.Name)
+	}
+	return &dirFetcher{cfg: cfg}, nil
+}
+
+func (d *dirFetcher) Fetch(ctx context.Context, cacheDir string) (*Snapshot, error) {
+	key, err := hashCoverageDir(d.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(d.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Key: key,
+		Dir: d.cfg.Path,
+		Source: TreeSource{
+			Type:      "local",
+			Path:      d.cfg.Path,
+			Timestamp: info.ModTime(),
+		},
+	}, nil
+}
+
+// hashCoverageDir computes a digest over the names and contents of all
+// coverage files below dir.
+func hashCoverageDir(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, "covmeta.") || strings.HasPrefix(name, "covcounters.") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no coverage files found in %s", dir)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, p := range files {
+		rel, _ := filepath.Rel(dir, p)
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+		file, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// tarballFetcher extracts a local archive into the cache.
+type tarballFetcher struct {
+	cfg SourceConfig
+}
+
+func newTarballFetcher(cfg SourceConfig) (Fetcher, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("source %s: tarball source requires a path", cfg.Name)
+	}
+	return &tarballFetcher{cfg: cfg}, nil
+}
+
+func (t *tarballFetcher) Fetch(ctx context.Context, cacheDir string) (*Snapshot, error) {
+	data, err := os.ReadFile(t.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(t.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	key := digest(data)
+	dir, err := extractToCache(cacheDir, key, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("extracting %s: %v", t.cfg.Path, err)
+	}
+	return &Snapshot{
+		Key: key,
+		Dir: dir,
+		Source: TreeSource{
+			Type:      "local",
+			Path:      t.cfg.Path,
+			Timestamp: info.ModTime(),
+		},
+	}, nil
+}
+
+// MaxDownloadSize is the largest archive, in bytes, that an http source
+// may serve. Larger responses fail the sync rather than being read into
+// memory in full.
+var MaxDownloadSize int64 = 1 << 30
+
+// httpFetcher downloads an archive from an HTTP endpoint.
+type httpFetcher struct {
+	cfg    SourceConfig
+	client *http.Client
+}
+
+func newHTTPFetcher(cfg SourceConfig) (Fetcher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("source %s: http source requires a url", cfg.Name)
+	}
+	return &httpFetcher{cfg: cfg, client: http.DefaultClient}, nil
+}
+
+func (h *httpFetcher) Fetch(ctx context.Context, cacheDir string) (*Snapshot, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", h.cfg.URL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxDownloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", h.cfg.URL, err)
+	}
+	if int64(len(data)) > MaxDownloadSize {
+		return nil, fmt.Errorf("reading %s: archive exceeds %d bytes", h.cfg.URL, MaxDownloadSize)
+	}
+
+	key := digest(data)
+	dir, err := extractToCache(cacheDir, key, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("extracting %s: %v", h.cfg.URL, err)
+	}
+
+	timestamp := time.Now()
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		timestamp = lm
+	}
+	return &Snapshot{
+		Key: key,
+		Dir: dir,
+		Source: TreeSource{
+			Type:      "remote",
+			Path:      h.cfg.URL,
+			Timestamp: timestamp,
+		},
+	}, nil
+}
+
+// gitFetcher reads coverage data committed to a git repository.
+// It keeps a bare mirror of the repository in the cache and extracts
+// the configured path at the resolved commit.
+type gitFetcher struct {
+	cfg SourceConfig
+}
+
+func newGitFetcher(cfg SourceConfig) (Fetcher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("source %s: git source requires a url", cfg.Name)
+	}
+	return &gitFetcher{cfg: cfg}, nil
+}
+
+func (g *gitFetcher) Fetch(ctx context.Context, cacheDir string) (*Snapshot, error) {
+	mirror := filepath.Join(cacheDir, "git", digest([]byte(g.cfg.URL))[:16])
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(mirror), 0755); err != nil {
+			return nil, err
+		}
+		if _, err := runGit(ctx, "", "clone", "--mirror", "--quiet", g.cfg.URL, mirror); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := runGit(ctx, mirror, "fetch", "--prune", "--quiet", "origin"); err != nil {
+			return nil, err
+		}
+	}
+
+	ref := g.cfg.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	out, err := runGit(ctx, mirror, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	commit := strings.TrimSpace(string(out))
+
+	source := TreeSource{
+		Type:       "git",
+		Repository: g.cfg.URL,
+		Commit:     commit,
+		Path:       g.cfg.Path,
+	}
+	if g.cfg.Ref != "" && g.cfg.Ref != commit {
+		source.Branch = g.cfg.Ref
+	}
+	if out, err := runGit(ctx, mirror, "show", "-s", "--format=%ct", commit); err == nil {
+		if sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err == nil {
+			source.Timestamp = time.Unix(sec, 0)
+		}
+	}
+
+	// The same commit may hold several coverage paths; key on both.
+	key := commit
+	if g.cfg.Path != "" {
+		key = digest([]byte(commit + ":" + g.cfg.Path))
+	}
+
+	args := []string{"archive", "--format=tar", commit}
+	if g.cfg.Path != "" {
+		args = append(args, "--", g.cfg.Path)
+	}
+	archive, err := runGit(ctx, mirror, args...)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := extractToCache(cacheDir, key, bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("extracting %s at %s: %v", g.cfg.Path, commit, err)
+	}
+	if g.cfg.Path != "" {
+		dir = filepath.Join(dir, filepath.FromSlash(g.cfg.Path))
+	}
+	return &Snapshot{Key: key, Dir: dir, Source: source}, nil
+}
+
+func runGit(ctx context.Context, gitDir string, args ...string) ([]byte, error) {
+	subcmd := args[0]
+	if gitDir != "" {
+		args = append([]string{"--git-dir", gitDir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", subcmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// extractToCache extracts a tar or gzip-compressed tar archive into
+// cacheDir/<key>. If that directory already exists the archive is assumed
+// to have been extracted before and is not extracted again.
+func extractToCache(cacheDir, key string, r io.Reader) (string, error) {
+	dest := filepath.Join(cacheDir, "snapshots", key)
+	if _, err := os.Stat(dest); err == nil {
+		return dest, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(cacheDir, ".extract-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := extractTar(tmp, r); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+func extractTar(dest string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(hdr.Name)
+		if name == "." && hdr.Typeflag == tar.TypeDir {
+			// Archives created with "tar -C dir ." start with a "./" entry.
+			continue
+		}
+		if name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid archive entry %q", hdr.Name)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			// Links and special files never hold coverage data.
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covforest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/tmc/covutil/internal/coverage"
+	"github.com/tmc/covutil/internal/coverage/encodecounter"
+	"github.com/tmc/covutil/internal/coverage/encodemeta"
+	"github.com/tmc/covutil/internal/coverage/slicewriter"
+)
+
+type testCounters [][]uint32
+
+func (c testCounters) VisitFuncs(f encodecounter.CounterVisitorFn) error {
+	for i, counts := range c {
+		if err := f(0, uint32(i), counts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTestCoverage writes a GOCOVERDIR-style pod for a single package
+// with two functions into dir.
+func writeTestCoverage(t *testing.T, dir string, counts testCounters) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := encodemeta.NewCoverageMetaDataBuilder("example.com/pkg", "pkg", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.AddFunc(coverage.FuncDesc{
+		Funcname: "Hello",
+		Srcfile:  "example.com/pkg/hello.go",
+		Units: []coverage.CoverableUnit{
+			{StLine: 3, StCol: 14, EnLine: 5, EnCol: 2, NxStmts: 1},
+			{StLine: 6, StCol: 2, EnLine: 8, EnCol: 3, NxStmts: 2},
+		},
+	})
+	b.AddFunc(coverage.FuncDesc{
+		Funcname: "Goodbye",
+		Srcfile:  "example.com/pkg/hello.go",
+		Units: []coverage.CoverableUnit{
+			{StLine: 10, StCol: 16, EnLine: 12, EnCol: 2, NxStmts: 1},
+		},
+	})
+	ws := &slicewriter.WriteSeeker{}
+	if _, err := b.Emit(ws); err != nil {
+		t.Fatal(err)
+	}
+	blob := ws.BytesWritten()
+	hash := md5.Sum(blob)
+
+	mf, err := os.Create(filepath.Join(dir, fmt.Sprintf("covmeta.%x", hash)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfw := encodemeta.NewCoverageMetaFileWriter(mf.Name(), mf)
+	if err := mfw.Write(hash, [][]byte{blob}, coverage.CtrModeCount, coverage.CtrGranularityPerBlock); err != nil {
+		t.Fatal(err)
+	}
+	mf.Close()
+
+	cf, err := os.Create(filepath.Join(dir, fmt.Sprintf("covcounters.%x.1.1", hash)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfw := encodecounter.NewCoverageDataWriter(cf, coverage.CtrULeb128)
+	if err := cfw.Write(hash, map[string]string{"GOOS": "linux", "GOARCH": "amd64"}, counts); err != nil {
+		t.Fatal(err)
+	}
+	cf.Close()
+}
+
+// tarDir archives the contents of dir as a gzip-compressed tarball, laid
+// out like "tar -czf - -C dir ." would: every name starts with "./" and
+// directories, including "./" itself, have their own entries.
+func tarDir(t *testing.T, dir string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		name := "./" + filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == "." {
+				name = "./"
+			} else {
+				name += "/"
+			}
+			return tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir})
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	zw.Close()
+	return buf.Bytes()
+}
+
+func syncOnce(t *testing.T, f *Forest, cfg *SyncConfig) []SyncResult {
+	t.Helper()
+	results := f.Sync(context.Background(), cfg)
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatalf("sync %s: %v", r.Name, r.Err)
+		}
+	}
+	return results
+}
+
+func TestSyncHTTP(t *testing.T) {
+	covDir := filepath.Join(t.TempDir(), "cov")
+	writeTestCoverage(t, covDir, testCounters{{1, 0}, {0}})
+	archive := tarDir(t, covDir)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	cfg := &SyncConfig{
+		CacheDir: t.TempDir(),
+		Sources:  []SourceConfig{{Name: "ci", Type: "http", URL: srv.URL + "/cov.tar.gz", Machine: "worker-1"}},
+	}
+	forest := NewForest()
+
+	results := syncOnce(t, forest, cfg)
+	if !results[0].Added {
+		t.Fatalf("first sync did not add a tree")
+	}
+	tree, err := forest.GetTree(results[0].TreeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Source.Type != "remote" || tree.Source.Machine != "worker-1" {
+		t.Errorf("unexpected source %+v", tree.Source)
+	}
+	pkg := tree.CoverageTree.GetPackage("example.com/pkg")
+	if pkg == nil {
+		t.Fatalf("package not loaded; have %v", tree.CoverageTree.GetPackageNames())
+	}
+	if pkg.CoveredLines != 3 || pkg.TotalLines != 9 {
+		t.Errorf("got %d/%d covered lines, want 3/9", pkg.CoveredLines, pkg.TotalLines)
+	}
+
+	results = syncOnce(t, forest, cfg)
+	if results[0].Added {
+		t.Errorf("second sync of unchanged artifact added a tree")
+	}
+	if len(forest.Trees) != 1 {
+		t.Errorf("forest has %d trees, want 1", len(forest.Trees))
+	}
+
+	defer func(n int64) { MaxDownloadSize = n }(MaxDownloadSize)
+	MaxDownloadSize = int64(len(archive)) - 1
+	cfg.CacheDir = t.TempDir()
+	if results := NewForest().Sync(context.Background(), cfg); results[0].Err == nil {
+		t.Errorf("sync of archive larger than MaxDownloadSize succeeded")
+	}
+}
+
+func TestSyncGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	work := t.TempDir()
+	bare := filepath.Join(t.TempDir(), "repo.git")
+	git := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git(work, "init", "-q", "-b", "main")
+	writeTestCoverage(t, filepath.Join(work, "coverage"), testCounters{{1, 1}, {0}})
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "coverage")
+	git(work, "clone", "-q", "--bare", work, bare)
+
+	cfg := &SyncConfig{
+		CacheDir: t.TempDir(),
+		Sources:  []SourceConfig{{Name: "main", Type: "git", URL: bare, Ref: "main", Path: "coverage"}},
+	}
+	forest := NewForest()
+
+	results := syncOnce(t, forest, cfg)
+	if !results[0].Added {
+		t.Fatalf("first sync did not add a tree")
+	}
+	tree, _ := forest.GetTree(results[0].TreeID)
+	if tree.Source.Type != "git" || len(tree.Source.Commit) != 40 || tree.Source.Branch != "main" {
+		t.Errorf("unexpected source %+v", tree.Source)
+	}
+	if got := tree.CoverageTree.Summary().CoveredLines; got != 6 {
+		t.Errorf("got %d covered lines, want 6", got)
+	}
+
+	if results := syncOnce(t, forest, cfg); results[0].Added {
+		t.Errorf("second sync of the same commit added a tree")
+	}
+
+	// A new commit produces a new tree.
+	writeTestCoverage(t, filepath.Join(work, "coverage"), testCounters{{1, 1}, {1}})
+	git(work, "commit", "-q", "-a", "-m", "more coverage")
+	git(work, "push", "-q", bare, "main")
+
+	results = syncOnce(t, forest, cfg)
+	if !results[0].Added {
+		t.Errorf("sync after new commit did not add a tree")
+	}
+	if len(forest.Trees) != 2 {
+		t.Errorf("forest has %d trees, want 2", len(forest.Trees))
+	}
+}
+
+func TestSyncLocalSources(t *testing.T) {
+	dir := t.TempDir()
+	covDir := filepath.Join(dir, "cov")
+	writeTestCoverage(t, covDir, testCounters{{0, 1}, {1}})
+	if err := os.WriteFile(filepath.Join(dir, "cov.tar.gz"), tarDir(t, covDir), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(dir, "sync.json")
+	config := `{
+  "cache_dir": "cache",
+  "sources": [
+    {"name": "local", "type": "dir", "path": "cov"},
+    {"name": "artifact", "type": "tarball", "path": "cov.tar.gz"}
+  ]
+}`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadSyncConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CacheDir != filepath.Join(dir, "cache") {
+		t.Errorf("cache dir not resolved relative to config: %s", cfg.CacheDir)
+	}
+
+	forest := NewForest()
+	syncOnce(t, forest, cfg)
+	results := syncOnce(t, forest, cfg)
+	for _, r := range results {
+		if r.Added {
+			t.Errorf("%s: re-sync added a tree", r.Name)
+		}
+	}
+	if len(forest.Trees) != 2 {
+		t.Errorf("forest has %d trees, want 2", len(forest.Trees))
+	}
+
+	// The same data reached through a renamed source, or through a
+	// second source, is not added again.
+	cfg.Sources = []SourceConfig{
+		{Name: "renamed", Type: "dir", Path: covDir},
+		{Name: "mirror", Type: "tarball", Path: filepath.Join(dir, "cov.tar.gz")},
+	}
+	for _, r := range syncOnce(t, forest, cfg) {
+		if r.Added {
+			t.Errorf("%s: sync of known snapshot added tree %s", r.Name, r.TreeID)
+		}
+		if _, err := forest.GetTree(r.TreeID); err != nil {
+			t.Errorf("%s: %v", r.Name, err)
+		}
+	}
+	if len(forest.Trees) != 2 {
+		t.Errorf("forest has %d trees, want 2", len(forest.Trees))
+	}
+}
+
+func TestSyncUnknownType(t *testing.T) {
+	forest := NewForest()
+	results := forest.Sync(context.Background(), &SyncConfig{
+		CacheDir: t.TempDir(),
+		Sources:  []SourceConfig{{Name: "s3", Type: "s3"}},
+	})
+	if results[0].Err == nil {
+		t.Errorf("expected error for unknown source type")
+	}
+}
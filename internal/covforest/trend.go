@@ -0,0 +1,187 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covforest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+// TrendOptions selects the trees and packages of a Trend.
+type TrendOptions struct {
+	// Branch restricts the trend to trees collected on this branch.
+	// If empty, trees of all branches are used.
+	Branch string
+	// Package restricts the trend to packages matching this pattern,
+	// as interpreted by covtree.MatchPackagePattern. If empty, all
+	// packages are used.
+	Package string
+	// Threshold is the drop in coverage, in percentage points, between
+	// consecutive commits above which a package is reported as having
+	// regressed.
+	Threshold float64
+}
+
+// Trend is the coverage of packages over time.
+type Trend struct {
+	Branch      string          `json:"branch,omitempty"`
+	Packages    []*PackageTrend `json:"packages"`
+	Regressions []*Regression   `json:"regressions,omitempty"`
+}
+
+// PackageTrend is the coverage of a package at successive commits.
+type PackageTrend struct {
+	ImportPath string       `json:"import_path"`
+	Points     []TrendPoint `json:"points"`
+}
+
+// TrendPoint is the coverage of a package at one commit. Trees collected
+// at the same commit, such as by several CI jobs, are merged into one
+// point; trees without a commit each make their own.
+type TrendPoint struct {
+	Commit       string    `json:"commit,omitempty"`
+	TreeIDs      []string  `json:"tree_ids"`
+	Timestamp    time.Time `json:"timestamp"`
+	TotalLines   int       `json:"total_lines"`
+	CoveredLines int       `json:"covered_lines"`
+	CoverageRate float64   `json:"coverage_rate"`
+}
+
+// Regression is a drop in the coverage of a package between consecutive
+// commits.
+type Regression struct {
+	ImportPath string     `json:"import_path"`
+	From       TrendPoint `json:"from"`
+	To         TrendPoint `json:"to"`
+	// Drop is the decrease in coverage in percentage points.
+	Drop float64 `json:"drop"`
+	// Functions lists the functions with units that were covered at the
+	// earlier commit and are not at the later one.
+	Functions []string `json:"functions,omitempty"`
+}
+
+// commitTrees is the merged coverage of the trees of one commit.
+type commitTrees struct {
+	commit string
+	ids    []string
+	time   time.Time
+	merged *covtree.CoverageTree
+	trees  []*Tree
+}
+
+// Trend returns the coverage of the packages of the forest over time, in
+// the order the trees were collected, and the regressions between
+// consecutive commits. The trees must have their coverage loaded.
+func (f *Forest) Trend(opts TrendOptions) *Trend {
+	var trees []*Tree
+	for _, t := range f.sortedTrees() {
+		if t.CoverageTree != nil && (opts.Branch == "" || t.Source.Branch == opts.Branch) {
+			trees = append(trees, t)
+		}
+	}
+	sort.SliceStable(trees, func(i, j int) bool { return collectedAt(trees[i]).Before(collectedAt(trees[j])) })
+
+	var commits []*commitTrees
+	byCommit := make(map[string]*commitTrees)
+	for _, t := range trees {
+		c := byCommit[t.Source.Commit]
+		if c == nil || t.Source.Commit == "" {
+			c = &commitTrees{commit: t.Source.Commit}
+			commits = append(commits, c)
+			if t.Source.Commit != "" {
+				byCommit[t.Source.Commit] = c
+			}
+		}
+		c.ids = append(c.ids, t.ID)
+		c.trees = append(c.trees, t)
+		c.time = collectedAt(t)
+	}
+	for _, c := range commits {
+		c.merged = mergeTrees(c.trees)
+	}
+
+	trend := &Trend{Branch: opts.Branch}
+	byPkg := make(map[string]*PackageTrend)
+	prev := make(map[string]*commitTrees)
+	for _, c := range commits {
+		for _, name := range c.merged.GetPackageNames() {
+			if opts.Package != "" && !covtree.MatchPackagePattern(opts.Package, name) {
+				continue
+			}
+			pkg := c.merged.Packages[name]
+			point := TrendPoint{
+				Commit:       c.commit,
+				TreeIDs:      c.ids,
+				Timestamp:    c.time,
+				TotalLines:   pkg.TotalLines,
+				CoveredLines: pkg.CoveredLines,
+				CoverageRate: pkg.CoverageRate,
+			}
+			pt := byPkg[name]
+			if pt == nil {
+				pt = &PackageTrend{ImportPath: name}
+				byPkg[name] = pt
+				trend.Packages = append(trend.Packages, pt)
+			}
+			if p := prev[name]; p != nil {
+				last := pt.Points[len(pt.Points)-1]
+				if drop := 100 * (last.CoverageRate - point.CoverageRate); drop > opts.Threshold {
+					trend.Regressions = append(trend.Regressions, &Regression{
+						ImportPath: name,
+						From:       last,
+						To:         point,
+						Drop:       drop,
+						Functions:  lostFunctions(p.merged.Packages[name], pkg),
+					})
+				}
+			}
+			pt.Points = append(pt.Points, point)
+			prev[name] = c
+		}
+	}
+	sort.Slice(trend.Packages, func(i, j int) bool { return trend.Packages[i].ImportPath < trend.Packages[j].ImportPath })
+	return trend
+}
+
+// lostFunctions returns the functions of pkg with a unit that was covered
+// in old and is not in pkg. Units are matched by position within their
+// function, so that code moving within a file is not mistaken for a loss,
+// or by source position if the number of units changed.
+func lostFunctions(old, pkg *covtree.PackageNode) []string {
+	type funcKey struct{ name, file string }
+	type unitKey struct{ stLine, stCol, enLine, enCol uint32 }
+	oldFuncs := make(map[funcKey]*covtree.FunctionNode)
+	for _, fn := range old.Functions {
+		oldFuncs[funcKey{fn.Name, fn.File}] = fn
+	}
+
+	var lost []string
+	for _, fn := range pkg.Functions {
+		ofn := oldFuncs[funcKey{fn.Name, fn.File}]
+		if ofn == nil {
+			continue
+		}
+		covered := make(map[unitKey]bool)
+		for _, u := range ofn.Units {
+			covered[unitKey{u.StartLine, u.StartCol, u.EndLine, u.EndCol}] = u.Covered
+		}
+		for i, u := range fn.Units {
+			var was bool
+			if len(ofn.Units) == len(fn.Units) {
+				was = ofn.Units[i].Covered
+			} else {
+				was = covered[unitKey{u.StartLine, u.StartCol, u.EndLine, u.EndCol}]
+			}
+			if was && !u.Covered {
+				lost = append(lost, fn.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(lost)
+	return lost
+}
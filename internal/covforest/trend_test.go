@@ -0,0 +1,80 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package covforest
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrend(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	forest := NewForest()
+	add := func(id, commit, branch string, at time.Duration, counts testCounters) {
+		t.Helper()
+		dir := filepath.Join(t.TempDir(), id)
+		writeTestCoverage(t, dir, counts)
+		tree := loadTestTree(t, id, dir)
+		tree.Source = TreeSource{Commit: commit, Branch: branch, Timestamp: base.Add(at)}
+		if err := forest.AddTree(tree); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Commit c2 is covered by two CI jobs whose trees are merged; c3
+	// loses the coverage of Goodbye, and c4 of most of Hello.
+	add("t1", "c1", "main", 0, testCounters{{1, 0}, {0}})
+	add("t2a", "c2", "main", 2*time.Hour, testCounters{{1, 0}, {0}})
+	add("t2b", "c2", "main", time.Hour, testCounters{{0, 0}, {1}})
+	add("t3", "c3", "main", 3*time.Hour, testCounters{{1, 1}, {0}})
+	add("t4", "c4", "main", 4*time.Hour, testCounters{{0, 1}, {0}})
+	add("dev", "d1", "dev", 5*time.Hour, testCounters{{0, 0}, {0}})
+
+	trend := forest.Trend(TrendOptions{Branch: "main", Package: "example.com/...", Threshold: 10})
+	if len(trend.Packages) != 1 {
+		t.Fatalf("got %d packages, want 1", len(trend.Packages))
+	}
+	var commits []string
+	var covered []int
+	for _, p := range trend.Packages[0].Points {
+		commits = append(commits, p.Commit)
+		covered = append(covered, p.CoveredLines)
+	}
+	if want := []string{"c1", "c2", "c3", "c4"}; !reflect.DeepEqual(commits, want) {
+		t.Errorf("commits = %v, want %v", commits, want)
+	}
+	if want := []int{3, 6, 6, 3}; !reflect.DeepEqual(covered, want) {
+		t.Errorf("covered lines = %v, want %v", covered, want)
+	}
+	if ids := trend.Packages[0].Points[1].TreeIDs; !reflect.DeepEqual(ids, []string{"t2b", "t2a"}) {
+		t.Errorf("c2 trees = %v", ids)
+	}
+
+	// c2 to c3 keeps the same number of lines, so only c3 to c4 is a
+	// regression.
+	if len(trend.Regressions) != 1 {
+		t.Fatalf("got %d regressions, want 1: %+v", len(trend.Regressions), trend.Regressions)
+	}
+	r := trend.Regressions[0]
+	if r.From.Commit != "c3" || r.To.Commit != "c4" || r.Drop < 33 || r.Drop > 34 {
+		t.Errorf("regression %s -> %s drop %.1f, want c3 -> c4 drop 33.3", r.From.Commit, r.To.Commit, r.Drop)
+	}
+	if !reflect.DeepEqual(r.Functions, []string{"Hello"}) {
+		t.Errorf("lost functions = %v, want [Hello]", r.Functions)
+	}
+
+	// A higher threshold hides the regression; an unmatched pattern
+	// hides the package.
+	if n := len(forest.Trend(TrendOptions{Branch: "main", Threshold: 40}).Regressions); n != 0 {
+		t.Errorf("got %d regressions above 40 points", n)
+	}
+	if n := len(forest.Trend(TrendOptions{Package: "other/..."}).Packages); n != 0 {
+		t.Errorf("got %d packages for unmatched pattern", n)
+	}
+	if n := len(forest.Trend(TrendOptions{}).Packages[0].Points); n != 5 {
+		t.Errorf("got %d points across branches, want 5", n)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package miniyaml parses the subset of YAML needed for configuration
+// files such as coverage policies, without an external dependency: block
+// mappings and sequences, flow sequences of scalars, quoted and plain
+// scalars, and comments. Anchors, multi-line scalars, flow mappings and
+// multiple documents are not supported.
+package miniyaml
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type line struct {
+	num    int // 1-based line number, for errors
+	indent int
+	text   string
+}
+
+// Parse decodes data into map[string]any, []any, string, float64, bool and
+// nil values, as encoding/json would decode the equivalent JSON, so that
+// the result can be re-encoded as JSON. An empty document yields nil.
+// Numbers must be finite: ".inf", ".nan" and their spellings accepted by
+// strconv.ParseFloat are rejected.
+func Parse(data []byte) (any, error) {
+	var lines []line
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(stripComment(raw), " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, line{num: i + 1, indent: len(raw) - len(text), text: text})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := &parser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.pos].num)
+	}
+	return v, nil
+}
+
+type parser struct {
+	lines []line
+	pos   int
+}
+
+func (p *parser) block(indent int) (any, error) {
+	if isSeqItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *parser) sequence(indent int) (any, error) {
+	seq := []any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || !isSeqItem(l.text) {
+			break
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+		switch {
+		case rest == "":
+			p.pos++
+			if p.pos >= len(p.lines) || p.lines[p.pos].indent <= indent {
+				seq = append(seq, nil)
+				continue
+			}
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		case isSeqItem(rest) || keyEnd(rest) >= 0:
+			// An inline block, as in "- key: value"; its remaining
+			// lines are indented to match the first.
+			p.lines[p.pos] = line{num: l.num, indent: indent + len(l.text) - len(rest), text: rest}
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		default:
+			v, err := parseScalar(rest, l.num)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			p.pos++
+		}
+	}
+	return seq, nil
+}
+
+func (p *parser) mapping(indent int) (any, error) {
+	m := map[string]any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		if isSeqItem(l.text) {
+			break
+		}
+		end := keyEnd(l.text)
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.num)
+		}
+		key, err := parseScalar(l.text[:end], l.num)
+		if err != nil {
+			return nil, err
+		}
+		k := fmt.Sprint(key)
+		if _, dup := m[k]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, k)
+		}
+		rest := strings.TrimSpace(l.text[end+1:])
+		p.pos++
+		if rest != "" {
+			if m[k], err = parseScalar(rest, l.num); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch {
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			m[k], err = p.block(p.lines[p.pos].indent)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSeqItem(p.lines[p.pos].text):
+			// A sequence may share its key's indentation.
+			m[k], err = p.sequence(indent)
+		default:
+			m[k] = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// keyEnd returns the index of the colon ending a mapping key in text,
+// or -1 if text is not a "key: value" pair.
+func keyEnd(text string) int {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 {
+				quote = c
+			}
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a trailing "# comment" outside of quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseScalar(s string, num int) (any, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: malformed string %s", num, s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("line %d: malformed string %s", num, s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("line %d: malformed sequence %s", num, s)
+		}
+		seq := []any{}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		if inner == "" {
+			return seq, nil
+		}
+		for _, item := range strings.Split(inner, ",") {
+			v, err := parseScalar(strings.TrimSpace(item), num)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	case strings.HasPrefix(s, "{"):
+		return nil, fmt.Errorf("line %d: flow mappings are not supported", num)
+	}
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	switch strings.ToLower(strings.TrimLeft(s, "+-")) {
+	case ".inf", ".nan":
+		return nil, fmt.Errorf("line %d: non-finite number %s is not supported", num, s)
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil || errors.Is(err, strconv.ErrRange) {
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("line %d: non-finite number %s is not supported", num, s)
+		}
+		return f, nil
+	}
+	return s, nil
+}
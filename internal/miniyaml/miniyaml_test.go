@@ -0,0 +1,76 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package miniyaml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want any
+	}{
+		{"empty", "# nothing\n---\n", nil},
+		{"scalars", "a: 1.5\nb: true\nc: ~\nd: plain text\ne: \"quoted # not a comment\"\nf: 'it''s'\n", map[string]any{
+			"a": 1.5, "b": true, "c": nil, "d": "plain text", "e": "quoted # not a comment", "f": "it's",
+		}},
+		{"nested", "outer:\n  inner:\n    k: v   # comment\n  n: 2\n", map[string]any{
+			"outer": map[string]any{"inner": map[string]any{"k": "v"}, "n": 2.0},
+		}},
+		{"sequence", "- 1\n- two\n-\n", []any{1.0, "two", nil}},
+		{"flow sequence", "tags: [a, 'b', 3]\nnone: []\n", map[string]any{
+			"tags": []any{"a", "b", 3.0}, "none": []any{},
+		}},
+		{"sequence of maps", "rules:\n  - pattern: x/...\n    min: 40\n  - pattern: y\n", map[string]any{
+			"rules": []any{map[string]any{"pattern": "x/...", "min": 40.0}, map[string]any{"pattern": "y"}},
+		}},
+		{"sequence at key indentation", "rules:\n- a\n- b\nnext: 1\n", map[string]any{
+			"rules": []any{"a", "b"}, "next": 1.0,
+		}},
+		{"nested sequences", "- - 1\n  - 2\n- 3\n", []any{[]any{1.0, 2.0}, 3.0}},
+		{"url value", "url: http://example.com/x\n", map[string]any{"url": "http://example.com/x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in, err string
+	}{
+		{"a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"a: 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"just text\n", `line 1: expected "key: value"`},
+		{"a:\n\tb: 1\n", "line 2: tabs are not allowed"},
+		{"a: \"open\n", "line 1: malformed string"},
+		{"a: 'open\n", "line 1: malformed string"},
+		{"a: [1, 2\n", "line 1: malformed sequence"},
+		{"a: {b: 1}\n", "line 1: flow mappings are not supported"},
+		{"a: .inf\n", "line 1: non-finite number .inf"},
+		{"a: -.Inf\n", "line 1: non-finite number -.Inf"},
+		{"a: nan\n", "line 1: non-finite number nan"},
+		{"a: [1, Infinity]\n", "line 1: non-finite number Infinity"},
+		{"a: 1e999\n", "line 1: non-finite number 1e999"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.in, err, tt.err)
+		}
+	}
+}
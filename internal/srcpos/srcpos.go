@@ -0,0 +1,72 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package srcpos maps the files and lines of a diff onto the coverable
+// units of coverage data.
+package srcpos
+
+import "strings"
+
+// MatchFile returns the key of files that diffPath names, preferring an
+// exact match and then the longest suffix match at a path element, or ""
+// if there is none. Diff paths are relative to the repository root while
+// coverage data names files by import path, so either may be a suffix of
+// the other.
+func MatchFile[V any](files map[string]V, diffPath string) string {
+	if _, ok := files[diffPath]; ok {
+		return diffPath
+	}
+	var best string
+	for f := range files {
+		if strings.HasSuffix(f, "/"+diffPath) || strings.HasSuffix(diffPath, "/"+f) {
+			if len(f) > len(best) || len(f) == len(best) && f < best {
+				best = f
+			}
+		}
+	}
+	return best
+}
+
+// Span is the source range of a coverable unit.
+type Span struct {
+	StartLine, StartCol uint32
+	EndLine, EndCol     uint32
+}
+
+// Contains reports whether s strictly contains the range of t.
+func (s Span) Contains(t Span) bool {
+	if s == t {
+		return false
+	}
+	startsBefore := s.StartLine < t.StartLine || s.StartLine == t.StartLine && s.StartCol <= t.StartCol
+	endsAfter := s.EndLine > t.EndLine || s.EndLine == t.EndLine && s.EndCol >= t.EndCol
+	return startsBefore && endsAfter
+}
+
+// Innermost returns the units spanning line that contain no other unit
+// spanning it, in order. Where units nest, as with function literals
+// inside a statement, only these describe the line. span returns the
+// range of a unit.
+func Innermost[U any](units []U, line uint32, span func(U) Span) []U {
+	var spanning []U
+	for _, u := range units {
+		if s := span(u); s.StartLine <= line && line <= s.EndLine {
+			spanning = append(spanning, u)
+		}
+	}
+	var inner []U
+	for i, u := range spanning {
+		contains := false
+		for j, v := range spanning {
+			if i != j && span(u).Contains(span(v)) {
+				contains = true
+				break
+			}
+		}
+		if !contains {
+			inner = append(inner, u)
+		}
+	}
+	return inner
+}
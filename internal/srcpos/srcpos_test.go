@@ -0,0 +1,53 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package srcpos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchFile(t *testing.T) {
+	files := map[string]int{
+		"example.com/mod/a.go":     0,
+		"example.com/mod/sub/a.go": 0,
+		"example.com/mod/xa.go":    0,
+	}
+	tests := []struct{ path, want string }{
+		{"example.com/mod/a.go", "example.com/mod/a.go"},
+		{"sub/a.go", "example.com/mod/sub/a.go"},
+		{"a.go", "example.com/mod/sub/a.go"},
+		{"src/example.com/mod/xa.go", "example.com/mod/xa.go"},
+		{"b.go", ""},
+	}
+	for _, tt := range tests {
+		if got := MatchFile(files, tt.path); got != tt.want {
+			t.Errorf("MatchFile(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestInnermost(t *testing.T) {
+	stmt := Span{1, 1, 5, 2}  // a statement holding a function literal
+	lit := Span{2, 10, 4, 3}  // the literal's body
+	dup := Span{2, 10, 4, 3}  // a second unit with the same range
+	next := Span{5, 3, 6, 10} // a unit starting on the statement's last line
+	units := []Span{stmt, lit, dup, next}
+	id := func(s Span) Span { return s }
+	tests := []struct {
+		line uint32
+		want []Span
+	}{
+		{1, []Span{stmt}},
+		{3, []Span{lit, dup}},
+		{5, []Span{stmt, next}},
+		{7, nil},
+	}
+	for _, tt := range tests {
+		if got := Innermost(units, tt.line, id); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Innermost(line %d) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package covutil
+
+import (
+	"fmt"
+	"io"
+)
+
+// --- Package-Level Merging ---
+
+// MergePackages merges profiles of different binaries, such as the test
+// binary of a package and the "go build -cover" binaries run by its
+// integration tests, whose meta-data files differ. Like "go tool covdata
+// merge", it returns a single profile with a synthesized meta-data file
+// holding the union of the profiles' packages.
+//
+// Packages are matched by import path. Where the profiles instrumented a
+// package identically, as shown by its fingerprint, the hash of its
+// meta-data, its counters are merged unit by unit. Otherwise, as when
+// the binaries were built from different versions of the source or with
+// different build tags, functions are matched by name and units by their
+// positions, and functions and units found in only some profiles are added
+// to the package.
+//
+// Counters are summed, saturating rather than wrapping around, except in
+// set mode, where a unit is set if it is set in any profile. MergePackages
+// returns an error if the profiles have different modes or granularities.
+func MergePackages(profiles ...*Profile) (*Profile, error) {
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no profiles to merge")
+	}
+	for i, p := range profiles {
+		if p == nil {
+			return nil, fmt.Errorf("profile %d is nil", i)
+		}
+		if p.Meta.Mode != profiles[0].Meta.Mode {
+			return nil, fmt.Errorf("profile %d mode %s mismatch canonical %s", i, p.Meta.Mode, profiles[0].Meta.Mode)
+		}
+		if p.Meta.Granularity != profiles[0].Meta.Granularity {
+			return nil, fmt.Errorf("profile %d gran %s mismatch canonical %s", i, p.Meta.Granularity, profiles[0].Meta.Granularity)
+		}
+	}
+
+	m := &packageMerger{
+		result: &Profile{
+			Meta: MetaFile{
+				Mode:        profiles[0].Meta.Mode,
+				Granularity: profiles[0].Meta.Granularity,
+			},
+			Counters: make(map[PkgFuncKey][]uint32),
+			Args:     make(map[string]string),
+		},
+		pkgs: make(map[string]*mergedPackage),
+	}
+	for _, p := range profiles {
+		for i := range p.Meta.Packages {
+			if err := m.add(&p.Meta.Packages[i], p.Counters); err != nil {
+				return nil, err
+			}
+		}
+		for k, v := range p.Args {
+			m.result.Args[k] = v
+		}
+	}
+
+	hash, err := EncodeMetaFile(io.Discard, &m.result.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("encoding merged meta-data: %w", err)
+	}
+	m.result.Meta.FileHash = hash
+	return m.result, nil
+}
+
+// packageMerger accumulates the packages of several profiles.
+type packageMerger struct {
+	result *Profile
+	pkgs   map[string]*mergedPackage // by import path
+}
+
+// mergedPackage records a package of the merged profile.
+type mergedPackage struct {
+	index       int            // in result.Meta.Packages
+	fingerprint [16]byte       // of the package as first added
+	funcs       map[string]int // function index by name
+}
+
+// unitPos is the position of a coverable unit in its source file.
+type unitPos struct {
+	startLine, startCol, endLine, endCol uint32
+}
+
+func (m *packageMerger) add(pkg *PackageMeta, counters map[PkgFuncKey][]uint32) error {
+	_, fingerprint, err := encodePackageMeta(pkg)
+	if err != nil {
+		return err
+	}
+	mp, ok := m.pkgs[pkg.Path]
+	if !ok {
+		mp = &mergedPackage{
+			index:       len(m.result.Meta.Packages),
+			fingerprint: fingerprint,
+			funcs:       make(map[string]int),
+		}
+		m.pkgs[pkg.Path] = mp
+		m.result.Meta.Packages = append(m.result.Meta.Packages, PackageMeta{
+			Path:       pkg.Path,
+			Name:       pkg.Name,
+			ModulePath: pkg.ModulePath,
+		})
+	}
+	dst := &m.result.Meta.Packages[mp.index]
+	for _, fn := range pkg.Functions {
+		key := PkgFuncKey{PkgPath: pkg.Path, FuncName: fn.FuncName}
+		counts := counters[key]
+		if len(counts) != len(fn.Units) {
+			counts = nil
+		}
+		fnIdx, ok := mp.funcs[fn.FuncName]
+		if !ok {
+			mp.funcs[fn.FuncName] = len(dst.Functions)
+			fn.PackagePath = pkg.Path
+			fn.Units = slicesClone(fn.Units)
+			dst.Functions = append(dst.Functions, fn)
+			if counts != nil {
+				m.result.Counters[key] = slicesClone(counts)
+			}
+			continue
+		}
+
+		// Units found in the package as first added keep their indices,
+		// so packages with the same fingerprint merge positionally. The
+		// units of a differing build are added even if it has no counters
+		// for the function, so that they count as uncovered.
+		dstFn := &dst.Functions[fnIdx]
+		idx := make([]int, len(fn.Units))
+		if fingerprint == mp.fingerprint {
+			for i := range idx {
+				idx[i] = i
+			}
+		} else {
+			pos := make(map[unitPos]int, len(dstFn.Units))
+			for i, u := range dstFn.Units {
+				pos[unitPos{u.StartLine, u.StartCol, u.EndLine, u.EndCol}] = i
+			}
+			for i, u := range fn.Units {
+				j, ok := pos[unitPos{u.StartLine, u.StartCol, u.EndLine, u.EndCol}]
+				if !ok {
+					j = len(dstFn.Units)
+					dstFn.Units = append(dstFn.Units, u)
+				}
+				idx[i] = j
+			}
+		}
+		dstCounts, ok := m.result.Counters[key]
+		if !ok && counts == nil {
+			continue
+		}
+		for len(dstCounts) < len(dstFn.Units) {
+			dstCounts = append(dstCounts, 0)
+		}
+		for i, c := range counts {
+			dstCounts[idx[i]] = m.mergeCount(dstCounts[idx[i]], c)
+		}
+		m.result.Counters[key] = dstCounts
+	}
+	return nil
+}
+
+func (m *packageMerger) mergeCount(a, b uint32) uint32 {
+	if m.result.Meta.Mode == ModeSet {
+		if a != 0 || b != 0 {
+			return 1
+		}
+		return 0
+	}
+	return saturatingAdd(a, b)
+}
@@ -0,0 +1,167 @@
+package covutil
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// mergeTestProfile returns a profile with the given packages, whose
+// functions have a one-line unit on each of the given lines.
+func mergeTestProfile(mode CounterMode, pkgs map[string]map[string][]uint32, counts map[string][]uint32) *Profile {
+	p := &Profile{
+		Meta:     MetaFile{Mode: mode, Granularity: GranularityBlock},
+		Counters: make(map[PkgFuncKey][]uint32),
+	}
+	for _, path := range sortedKeys(boolSet(pkgs)) {
+		pm := PackageMeta{Path: path, Name: path[len(path)-1:]}
+		for _, name := range sortedKeys(boolSet(pkgs[path])) {
+			fn := FuncDesc{PackagePath: path, FuncName: name, SrcFile: path + "/f.go"}
+			for _, line := range pkgs[path][name] {
+				fn.Units = append(fn.Units, CoverableUnit{StartLine: line, StartCol: 1, EndLine: line, EndCol: 10, NumStmt: 1})
+			}
+			pm.Functions = append(pm.Functions, fn)
+			if c, ok := counts[path+"."+name]; ok {
+				p.Counters[PkgFuncKey{PkgPath: path, FuncName: name}] = c
+			}
+		}
+		p.Meta.Packages = append(p.Meta.Packages, pm)
+	}
+	var err error
+	if p.Meta.FileHash, err = EncodeMetaFile(new(bytes.Buffer), &p.Meta); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func boolSet[V any](m map[string]V) map[string]bool {
+	s := make(map[string]bool, len(m))
+	for k := range m {
+		s[k] = true
+	}
+	return s
+}
+
+func TestMergePackages(t *testing.T) {
+	// The unit-test binary instruments a and b; the integration binary
+	// instruments a, an older version of b, and c.
+	unit := mergeTestProfile(ModeCount, map[string]map[string][]uint32{
+		"example.com/a": {"F": {1, 2}},
+		"example.com/b": {"G": {1, 2, 3}, "H": {10}},
+	}, map[string][]uint32{
+		"example.com/a.F": {1, 0},
+		"example.com/b.G": {1, 0, 1},
+		"example.com/b.H": {2},
+	})
+	integration := mergeTestProfile(ModeCount, map[string]map[string][]uint32{
+		"example.com/a": {"F": {1, 2}},
+		"example.com/b": {"G": {1, 3, 4}, "K": {20}},
+		"example.com/c": {"M": {5}},
+	}, map[string][]uint32{
+		"example.com/a.F": {2, 3},
+		"example.com/b.G": {4, 5, 6},
+		"example.com/b.K": {1},
+		"example.com/c.M": {7},
+	})
+	if unit.Meta.FileHash == integration.Meta.FileHash {
+		t.Fatal("test profiles have the same meta hash")
+	}
+	if _, err := MergeProfiles(unit, integration); err == nil {
+		t.Fatal("MergeProfiles of different binaries succeeded")
+	}
+
+	merged, err := MergePackages(unit, integration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, pkg := range merged.Meta.Packages {
+		paths = append(paths, pkg.Path)
+	}
+	if want := []string{"example.com/a", "example.com/b", "example.com/c"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("packages = %v, want %v", paths, want)
+	}
+	g := merged.Meta.Packages[1].Functions[0]
+	if g.FuncName != "G" || len(g.Units) != 4 || g.Units[3].StartLine != 4 {
+		t.Errorf("G = %+v, want the units of both versions", g)
+	}
+	want := map[PkgFuncKey][]uint32{
+		{PkgPath: "example.com/a", FuncName: "F"}: {3, 3},
+		{PkgPath: "example.com/b", FuncName: "G"}: {5, 0, 6, 6},
+		{PkgPath: "example.com/b", FuncName: "H"}: {2},
+		{PkgPath: "example.com/b", FuncName: "K"}: {1},
+		{PkgPath: "example.com/c", FuncName: "M"}: {7},
+	}
+	if !reflect.DeepEqual(merged.Counters, want) {
+		t.Errorf("counters = %v, want %v", merged.Counters, want)
+	}
+	if got := unit.Counters[PkgFuncKey{PkgPath: "example.com/a", FuncName: "F"}]; got[0] != 1 {
+		t.Errorf("MergePackages modified its input")
+	}
+
+	// The synthesized meta-data file can be written and read back.
+	var meta bytes.Buffer
+	hash, err := EncodeMetaFile(&meta, &merged.Meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != merged.Meta.FileHash {
+		t.Errorf("meta hash = %x, encoded as %x", merged.Meta.FileHash, hash)
+	}
+	if _, err := LoadMetaFile(&meta, "covmeta"); err != nil {
+		t.Errorf("reading merged meta-data: %v", err)
+	}
+
+	set := mergeTestProfile(ModeSet, map[string]map[string][]uint32{"example.com/a": {"F": {1, 2}}}, nil)
+	if _, err := MergePackages(unit, set); err == nil {
+		t.Errorf("MergePackages of profiles with different modes succeeded")
+	}
+}
+
+func TestMergePackagesUnexecutedBuild(t *testing.T) {
+	// F ran in the first build; the second build, which has an extra
+	// unit in F, never ran it.
+	executed := mergeTestProfile(ModeSet, map[string]map[string][]uint32{
+		"example.com/a": {"F": {1}},
+	}, map[string][]uint32{"example.com/a.F": {1}})
+	unexecuted := mergeTestProfile(ModeSet, map[string]map[string][]uint32{
+		"example.com/a": {"F": {1, 2}},
+	}, nil)
+
+	for _, order := range [][]*Profile{{executed, unexecuted}, {unexecuted, executed}} {
+		merged, err := MergePackages(order...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn := merged.Meta.Packages[0].Functions[0]
+		if len(fn.Units) != 2 {
+			t.Errorf("F has %d units, want 2", len(fn.Units))
+		}
+		key := PkgFuncKey{PkgPath: "example.com/a", FuncName: "F"}
+		if got := merged.Counters[key]; !reflect.DeepEqual(got, []uint32{1, 0}) {
+			t.Errorf("F counters = %v, want [1 0]", got)
+		}
+	}
+}
+
+func TestCoverageSetMergeBinaries(t *testing.T) {
+	cs := &CoverageSet{Pods: []*Pod{
+		{ID: "unit", Profile: mergeTestProfile(ModeSet, map[string]map[string][]uint32{
+			"example.com/a": {"F": {1, 2}},
+		}, map[string][]uint32{"example.com/a.F": {1, 0}})},
+		{ID: "integration", Profile: mergeTestProfile(ModeSet, map[string]map[string][]uint32{
+			"example.com/a": {"F": {1, 2}},
+			"example.com/c": {"M": {5}},
+		}, map[string][]uint32{"example.com/a.F": {1, 1}, "example.com/c.M": {1}})},
+	}}
+	pod, err := cs.Merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pod.Profile.Counters[PkgFuncKey{PkgPath: "example.com/a", FuncName: "F"}]; !reflect.DeepEqual(got, []uint32{1, 1}) {
+		t.Errorf("F = %v, want {1, 1}", got)
+	}
+	if len(pod.Profile.Meta.Packages) != 2 {
+		t.Errorf("merged %d packages, want 2", len(pod.Profile.Meta.Packages))
+	}
+}
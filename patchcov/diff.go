@@ -0,0 +1,150 @@
+package patchcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileDiff records the lines added or modified in one file of a patch.
+type FileDiff struct {
+	// OldPath is the file's path before the change, or "" if it was added.
+	OldPath string
+	// NewPath is the file's path after the change, or "" if it was deleted.
+	NewPath string
+	// Lines are the line numbers, in the new file, of added or modified
+	// lines, in increasing order.
+	Lines []int
+}
+
+// ParseUnifiedDiff parses a unified diff such as the output of "git diff"
+// or "diff -u" and returns the files it changes. Deleted files and files
+// with no added lines are included with an empty Lines slice.
+func ParseUnifiedDiff(r io.Reader) ([]*FileDiff, error) {
+	var (
+		files   []*FileDiff
+		cur     *FileDiff
+		newLine int // next line number in the new file
+		oldLeft int // lines of the current hunk still to read from the old file
+		newLeft int // lines of the current hunk still to read from the new file
+		lineNo  int
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		lineNo++
+		line := sc.Text()
+
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				cur.Lines = append(cur.Lines, newLine)
+				newLine++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+			case strings.HasPrefix(line, " "), line == "":
+				// Some tools strip the leading space of empty context lines.
+				newLine++
+				oldLeft--
+				newLeft--
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			default:
+				return nil, fmt.Errorf("line %d: unexpected line in hunk: %q", lineNo, line)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			cur = &FileDiff{}
+			files = append(files, cur)
+		case strings.HasPrefix(line, "--- "):
+			if cur == nil || cur.OldPath != "" || cur.NewPath != "" {
+				// Plain unified diffs have no "diff --git" header.
+				cur = &FileDiff{}
+				files = append(files, cur)
+			}
+			cur.OldPath = diffPath(line[len("--- "):], "a/")
+		case strings.HasPrefix(line, "+++ "):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: +++ header without preceding --- header", lineNo)
+			}
+			cur.NewPath = diffPath(line[len("+++ "):], "b/")
+		case strings.HasPrefix(line, "@@ "):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk outside of a file", lineNo)
+			}
+			_, oldCount, newStart, newCount, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			newLine, oldLeft, newLeft = newStart, oldCount, newCount
+		}
+		// Other lines (index, mode, rename and binary headers, or
+		// commit messages in "git show" output) carry no line changes.
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		sort.Ints(f.Lines)
+	}
+	return files, nil
+}
+
+// diffPath extracts a path from a ---/+++ header, dropping git's a/ or b/
+// prefix and any trailing timestamp. It returns "" for /dev/null.
+func diffPath(s, prefix string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if unq, err := strconv.Unquote(s); err == nil {
+		s = unq
+	}
+	if s == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(s, prefix)
+}
+
+// parseHunkHeader parses a header of the form "@@ -l,s +l,s @@".
+func parseHunkHeader(line string) (oldStart, oldCount, newStart, newCount int, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[3] != "@@" {
+		return 0, 0, 0, 0, fmt.Errorf("malformed hunk header %q", line)
+	}
+	oldStart, oldCount, err = parseRange(fields[1], "-")
+	if err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("malformed hunk header %q: %v", line, err)
+	}
+	newStart, newCount, err = parseRange(fields[2], "+")
+	if err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("malformed hunk header %q: %v", line, err)
+	}
+	return oldStart, oldCount, newStart, newCount, nil
+}
+
+// parseRange parses "-l,s" or "+l,s"; the count defaults to 1.
+func parseRange(s, sign string) (start, count int, err error) {
+	s, ok := strings.CutPrefix(s, sign)
+	if !ok {
+		return 0, 0, fmt.Errorf("range %q does not start with %q", s, sign)
+	}
+	startStr, countStr, hasCount := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(startStr); err != nil {
+		return 0, 0, err
+	}
+	count = 1
+	if hasCount {
+		if count, err = strconv.Atoi(countStr); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, count, nil
+}
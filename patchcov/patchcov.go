@@ -0,0 +1,141 @@
+// Package patchcov computes patch coverage: the share of the lines changed
+// by a patch that were executed according to a coverage tree.
+//
+// A patch is read as a unified diff, as produced by "git diff", and each
+// added or modified line is mapped onto the coverable units of the
+// covtree.FunctionNode values recorded for its file. Lines that fall
+// outside every unit (comments, declarations, blank lines) are not
+// counted. Everything is computed from local files; no network access
+// or version control is needed.
+//
+// Typical use:
+//
+//	diffs, err := patchcov.ParseUnifiedDiff(os.Stdin)
+//	...
+//	tree := covtree.NewCoverageTree()
+//	err = tree.LoadFromNestedRepository(coverDir)
+//	...
+//	report := patchcov.Analyze(tree, diffs)
+//	fmt.Printf("patch coverage: %.1f%%\n", report.Percent())
+package patchcov
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tmc/covutil/covtree"
+	"github.com/tmc/covutil/internal/srcpos"
+)
+
+// FileReport is the patch coverage of a single changed file.
+type FileReport struct {
+	// Path is the file's path as named in the diff.
+	Path string
+	// CoverageFile is the matching source path in the coverage data, or
+	// "" if the file has no coverage data.
+	CoverageFile string
+	// Changed is the number of added or modified lines.
+	Changed int
+	// Instrumented is the number of changed lines inside a coverable unit.
+	Instrumented int
+	// Covered is the number of instrumented changed lines that executed.
+	Covered int
+	// Uncovered lists the instrumented changed lines that did not execute.
+	Uncovered []int
+}
+
+// Report is the patch coverage of a whole diff.
+type Report struct {
+	// Files lists changed Go files, sorted by path. Test files and files
+	// without changed lines are omitted.
+	Files []FileReport
+	// Instrumented and Covered total the corresponding file counts.
+	Instrumented int
+	Covered      int
+}
+
+// Percent returns the percentage of instrumented changed lines that were
+// covered. A patch that changes no instrumented lines is fully covered.
+func (r *Report) Percent() float64 {
+	if r.Instrumented == 0 {
+		return 100
+	}
+	return 100 * float64(r.Covered) / float64(r.Instrumented)
+}
+
+// Unmatched returns the changed Go files for which the tree holds no
+// coverage data, such as packages that no test built.
+func (r *Report) Unmatched() []string {
+	var files []string
+	for _, f := range r.Files {
+		if f.CoverageFile == "" {
+			files = append(files, f.Path)
+		}
+	}
+	return files
+}
+
+// Analyze computes the patch coverage of diffs against tree.
+//
+// Diff paths are usually relative to a repository root while coverage
+// data records "<import path>/<file>", so a diff path matches a coverage
+// file when one is a suffix of the other at a path element boundary.
+func Analyze(tree *covtree.CoverageTree, diffs []*FileDiff) *Report {
+	files := make(map[string][]*covtree.FunctionNode)
+	for _, pkg := range tree.Packages {
+		for _, fn := range pkg.Functions {
+			files[fn.File] = append(files[fn.File], fn)
+		}
+	}
+
+	r := &Report{}
+	for _, d := range diffs {
+		if d.NewPath == "" || len(d.Lines) == 0 ||
+			path.Ext(d.NewPath) != ".go" || strings.HasSuffix(d.NewPath, "_test.go") {
+			continue
+		}
+		fr := FileReport{Path: d.NewPath, Changed: len(d.Lines)}
+		fr.CoverageFile = srcpos.MatchFile(files, d.NewPath)
+		if fr.CoverageFile != "" {
+			var units []covtree.CoverableUnitNode
+			for _, fn := range files[fr.CoverageFile] {
+				units = append(units, fn.Units...)
+			}
+			for _, line := range d.Lines {
+				instrumented, covered := lineCoverage(units, uint32(line))
+				if !instrumented {
+					continue
+				}
+				fr.Instrumented++
+				if covered {
+					fr.Covered++
+				} else {
+					fr.Uncovered = append(fr.Uncovered, line)
+				}
+			}
+		}
+		r.Instrumented += fr.Instrumented
+		r.Covered += fr.Covered
+		r.Files = append(r.Files, fr)
+	}
+	sort.Slice(r.Files, func(i, j int) bool { return r.Files[i].Path < r.Files[j].Path })
+	return r
+}
+
+// lineCoverage reports whether line lies inside a coverable unit and, if
+// so, whether it executed. Where units nest, as with function literals
+// inside a statement, only the innermost units spanning the line count.
+func lineCoverage(units []covtree.CoverableUnitNode, line uint32) (instrumented, covered bool) {
+	for _, u := range srcpos.Innermost(units, line, unitSpan) {
+		instrumented = true
+		if u.Covered {
+			covered = true
+		}
+	}
+	return instrumented, covered
+}
+
+func unitSpan(u covtree.CoverableUnitNode) srcpos.Span {
+	return srcpos.Span{StartLine: u.StartLine, StartCol: u.StartCol, EndLine: u.EndLine, EndCol: u.EndCol}
+}
@@ -0,0 +1,133 @@
+package patchcov
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tmc/covutil/covtree"
+)
+
+const testDiff = `diff --git a/a/a.go b/a/a.go
+index 3b18e51..a9c2f4e 100644
+--- a/a/a.go
++++ b/a/a.go
+@@ -3,6 +3,8 @@ package a
+ func Add(x, y int) int {
+-	if x < 0 {
++	if x < 0 || y < 0 {
+ 		return 0
+ 	}
++	// Sum the operands.
++	s := x + y
++	return s
+-	return x + y
+ }
+diff --git a/a/a_test.go b/a/a_test.go
+--- a/a/a_test.go
++++ b/a/a_test.go
+@@ -1 +1,2 @@
+ package a
++// test
+diff --git a/README.md b/README.md
+new file mode 100644
+--- /dev/null
++++ b/README.md
+@@ -0,0 +1 @@
++# a
+diff --git a/b/b.go b/b/b.go
+deleted file mode 100644
+--- a/b/b.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+-package b
+-
+diff --git a/c/c.go b/c/c.go
+--- a/c/c.go
++++ b/c/c.go
+@@ -10,0 +11 @@ func C() {
++	c()
+\ No newline at end of file
+`
+
+func TestParseUnifiedDiff(t *testing.T) {
+	files, err := ParseUnifiedDiff(strings.NewReader(testDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileDiff{
+		{OldPath: "a/a.go", NewPath: "a/a.go", Lines: []int{4, 7, 8, 9}},
+		{OldPath: "a/a_test.go", NewPath: "a/a_test.go", Lines: []int{2}},
+		{OldPath: "", NewPath: "README.md", Lines: []int{1}},
+		{OldPath: "b/b.go", NewPath: ""},
+		{OldPath: "c/c.go", NewPath: "c/c.go", Lines: []int{11}},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("got %d files, want %d", len(files), len(want))
+	}
+	for i, f := range files {
+		if !reflect.DeepEqual(*f, want[i]) {
+			t.Errorf("file %d: got %+v, want %+v", i, *f, want[i])
+		}
+	}
+}
+
+func TestParsePlainUnifiedDiff(t *testing.T) {
+	diff := "--- old/x.go\t2024-01-01 00:00:00\n+++ new/x.go\t2024-01-02 00:00:00\n@@ -1,2 +1,2 @@\n package x\n-var v = 1\n+var v = 2\n"
+	files, err := ParseUnifiedDiff(strings.NewReader(diff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].NewPath != "new/x.go" || !reflect.DeepEqual(files[0].Lines, []int{2}) {
+		t.Errorf("got %+v", files[0])
+	}
+
+	if _, err := ParseUnifiedDiff(strings.NewReader("--- a/x\n+++ b/x\n@@ bogus @@\n")); err == nil {
+		t.Errorf("expected error for malformed hunk header")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tree := covtree.NewCoverageTree()
+	tree.Packages["example.com/mod/a"] = &covtree.PackageNode{
+		ImportPath: "example.com/mod/a",
+		Functions: []*covtree.FunctionNode{{
+			Name: "Add",
+			File: "example.com/mod/a/a.go",
+			Units: []covtree.CoverableUnitNode{
+				{StartLine: 3, StartCol: 24, EndLine: 4, EndCol: 21, Count: 1, Covered: true},
+				{StartLine: 4, StartCol: 21, EndLine: 6, EndCol: 3},
+				{StartLine: 7, StartCol: 2, EndLine: 9, EndCol: 10, Count: 1, Covered: true},
+				// A function literal nested in the unit above, never called.
+				{StartLine: 8, StartCol: 10, EndLine: 8, EndCol: 20},
+			},
+		}},
+	}
+
+	diffs, err := ParseUnifiedDiff(strings.NewReader(testDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Analyze(tree, diffs)
+
+	if len(r.Files) != 2 {
+		t.Fatalf("got %d files, want 2: %+v", len(r.Files), r.Files)
+	}
+	a := r.Files[0]
+	if a.CoverageFile != "example.com/mod/a/a.go" {
+		t.Errorf("a/a.go matched %q", a.CoverageFile)
+	}
+	if a.Instrumented != 4 || a.Covered != 3 || !reflect.DeepEqual(a.Uncovered, []int{8}) {
+		t.Errorf("a/a.go: got %+v", a)
+	}
+	if got := r.Unmatched(); !reflect.DeepEqual(got, []string{"c/c.go"}) {
+		t.Errorf("Unmatched() = %v", got)
+	}
+	if got := r.Percent(); got != 75 {
+		t.Errorf("Percent() = %v, want 75", got)
+	}
+
+	if got := (&Report{}).Percent(); got != 100 {
+		t.Errorf("empty report Percent() = %v, want 100", got)
+	}
+}
@@ -98,8 +98,20 @@
 //	// Simulate execution tracking
 //	tracker.TrackExecution("setup.bash", "integration-test", 3) // export PATH
 //	tracker.TrackExecution("setup.bash", "integration-test", 4) // LOGFILE assignment
-//	tracker.TrackExecution("setup.bash", "integration-test", 9) // if statement
-//	tracker.TrackExecution("setup.bash", "integration-test", 13) // echo command
+//	tracker.TrackExecution("setup.bash", "integration-test", 11) // if statement
+//	tracker.TrackExecution("setup.bash", "integration-test", 15) // echo command
+//
+// The bash parser parses scripts into statements, so a command continued
+// over several lines or followed by a here document is tracked as one unit
+// spanning all its lines, and lines such as "fi" and "done" are not
+// counted.
+//
+// Rather than calling TrackExecution by hand, tests can run bash scripts
+// with the shexec subpackage, which traces them with xtrace and tracks each
+// line they execute, including lines of sourced files and functions:
+//
+//	cmd := shexec.Command(tracker, "integration-test", "setup.bash")
+//	out, err := cmd.CombinedOutput()
 //
 // # Python Script Example
 //
@@ -73,8 +73,8 @@ echo "Starting test" >> "$LOGFILE"
 	// Simulate execution tracking
 	tracker.TrackExecution("setup.bash", "integration-test", 3)  // export PATH
 	tracker.TrackExecution("setup.bash", "integration-test", 4)  // LOGFILE assignment
-	tracker.TrackExecution("setup.bash", "integration-test", 9)  // if statement
-	tracker.TrackExecution("setup.bash", "integration-test", 13) // echo command
+	tracker.TrackExecution("setup.bash", "integration-test", 11) // if statement
+	tracker.TrackExecution("setup.bash", "integration-test", 15) // echo command
 
 	report := tracker.GetReport()
 	fmt.Println(report)
@@ -83,9 +83,9 @@ echo "Starting test" >> "$LOGFILE"
 	// === Synthetic Coverage Report ===
 	//
 	// Artifact: setup.bash (Test: integration-test)
-	//   Commands: 11 total, 4 executed (36.4%)
+	//   Commands: 8 total, 4 executed (50.0%)
 	//
-	// Overall: 4/11 commands executed (36.4%)
+	// Overall: 4/8 commands executed (50.0%)
 }
 
 // Example demonstrates Python script tracking
@@ -3,7 +3,10 @@ package bash
 
 import (
 	"regexp"
+	"sort"
 	"strings"
+
+	"github.com/tmc/covutil/synthetic/parsers"
 )
 
 // Parser handles bash scripts with extended bash-specific features
@@ -24,9 +27,48 @@ func (p *Parser) Description() string {
 	return "Advanced bash syntax with functions, arrays, here docs"
 }
 
-// ParseScript analyzes bash script content and identifies executable lines
+// ParseUnits parses bash script content and returns its statements:
+// simple commands, [[ ]] and (( )) commands, and the headers of for, select
+// and case statements. A statement spans continuation lines and the bodies
+// of its here documents. ParseUnits returns a *SyntaxError if content is
+// not a valid bash script.
+func (p *Parser) ParseUnits(content string) ([]parsers.Unit, error) {
+	f, err := parse(content)
+	if err != nil {
+		return nil, err
+	}
+	var units []parsers.Unit
+	statements(f.stmts, func(pos, end int) {
+		text := strings.TrimRight(content[pos:end], " \t\r\n")
+		end = pos + len(text)
+		u := parsers.Unit{Text: text}
+		u.StartLine, u.StartCol = f.lines.position(pos)
+		u.EndLine, u.EndCol = f.lines.position(end - 1)
+		u.EndCol++
+		units = append(units, u)
+	})
+	sort.SliceStable(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		return a.StartCol < b.StartCol
+	})
+	return units, nil
+}
+
+// ParseScript analyzes bash script content and identifies executable lines.
+// It reports the first line of each statement found by ParseUnits, or, if
+// the script does not parse, classifies its lines one by one.
 func (p *Parser) ParseScript(content string) map[int]string {
 	lines := strings.Split(content, "\n")
+	if units, err := p.ParseUnits(content); err == nil {
+		commands := make(map[int]string)
+		for _, u := range units {
+			commands[u.StartLine] = strings.TrimSpace(lines[u.StartLine-1])
+		}
+		return commands
+	}
 	commands := make(map[int]string)
 
 	inFunction := false
@@ -0,0 +1,155 @@
+package bash
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUnits(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string // startLine.startCol,endLine.endCol text
+	}{
+		{
+			name: "heredoc with tabs stripped",
+			script: "cat <<-EOF > out\n" +
+				"\tfunction not_code() {\n" +
+				"\tEOF\n" +
+				"echo done\n",
+			want: []string{
+				"1.1,3.5 cat <<-EOF > out\n\tfunction not_code() {\n\tEOF",
+				"4.1,4.10 echo done",
+			},
+		},
+		{
+			name: "quoted heredoc in pipeline",
+			script: "cat <<'END' |\n" +
+				"$(not run)\n" +
+				"END\n" +
+				"  tr a-z A-Z\n",
+			want: []string{
+				"1.1,3.4 cat <<'END' |\n$(not run)\nEND",
+				"4.3,4.13 tr a-z A-Z",
+			},
+		},
+		{
+			name: "continuation lines",
+			script: "docker run \\\n" +
+				"  --rm \\\n" +
+				"  image # comment\n" +
+				"x=1; y=2\n",
+			want: []string{
+				"1.1,3.8 docker run \\\n  --rm \\\n  image",
+				"4.1,4.4 x=1",
+				"4.6,4.9 y=2",
+			},
+		},
+		{
+			name: "case arms",
+			script: "case \"$1\" in\n" +
+				"  start|up) run ;;\n" +
+				"  (stop)\n" +
+				"    halt\n" +
+				"    ;;\n" +
+				"  *) usage; exit 1 ;;\n" +
+				"esac\n",
+			want: []string{
+				"1.1,1.13 case \"$1\" in",
+				"2.13,2.16 run",
+				"4.5,4.9 halt",
+				"6.6,6.11 usage",
+				"6.13,6.19 exit 1",
+			},
+		},
+		{
+			name: "compound commands",
+			script: "deploy() {\n" +
+				"  local -a hosts=(a\n" +
+				"    b)\n" +
+				"  for h in \"${hosts[@]}\"; do\n" +
+				"    if [[ $h == a && -n $(echo \"$h\") ]]; then ssh \"$h\" true; fi\n" +
+				"  done\n" +
+				"}\n" +
+				"while (( n < 3 )); do n=$((n+1)); done\n",
+			want: []string{
+				"2.3,3.7 local -a hosts=(a\n    b)",
+				"4.3,4.25 for h in \"${hosts[@]}\"",
+				"5.8,5.40 [[ $h == a && -n $(echo \"$h\") ]]",
+				"5.47,5.60 ssh \"$h\" true",
+				"8.7,8.18 (( n < 3 ))",
+				"8.23,8.33 n=$((n+1))",
+			},
+		},
+		{
+			name:   "command substitution with case",
+			script: "x=$(case $y in a) echo a;; esac) && echo \"$x\" | wc -c 2>&1\n",
+			want: []string{
+				"1.1,1.33 x=$(case $y in a) echo a;; esac)",
+				"1.37,1.46 echo \"$x\"",
+				"1.49,1.59 wc -c 2>&1",
+			},
+		},
+	}
+	p := &Parser{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units, err := p.ParseUnits(tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, u := range units {
+				got = append(got, fmt.Sprintf("%d.%d,%d.%d %s", u.StartLine, u.StartCol, u.EndLine, u.EndCol, u.Text))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("units:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseUnitsSyntaxError(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"if true; then\n  echo\n", "3:1: unexpected end of file, expected \"fi\""},
+		{"echo 'unterminated\n", "1:6: unterminated single quote"},
+		{"x=$(echo\n", "1:3: unterminated $("},
+		{"echo a )\n", "1:8: unexpected \")\""},
+	}
+	for _, tt := range tests {
+		_, err := (&Parser{}).ParseUnits(tt.script)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) || err.Error() != tt.want {
+			t.Errorf("ParseUnits(%q) error = %v, want %s", tt.script, err, tt.want)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	p := &Parser{}
+	got := p.ParseScript("#!/bin/bash\n" +
+		"cat <<EOF\n" +
+		"function in_heredoc() {\n" +
+		"EOF\n" +
+		"a \\\n" +
+		"  b\n" +
+		"if true; then\n" +
+		"  :\n" +
+		"fi\n")
+	want := map[int]string{2: "cat <<EOF", 5: "a \\", 7: "if true; then", 8: ":"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseScript = %q, want %q", got, want)
+	}
+
+	// Scripts that do not parse are classified line by line.
+	got = p.ParseScript("echo 'a\nexport B=1\n")
+	if got[2] != "export B=1" {
+		t.Errorf("ParseScript of invalid script = %q, want line 2", got)
+	}
+}
@@ -3,6 +3,16 @@
 // The bash parser recognizes advanced bash-specific features beyond standard POSIX shell,
 // including bash builtins, test constructs, process substitution, arrays, and here documents.
 //
+// The parser parses scripts into a syntax tree rather than classifying
+// lines one at a time. ParseUnits returns the statements bash executes:
+// simple commands, [[ ]] and (( )) commands, and the headers of for, select
+// and case statements, with their line and column ranges. A statement
+// continued with a backslash, or followed by a here document, spans all of
+// its lines; case arms, "<<-" here documents with tab-indented
+// delimiters, and command substitutions are parsed as bash parses them.
+// ParseScript reports the first line of each statement, falling back to
+// line classification for scripts that do not parse.
+//
 // # Features
 //
 // - Function definitions and calls
@@ -19,13 +29,15 @@
 //
 //	parser := &bash.Parser{}
 //	commands := parser.ParseScript(bashScript)
+//	units, err := parser.ParseUnits(bashScript)
 //
 // The parser automatically registers itself with the global registry when the
 // defaults package is imported.
 //
 // # Recognized Patterns
 //
-// The parser identifies the following as executable:
+// For scripts that do not parse, and for IsExecutable, the parser
+// identifies the following as executable:
 //
 //   - Variable assignments (VAR=value)
 //   - Command executions with arguments
@@ -0,0 +1,760 @@
+package bash
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SyntaxError reports a script that bash would refuse to run.
+type SyntaxError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+type tokKind int
+
+const (
+	tEOF tokKind = iota
+	tNewline
+	tOp   // operator or redirection
+	tWord // word, including reserved words
+)
+
+type token struct {
+	kind     tokKind
+	val      string // operator, or source text of word
+	pos, end int
+}
+
+// heredoc is a here document whose body has not been read yet.
+type heredoc struct {
+	delim string
+	strip bool // <<-: leading tabs are removed
+	end   *int // end of the command reading it
+}
+
+// parser is a recursive-descent parser of bash scripts. The lexer is
+// driven by the parser, so that words can contain command substitutions,
+// which are parsed as scripts of their own.
+type parser struct {
+	src        string
+	lines      lineTable
+	off        int // next byte to lex
+	tok        token
+	heredocs   []*heredoc
+	errPos     int
+	errMessage string
+}
+
+// parse parses the script src.
+func parse(src string) (f *file, err error) {
+	p := &parser{src: src, lines: lineTable{0}}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(*parser); !ok {
+				panic(e)
+			}
+			line, col := p.lines.position(p.errPos)
+			err = &SyntaxError{Line: line, Col: col, Msg: p.errMessage}
+		}
+	}()
+	p.next()
+	f = &file{stmts: p.stmtList(), lines: p.lines}
+	if p.tok.kind != tEOF {
+		p.unexpected()
+	}
+	return f, nil
+}
+
+// lineTable holds the offsets of the starts of the lines of a script.
+type lineTable []int
+
+// position returns the 1-based line and column of offset off.
+func (t lineTable) position(off int) (line, col int) {
+	i := sort.Search(len(t), func(i int) bool { return t[i] > off }) - 1
+	return i + 1, off - t[i] + 1
+}
+
+func (p *parser) errorf(pos int, format string, args ...any) {
+	p.errPos = pos
+	p.errMessage = fmt.Sprintf(format, args...)
+	panic(p)
+}
+
+func (p *parser) unexpected() {
+	switch p.tok.kind {
+	case tEOF:
+		p.errorf(p.tok.pos, "unexpected end of file")
+	case tNewline:
+		p.errorf(p.tok.pos, "unexpected newline")
+	}
+	p.errorf(p.tok.pos, "unexpected %q", p.tok.val)
+}
+
+// --- Lexer ---
+
+// operators lists the operators, longest first where one is a prefix of
+// another.
+var operators = []string{
+	";;&", ";;", ";&", ";",
+	"&&", "&>>", "&>", "&",
+	"||", "|&", "|",
+	"(", ")",
+	"<<<", "<<-", "<<", "<&", "<>", "<",
+	">>", ">&", ">|", ">",
+}
+
+func isRedirect(op string) bool {
+	return op[0] == '<' || op[0] == '>' || strings.HasPrefix(op, "&>")
+}
+
+// next lexes the next token into p.tok.
+func (p *parser) next() {
+	p.skipBlanks()
+	pos := p.off
+	if pos >= len(p.src) {
+		p.tok = token{kind: tEOF, pos: pos, end: pos}
+		return
+	}
+	if p.src[pos] == '\n' {
+		p.off++
+		p.tok = token{kind: tNewline, val: "\n", pos: pos, end: p.off}
+		p.readHeredocs()
+		return
+	}
+
+	// A redirection may name its file descriptor: 2>&1.
+	i := pos
+	for i < len(p.src) && '0' <= p.src[i] && p.src[i] <= '9' {
+		i++
+	}
+	if i > pos && i < len(p.src) && (p.src[i] == '<' || p.src[i] == '>') && !p.at(i+1, "(") {
+		for _, op := range operators {
+			if p.at(i, op) && isRedirect(op) {
+				p.off = i + len(op)
+				p.tok = token{kind: tOp, val: op, pos: pos, end: p.off}
+				return
+			}
+		}
+	}
+
+	if (p.at(pos, "<") || p.at(pos, ">")) && p.at(pos+1, "(") {
+		p.word(false) // process substitution
+	} else {
+		for _, op := range operators {
+			if p.at(pos, op) {
+				p.off += len(op)
+				p.tok = token{kind: tOp, val: op, pos: pos, end: p.off}
+				return
+			}
+		}
+		p.word(false)
+	}
+	p.tok = token{kind: tWord, val: p.src[pos:p.off], pos: pos, end: p.off}
+}
+
+func (p *parser) at(off int, s string) bool {
+	return strings.HasPrefix(p.src[min(off, len(p.src)):], s)
+}
+
+// skipBlanks skips blanks, escaped newlines and comments.
+func (p *parser) skipBlanks() {
+	for p.off < len(p.src) {
+		switch c := p.src[p.off]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.off++
+		case c == '\\' && p.at(p.off+1, "\n"):
+			p.off += 2
+		case c == '#':
+			for p.off < len(p.src) && p.src[p.off] != '\n' {
+				p.off++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// assignPrefix matches the start of an array assignment: a=( or a+=(.
+var assignPrefix = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\[[^]]*\])?\+?=$`)
+
+// word advances past the word at p.off. In a [[ ]] conditional, where
+// test is set, only blanks and newlines end a word, and the word "]]"
+// ends where it is found.
+func (p *parser) word(test bool) {
+	start := p.off
+	for p.off < len(p.src) {
+		if test && p.src[start:p.off] == "]]" {
+			return
+		}
+		c := p.src[p.off]
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			return
+		case ';', '&', '|', '(', ')', '<', '>':
+			switch {
+			case (c == '<' || c == '>') && p.at(p.off+1, "("):
+				p.off += 2
+				p.subst(p.off - 2)
+			case c == '(' && assignPrefix.MatchString(p.src[start:p.off]):
+				p.parens()
+			case test:
+				p.off++
+			default:
+				return
+			}
+		case '\\':
+			p.off += 2
+		case '\'':
+			p.single()
+		case '"':
+			p.double()
+		case '`':
+			p.backquote()
+		case '$':
+			p.dollar()
+		case '@', '!', '+', '*', '?':
+			if p.at(p.off+1, "(") { // extended glob
+				p.off++
+				p.parens()
+			} else {
+				p.off++
+			}
+		default:
+			p.off++
+		}
+	}
+	p.off = min(p.off, len(p.src))
+}
+
+// single advances past the single-quoted string at p.off.
+func (p *parser) single() {
+	i := strings.IndexByte(p.src[p.off+1:], '\'')
+	if i < 0 {
+		p.errorf(p.off, "unterminated single quote")
+	}
+	p.off += i + 2
+}
+
+// double advances past the double-quoted string at p.off.
+func (p *parser) double() {
+	start := p.off
+	p.off++
+	for p.off < len(p.src) {
+		switch p.src[p.off] {
+		case '"':
+			p.off++
+			return
+		case '\\':
+			p.off += 2
+		case '$':
+			if p.at(p.off+1, "(") || p.at(p.off+1, "{") {
+				p.dollar()
+			} else {
+				p.off++ // $' and $" are not special in double quotes
+			}
+		case '`':
+			p.backquote()
+		default:
+			p.off++
+		}
+	}
+	p.errorf(start, "unterminated double quote")
+}
+
+// backquote advances past the `...` command substitution at p.off.
+func (p *parser) backquote() {
+	start := p.off
+	for p.off++; p.off < len(p.src); p.off++ {
+		switch p.src[p.off] {
+		case '\\':
+			p.off++
+		case '`':
+			p.off++
+			return
+		}
+	}
+	p.errorf(start, "unterminated backquote")
+}
+
+// dollar advances past the expansion or quoted string at p.off.
+func (p *parser) dollar() {
+	start := p.off
+	p.off++
+	switch {
+	case p.at(p.off, "'"):
+		for p.off++; p.off < len(p.src); p.off++ {
+			switch p.src[p.off] {
+			case '\\':
+				p.off++
+			case '\'':
+				p.off++
+				return
+			}
+		}
+		p.errorf(start, "unterminated $' quote")
+	case p.at(p.off, "\""):
+		p.double()
+	case p.at(p.off, "(("):
+		p.parens()
+	case p.at(p.off, "("):
+		p.off++
+		p.subst(start)
+	case p.at(p.off, "{"):
+		depth := 0
+		for p.off < len(p.src) {
+			switch p.src[p.off] {
+			case '{':
+				depth++
+				p.off++
+			case '}':
+				p.off++
+				if depth--; depth == 0 {
+					return
+				}
+			case '\\':
+				p.off += 2
+			case '\'':
+				p.single()
+			case '"':
+				p.double()
+			case '`':
+				p.backquote()
+			case '$':
+				p.dollar()
+			default:
+				p.off++
+			}
+		}
+		p.errorf(start, "unterminated ${")
+	}
+}
+
+// parens advances past the balanced parentheses at p.off, as found in
+// arithmetic expressions, array values and extended globs.
+func (p *parser) parens() {
+	start := p.off
+	depth := 0
+	for p.off < len(p.src) {
+		switch p.src[p.off] {
+		case '(':
+			depth++
+			p.off++
+		case ')':
+			p.off++
+			if depth--; depth == 0 {
+				return
+			}
+		case '\\':
+			p.off += 2
+		case '\'':
+			p.single()
+		case '"':
+			p.double()
+		case '`':
+			p.backquote()
+		case '$':
+			p.dollar()
+		default:
+			p.off++
+		}
+	}
+	p.errorf(start, "unterminated (")
+}
+
+// subst parses the script of the command or process substitution starting
+// at pos, whose "(" has been consumed, and advances past its ")".
+func (p *parser) subst(pos int) {
+	saved := p.tok
+	p.next()
+	p.stmtList()
+	if p.tok.kind != tOp || p.tok.val != ")" {
+		if p.tok.kind == tEOF {
+			p.errorf(pos, "unterminated %s", p.src[pos:pos+2])
+		}
+		p.unexpected()
+	}
+	p.tok = saved
+}
+
+// readHeredocs reads the bodies of the pending here documents, which
+// start at the line at p.off.
+func (p *parser) readHeredocs() {
+	for _, h := range p.heredocs {
+		for p.off < len(p.src) {
+			eol := strings.IndexByte(p.src[p.off:], '\n')
+			if eol < 0 {
+				eol = len(p.src)
+			} else {
+				eol += p.off
+			}
+			line := p.src[p.off:eol]
+			if h.strip {
+				line = strings.TrimLeft(line, "\t")
+			}
+			p.off = min(eol+1, len(p.src))
+			*h.end = max(*h.end, eol)
+			if line == h.delim {
+				break
+			}
+		}
+	}
+	p.heredocs = nil
+}
+
+// --- Parser ---
+
+// terminators are the reserved words that end a list of statements.
+var terminators = map[string]bool{
+	"then": true, "elif": true, "else": true, "fi": true,
+	"do": true, "done": true, "esac": true, "}": true,
+}
+
+func (p *parser) isWord(s string) bool {
+	return p.tok.kind == tWord && p.tok.val == s
+}
+
+func (p *parser) isOp(s string) bool {
+	return p.tok.kind == tOp && p.tok.val == s
+}
+
+// expect consumes the reserved word or operator s.
+func (p *parser) expect(s string) int {
+	if p.tok.val != s || p.tok.kind != tWord && p.tok.kind != tOp {
+		if p.tok.kind == tEOF {
+			p.errorf(p.tok.pos, "unexpected end of file, expected %q", s)
+		}
+		p.errorf(p.tok.pos, "unexpected %q, expected %q", p.tok.val, s)
+	}
+	end := p.tok.end
+	p.next()
+	return end
+}
+
+func (p *parser) skipNewlines() {
+	for p.tok.kind == tNewline {
+		p.next()
+	}
+}
+
+// stmtList parses statements up to a token that cannot start one.
+func (p *parser) stmtList() []command {
+	var cmds []command
+	for {
+		p.skipNewlines()
+		switch p.tok.kind {
+		case tEOF:
+			return cmds
+		case tOp:
+			if p.tok.val != "(" && !isRedirect(p.tok.val) {
+				return cmds
+			}
+		case tWord:
+			if terminators[p.tok.val] {
+				return cmds
+			}
+		}
+		cmds = append(cmds, p.andOr())
+		switch {
+		case p.isOp(";"), p.isOp("&"):
+			p.next()
+		case p.tok.kind == tNewline:
+		default:
+			return cmds
+		}
+	}
+}
+
+func (p *parser) andOr() command {
+	x := p.pipeline()
+	for p.isOp("&&") || p.isOp("||") {
+		op := p.tok.val
+		p.next()
+		p.skipNewlines()
+		x = &binaryCmd{op: op, x: x, y: p.pipeline()}
+	}
+	return x
+}
+
+func (p *parser) pipeline() command {
+	if p.isWord("time") {
+		p.next()
+		if p.isWord("-p") {
+			p.next()
+		}
+	}
+	for p.isWord("!") {
+		p.next()
+	}
+	x := p.command()
+	for p.isOp("|") || p.isOp("|&") {
+		op := p.tok.val
+		p.next()
+		p.skipNewlines()
+		x = &binaryCmd{op: op, x: x, y: p.command()}
+	}
+	return x
+}
+
+func (p *parser) command() command {
+	var cmd command
+	switch {
+	case p.isWord("if"):
+		cmd = p.ifClause()
+	case p.isWord("while"), p.isWord("until"):
+		cmd = p.whileClause()
+	case p.isWord("for"), p.isWord("select"):
+		cmd = p.forClause()
+	case p.isWord("case"):
+		cmd = p.caseClause()
+	case p.isWord("function"):
+		cmd = p.funcDecl()
+	case p.isWord("{"):
+		pos := p.tok.pos
+		p.next()
+		stmts := p.stmtList()
+		cmd = &block{pos: pos, stmts: stmts, end: p.expect("}")}
+	case p.isWord("[["):
+		cmd = p.testClause()
+	case p.isOp("(") && p.at(p.tok.end, "("):
+		pos := p.tok.pos
+		p.off = pos
+		p.parens()
+		cmd = &arithmCmd{pos: pos, end: p.off}
+		p.next()
+	case p.isOp("("):
+		pos := p.tok.pos
+		p.next()
+		stmts := p.stmtList()
+		cmd = &subshell{pos: pos, stmts: stmts, end: p.expect(")")}
+	case p.tok.kind == tWord, p.tok.kind == tOp && isRedirect(p.tok.val):
+		return p.simpleCommand()
+	default:
+		p.unexpected()
+	}
+	var end int
+	for p.tok.kind == tOp && isRedirect(p.tok.val) {
+		p.redirect(&end)
+	}
+	return cmd
+}
+
+// simpleCommand parses a simple command or a "name()" function
+// definition.
+func (p *parser) simpleCommand() command {
+	c := &callExpr{pos: p.tok.pos}
+	for words := 0; ; {
+		switch {
+		case p.tok.kind == tWord:
+			if words == 0 && c.end == 0 && p.nextIsParen() {
+				name := p.tok.val
+				p.next()
+				p.expect("(")
+				p.expect(")")
+				p.skipNewlines()
+				return &funcDecl{pos: c.pos, name: name, body: p.command()}
+			}
+			words++
+			c.end = p.tok.end
+			p.next()
+		case p.tok.kind == tOp && isRedirect(p.tok.val):
+			p.redirect(&c.end)
+		default:
+			return c
+		}
+	}
+}
+
+// nextIsParen reports whether the word in p.tok is followed by "(" after
+// blanks, as in "name ()".
+func (p *parser) nextIsParen() bool {
+	i := p.tok.end
+	for i < len(p.src) && (p.src[i] == ' ' || p.src[i] == '\t') {
+		i++
+	}
+	return p.at(i, "(") && !p.at(i, "((")
+}
+
+// redirect parses a redirection, extending *end to cover it and any here
+// document it reads.
+func (p *parser) redirect(end *int) {
+	op := p.tok.val
+	p.next()
+	if p.tok.kind != tWord {
+		p.unexpected()
+	}
+	*end = p.tok.end
+	if op == "<<" || op == "<<-" {
+		delim := strings.Map(func(r rune) rune {
+			if r == '\'' || r == '"' || r == '\\' {
+				return -1
+			}
+			return r
+		}, p.tok.val)
+		p.heredocs = append(p.heredocs, &heredoc{delim: delim, strip: op == "<<-", end: end})
+	}
+	p.next()
+}
+
+func (p *parser) ifClause() command {
+	c := &ifClause{pos: p.tok.pos}
+	p.next()
+	for cur := c; ; {
+		cur.cond = p.stmtList()
+		p.expect("then")
+		cur.then = p.stmtList()
+		if !p.isWord("elif") {
+			if p.isWord("else") {
+				p.next()
+				cur.els = p.stmtList()
+			}
+			break
+		}
+		elif := &ifClause{pos: p.tok.pos}
+		p.next()
+		cur.els = []command{elif}
+		cur = elif
+	}
+	c.end = p.expect("fi")
+	return c
+}
+
+func (p *parser) whileClause() command {
+	c := &whileClause{pos: p.tok.pos, until: p.tok.val == "until"}
+	p.next()
+	c.cond = p.stmtList()
+	p.expect("do")
+	c.do = p.stmtList()
+	c.end = p.expect("done")
+	return c
+}
+
+func (p *parser) forClause() command {
+	c := &forClause{pos: p.tok.pos, sel: p.tok.val == "select"}
+	p.next()
+	if p.isOp("(") && p.at(p.tok.end, "(") {
+		p.off = p.tok.pos
+		p.parens()
+		c.headerEnd = p.off
+		p.next()
+	} else {
+		if p.tok.kind != tWord {
+			p.unexpected()
+		}
+		c.headerEnd = p.tok.end
+		p.next()
+		p.skipNewlines()
+		if p.isWord("in") {
+			c.headerEnd = p.tok.end
+			for p.next(); p.tok.kind == tWord; p.next() {
+				c.headerEnd = p.tok.end
+			}
+		}
+	}
+	if p.isOp(";") {
+		p.next()
+	}
+	p.skipNewlines()
+	if p.isWord("{") {
+		body := p.command()
+		c.do = []command{body}
+		_, c.end = body.span()
+		return c
+	}
+	p.expect("do")
+	c.do = p.stmtList()
+	c.end = p.expect("done")
+	return c
+}
+
+func (p *parser) caseClause() command {
+	c := &caseClause{pos: p.tok.pos}
+	p.next()
+	if p.tok.kind != tWord {
+		p.unexpected()
+	}
+	p.next()
+	p.skipNewlines()
+	c.headerEnd = p.expect("in")
+	for {
+		p.skipNewlines()
+		if p.isWord("esac") {
+			break
+		}
+		if p.isOp("(") {
+			p.next()
+		}
+		for {
+			if p.tok.kind != tWord {
+				p.unexpected()
+			}
+			p.next()
+			if !p.isOp("|") {
+				break
+			}
+			p.next()
+		}
+		p.expect(")")
+		c.arms = append(c.arms, p.stmtList())
+		if p.isOp(";;") || p.isOp(";&") || p.isOp(";;&") {
+			p.next()
+		} else if !p.isWord("esac") {
+			p.unexpected()
+		}
+	}
+	c.end = p.expect("esac")
+	return c
+}
+
+func (p *parser) funcDecl() command {
+	pos := p.tok.pos
+	p.next()
+	if p.tok.kind != tWord {
+		p.unexpected()
+	}
+	name := p.tok.val
+	p.next()
+	if p.isOp("(") {
+		p.next()
+		p.expect(")")
+	}
+	p.skipNewlines()
+	return &funcDecl{pos: pos, name: name, body: p.command()}
+}
+
+// testClause parses a [[ ]] conditional. Its operands and operators are
+// read as words, since < and > compare strings and parentheses group
+// expressions.
+func (p *parser) testClause() command {
+	c := &testClause{pos: p.tok.pos}
+	p.off = p.tok.end
+	for {
+		p.skipBlanks()
+		for p.at(p.off, "\n") {
+			p.off++
+			p.skipBlanks()
+		}
+		if p.off >= len(p.src) {
+			p.errorf(c.pos, "unterminated [[")
+		}
+		start := p.off
+		p.word(true)
+		if p.src[start:p.off] == "]]" {
+			break
+		}
+	}
+	c.end = p.off
+	p.next()
+	return c
+}
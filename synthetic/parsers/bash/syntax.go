@@ -0,0 +1,147 @@
+package bash
+
+// The syntax tree of a bash script. Nodes record byte offsets into the
+// source; only the structure needed to find statements is kept.
+
+// file is a parsed script.
+type file struct {
+	stmts []command
+	lines lineTable
+}
+
+// command is a node of the syntax tree.
+type command interface {
+	span() (pos, end int)
+}
+
+// callExpr is a simple command: assignments, words and redirections.
+type callExpr struct {
+	pos, end int // end includes here-document bodies
+}
+
+// binaryCmd is a pipeline or an && or || list.
+type binaryCmd struct {
+	op   string
+	x, y command
+}
+
+// ifClause is an if statement; elif branches are nested in els.
+type ifClause struct {
+	pos, end  int
+	cond      []command
+	then, els []command
+}
+
+// whileClause is a while or until loop.
+type whileClause struct {
+	pos, end int
+	until    bool
+	cond, do []command
+}
+
+// forClause is a for or select loop. Its header runs from the keyword
+// to the end of the word list or arithmetic expression.
+type forClause struct {
+	pos, end  int
+	headerEnd int
+	sel       bool
+	do        []command
+}
+
+// caseClause is a case statement. Its header is "case word in".
+type caseClause struct {
+	pos, end  int
+	headerEnd int
+	arms      [][]command
+}
+
+// block is a { ... } group.
+type block struct {
+	pos, end int
+	stmts    []command
+}
+
+// subshell is a ( ... ) group.
+type subshell struct {
+	pos, end int
+	stmts    []command
+}
+
+// funcDecl is a function definition.
+type funcDecl struct {
+	pos  int
+	name string
+	body command
+}
+
+// arithmCmd is an (( ... )) arithmetic command.
+type arithmCmd struct {
+	pos, end int
+}
+
+// testClause is a [[ ... ]] conditional command.
+type testClause struct {
+	pos, end int
+}
+
+func (c *callExpr) span() (int, int)    { return c.pos, c.end }
+func (c *ifClause) span() (int, int)    { return c.pos, c.end }
+func (c *whileClause) span() (int, int) { return c.pos, c.end }
+func (c *forClause) span() (int, int)   { return c.pos, c.end }
+func (c *caseClause) span() (int, int)  { return c.pos, c.end }
+func (c *block) span() (int, int)       { return c.pos, c.end }
+func (c *subshell) span() (int, int)    { return c.pos, c.end }
+func (c *arithmCmd) span() (int, int)   { return c.pos, c.end }
+func (c *testClause) span() (int, int)  { return c.pos, c.end }
+
+func (c *binaryCmd) span() (int, int) {
+	pos, _ := c.x.span()
+	_, end := c.y.span()
+	return pos, end
+}
+
+func (c *funcDecl) span() (int, int) {
+	_, end := c.body.span()
+	return c.pos, end
+}
+
+// statements calls fn with the span of each statement in cmds that bash
+// executes as a unit: simple commands, conditional and arithmetic
+// commands, and the headers of for, select and case statements. Compound
+// commands contribute the statements they contain, and a function
+// definition the statements of its body.
+func statements(cmds []command, fn func(pos, end int)) {
+	for _, cmd := range cmds {
+		switch c := cmd.(type) {
+		case *callExpr:
+			fn(c.pos, c.end)
+		case *arithmCmd:
+			fn(c.pos, c.end)
+		case *testClause:
+			fn(c.pos, c.end)
+		case *binaryCmd:
+			statements([]command{c.x, c.y}, fn)
+		case *ifClause:
+			statements(c.cond, fn)
+			statements(c.then, fn)
+			statements(c.els, fn)
+		case *whileClause:
+			statements(c.cond, fn)
+			statements(c.do, fn)
+		case *forClause:
+			fn(c.pos, c.headerEnd)
+			statements(c.do, fn)
+		case *caseClause:
+			fn(c.pos, c.headerEnd)
+			for _, arm := range c.arms {
+				statements(arm, fn)
+			}
+		case *block:
+			statements(c.stmts, fn)
+		case *subshell:
+			statements(c.stmts, fn)
+		case *funcDecl:
+			statements([]command{c.body}, fn)
+		}
+	}
+}
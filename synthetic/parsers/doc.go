@@ -17,6 +17,16 @@
 //	    IsExecutable(line string) bool
 //	}
 //
+// Parsers that parse scripts into statements can also implement
+// UnitParser. Its ParseUnits method returns the statements as Units with
+// line and column ranges, which ScriptTracker prefers to ParseScript's
+// line classification:
+//
+//	interface UnitParser {
+//	    Parser
+//	    ParseUnits(content string) ([]Unit, error)
+//	}
+//
 // # Registry System
 //
 // The Registry manages parser registration and lookup:
@@ -25,6 +25,26 @@ type Parser interface {
 	Description() string
 }
 
+// Unit is a statement of a script, spanning one or more lines. Lines and
+// columns are 1-based; EndCol is the column just past the statement.
+type Unit struct {
+	StartLine, StartCol int
+	EndLine, EndCol     int
+	Text                string // source text of the statement
+}
+
+// UnitParser is implemented by parsers that parse scripts into statements
+// rather than classifying them line by line. Their units span the lines of
+// multi-line statements, such as commands continued with a backslash or
+// followed by here documents.
+type UnitParser interface {
+	Parser
+
+	// ParseUnits returns the statements of content in source order, or an
+	// error if content is not a valid script
+	ParseUnits(content string) ([]Unit, error)
+}
+
 // Registry manages available script parsers
 type Registry struct {
 	mu      sync.RWMutex
@@ -68,8 +68,20 @@ func (st *ScriptTracker) ParseAndTrack(scriptContent, scriptName, scriptType, te
 		return fmt.Errorf("no parser registered for script type: %s", scriptType)
 	}
 
-	// Parse the script to identify executable lines
-	commands := parser.ParseScript(scriptContent)
+	// Parse the script to identify executable lines, using the parser's
+	// statements where it reports them. Scripts it cannot parse into
+	// statements, such as fragments or scripts using syntax it does not
+	// support, are classified line by line.
+	var commands map[int]string
+	var units map[int]parsers.Unit
+	if up, ok := parser.(parsers.UnitParser); ok {
+		if stmts, err := up.ParseUnits(scriptContent); err == nil {
+			commands, units = lineUnits(scriptContent, stmts)
+		}
+	}
+	if commands == nil {
+		commands = parser.ParseScript(scriptContent)
+	}
 
 	// Set up tracking for each executable line
 	st.mu.Lock()
@@ -87,11 +99,33 @@ func (st *ScriptTracker) ParseAndTrack(scriptContent, scriptName, scriptType, te
 
 	coverage := st.coverages[key]
 	coverage.Commands = commands
+	coverage.Units = units
 	coverage.TotalLines = len(commands)
 
 	return nil
 }
 
+// lineUnits groups statements by the line they start on, since executions
+// are tracked by line. It returns the source of each such line and the
+// span of its statements.
+func lineUnits(content string, stmts []parsers.Unit) (map[int]string, map[int]parsers.Unit) {
+	lines := strings.Split(content, "\n")
+	commands := make(map[int]string)
+	units := make(map[int]parsers.Unit)
+	for _, u := range stmts {
+		if prev, ok := units[u.StartLine]; ok {
+			u.StartCol = min(u.StartCol, prev.StartCol)
+			if prev.EndLine > u.EndLine || prev.EndLine == u.EndLine && prev.EndCol > u.EndCol {
+				u.EndLine, u.EndCol = prev.EndLine, prev.EndCol
+			}
+			u.Text = strings.TrimSpace(lines[u.StartLine-1])
+		}
+		units[u.StartLine] = u
+		commands[u.StartLine] = strings.TrimSpace(lines[u.StartLine-1])
+	}
+	return commands, units
+}
+
 // GetRegisteredParsers returns information about all registered parsers
 func (st *ScriptTracker) GetRegisteredParsers() map[string][]string {
 	return st.registry.RegisteredTypes()
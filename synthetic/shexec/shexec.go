@@ -0,0 +1,308 @@
+// Package shexec runs bash scripts with execution tracing, recording the
+// lines they execute in a synthetic.ScriptTracker.
+//
+// A Cmd wraps an exec.Cmd running a script with bash. Before the script
+// runs, bash reads a startup file named by BASH_ENV that sets
+//
+//	PS4='+${BASH_SOURCE}:${LINENO}: '
+//
+// points BASH_XTRACEFD at a pipe and enables xtrace. Each traced command
+// then names the file and line it came from, whether it is in the script
+// itself, a file it sources, a function defined in one, or another bash
+// script it runs. The lines of each file are tracked under its path, as
+// reported by bash, once the file has been parsed with the bash parser:
+//
+//	tracker := synthetic.NewScriptTracker()
+//	cmd := shexec.Command(tracker, "TestDeploy", "scripts/deploy.sh", "--dry-run")
+//	out, err := cmd.CombinedOutput()
+//	...
+//	fmt.Print(tracker.GetReport())
+//
+// Tracing is turned off by "set +x" in the script, and a script that
+// enables xtrace itself writes its trace to the pipe rather than to its
+// standard error. Files that cannot be read or parsed, such as scripts
+// passed on standard input, are not tracked. Like exec.Cmd, Wait waits
+// for background processes that keep the trace pipe open.
+package shexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/covutil/synthetic"
+	"github.com/tmc/covutil/synthetic/parsers"
+	"github.com/tmc/covutil/synthetic/parsers/bash"
+)
+
+// PS4 is the prompt bash writes before each traced command.
+const PS4 = `+${BASH_SOURCE}:${LINENO}: `
+
+// traceLine matches the prompt of a traced command. The prompt's "+" is
+// repeated for each level of indirection, such as command substitutions.
+var traceLine = regexp.MustCompile(`^\++(.+?):([0-9]+): `)
+
+// Cmd is a bash script being prepared or run with execution tracing.
+type Cmd struct {
+	*exec.Cmd
+
+	tracker  *synthetic.ScriptTracker
+	testName string
+	script   string
+
+	startup string // BASH_ENV file
+	done    chan struct{}
+	files   map[string]*script // by source, as reported by bash
+}
+
+// script is a file whose executions are tracked.
+type script struct {
+	name  string
+	units []parsers.Unit
+	cache map[unitKey]int
+}
+
+type unitKey struct {
+	line int
+	word string
+}
+
+// Command returns a Cmd to run the bash script at path with the given
+// arguments, tracking the lines it executes under testName.
+func Command(tracker *synthetic.ScriptTracker, testName, path string, args ...string) *Cmd {
+	return newCmd(exec.Command("bash", append([]string{path}, args...)...), tracker, testName, path)
+}
+
+// CommandContext is like Command but includes a context, which kills the
+// script if it is done before the script completes.
+func CommandContext(ctx context.Context, tracker *synthetic.ScriptTracker, testName, path string, args ...string) *Cmd {
+	return newCmd(exec.CommandContext(ctx, "bash", append([]string{path}, args...)...), tracker, testName, path)
+}
+
+func newCmd(cmd *exec.Cmd, tracker *synthetic.ScriptTracker, testName, path string) *Cmd {
+	return &Cmd{
+		Cmd:      cmd,
+		tracker:  tracker,
+		testName: testName,
+		script:   path,
+		files:    make(map[string]*script),
+	}
+}
+
+// Start starts the script with tracing enabled. The script is parsed
+// first, so that its lines are tracked even if none of them run, and Start
+// returns an error if it cannot be parsed.
+func (c *Cmd) Start() error {
+	if c.done != nil {
+		return errors.New("shexec: already started")
+	}
+	if _, err := c.file(c.script); err != nil {
+		return fmt.Errorf("shexec: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	env := c.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	fd := 3 + len(c.ExtraFiles)
+	startup, err := writeStartup(env, fd)
+	if err != nil {
+		r.Close()
+		w.Close()
+		return err
+	}
+	c.Env = append(env, "BASH_ENV="+startup)
+	c.ExtraFiles = append(c.ExtraFiles, w)
+	err = c.Cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		os.Remove(startup)
+		return err
+	}
+
+	c.startup = startup
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.readTrace(r)
+		r.Close()
+	}()
+	return nil
+}
+
+// writeStartup writes the BASH_ENV file enabling tracing to fd, which
+// first reads any BASH_ENV file set in env.
+func writeStartup(env []string, fd int) (string, error) {
+	f, err := os.CreateTemp("", "shexec-*.bash")
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i := len(env) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(env[i], "BASH_ENV="); ok {
+			if v != "" {
+				fmt.Fprintf(&b, ". %s\n", quote(v))
+			}
+			break
+		}
+	}
+	fmt.Fprintf(&b, "PS4=%s\nBASH_XTRACEFD=%d\nset -x\n", quote(PS4), fd)
+	_, err = f.WriteString(b.String())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// quote returns s quoted for the shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Wait waits for the script to exit and for its trace to be read.
+func (c *Cmd) Wait() error {
+	if c.done == nil {
+		return errors.New("shexec: not started")
+	}
+	err := c.Cmd.Wait()
+	<-c.done
+	os.Remove(c.startup)
+	return err
+}
+
+// Run starts the script and waits for it to complete.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the script and returns its standard output.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+	err := c.Run()
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the script and returns its combined standard output
+// and standard error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	var b bytes.Buffer
+	c.Stdout = &b
+	c.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// readTrace tracks the executions recorded in the trace read from r.
+func (c *Cmd) readTrace(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		m := traceLine.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue // continuation of a multi-line command
+		}
+		f, err := c.file(m[1])
+		if err != nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[2])
+		if line, ok := f.unit(n, sc.Text()[len(m[0]):]); ok {
+			c.tracker.TrackExecution(f.name, c.testName, line)
+		}
+	}
+	io.Copy(io.Discard, r) // after an overlong line
+}
+
+// file returns the tracked file for source, parsing it on first use.
+func (c *Cmd) file(source string) (*script, error) {
+	if f, ok := c.files[source]; ok {
+		if f == nil {
+			return nil, errors.New("not tracked")
+		}
+		return f, nil
+	}
+	c.files[source] = nil
+	path := source
+	if !filepath.IsAbs(path) && c.Dir != "" {
+		path = filepath.Join(c.Dir, path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	units, err := (&bash.Parser{}).ParseUnits(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", source, err)
+	}
+	name := filepath.Clean(source)
+	if err := c.tracker.ParseAndTrack(string(content), name, "bash", c.testName); err != nil {
+		return nil, err
+	}
+	f := &script{name: name, units: units, cache: make(map[unitKey]int)}
+	c.files[source] = f
+	return f, nil
+}
+
+// unit returns the line a traced command starts on. Bash reports some
+// multi-line commands, such as array assignments and [[ ]] conditionals,
+// at their last line, so the command is matched by its first word against
+// the statements spanning line.
+func (f *script) unit(line int, command string) (int, bool) {
+	key := unitKey{line, firstWord(command)}
+	if start, ok := f.cache[key]; ok {
+		return start, start > 0
+	}
+	start := 0
+	for _, u := range f.units {
+		if u.StartLine > line {
+			break
+		}
+		if line > u.EndLine {
+			continue
+		}
+		start = u.StartLine
+		if firstWord(u.Text) == key.word {
+			break
+		}
+	}
+	f.cache[key] = start
+	return start, start > 0
+}
+
+func firstWord(s string) string {
+	if i := strings.IndexAny(s, " \t\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package shexec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tmc/covutil/synthetic"
+)
+
+func TestCommand(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+	dir := t.TempDir()
+	writeFile(t, dir, "deploy.sh", `#!/bin/bash
+source ./lib.sh
+cat <<EOF |
+deploying
+EOF
+  tr a-z A-Z
+hosts=(a
+  b)
+for h in "${hosts[@]}"; do
+  if [[ $h == a &&
+        -n $h ]]; then
+    greet "$h"
+  else
+    echo "skipping $h"
+  fi
+done
+if [[ $1 == --rollback ]]; then
+  echo "rolling back"
+fi
+bash ./check.sh
+`)
+	writeFile(t, dir, "lib.sh", `greet() {
+  echo "hello $1"
+}
+`)
+	writeFile(t, dir, "check.sh", "echo ok\n")
+
+	tracker := synthetic.NewScriptTracker()
+	cmd := Command(tracker, "TestDeploy", "./deploy.sh")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if want := "DEPLOYING\nhello a\nskipping b\nok\n"; string(out) != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+
+	pod, err := tracker.GeneratePod()
+	if err != nil {
+		t.Fatal(err)
+	}
+	executed := make(map[string][]int)
+	for key, counts := range pod.Profile.Counters {
+		if counts[0] != 0 {
+			artifact := strings.TrimPrefix(key.PkgPath, "synthetic/TestDeploy/")
+			line, _ := strconv.Atoi(strings.TrimPrefix(key.FuncName, "line_"))
+			executed[artifact] = append(executed[artifact], line)
+		}
+	}
+	for _, lines := range executed {
+		sort.Ints(lines)
+	}
+	want := map[string][]int{
+		"deploy.sh": {2, 3, 6, 7, 9, 10, 12, 14, 17, 20},
+		"lib.sh":    {2},
+		"check.sh":  {1},
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("executed lines = %v, want %v", executed, want)
+	}
+	if report := tracker.GetReport(); !strings.Contains(report, "Commands: 11 total, 10 executed") {
+		t.Errorf("report does not show 10 of 11 deploy.sh commands executed:\n%s", report)
+	}
+}
+
+func TestCommandParseError(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "bad.sh", "if true; then\n")
+	cmd := Command(synthetic.NewScriptTracker(), "TestBad", filepath.Join(dir, "bad.sh"))
+	if err := cmd.Run(); err == nil || !strings.Contains(err.Error(), "unexpected end of file") {
+		t.Errorf("Run of invalid script: err = %v, want syntax error", err)
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/synthetic/parsers"
 )
 
 // Tracker defines the interface for tracking coverage of non-Go artifacts
@@ -36,6 +37,7 @@ type Coverage struct {
 	TotalLines    int
 	ExecutedLines map[int]bool
 	Commands      map[int]string
+	Units         map[int]parsers.Unit // statement spans by start line, if known
 	TestName      string
 	Timestamp     time.Time
 }
@@ -139,7 +141,8 @@ func (t *BasicTracker) GeneratePod() (*covutil.Pod, error) {
 		for lineNum, command := range coverage.Commands {
 			funcName := fmt.Sprintf("line_%d", lineNum)
 
-			// Create a single coverable unit for this line
+			// Create a single coverable unit for this line, spanning the
+			// statements starting on it if the parser reported them
 			units := []covutil.CoverableUnit{
 				{
 					StartLine: uint32(lineNum),
@@ -149,6 +152,11 @@ func (t *BasicTracker) GeneratePod() (*covutil.Pod, error) {
 					NumStmt:   1,
 				},
 			}
+			if u, ok := coverage.Units[lineNum]; ok {
+				units[0].StartCol = uint32(u.StartCol)
+				units[0].EndLine = uint32(u.EndLine)
+				units[0].EndCol = uint32(u.EndCol)
+			}
 
 			funcDesc := covutil.FuncDesc{
 				FuncName: funcName,
@@ -339,8 +347,12 @@ func (t *BasicTracker) generateTextProfile() ([]byte, error) {
 			filePath := fmt.Sprintf("synthetic://%s/%s", coverage.TestName, coverage.ArtifactName)
 
 			// Format: file:startLine.startCol,endLine.endCol numStmt count
-			profile += fmt.Sprintf("%s:%d.1,%d.%d 1 %d\n",
-				filePath, lineNum, lineNum, len(command)+1, executed)
+			startCol, endLine, endCol := 1, lineNum, len(command)+1
+			if u, ok := coverage.Units[lineNum]; ok {
+				startCol, endLine, endCol = u.StartCol, u.EndLine, u.EndCol
+			}
+			profile += fmt.Sprintf("%s:%d.%d,%d.%d 1 %d\n",
+				filePath, lineNum, startCol, endLine, endCol, executed)
 		}
 	}
 
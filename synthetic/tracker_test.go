@@ -1,6 +1,9 @@
 package synthetic
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -245,3 +248,39 @@ func TestTrackerDisabled(t *testing.T) {
 		t.Error("Disabled tracker should not collect data")
 	}
 }
+
+func TestScriptTrackerUnits(t *testing.T) {
+	tracker := NewScriptTracker()
+	script := "#!/bin/bash\n" +
+		"cat <<EOF > config\n" +
+		"if this were code\n" +
+		"EOF\n" +
+		"deploy --env=prod \\\n" +
+		"  --wait; echo ok\n"
+	if err := tracker.ParseAndTrack(script, "deploy.sh", "bash", "units"); err != nil {
+		t.Fatal(err)
+	}
+	tracker.TrackExecution("deploy.sh", "units", 2)
+
+	pod, err := tracker.GeneratePod()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spans []string
+	for _, fn := range pod.Profile.Meta.Packages[0].Functions {
+		u := fn.Units[0]
+		spans = append(spans, fmt.Sprintf("%d.%d,%d.%d", u.StartLine, u.StartCol, u.EndLine, u.EndCol))
+	}
+	sort.Strings(spans)
+	if want := []string{"2.1,4.4", "5.1,6.9", "6.11,6.18"}; !reflect.DeepEqual(spans, want) {
+		t.Errorf("units = %v, want %v", spans, want)
+	}
+
+	// Scripts that do not parse are tracked line by line.
+	if err := tracker.ParseAndTrack("if true; then\n", "bad.sh", "bash", "units"); err != nil {
+		t.Fatal(err)
+	}
+	if cov := tracker.coverages["units:bad.sh"]; cov.Commands[1] != "if true; then" || cov.Units != nil {
+		t.Errorf("invalid script tracked with commands %q and units %v", cov.Commands, cov.Units)
+	}
+}
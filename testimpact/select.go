@@ -0,0 +1,182 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testimpact
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/internal/srcpos"
+	"github.com/tmc/covutil/patchcov"
+)
+
+// Selection is the set of tests affected by a change.
+type Selection struct {
+	// Tests lists the tests that executed a changed unit, sorted by name.
+	Tests []string `json:"tests"`
+	// Changed lists the changed units, ordered by file and position.
+	Changed []Unit `json:"changed"`
+	// Untested lists the changed units no test executed.
+	Untested []Unit `json:"untested,omitempty"`
+	// Unmatched lists the changed Go files with no coverage data, such as
+	// files of packages no test built. Tests of these files cannot be
+	// selected.
+	Unmatched []string `json:"unmatched,omitempty"`
+}
+
+// RunPattern returns a regular expression for the -run flag of "go test"
+// that matches the selected tests, or "^$" if there are none. Subtests
+// select their top-level test, and test names derived from directory
+// paths, such as "pkg/TestFoo", are reduced to the element naming a test
+// function.
+func (s *Selection) RunPattern() string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range s.Tests {
+		name = topLevelTest(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, regexp.QuoteMeta(name))
+		}
+	}
+	if len(names) == 0 {
+		return "^$"
+	}
+	sort.Strings(names)
+	return "^(" + strings.Join(names, "|") + ")$"
+}
+
+// topLevelTest returns the element of a slash-separated test name that
+// names a test function, or its first element if none does.
+func topLevelTest(name string) string {
+	elems := strings.Split(name, "/")
+	for _, e := range elems {
+		for _, prefix := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+			if strings.HasPrefix(e, prefix) {
+				return e
+			}
+		}
+	}
+	return elems[0]
+}
+
+// Select returns the tests of set that executed a unit spanning a line
+// added or modified by diffs. Where units nest, only the innermost units
+// spanning a line count. Test files and non-Go files are ignored; diff
+// paths are matched against the source paths recorded in the coverage
+// data by suffix, as by patchcov.Analyze.
+func Select(set *covutil.CoverageSet, diffs []*patchcov.FileDiff) *Selection {
+	files := make(map[string][]Unit)
+	for _, u := range allUnits(set) {
+		files[u.File] = append(files[u.File], u)
+	}
+
+	s := &Selection{}
+	changed := make(map[Unit]bool)
+	for _, d := range diffs {
+		if d.NewPath == "" || len(d.Lines) == 0 ||
+			path.Ext(d.NewPath) != ".go" || strings.HasSuffix(d.NewPath, "_test.go") {
+			continue
+		}
+		file := srcpos.MatchFile(files, d.NewPath)
+		if file == "" {
+			s.Unmatched = append(s.Unmatched, d.NewPath)
+			continue
+		}
+		for _, line := range d.Lines {
+			for _, u := range srcpos.Innermost(files[file], uint32(line), Unit.span) {
+				changed[u] = true
+			}
+		}
+	}
+	sort.Strings(s.Unmatched)
+	return s.finish(set, changed)
+}
+
+// SelectFuncs returns the tests of set that executed any unit of the
+// given functions, as identified by their package path and name.
+func SelectFuncs(set *covutil.CoverageSet, funcs []covutil.FuncDesc) *Selection {
+	want := make(map[covutil.PkgFuncKey]bool)
+	for _, fd := range funcs {
+		want[covutil.PkgFuncKey{PkgPath: fd.PackagePath, FuncName: fd.FuncName}] = true
+	}
+	changed := make(map[Unit]bool)
+	for _, u := range allUnits(set) {
+		if want[covutil.PkgFuncKey{PkgPath: u.PkgPath, FuncName: u.FuncName}] {
+			changed[u] = true
+		}
+	}
+	return (&Selection{}).finish(set, changed)
+}
+
+// finish fills in the tests that executed the changed units.
+func (s *Selection) finish(set *covutil.CoverageSet, changed map[Unit]bool) *Selection {
+	tested := make(map[Unit]bool)
+	s.Tests = []string{}
+	for _, name := range set.TestNames() {
+		selected := false
+		for _, cu := range set.UnitsCoveredBy(name) {
+			if u := unitOf(cu.PkgPath, cu.FuncName, cu.File, cu.Unit); changed[u] {
+				tested[u] = true
+				selected = true
+			}
+		}
+		if selected {
+			s.Tests = append(s.Tests, name)
+		}
+	}
+	for u := range changed {
+		s.Changed = append(s.Changed, u)
+		if !tested[u] {
+			s.Untested = append(s.Untested, u)
+		}
+	}
+	sortUnits(s.Changed)
+	sortUnits(s.Untested)
+	return s
+}
+
+// allUnits returns the units of every function in the meta-data of set,
+// executed or not.
+func allUnits(set *covutil.CoverageSet) []Unit {
+	seen := make(map[Unit]bool)
+	var units []Unit
+	for _, pod := range set.Pods {
+		if pod.Profile == nil {
+			continue
+		}
+		for _, pkg := range pod.Profile.Meta.Packages {
+			for _, fd := range pkg.Functions {
+				for _, cu := range fd.Units {
+					u := unitOf(pkg.Path, fd.FuncName, fd.SrcFile, cu)
+					if !seen[u] {
+						seen[u] = true
+						units = append(units, u)
+					}
+				}
+			}
+		}
+	}
+	return units
+}
+
+func unitOf(pkgPath, funcName, file string, cu covutil.CoverableUnit) Unit {
+	return Unit{
+		PkgPath:   pkgPath,
+		FuncName:  funcName,
+		File:      file,
+		StartLine: cu.StartLine,
+		StartCol:  cu.StartCol,
+		EndLine:   cu.EndLine,
+		EndCol:    cu.EndCol,
+	}
+}
+
+func (u Unit) span() srcpos.Span {
+	return srcpos.Span{StartLine: u.StartLine, StartCol: u.StartCol, EndLine: u.EndLine, EndCol: u.EndCol}
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package testimpact analyzes the coverage of individual tests, as
+// recorded in a coverage set holding the pods of each test under its name
+// (see covutil.PodTestName and covutil.WithTestDirectories).
+//
+// Analyze reports the units each test covers that no other test does, the
+// tests whose coverage is contained in that of another test, and a small
+// set of tests that together reach the coverage of all of them. Select
+// reports the tests that executed the code changed by a patch, so that
+// they can be run before the full suite.
+package testimpact
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/tmc/covutil"
+)
+
+// Unit identifies a coverable unit of a function.
+type Unit struct {
+	PkgPath   string `json:"pkg_path"`
+	FuncName  string `json:"func_name"`
+	File      string `json:"file"`
+	StartLine uint32 `json:"start_line"`
+	StartCol  uint32 `json:"start_col"`
+	EndLine   uint32 `json:"end_line"`
+	EndCol    uint32 `json:"end_col"`
+}
+
+// String returns the unit in file:line.col,line.col form.
+func (u Unit) String() string {
+	return fmt.Sprintf("%s:%d.%d,%d.%d", u.File, u.StartLine, u.StartCol, u.EndLine, u.EndCol)
+}
+
+// Test is the coverage of one test relative to the others.
+type Test struct {
+	Name string `json:"name"`
+	// Units is the number of units the test covers.
+	Units int `json:"units"`
+	// Unique lists the units no other test covers, ordered by file and
+	// position.
+	Unique []Unit `json:"unique,omitempty"`
+	// SameAs lists the tests covering exactly the same units.
+	SameAs []string `json:"same_as,omitempty"`
+	// SubsetOf lists the tests covering every unit this test covers and
+	// more.
+	SubsetOf []string `json:"subset_of,omitempty"`
+}
+
+// Redundant reports whether another test covers every unit the test
+// covers, so that removing one of them loses no coverage.
+func (t *Test) Redundant() bool {
+	return len(t.SameAs) > 0 || len(t.SubsetOf) > 0
+}
+
+// Report is the result of Analyze.
+type Report struct {
+	// Units is the number of units covered by any test.
+	Units int `json:"units"`
+	// Tests holds each test, sorted by name.
+	Tests []*Test `json:"tests"`
+	// Minimal is a set of tests that together cover every unit covered
+	// by any test, in the order they were chosen.
+	Minimal []string `json:"minimal"`
+}
+
+// ZeroValue returns the tests that cover no unit other tests do not also
+// cover, including those that cover no units at all.
+func (r *Report) ZeroValue() []*Test {
+	var tests []*Test
+	for _, t := range r.Tests {
+		if len(t.Unique) == 0 {
+			tests = append(tests, t)
+		}
+	}
+	return tests
+}
+
+// Analyze analyzes the coverage of the tests of set. Pods not attributed
+// to a test are ignored. Units are matched by position, so that pods built
+// from different binaries line up.
+//
+// The minimal set is chosen greedily, taking at each step the test
+// covering the most units not yet covered, and the first by name among
+// equals. It is not necessarily the smallest possible.
+func Analyze(set *covutil.CoverageSet) *Report {
+	index := make(map[Unit]int)
+	var units []Unit
+	names := set.TestNames()
+	covered := make([]bitset, len(names))
+	for i, name := range names {
+		for _, cu := range set.UnitsCoveredBy(name) {
+			u := unitOf(cu.PkgPath, cu.FuncName, cu.File, cu.Unit)
+			j, ok := index[u]
+			if !ok {
+				j = len(units)
+				index[u] = j
+				units = append(units, u)
+			}
+			covered[i].set(j)
+		}
+	}
+
+	// Count the tests covering each unit.
+	coverers := make([]int, len(units))
+	for _, c := range covered {
+		c.each(func(j int) { coverers[j]++ })
+	}
+
+	r := &Report{Units: len(units), Tests: make([]*Test, len(names))}
+	for i, name := range names {
+		t := &Test{Name: name, Units: covered[i].count()}
+		covered[i].each(func(j int) {
+			if coverers[j] == 1 {
+				t.Unique = append(t.Unique, units[j])
+			}
+		})
+		sortUnits(t.Unique)
+		for k, other := range names {
+			if k == i || !covered[i].subsetOf(covered[k]) {
+				continue
+			}
+			if covered[k].count() == t.Units {
+				t.SameAs = append(t.SameAs, other)
+			} else {
+				t.SubsetOf = append(t.SubsetOf, other)
+			}
+		}
+		r.Tests[i] = t
+	}
+	r.Minimal = minimalSet(names, covered, len(units))
+	return r
+}
+
+// minimalSet chooses tests greedily until all n units are covered.
+func minimalSet(names []string, covered []bitset, n int) []string {
+	var remaining bitset
+	for j := range n {
+		remaining.set(j)
+	}
+	minimal := []string{}
+	for remaining.count() > 0 {
+		best, bestGain := -1, 0
+		for i := range names {
+			if gain := covered[i].intersectCount(remaining); gain > bestGain {
+				best, bestGain = i, gain
+			}
+		}
+		minimal = append(minimal, names[best])
+		remaining.clear(covered[best])
+	}
+	return minimal
+}
+
+func sortUnits(units []Unit) {
+	sort.Slice(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		return a.StartCol < b.StartCol
+	})
+}
+
+// bitset is a set of unit indexes.
+type bitset []uint64
+
+func (s *bitset) set(i int) {
+	for len(*s) <= i/64 {
+		*s = append(*s, 0)
+	}
+	(*s)[i/64] |= 1 << (i % 64)
+}
+
+func (s bitset) each(f func(int)) {
+	for w, word := range s {
+		for word != 0 {
+			b := bits.TrailingZeros64(word)
+			f(w*64 + b)
+			word &^= 1 << b
+		}
+	}
+}
+
+func (s bitset) count() int {
+	n := 0
+	for _, word := range s {
+		n += bits.OnesCount64(word)
+	}
+	return n
+}
+
+// subsetOf reports whether every element of s is in t.
+func (s bitset) subsetOf(t bitset) bool {
+	for w, word := range s {
+		var other uint64
+		if w < len(t) {
+			other = t[w]
+		}
+		if word&^other != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s bitset) intersectCount(t bitset) int {
+	n := 0
+	for w := range min(len(s), len(t)) {
+		n += bits.OnesCount64(s[w] & t[w])
+	}
+	return n
+}
+
+// clear removes the elements of t from s.
+func (s bitset) clear(t bitset) {
+	for w := range min(len(s), len(t)) {
+		s[w] &^= t[w]
+	}
+}
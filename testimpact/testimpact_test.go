@@ -0,0 +1,135 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testimpact
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tmc/covutil"
+	"github.com/tmc/covutil/patchcov"
+)
+
+// testSet returns a coverage set with one pod per test of a function with
+// four units.
+func testSet(tests map[string][]uint32) *covutil.CoverageSet {
+	set := &covutil.CoverageSet{}
+	for name, counts := range tests {
+		set.Pods = append(set.Pods, &covutil.Pod{
+			ID:     name,
+			Labels: map[string]string{covutil.TestNameLabel: name},
+			Profile: &covutil.Profile{
+				Meta: covutil.MetaFile{
+					Packages: []covutil.PackageMeta{{
+						Path: "example.com/a",
+						Functions: []covutil.FuncDesc{{
+							FuncName: "F",
+							SrcFile:  "example.com/a/a.go",
+							Units: []covutil.CoverableUnit{
+								{StartLine: 1, EndLine: 1, NumStmt: 1},
+								{StartLine: 2, EndLine: 2, NumStmt: 1},
+								{StartLine: 3, EndLine: 3, NumStmt: 1},
+								{StartLine: 4, EndLine: 4, NumStmt: 1},
+							},
+						}},
+					}},
+				},
+				Counters: map[covutil.PkgFuncKey][]uint32{
+					{PkgPath: "example.com/a", FuncName: "F"}: counts,
+				},
+			},
+		})
+	}
+	return set
+}
+
+func TestAnalyze(t *testing.T) {
+	r := Analyze(testSet(map[string][]uint32{
+		"TestAll":  {1, 2, 3, 0},
+		"TestA":    {1, 0, 0, 0},
+		"TestA2":   {5, 0, 0, 0},
+		"TestD":    {0, 0, 0, 1},
+		"TestNone": {0, 0, 0, 0},
+	}))
+
+	if r.Units != 4 {
+		t.Errorf("Units = %d, want 4", r.Units)
+	}
+	type result struct {
+		units            int
+		unique           []uint32 // start lines
+		sameAs, subsetOf []string
+	}
+	want := map[string]result{
+		"TestA":    {1, nil, []string{"TestA2"}, []string{"TestAll"}},
+		"TestA2":   {1, nil, []string{"TestA"}, []string{"TestAll"}},
+		"TestAll":  {3, []uint32{2, 3}, nil, nil},
+		"TestD":    {1, []uint32{4}, nil, nil},
+		"TestNone": {0, nil, nil, []string{"TestA", "TestA2", "TestAll", "TestD"}},
+	}
+	got := make(map[string]result)
+	for _, test := range r.Tests {
+		res := result{units: test.Units, sameAs: test.SameAs, subsetOf: test.SubsetOf}
+		for _, u := range test.Unique {
+			res.unique = append(res.unique, u.StartLine)
+		}
+		got[test.Name] = res
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tests:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	var zero []string
+	for _, test := range r.ZeroValue() {
+		zero = append(zero, test.Name)
+	}
+	if want := []string{"TestA", "TestA2", "TestNone"}; !reflect.DeepEqual(zero, want) {
+		t.Errorf("ZeroValue() = %v, want %v", zero, want)
+	}
+	if want := []string{"TestAll", "TestD"}; !reflect.DeepEqual(r.Minimal, want) {
+		t.Errorf("Minimal = %v, want %v", r.Minimal, want)
+	}
+}
+
+func TestAnalyzeNoTests(t *testing.T) {
+	r := Analyze(&covutil.CoverageSet{})
+	if r.Units != 0 || len(r.Tests) != 0 || len(r.Minimal) != 0 {
+		t.Errorf("Analyze of empty set = %+v", r)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	set := testSet(map[string][]uint32{
+		"TestAll":        {1, 2, 3, 0},
+		"TestNone":       {0, 0, 0, 0},
+		"pkg/TestA/sub":  {1, 0, 0, 0},
+		"pkg/TestA/sub2": {1, 0, 0, 0},
+	})
+	s := Select(set, []*patchcov.FileDiff{
+		{NewPath: "a/a.go", Lines: []int{1, 4, 9}},
+		{NewPath: "a/a_test.go", Lines: []int{1}},
+		{NewPath: "b/b.go", Lines: []int{1}},
+	})
+	if want := []string{"TestAll", "pkg/TestA/sub", "pkg/TestA/sub2"}; !reflect.DeepEqual(s.Tests, want) {
+		t.Errorf("Tests = %v, want %v", s.Tests, want)
+	}
+	if got, want := s.RunPattern(), "^(TestA|TestAll)$"; got != want {
+		t.Errorf("RunPattern() = %q, want %q", got, want)
+	}
+	if len(s.Changed) != 2 || len(s.Untested) != 1 || s.Untested[0].StartLine != 4 {
+		t.Errorf("Changed = %v, Untested = %v", s.Changed, s.Untested)
+	}
+	if !reflect.DeepEqual(s.Unmatched, []string{"b/b.go"}) {
+		t.Errorf("Unmatched = %v, want [b/b.go]", s.Unmatched)
+	}
+
+	s = SelectFuncs(set, []covutil.FuncDesc{{PackagePath: "example.com/a", FuncName: "F"}})
+	if len(s.Tests) != 3 || len(s.Changed) != 4 || len(s.Untested) != 1 {
+		t.Errorf("SelectFuncs = %+v", s)
+	}
+	if got := SelectFuncs(set, nil).RunPattern(); got != "^$" {
+		t.Errorf("RunPattern() of empty selection = %q, want ^$", got)
+	}
+}
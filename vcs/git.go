@@ -0,0 +1,350 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// repo is a git repository read directly from its .git directory.
+type repo struct {
+	workTree  string // root of the working tree
+	gitDir    string // per-worktree state: HEAD, index
+	commonDir string // shared state: refs, packed-refs, objects, config
+	hashLen   int    // length of object IDs in bytes
+
+	config   map[string]string // "section.subsection.key" to value
+	packList []*pack
+}
+
+// errNoRepo is returned by findRepo if dir is not within a git repository.
+var errNoRepo = errors.New("not a git repository")
+
+// findRepo returns the repository containing dir, looking for a .git
+// directory, or a .git file as used by worktrees and submodules, in dir
+// and its parents.
+func findRepo(dir string) (*repo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if fi, err := os.Stat(dotGit); err == nil {
+			if fi.IsDir() {
+				return openRepo(dir, dotGit)
+			}
+			data, err := os.ReadFile(dotGit)
+			if err != nil {
+				return nil, err
+			}
+			gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+			if !ok {
+				return nil, fmt.Errorf("%s: malformed .git file", dotGit)
+			}
+			gitDir = strings.TrimSpace(gitDir)
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			return openRepo(dir, gitDir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, errNoRepo
+		}
+		dir = parent
+	}
+}
+
+func openRepo(workTree, gitDir string) (*repo, error) {
+	r := &repo{workTree: workTree, gitDir: gitDir, commonDir: gitDir, hashLen: 20}
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		common := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(gitDir, common)
+		}
+		r.commonDir = common
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "HEAD")); err != nil {
+		return nil, fmt.Errorf("%s: not a git directory", gitDir)
+	}
+	var err error
+	if r.config, err = readConfig(filepath.Join(r.commonDir, "config")); err != nil {
+		return nil, err
+	}
+	switch format := r.config["extensions.objectformat"]; format {
+	case "", "sha1":
+	case "sha256":
+		r.hashLen = 32
+	default:
+		return nil, fmt.Errorf("%s: unsupported object format %q", gitDir, format)
+	}
+	return r, nil
+}
+
+// readConfig reads the subset of the git config format needed here:
+// sections, quoted subsections and single-line values. Keys are returned
+// as "section.subsection.key" with the section and key lowercased.
+func readConfig(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := make(map[string]string)
+	var section string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.LastIndexByte(line, ']')
+			if end < 0 {
+				continue
+			}
+			name, sub, ok := strings.Cut(line[1:end], " ")
+			section = strings.ToLower(name)
+			if ok {
+				section += "." + strings.Trim(strings.TrimSpace(sub), `"`)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			value = "true"
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		config[section+"."+strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return config, s.Err()
+}
+
+// head returns the branch checked out, or "" if HEAD is detached, and the
+// commit HEAD refers to, or "" if the branch has no commits yet.
+func (r *repo) head() (branch, commit string, err error) {
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "HEAD"))
+	if err != nil {
+		return "", "", err
+	}
+	head := strings.TrimSpace(string(data))
+	ref, ok := strings.CutPrefix(head, "ref:")
+	if !ok {
+		if !r.isID(head) {
+			return "", "", fmt.Errorf("malformed HEAD %q", head)
+		}
+		return "", head, nil
+	}
+	ref = strings.TrimSpace(ref)
+	branch = strings.TrimPrefix(ref, "refs/heads/")
+	commit, err = r.resolveRef(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return branch, commit, err
+}
+
+// resolveRef returns the object ID a ref refers to, following symbolic
+// refs, from a loose ref file or from packed-refs.
+func (r *repo) resolveRef(ref string) (string, error) {
+	for range 10 {
+		data, err := os.ReadFile(r.refPath(ref))
+		if errors.Is(err, os.ErrNotExist) {
+			packed, _, err := r.packedRefs()
+			if err != nil {
+				return "", err
+			}
+			if id, ok := packed[ref]; ok {
+				return id, nil
+			}
+			return "", fmt.Errorf("ref %s: %w", ref, os.ErrNotExist)
+		}
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimSpace(string(data))
+		if target, ok := strings.CutPrefix(value, "ref:"); ok {
+			ref = strings.TrimSpace(target)
+			continue
+		}
+		if !r.isID(value) {
+			return "", fmt.Errorf("ref %s: malformed value %q", ref, value)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("ref %s: too many levels of symbolic refs", ref)
+}
+
+// refPath returns the file of a loose ref. Refs other than those under
+// refs/ (HEAD, and pseudo-refs such as ORIG_HEAD) belong to the worktree.
+func (r *repo) refPath(ref string) string {
+	if strings.HasPrefix(ref, "refs/") {
+		return filepath.Join(r.commonDir, filepath.FromSlash(ref))
+	}
+	return filepath.Join(r.gitDir, filepath.FromSlash(ref))
+}
+
+// packedRefs returns the refs listed in packed-refs, and for annotated
+// tags the commits they peel to.
+func (r *repo) packedRefs() (refs, peeled map[string]string, err error) {
+	refs = make(map[string]string)
+	peeled = make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(r.commonDir, "packed-refs"))
+	if errors.Is(err, os.ErrNotExist) {
+		return refs, peeled, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var last string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line[0] == '#':
+		case line[0] == '^':
+			if last != "" && r.isID(line[1:]) {
+				peeled[last] = line[1:]
+			}
+		default:
+			id, ref, ok := strings.Cut(line, " ")
+			if ok && r.isID(id) {
+				refs[ref] = id
+				last = ref
+			}
+		}
+	}
+	return refs, peeled, nil
+}
+
+// tag returns the name of a tag that refers to commit, directly or through
+// an annotated tag, or "" if there is none. If several tags do, the
+// lexically greatest is returned.
+func (r *repo) tag(commit string) string {
+	refs, peeled, err := r.packedRefs()
+	if err != nil {
+		return ""
+	}
+	tagsDir := filepath.Join(r.commonDir, "refs", "tags")
+	filepath.WalkDir(tagsDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.commonDir, path)
+		if err != nil {
+			return nil
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			ref := filepath.ToSlash(rel)
+			refs[ref] = strings.TrimSpace(string(data))
+			delete(peeled, ref)
+		}
+		return nil
+	})
+
+	var best string
+	for ref, id := range refs {
+		name, ok := strings.CutPrefix(ref, "refs/tags/")
+		if !ok || name <= best {
+			continue
+		}
+		if id != commit {
+			if p, ok := peeled[ref]; ok {
+				id = p
+			} else if id, ok = r.peelTag(id); !ok {
+				continue
+			}
+		}
+		if id == commit {
+			best = name
+		}
+	}
+	return best
+}
+
+// peelTag returns the object an annotated tag refers to.
+func (r *repo) peelTag(id string) (string, bool) {
+	for range 10 {
+		typ, data, err := r.readObject(id)
+		if err != nil || typ != objTag {
+			return id, err == nil
+		}
+		target, ok := header(data, "object")
+		if !ok {
+			return "", false
+		}
+		id = target
+	}
+	return "", false
+}
+
+// commitTime returns the committer time of a commit.
+func (r *repo) commitTime(commit string) (time.Time, error) {
+	typ, data, err := r.readObject(commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if typ != objCommit {
+		return time.Time{}, fmt.Errorf("object %s is not a commit", commit)
+	}
+	committer, ok := header(data, "committer")
+	if !ok {
+		return time.Time{}, fmt.Errorf("commit %s has no committer", commit)
+	}
+	// The committer is "Name <email> seconds zone".
+	fields := strings.Fields(committer[strings.LastIndexByte(committer, '>')+1:])
+	if len(fields) != 2 {
+		return time.Time{}, fmt.Errorf("commit %s: malformed committer %q", commit, committer)
+	}
+	sec, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("commit %s: malformed committer %q", commit, committer)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// header returns the value of the first header line of a commit or tag
+// object with the given key.
+func header(data []byte, key string) (string, bool) {
+	for len(data) > 0 {
+		line, rest, _ := bytes.Cut(data, []byte("\n"))
+		if len(line) == 0 {
+			break // end of headers
+		}
+		if k, v, ok := bytes.Cut(line, []byte(" ")); ok && string(k) == key {
+			return string(v), true
+		}
+		data = rest
+	}
+	return "", false
+}
+
+// isID reports whether s is a hex object ID.
+func (r *repo) isID(s string) bool {
+	if len(s) != 2*r.hashLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
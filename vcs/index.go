@@ -0,0 +1,282 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// indexEntry is an entry of the git index.
+type indexEntry struct {
+	path         string
+	id           string
+	mode         uint32
+	size         uint32
+	mtimeSec     uint32
+	mtimeNsec    uint32
+	stage        int
+	skipWorktree bool
+	intentToAdd  bool
+}
+
+// Index entry modes.
+const (
+	modeSymlink = 0o120000
+	modeGitlink = 0o160000
+)
+
+// readIndex reads the entries of the index, which must be in version 2, 3
+// or 4 of the format. Extensions are ignored.
+func (r *repo) readIndex() ([]indexEntry, os.FileInfo, error) {
+	name := filepath.Join(r.gitDir, "index")
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, nil, fmt.Errorf("%s: not a git index", name)
+	}
+	version := binary.BigEndian.Uint32(data[4:])
+	if version < 2 || version > 4 {
+		return nil, nil, fmt.Errorf("%s: unsupported index version %d", name, version)
+	}
+	n := binary.BigEndian.Uint32(data[8:])
+	errTruncated := fmt.Errorf("%s: truncated index", name)
+
+	entries := make([]indexEntry, 0, n)
+	var prev []byte
+	off := 12
+	for range n {
+		p := data[off:]
+		fixed := 40 + r.hashLen + 2
+		if len(p) < fixed {
+			return nil, nil, errTruncated
+		}
+		e := indexEntry{
+			mtimeSec:  binary.BigEndian.Uint32(p[8:]),
+			mtimeNsec: binary.BigEndian.Uint32(p[12:]),
+			mode:      binary.BigEndian.Uint32(p[24:]),
+			size:      binary.BigEndian.Uint32(p[36:]),
+			id:        hex.EncodeToString(p[40 : 40+r.hashLen]),
+		}
+		flags := binary.BigEndian.Uint16(p[40+r.hashLen:])
+		e.stage = int(flags>>12) & 3
+		if flags&0x4000 != 0 && version >= 3 {
+			if len(p) < fixed+2 {
+				return nil, nil, errTruncated
+			}
+			ext := binary.BigEndian.Uint16(p[fixed:])
+			e.skipWorktree = ext&0x4000 != 0
+			e.intentToAdd = ext&0x2000 != 0
+			fixed += 2
+		}
+
+		var path []byte
+		if version == 4 {
+			// The path is stored as the number of bytes to remove from
+			// the end of the previous path and a suffix to append.
+			strip, n := offsetVarint(p[fixed:])
+			if n == 0 || strip > uint64(len(prev)) {
+				return nil, nil, errTruncated
+			}
+			suffix, _, ok := bytes.Cut(p[fixed+n:], []byte{0})
+			if !ok {
+				return nil, nil, errTruncated
+			}
+			keep := len(prev) - int(strip)
+			path = append(prev[:keep:keep], suffix...)
+			off += fixed + n + len(suffix) + 1
+		} else {
+			var ok bool
+			path, _, ok = bytes.Cut(p[fixed:], []byte{0})
+			if !ok {
+				return nil, nil, errTruncated
+			}
+			// Entries are padded with 1 to 8 NULs to a multiple of 8 bytes.
+			size := (fixed + len(path) + 8) &^ 7
+			if len(p) < size {
+				return nil, nil, errTruncated
+			}
+			off += size
+		}
+		prev = path
+		e.path = string(path)
+		entries = append(entries, e)
+	}
+	return entries, fi, nil
+}
+
+// offsetVarint decodes the variable-length integer encoding git uses for
+// pack delta offsets and index v4 path prefixes. It returns the value and
+// the number of bytes read, or 0 if p is truncated.
+func offsetVarint(p []byte) (uint64, int) {
+	var v uint64
+	for i, c := range p {
+		if i > 0 {
+			v++
+		}
+		v = v<<7 | uint64(c&0x7f)
+		if c&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// dirty reports whether the working tree or the index differ from the
+// commit head: whether a tracked file was modified, added, removed or
+// staged. Untracked files are not considered, since recognizing them
+// requires applying .gitignore rules. Content filters such as line ending
+// conversion are not applied, so files subject to them may be reported as
+// modified.
+func (r *repo) dirty(head string) (bool, error) {
+	entries, indexInfo, err := r.readIndex()
+	if errors.Is(err, os.ErrNotExist) {
+		// No index: clean only if nothing was ever committed.
+		return head != "", nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	committed := make(map[string]string)
+	if head != "" {
+		typ, data, err := r.readObject(head)
+		if err != nil {
+			return false, err
+		}
+		if typ != objCommit {
+			return false, fmt.Errorf("object %s is not a commit", head)
+		}
+		tree, ok := header(data, "tree")
+		if !ok {
+			return false, fmt.Errorf("commit %s has no tree", head)
+		}
+		if err := r.readTree(tree, "", committed); err != nil {
+			return false, err
+		}
+	}
+
+	// Compare the index with the commit.
+	if len(entries) != len(committed) {
+		return true, nil
+	}
+	for _, e := range entries {
+		if e.stage != 0 || e.intentToAdd || committed[e.path] != e.id {
+			return true, nil
+		}
+	}
+
+	// Compare the working tree with the index.
+	for _, e := range entries {
+		if e.skipWorktree || e.mode == modeGitlink {
+			continue
+		}
+		modified, err := r.modified(e, indexInfo)
+		if err != nil || modified {
+			return modified, err
+		}
+	}
+	return false, nil
+}
+
+// readTree adds the blobs of a tree object and its subtrees to files,
+// keyed by slash-separated path.
+func (r *repo) readTree(id, prefix string, files map[string]string) error {
+	typ, data, err := r.readObject(id)
+	if err != nil {
+		return err
+	}
+	if typ != objTree {
+		return fmt.Errorf("object %s is not a tree", id)
+	}
+	// Each entry is "mode name\0" followed by the binary object ID.
+	for len(data) > 0 {
+		hdr, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < r.hashLen {
+			return fmt.Errorf("tree %s is malformed", id)
+		}
+		modeStr, name, _ := bytes.Cut(hdr, []byte(" "))
+		mode, err := strconv.ParseUint(string(modeStr), 8, 32)
+		if err != nil {
+			return fmt.Errorf("tree %s is malformed", id)
+		}
+		child := hex.EncodeToString(rest[:r.hashLen])
+		data = rest[r.hashLen:]
+		path := prefix + string(name)
+		if mode == 0o40000 {
+			if err := r.readTree(child, path+"/", files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[path] = child
+	}
+	return nil
+}
+
+// modified reports whether the file of an index entry differs from the
+// entry. As in git, a file whose size and modification time match the
+// entry is assumed unmodified unless it was modified no earlier than the
+// index was written, in which case its content is hashed.
+func (r *repo) modified(e indexEntry, indexInfo os.FileInfo) (bool, error) {
+	name := filepath.Join(r.workTree, filepath.FromSlash(e.path))
+	fi, err := os.Lstat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	isLink := e.mode&0o170000 == modeSymlink
+	if isLink != (fi.Mode()&os.ModeSymlink != 0) || uint32(fi.Size()) != e.size {
+		return true, nil
+	}
+	mtime := fi.ModTime()
+	if uint32(mtime.Unix()) == e.mtimeSec && uint32(mtime.Nanosecond()) == e.mtimeNsec &&
+		mtime.Before(indexInfo.ModTime()) {
+		return false, nil
+	}
+
+	var content []byte
+	if isLink {
+		target, err := os.Readlink(name)
+		if err != nil {
+			return false, err
+		}
+		content = []byte(filepath.ToSlash(target))
+	} else if content, err = os.ReadFile(name); err != nil {
+		return false, err
+	}
+	return r.blobID(content) != e.id, nil
+}
+
+// blobID returns the object ID of a blob with the given content.
+func (r *repo) blobID(content []byte) string {
+	var h hash.Hash
+	if r.hashLen == 32 {
+		h = sha256.New()
+	} else {
+		h = sha1.New()
+	}
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	io.Copy(h, bytes.NewReader(content))
+	return hex.EncodeToString(h.Sum(nil))
+}
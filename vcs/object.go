@@ -0,0 +1,313 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Git object types, as numbered in pack files.
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var typeNames = map[string]int{"commit": objCommit, "tree": objTree, "blob": objBlob, "tag": objTag}
+
+// readObject returns the type and content of the object with the given
+// hex ID, from a loose object file or from a pack.
+func (r *repo) readObject(id string) (int, []byte, error) {
+	if len(id) != 2*r.hashLen {
+		return 0, nil, fmt.Errorf("invalid object ID %q", id)
+	}
+	typ, data, err := r.readLooseObject(id)
+	if !errors.Is(err, os.ErrNotExist) {
+		return typ, data, err
+	}
+	raw, err := hex.DecodeString(id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid object ID %q", id)
+	}
+	packs, err := r.packs()
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, p := range packs {
+		if off, ok := p.find(raw); ok {
+			return p.readAt(r, off)
+		}
+	}
+	return 0, nil, fmt.Errorf("object %s not found", id)
+}
+
+func (r *repo) readLooseObject(id string) (int, []byte, error) {
+	f, err := os.Open(filepath.Join(r.commonDir, "objects", id[:2], id[2:]))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return 0, nil, fmt.Errorf("object %s: %v", id, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return 0, nil, fmt.Errorf("object %s: %v", id, err)
+	}
+	hdr, body, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return 0, nil, fmt.Errorf("object %s: malformed header", id)
+	}
+	name, size, _ := bytes.Cut(hdr, []byte(" "))
+	typ, ok := typeNames[string(name)]
+	if n, err := strconv.Atoi(string(size)); !ok || err != nil || n != len(body) {
+		return 0, nil, fmt.Errorf("object %s: malformed header %q", id, hdr)
+	}
+	return typ, body, nil
+}
+
+// pack is a pack file and its version 2 index.
+type pack struct {
+	name    string // path of the .pack file
+	hashLen int
+	fanout  [256]uint32
+	ids     []byte // sorted object IDs
+	offsets []byte // 4-byte offsets
+	large   []byte // 8-byte offsets
+}
+
+// packs returns the packs of the repository, loading their indexes on
+// first use.
+func (r *repo) packs() ([]*pack, error) {
+	if r.packList != nil {
+		return r.packList, nil
+	}
+	names, err := filepath.Glob(filepath.Join(r.commonDir, "objects", "pack", "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	r.packList = []*pack{}
+	for _, name := range names {
+		p, err := readPackIndex(name, r.hashLen)
+		if err != nil {
+			return nil, err
+		}
+		r.packList = append(r.packList, p)
+	}
+	return r.packList, nil
+}
+
+func readPackIndex(name string, hashLen int) (*pack, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte("\377tOc")) || binary.BigEndian.Uint32(data[4:]) != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index format", name)
+	}
+	p := &pack{name: name[:len(name)-len(".idx")] + ".pack", hashLen: hashLen}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(data[8+4*i:])
+	}
+	n := int(p.fanout[255])
+	data = data[8+256*4:]
+	if len(data) < n*(hashLen+4+4) {
+		return nil, fmt.Errorf("%s: truncated pack index", name)
+	}
+	p.ids = data[:n*hashLen]
+	data = data[n*hashLen+n*4:] // skip CRCs
+	p.offsets = data[:n*4]
+	p.large = data[n*4:]
+	return p, nil
+}
+
+// find returns the offset in the pack of the object with the given ID.
+func (p *pack) find(id []byte) (int64, bool) {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(p.fanout[id[0]-1])
+	}
+	hi := int(p.fanout[id[0]])
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch bytes.Compare(p.ids[mid*p.hashLen:(mid+1)*p.hashLen], id) {
+		case 0:
+			off := binary.BigEndian.Uint32(p.offsets[mid*4:])
+			if off&0x80000000 == 0 {
+				return int64(off), true
+			}
+			i := int(off &^ 0x80000000)
+			if len(p.large) < (i+1)*8 {
+				return 0, false
+			}
+			return int64(binary.BigEndian.Uint64(p.large[i*8:])), true
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+// readAt reads the object at offset off of the pack, applying deltas.
+func (p *pack) readAt(r *repo, off int64) (int, []byte, error) {
+	f, err := os.Open(p.name)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	return p.readEntry(r, f, off, 0)
+}
+
+// maxDeltaDepth bounds delta chains, guarding against corrupt packs.
+const maxDeltaDepth = 1000
+
+func (p *pack) readEntry(r *repo, f *os.File, off int64, depth int) (int, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, fmt.Errorf("%s: delta chain too long", p.name)
+	}
+	br := bufio.NewReader(io.NewSectionReader(f, off, 1<<62))
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := int(c>>4) & 7
+	size := uint64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= uint64(c&0x7f) << shift
+	}
+
+	var baseType int
+	var base []byte
+	switch typ {
+	case objCommit, objTree, objBlob, objTag:
+	case objOfsDelta:
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			rel = (rel+1)<<7 | int64(c&0x7f)
+		}
+		if baseType, base, err = p.readEntry(r, f, off-rel, depth+1); err != nil {
+			return 0, nil, err
+		}
+	case objRefDelta:
+		id := make([]byte, p.hashLen)
+		if _, err := io.ReadFull(br, id); err != nil {
+			return 0, nil, err
+		}
+		if baseType, base, err = r.readObject(hex.EncodeToString(id)); err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, fmt.Errorf("%s: invalid object type %d at offset %d", p.name, typ, off)
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, int64(size)+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if uint64(len(data)) != size {
+		return 0, nil, fmt.Errorf("%s: object at offset %d has wrong size", p.name, off)
+	}
+	if base == nil {
+		return typ, data, nil
+	}
+	data, err = applyDelta(base, data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: object at offset %d: %v", p.name, off, err)
+	}
+	return baseType, data, nil
+}
+
+// applyDelta applies a git delta to base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	varint := func() (uint64, bool) {
+		var v uint64
+		for shift := 0; len(delta) > 0; shift += 7 {
+			c := delta[0]
+			delta = delta[1:]
+			v |= uint64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return v, true
+			}
+		}
+		return 0, false
+	}
+	srcSize, ok1 := varint()
+	dstSize, ok2 := varint()
+	if !ok1 || !ok2 || srcSize != uint64(len(base)) {
+		return nil, errors.New("malformed delta")
+	}
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			var off, n uint64
+			for i := 0; i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errors.New("malformed delta")
+				}
+				if i < 4 {
+					off |= uint64(delta[0]) << (8 * i)
+				} else {
+					n |= uint64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > uint64(len(base)) {
+				return nil, errors.New("malformed delta")
+			}
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, errors.New("malformed delta")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("malformed delta")
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, errors.New("malformed delta")
+	}
+	return out, nil
+}
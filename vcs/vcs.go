@@ -0,0 +1,175 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vcs determines the version control state of the source code
+// coverage data was collected from, as a covutil.SourceInfo.
+//
+// Git repositories are read directly from their .git directory, without
+// running git: HEAD, loose and packed refs, and commit objects, loose or
+// packed. When the data was not collected within a repository, the vcs
+// settings that the go command embeds in binaries (see
+// runtime/debug.BuildInfo) are read from the coverage-instrumented binary
+// instead.
+package vcs
+
+import (
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/tmc/covutil"
+)
+
+// ErrNotFound is returned when no version control information is found.
+var ErrNotFound = errors.New("vcs: no version control information found")
+
+// Git returns the state of the git repository containing dir, or
+// ErrNotFound if dir is not within a git repository.
+//
+// The repository URI is the URL of the "origin" remote. Dirty reports
+// whether a tracked file was modified, added, removed or staged;
+// untracked files are not considered.
+func Git(dir string) (*covutil.SourceInfo, error) {
+	r, err := findRepo(dir)
+	if err == errNoRepo {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vcs: %v", err)
+	}
+	branch, commit, err := r.head()
+	if err != nil {
+		return nil, fmt.Errorf("vcs: %s: %v", r.workTree, err)
+	}
+	info := &covutil.SourceInfo{
+		RepoURI:   r.config["remote.origin.url"],
+		CommitSHA: commit,
+		Branch:    branch,
+	}
+	if commit != "" {
+		if info.CommitTime, err = r.commitTime(commit); err != nil {
+			return nil, fmt.Errorf("vcs: %s: %v", r.workTree, err)
+		}
+		info.Tag = r.tag(commit)
+	}
+	if info.Dirty, err = r.dirty(commit); err != nil {
+		return nil, fmt.Errorf("vcs: %s: %v", r.workTree, err)
+	}
+	return info, nil
+}
+
+// Root returns the root of the working tree of the git repository
+// containing dir, or ErrNotFound if dir is not within a git repository.
+func Root(dir string) (string, error) {
+	r, err := findRepo(dir)
+	if err == errNoRepo {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("vcs: %v", err)
+	}
+	return r.workTree, nil
+}
+
+// FromBuildInfo returns the version control state recorded in the vcs
+// settings of a binary's build information, or nil if there are none.
+// The repository URI is the main module path; the branch and tag are not
+// recorded.
+func FromBuildInfo(bi *debug.BuildInfo) *covutil.SourceInfo {
+	if bi == nil {
+		return nil
+	}
+	var info covutil.SourceInfo
+	found := false
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.CommitSHA = s.Value
+		case "vcs.time":
+			info.CommitTime, _ = time.Parse(time.RFC3339Nano, s.Value)
+		case "vcs.modified":
+			info.Dirty = s.Value == "true"
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	info.RepoURI = bi.Main.Path
+	return &info
+}
+
+// FromBinary returns the version control state recorded in the build
+// information of the named binary, or ErrNotFound if none was recorded,
+// as for binaries built outside a repository or with -buildvcs=false.
+func FromBinary(name string) (*covutil.SourceInfo, error) {
+	bi, err := buildinfo.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("vcs: %v", err)
+	}
+	if info := FromBuildInfo(bi); info != nil {
+		return info, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Detect returns the state of the git repository containing dir, falling
+// back to the build information of the given binaries, in order.
+func Detect(dir string, binaries ...string) (*covutil.SourceInfo, error) {
+	info, err := Git(dir)
+	if err != ErrNotFound {
+		return info, err
+	}
+	for _, name := range binaries {
+		if info, err := FromBinary(name); err == nil {
+			return info, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Stamp sets the Source of each pod that has none. The git repository
+// containing dir is used if there is one; otherwise each pod gets the
+// build information of the binary that wrote it, as named by the "argv0"
+// argument of its counter files, if the binary still exists. If dir is
+// empty, only the binaries are used. It returns ErrNotFound if no pod
+// could be stamped.
+func Stamp(dir string, pods ...*covutil.Pod) error {
+	var info *covutil.SourceInfo
+	if dir != "" {
+		var err error
+		if info, err = Git(dir); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	binaries := make(map[string]*covutil.SourceInfo)
+	stamped := false
+	for _, pod := range pods {
+		if pod.Source != nil {
+			stamped = true
+			continue
+		}
+		src := info
+		if src == nil && pod.Profile != nil {
+			name := pod.Profile.Args["argv0"]
+			if _, ok := binaries[name]; !ok && name != "" {
+				binaries[name], _ = FromBinary(name)
+			}
+			src = binaries[name]
+		}
+		if src != nil {
+			s := *src
+			pod.Source = &s
+			stamped = true
+		}
+	}
+	if !stamped {
+		return ErrNotFound
+	}
+	return nil
+}
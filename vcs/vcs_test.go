@@ -0,0 +1,229 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/covutil"
+)
+
+// testRepo creates a git repository with the git command, which is only
+// used to build the fixtures the package reads.
+type testRepo struct {
+	t   *testing.T
+	dir string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	r := &testRepo{t: t, dir: t.TempDir()}
+	r.git("init", "-q", "-b", "main")
+	r.git("remote", "add", "origin", "https://example.com/repo.git")
+	return r
+}
+
+func (r *testRepo) git(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_COMMITTER_DATE=2024-03-01T12:00:00Z",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (r *testRepo) write(name, content string) {
+	r.t.Helper()
+	name = filepath.Join(r.dir, name)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func (r *testRepo) info(dir string) *covutil.SourceInfo {
+	r.t.Helper()
+	info, err := Git(dir)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return info
+}
+
+func TestGit(t *testing.T) {
+	r := newTestRepo(t)
+	if info := r.info(r.dir); info.CommitSHA != "" || info.Branch != "main" || info.Dirty {
+		t.Errorf("empty repository: got %+v", info)
+	}
+
+	r.write("a.go", "package a\n")
+	r.write("sub/b.go", "package sub\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "first")
+	r.git("tag", "-a", "-m", "release", "v1.0.0")
+	head := r.git("rev-parse", "HEAD")
+
+	want := covutil.SourceInfo{
+		RepoURI:    "https://example.com/repo.git",
+		CommitSHA:  head,
+		CommitTime: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Branch:     "main",
+		Tag:        "v1.0.0",
+	}
+	check := func(name string, dir string) {
+		t.Helper()
+		if got := r.info(dir); *got != want {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+	check("loose", filepath.Join(r.dir, "sub"))
+
+	r.write("sub/b.go", "package sub // changed\n")
+	want.Dirty = true
+	check("modified", r.dir)
+	r.git("add", ".")
+	check("staged", r.dir)
+	r.git("checkout", "-q", "HEAD", "--", ".")
+	r.write("c.go", "package a\n")
+	r.git("add", "-N", "c.go")
+	check("intent to add", r.dir)
+	r.git("rm", "-q", "--cached", "c.go")
+	os.Remove(filepath.Join(r.dir, "c.go"))
+	os.Remove(filepath.Join(r.dir, "a.go"))
+	check("removed", r.dir)
+	r.git("checkout", "-q", "HEAD", "--", ".")
+	want.Dirty = false
+	r.write("untracked.go", "package a\n")
+	check("untracked", r.dir)
+
+	r.git("update-index", "--index-version", "4")
+	check("index v4", r.dir)
+
+	r.git("gc", "-q")
+	if _, err := os.Stat(filepath.Join(r.dir, ".git", "refs", "heads", "main")); err == nil {
+		t.Fatalf("gc did not pack refs")
+	}
+	check("packed", r.dir)
+
+	r.git("checkout", "-q", "--detach")
+	want.Branch = ""
+	check("detached", r.dir)
+
+	wt := filepath.Join(t.TempDir(), "wt")
+	r.git("worktree", "add", "-q", "-b", "feature", wt)
+	want.Branch = "feature"
+	check("worktree", wt)
+
+	if _, err := Git(t.TempDir()); err != ErrNotFound {
+		t.Errorf("Git outside a repository: got error %v, want ErrNotFound", err)
+	}
+}
+
+// TestPackDeltas checks that objects stored as deltas in a pack are read
+// correctly.
+func TestPackDeltas(t *testing.T) {
+	r := newTestRepo(t)
+	var lines []string
+	for i := range 200 {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	for i := range 10 {
+		lines[i*20] = fmt.Sprintf("changed in commit %d", i)
+		r.write("big.txt", strings.Join(lines, "\n"))
+		r.git("add", ".")
+		r.git("commit", "-q", "-m", fmt.Sprint("commit ", i))
+	}
+	r.git("gc", "-q", "--aggressive")
+
+	repo, err := findRepo(r.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := strings.Fields(r.git("rev-list", "--objects", "--no-object-names", "HEAD"))
+	for _, id := range objects {
+		_, data, err := repo.readObject(id)
+		if err != nil {
+			t.Fatalf("reading %s: %v", id, err)
+		}
+		if want := r.git("cat-file", strings.TrimSpace(r.git("cat-file", "-t", id)), id); !bytes.Equal(bytes.TrimSpace(data), []byte(want)) {
+			t.Errorf("object %s differs from git cat-file", id)
+		}
+	}
+	if info := r.info(r.dir); info.Dirty {
+		t.Errorf("repository with packed objects reported dirty")
+	}
+}
+
+func TestFromBuildInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Path: "example.com/cmd"},
+		Settings: []debug.BuildSetting{
+			{Key: "-covermode", Value: "atomic"},
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "0123456789abcdef0123456789abcdef01234567"},
+			{Key: "vcs.time", Value: "2024-03-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	want := covutil.SourceInfo{
+		RepoURI:    "example.com/cmd",
+		CommitSHA:  "0123456789abcdef0123456789abcdef01234567",
+		CommitTime: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Dirty:      true,
+	}
+	if got := FromBuildInfo(bi); got == nil || *got != want {
+		t.Errorf("FromBuildInfo = %+v, want %+v", got, want)
+	}
+	if got := FromBuildInfo(&debug.BuildInfo{Main: bi.Main}); got != nil {
+		t.Errorf("FromBuildInfo without vcs settings = %+v, want nil", got)
+	}
+}
+
+func TestStamp(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a.go", "package a\n")
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", "first")
+
+	existing := &covutil.SourceInfo{CommitSHA: "kept"}
+	pods := []*covutil.Pod{
+		{ID: "a", Profile: &covutil.Profile{Args: map[string]string{"argv0": "/nonexistent"}}},
+		{ID: "b", Source: existing},
+	}
+	if err := Stamp(r.dir, pods...); err != nil {
+		t.Fatal(err)
+	}
+	if pods[0].Source == nil || pods[0].Source.CommitSHA != r.git("rev-parse", "HEAD") {
+		t.Errorf("pod a source = %+v", pods[0].Source)
+	}
+	if pods[1].Source != existing {
+		t.Errorf("pod b source was replaced")
+	}
+
+	pods = []*covutil.Pod{{ID: "c", Profile: &covutil.Profile{Args: map[string]string{"argv0": "/nonexistent"}}}}
+	if err := Stamp(t.TempDir(), pods...); err != ErrNotFound {
+		t.Errorf("Stamp without repository or binary: got error %v, want ErrNotFound", err)
+	}
+}